@@ -2,6 +2,7 @@ package buffer
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/ksrnnb/go-rdb/file"
 	"github.com/ksrnnb/go-rdb/logs"
@@ -13,8 +14,11 @@ type Buffer struct {
 	contents *file.Page
 	blk      file.BlockID
 	pins     int // ページがピン留めされた回数
-	txnum    int // ページの変更を行なったトランザクションを識別する。-1 は未変更
-	lsn      int // 最新のログレコードの Log Sequence Number
+	// txnums はページを変更したトランザクションで、空の場合は未変更
+	// latch で共有するページは、複数のトランザクションがコミットする前に変更する
+	txnums []int
+	mux    sync.Mutex
+	lsn    int // 最新のログレコードの Log Sequence Number
 }
 
 // 1個のバッファは1ページ分もつ
@@ -22,7 +26,6 @@ func NewBuffer(fm *file.FileManager, lm *logs.LogManager) *Buffer {
 	return &Buffer{
 		fm:       fm,
 		lm:       lm,
-		txnum:    -1,
 		lsn:      -1,
 		contents: file.NewPage(fm.BlockSize()),
 	}
@@ -43,7 +46,11 @@ func (b *Buffer) Block() file.BlockID {
 
 // トランザクションNo.とLSNを設定
 func (b *Buffer) SetModified(txnum, lsn int) {
-	b.txnum = txnum
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if !b.isModifiedBy(txnum) {
+		b.txnums = append(b.txnums, txnum)
+	}
 
 	if lsn >= 0 {
 		b.lsn = lsn
@@ -56,15 +63,26 @@ func (b *Buffer) IsPinned() bool {
 	return b.pins > 0
 }
 
-// トランザクションNo.を返す
-func (b *Buffer) ModifyingTx() int {
-	return b.txnum
+// IsModifiedBy はトランザクションがページを変更して、まだディスクに書き込んでいないかどうかを返す
+func (b *Buffer) IsModifiedBy(txnum int) bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.isModifiedBy(txnum)
+}
+
+func (b *Buffer) isModifiedBy(txnum int) bool {
+	for _, n := range b.txnums {
+		if n == txnum {
+			return true
+		}
+	}
+	return false
 }
 
 // 指定したブロックの内容をBufferのpageに割り当てる
 // 割り当て前に、バッファの内容はflush()してディスクに書き込まれる
-func (b *Buffer) assignToBlock(blk file.BlockID) error {
-	err := b.flush()
+func (b *Buffer) assignToBlock(blk file.BlockID, ios *IOStats) error {
+	err := b.flush(ios)
 
 	if err != nil {
 		return fmt.Errorf("buffer: assignToBlock() failed, %w", err)
@@ -74,18 +92,22 @@ func (b *Buffer) assignToBlock(blk file.BlockID) error {
 	if err != nil {
 		return fmt.Errorf("buffer: assignToBlock() failed, %w", err)
 	}
+	ios.addRead()
 
 	b.blk = blk
 	b.pins = 0
 	return nil
 }
 
-// トランザクションの値が0以上（SetModifiy()を実行）であれば
+// ページを変更したトランザクションがあれば（SetModifiy()を実行）
 // ログマネージャのFlushを実行し、ログファイルに書き込む
 // ファイルのブロックにページの内容を書き込む
-// トランザクションNo.は-1に戻す
-func (b *Buffer) flush() error {
-	if b.txnum >= 0 {
+// ページを変更したトランザクションは空に戻す
+// ios が nil でなければ書き込んだブロック数を加える
+func (b *Buffer) flush(ios *IOStats) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if len(b.txnums) > 0 {
 		err := b.lm.Flush(b.lsn)
 
 		if err != nil {
@@ -96,8 +118,9 @@ func (b *Buffer) flush() error {
 		if err != nil {
 			return fmt.Errorf("buffer: flush() failed, %w", err)
 		}
+		ios.addWrite()
 
-		b.txnum = -1
+		b.txnums = nil
 	}
 
 	return nil
@@ -55,8 +55,8 @@ func (bm *BufferManager) FlushAll(txnum int) error {
 	bm.cond.L.Lock()
 	defer bm.cond.L.Unlock()
 	for _, b := range bm.bufferPool {
-		if b.ModifyingTx() == txnum {
-			err := b.flush()
+		if b.IsModifiedBy(txnum) {
+			err := b.flush(nil)
 			if err != nil {
 				return err
 			}
@@ -82,11 +82,17 @@ func (bm *BufferManager) Unpin(b *Buffer) {
 // pinできたらBufferを返す
 // ディスクに書き込む可能性のあるメソッドはPin()またはFlushAll()のみ
 func (bm *BufferManager) Pin(blk file.BlockID) (*Buffer, error) {
+	return bm.PinCounting(blk, nil)
+}
+
+// PinCounting() は Pin() と同じく引数のブロックを pin して、 pin とディスクの読み書きの回数を ios に加える
+// トランザクションごとに I/O を数えるために使う
+func (bm *BufferManager) PinCounting(blk file.BlockID, ios *IOStats) (*Buffer, error) {
 	start := time.Now()
 	result := make(chan pinResult)
 	defer close(result)
 
-	go bm.pin(result, blk, start)
+	go bm.pin(result, blk, start, ios)
 
 	select {
 	case pr := <-result:
@@ -98,11 +104,11 @@ func (bm *BufferManager) Pin(blk file.BlockID) (*Buffer, error) {
 	}
 }
 
-func (bm *BufferManager) pin(result chan<- pinResult, blk file.BlockID, start time.Time) {
+func (bm *BufferManager) pin(result chan<- pinResult, blk file.BlockID, start time.Time, ios *IOStats) {
 	bm.cond.L.Lock()
 	defer bm.cond.L.Unlock()
 
-	b, err := bm.tryToPin(blk)
+	b, err := bm.tryToPin(blk, ios)
 
 	if err != nil {
 		if !errors.Is(err, errNotExistUnpin) {
@@ -113,7 +119,7 @@ func (bm *BufferManager) pin(result chan<- pinResult, blk file.BlockID, start ti
 
 	for b == nil && !isWaitingTooLong(start) {
 		bm.cond.Wait()
-		b, err = bm.tryToPin(blk)
+		b, err = bm.tryToPin(blk, ios)
 		if err != nil {
 			if !errors.Is(err, errNotExistUnpin) {
 				result <- pinResult{nil, fmt.Errorf("pin() failed, %w", err)}
@@ -130,7 +136,7 @@ func (bm *BufferManager) pin(result chan<- pinResult, blk file.BlockID, start ti
 // ブロックがない場合は、unpin状態のBufferを探す
 // unpinのBufferがあれば、引数のブロックをBufferに割り当てる（ディスク書き込み）
 // Bufferがない場合はnilを返す（=> pinできなかった）
-func (bm *BufferManager) tryToPin(blk file.BlockID) (b *Buffer, err error) {
+func (bm *BufferManager) tryToPin(blk file.BlockID, ios *IOStats) (b *Buffer, err error) {
 	b = bm.findExistingBuffer(blk)
 
 	if b == nil {
@@ -139,7 +145,7 @@ func (bm *BufferManager) tryToPin(blk file.BlockID) (b *Buffer, err error) {
 			return nil, err
 		}
 
-		err = b.assignToBlock(blk)
+		err = b.assignToBlock(blk, ios)
 		if err != nil {
 			return nil, fmt.Errorf("buffer(): tryToPin() failed, %w", err)
 		}
@@ -150,6 +156,7 @@ func (bm *BufferManager) tryToPin(blk file.BlockID) (b *Buffer, err error) {
 	}
 
 	b.pin()
+	ios.addPin()
 	return b, nil
 }
 
@@ -0,0 +1,37 @@
+package buffer
+
+// IOStats はバッファの pin と、 pin したときにディスクを読み書きしたブロック数
+// トランザクションごとに数えるので、他のトランザクションの I/O は含まない
+type IOStats struct {
+	Pins          int
+	BlocksRead    int
+	BlocksWritten int
+}
+
+// Sub() は s から other を引いた差分を返す
+func (s IOStats) Sub(other IOStats) IOStats {
+	return IOStats{
+		Pins:          s.Pins - other.Pins,
+		BlocksRead:    s.BlocksRead - other.BlocksRead,
+		BlocksWritten: s.BlocksWritten - other.BlocksWritten,
+	}
+}
+
+// addPin() などは、数えない場合 (s が nil の場合) は何もしない
+func (s *IOStats) addPin() {
+	if s != nil {
+		s.Pins++
+	}
+}
+
+func (s *IOStats) addRead() {
+	if s != nil {
+		s.BlocksRead++
+	}
+}
+
+func (s *IOStats) addWrite() {
+	if s != nil {
+		s.BlocksWritten++
+	}
+}
@@ -66,16 +66,24 @@ func (bb *ByteBuffer) GetInt() int {
 }
 
 // GetIntWithPosition get integers at the specified position (pos)
+// 現在の位置は変えないので、複数の goroutine から同時に読み込める
 func (bb *ByteBuffer) GetIntWithPosition(pos int) (int, error) {
-	if pos+IntByteSize > bb.Size() {
+	if pos < 0 || pos+IntByteSize > bb.Size() {
 		return 0, fmt.Errorf("bytebuffer: GetIntWithPositoin() cannot get with position %d", pos)
 	}
 
-	bb.pos = pos
-	bytelen := readInt(bb.buf[bb.pos:])
+	return int(readInt(bb.buf[pos:])), nil
+}
 
-	bb.pos += IntByteSize
-	return int(bytelen), nil
+// GetWithPosition copies bb.buf from the specified position (pos) to buf
+// 現在の位置は変えないので、複数の goroutine から同時に読み込める
+func (bb *ByteBuffer) GetWithPosition(pos int, buf []byte) error {
+	if pos < 0 || pos+len(buf) > bb.Size() {
+		return fmt.Errorf("bytebuffer: GetWithPosition() cannot get with position %d", pos)
+	}
+
+	copy(buf, bb.buf[pos:pos+len(buf)])
+	return nil
 }
 
 // PutInt set integer in current position and advance position the size of val
@@ -142,8 +150,9 @@ func (bb *ByteBuffer) sizeNeedsToStoreBytes(b []byte) int {
 	return bb.pos + len(b)
 }
 
-func readInt(buf []byte) uint32 {
-	return endian().Uint32(buf)
+// readInt は符号付きの 32bit 整数として読み込む (負の値も保存できるようにする)
+func readInt(buf []byte) int32 {
+	return int32(endian().Uint32(buf))
 }
 
 func putInt(buf []byte, val int) {
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 
 	"github.com/ksrnnb/go-rdb/file"
+	"github.com/ksrnnb/go-rdb/query"
 	"github.com/ksrnnb/go-rdb/server"
 )
 
@@ -42,22 +43,31 @@ func main() {
 		panic(err)
 	}
 
+	insertUser, err := pe.Prepare("insert into users (uid, uname) values (?, ?)")
+	if err != nil {
+		panic(err)
+	}
+	insertPicture, err := pe.Prepare("insert into pictures (pid, user_id, title) values (?, ?, ?)")
+	if err != nil {
+		panic(err)
+	}
+
 	for i := 1; i < 100000; i++ {
 		txn, err := db.NewTransaction()
 		if err != nil {
 			panic(err)
 		}
 
-		q1 := fmt.Sprintf("insert into users (uid, uname) values (%d, 'user%d')", i, i)
-		q2 := fmt.Sprintf("insert into pictures (pid, user_id, title) values (%d, %d, 'title%d')", i, i, i)
+		p1 := []query.Constant{query.NewConstant(i), query.NewConstant(fmt.Sprintf("user%d", i))}
+		p2 := []query.Constant{query.NewConstant(i), query.NewConstant(i), query.NewConstant(fmt.Sprintf("title%d", i))}
 
-		fmt.Printf("executing: %s\n", q1)
-		_, err = pe.ExecuteUpdate(q1, txn)
+		fmt.Printf("executing: %s %v\n", insertUser.Query(), p1)
+		_, err = insertUser.ExecuteUpdate(p1, txn)
 		if err != nil {
 			panic(err)
 		}
-		fmt.Printf("executing: %s\n", q2)
-		_, err = pe.ExecuteUpdate(q2, txn)
+		fmt.Printf("executing: %s %v\n", insertPicture.Query(), p2)
+		_, err = insertPicture.ExecuteUpdate(p2, txn)
 		if err != nil {
 			panic(err)
 		}
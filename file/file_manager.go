@@ -8,9 +8,14 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 )
 
+// TemporaryFilePrefix は一時テーブルのファイル名の接頭辞
+// 一時テーブルはトランザクションをまたいで使わないので、起動時に削除する
+const TemporaryFilePrefix = "temporary_table_"
+
 // FileManager は特定のブロックの内容をページに読み込んだり、ページの内容をブロックに書き込んだりする
 // ファイルへのアクセスはブロック単位で行う
 type FileManager struct {
@@ -32,6 +37,9 @@ func NewFileManager(dirname string, bs int) (*FileManager, error) {
 	if err != nil {
 		log.Fatalf("NewSimpleDB() failed, %v", err)
 	}
+	if err := removeTemporaryFiles(dbDirectory); err != nil {
+		return nil, err
+	}
 
 	fm := &FileManager{
 		dbDirectory: dbDirectory,
@@ -173,6 +181,22 @@ func (fm *FileManager) getFile(filename string) (*os.File, error) {
 	return f, nil
 }
 
+// removeTemporaryFiles()は前回の起動時に作成した一時テーブルのファイルを削除する
+func removeTemporaryFiles(dbDirectory string) error {
+	entries, err := os.ReadDir(dbDirectory)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), TemporaryFilePrefix) {
+			if err := os.Remove(filepath.Join(dbDirectory, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // createDirectoryIfNeeded()はディレクトリ名を引数にとり、
 // 兄弟となる階層にディレクトリが存在しなければ作成、存在すればパスを返す
 func createDirectoryIfNeeded(dirname string) (dbDirectory string, err error) {
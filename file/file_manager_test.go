@@ -1,6 +1,8 @@
 package file
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -46,3 +48,21 @@ func TestFile(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, intVal, pos2Val)
 }
+
+func TestNewFileManager_RemoveTemporaryFiles(t *testing.T) {
+	fm, err := NewFileManager("data", 400)
+	require.NoError(t, err)
+	tempFile := TemporaryFilePrefix + "test"
+	_, err = fm.Append(tempFile)
+	require.NoError(t, err)
+	_, err = fm.Append("tempTestFile")
+	require.NoError(t, err)
+
+	// 一時テーブルのファイルだけ起動時に削除される
+	_, err = NewFileManager("data", 400)
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(ProjectRootDir(), "data", tempFile))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(ProjectRootDir(), "data", "tempTestFile"))
+	assert.NoError(t, err)
+}
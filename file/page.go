@@ -29,7 +29,7 @@ func (p *Page) GetBytes(pos int) ([]byte, error) {
 	}
 
 	newByte := make([]byte, bytelen)
-	if err := p.bb.Get(newByte); err != nil {
+	if err := p.bb.GetWithPosition(pos+bytebuffer.IntByteSize, newByte); err != nil {
 		return []byte{}, err
 	}
 
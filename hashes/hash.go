@@ -1,14 +1,42 @@
 package hashes
 
-import (
-	"fmt"
-	"hash/fnv"
+// FNV-1a (32 bit) の定数
+const (
+	offset32 = 2166136261
+	prime32  = 16777619
 )
 
-var h = fnv.New32a()
+// String は s の FNV-1a のハッシュ値を返す
+// hash/fnv と違って状態を持たないので、複数の goroutine から同時に呼び出せて、メモリも割り当てない
+func String(s string) uint32 {
+	h := uint32(offset32)
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= prime32
+	}
+	return h
+}
+
+// Int は v を 8 バイトのリトルエンディアンとみなした FNV-1a のハッシュ値を返す
+func Int(v int) uint32 {
+	h := uint32(offset32)
+	u := uint64(v)
+	for i := 0; i < 8; i++ {
+		h ^= uint32(u & 0xff)
+		h *= prime32
+		u >>= 8
+	}
+	return h
+}
 
-func HashCode(v interface{}) uint32 {
-	b := []byte(fmt.Sprintf("%v", v))
-	h.Write(b)
-	return h.Sum32()
+// Mix は h を seed ごとに異なる値に混ぜ合わせる
+// 同じハッシュ値の集合を、 seed を変えて別の分け方で分割し直すときに使う
+func Mix(h uint32, seed uint32) uint32 {
+	h ^= seed * 0x9e3779b9
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
 }
@@ -0,0 +1,50 @@
+package hashes
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestString(t *testing.T) {
+	// hash/fnv の New32a と同じ値になる
+	assert.Equal(t, uint32(0x811c9dc5), String(""))
+	assert.Equal(t, uint32(0xe40c292c), String("a"))
+	assert.Equal(t, uint32(0xbf9cf968), String("foobar"))
+	assert.Equal(t, String("hoge"), String("hoge"))
+	assert.NotEqual(t, String("hoge"), String("fuga"))
+}
+
+func TestInt(t *testing.T) {
+	assert.Equal(t, Int(42), Int(42))
+	assert.NotEqual(t, Int(1), Int(2))
+	assert.NotEqual(t, Int(-1), Int(1))
+}
+
+func TestMix(t *testing.T) {
+	h := String("hoge")
+	assert.Equal(t, Mix(h, 1), Mix(h, 1))
+	assert.NotEqual(t, Mix(h, 1), Mix(h, 2))
+}
+
+func TestConcurrentAndAllocationFree(t *testing.T) {
+	want := String("concurrent")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				assert.Equal(t, want, String("concurrent"))
+			}
+		}()
+	}
+	wg.Wait()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		String("allocation")
+		Int(12345)
+	})
+	assert.Equal(t, float64(0), allocs)
+}
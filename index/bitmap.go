@@ -0,0 +1,234 @@
+package index
+
+import "math/bits"
+
+// Bitmap は非負の整数の集合を、 WAH (Word-Aligned Hybrid) で圧縮して表すビットマップ
+// 位置を 31 ビットずつのグループに分けて、グループを 32 ビットの語で表す
+// 最上位ビットが 0 の語は下位 31 ビットがそのままグループのビットを表す literal で、
+// 最上位ビットが 1 の語は、全て 0 か全て 1 のグループが続く数を下位 30 ビットで表す fill になる
+// fill のグループの値は上から2番目のビットで表す
+type Bitmap struct {
+	words []uint32
+}
+
+const (
+	groupBits     = 31
+	allOnesGroup  = uint32(1)<<groupBits - 1
+	fillFlag      = uint32(1) << 31
+	fillValueFlag = uint32(1) << 30
+	maxFillCount  = fillValueFlag - 1
+)
+
+// run は同じ値のグループが count 個続くことを表す
+type run struct {
+	group uint32
+	count int
+}
+
+func NewBitmap() *Bitmap {
+	return &Bitmap{}
+}
+
+// NewBitmapFromWords は Words で取り出した圧縮した語からビットマップを作る
+func NewBitmapFromWords(words []uint32) *Bitmap {
+	return &Bitmap{words}
+}
+
+// Words は圧縮した語を返す
+func (b *Bitmap) Words() []uint32 {
+	return b.words
+}
+
+// Set は pos を集合に追加する
+func (b *Bitmap) Set(pos int) {
+	b.update(pos, func(g uint32, mask uint32) uint32 { return g | mask })
+}
+
+// Clear は pos を集合から取り除く
+func (b *Bitmap) Clear(pos int) {
+	b.update(pos, func(g uint32, mask uint32) uint32 { return g &^ mask })
+}
+
+// Contains は pos が集合に含まれるかどうかを返す
+func (b *Bitmap) Contains(pos int) bool {
+	g := pos / groupBits
+	idx := 0
+	for _, r := range b.runs() {
+		if g < idx+r.count {
+			return r.group&(1<<(pos%groupBits)) != 0
+		}
+		idx += r.count
+	}
+	return false
+}
+
+// IsEmpty は集合が空かどうかを返す
+func (b *Bitmap) IsEmpty() bool {
+	return len(b.words) == 0
+}
+
+// Count は集合の要素数を返す
+func (b *Bitmap) Count() int {
+	n := 0
+	for _, r := range b.runs() {
+		n += bits.OnesCount32(r.group) * r.count
+	}
+	return n
+}
+
+// Positions は集合の要素を昇順に返す
+func (b *Bitmap) Positions() []int {
+	positions := make([]int, 0)
+	idx := 0
+	for _, r := range b.runs() {
+		if r.group == 0 {
+			idx += r.count
+			continue
+		}
+		for i := 0; i < r.count; i++ {
+			for bit := 0; bit < groupBits; bit++ {
+				if r.group&(1<<bit) != 0 {
+					positions = append(positions, (idx+i)*groupBits+bit)
+				}
+			}
+		}
+		idx += r.count
+	}
+	return positions
+}
+
+// And は b と o の両方に含まれる要素の集合を返す
+func (b *Bitmap) And(o *Bitmap) *Bitmap {
+	return combine(b, o, func(x, y uint32) uint32 { return x & y })
+}
+
+// Or は b と o のどちらかに含まれる要素の集合を返す
+func (b *Bitmap) Or(o *Bitmap) *Bitmap {
+	return combine(b, o, func(x, y uint32) uint32 { return x | y })
+}
+
+// combine は圧縮したまま2つのビットマップのグループを先頭から順に f で組み合わせる
+// 短い方のビットマップの後ろは全て 0 のグループとみなす
+func combine(a, b *Bitmap, f func(x, y uint32) uint32) *Bitmap {
+	ra, rb := a.runs(), b.runs()
+	out := NewBitmap()
+	var ca, cb run
+	i, j := 0, 0
+	for {
+		if ca.count == 0 && i < len(ra) {
+			ca = ra[i]
+			i++
+		}
+		if cb.count == 0 && j < len(rb) {
+			cb = rb[j]
+			j++
+		}
+		if ca.count == 0 && cb.count == 0 {
+			break
+		}
+		n := ca.count
+		if n == 0 || (cb.count != 0 && cb.count < n) {
+			n = cb.count
+		}
+		ga, gb := uint32(0), uint32(0)
+		if ca.count != 0 {
+			ga = ca.group
+			ca.count -= n
+		}
+		if cb.count != 0 {
+			gb = cb.group
+			cb.count -= n
+		}
+		out.appendRun(f(ga, gb), n)
+	}
+	out.trim()
+	return out
+}
+
+// update は pos を含むグループを f で書き換える
+// グループを含む run を前後に分けて、書き換えたグループを間に入れる
+func (b *Bitmap) update(pos int, f func(g uint32, mask uint32) uint32) {
+	g := pos / groupBits
+	mask := uint32(1) << (pos % groupBits)
+	out := NewBitmap()
+	idx := 0
+	for _, r := range b.runs() {
+		if g >= idx && g < idx+r.count {
+			out.appendRun(r.group, g-idx)
+			out.appendRun(f(r.group, mask), 1)
+			out.appendRun(r.group, idx+r.count-g-1)
+		} else {
+			out.appendRun(r.group, r.count)
+		}
+		idx += r.count
+	}
+	if g >= idx {
+		out.appendRun(0, g-idx)
+		out.appendRun(f(0, mask), 1)
+	}
+	out.trim()
+	b.words = out.words
+}
+
+// runs は圧縮した語を run の並びに展開する
+func (b *Bitmap) runs() []run {
+	runs := make([]run, len(b.words))
+	for i, w := range b.words {
+		if w&fillFlag == 0 {
+			runs[i] = run{w, 1}
+			continue
+		}
+		group := uint32(0)
+		if w&fillValueFlag != 0 {
+			group = allOnesGroup
+		}
+		runs[i] = run{group, int(w & maxFillCount)}
+	}
+	return runs
+}
+
+// appendRun は group が count 個続く run を末尾に追加する
+// 全て 0 か全て 1 のグループは fill にして、直前の同じ値の fill があればつなげる
+func (b *Bitmap) appendRun(group uint32, count int) {
+	if count <= 0 {
+		return
+	}
+	if group != 0 && group != allOnesGroup {
+		for i := 0; i < count; i++ {
+			b.words = append(b.words, group)
+		}
+		return
+	}
+	fill := fillFlag
+	if group == allOnesGroup {
+		fill |= fillValueFlag
+	}
+	if n := len(b.words); n > 0 && b.words[n-1]&(fillFlag|fillValueFlag) == fill {
+		last := b.words[n-1] & maxFillCount
+		added := uint32(count)
+		if added > maxFillCount-last {
+			added = maxFillCount - last
+		}
+		b.words[n-1] = fill | (last + added)
+		count -= int(added)
+	}
+	for count > 0 {
+		n := count
+		if n > int(maxFillCount) {
+			n = int(maxFillCount)
+		}
+		b.words = append(b.words, fill|uint32(n))
+		count -= n
+	}
+}
+
+// trim は末尾の全て 0 のグループを取り除く
+func (b *Bitmap) trim() {
+	for n := len(b.words); n > 0; n = len(b.words) {
+		w := b.words[n-1]
+		if w != 0 && w&(fillFlag|fillValueFlag) != fillFlag {
+			return
+		}
+		b.words = b.words[:n-1]
+	}
+}
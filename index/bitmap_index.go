@@ -0,0 +1,520 @@
+package index
+
+import (
+	"fmt"
+
+	"github.com/ksrnnb/go-rdb/file"
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/record"
+	"github.com/ksrnnb/go-rdb/tx"
+)
+
+// ビットマップインデックスは値ごとに、その値のレコードの集合を圧縮したビットマップで保存する
+// レコードはテーブルのブロック番号と slot から、 block_number * slotsPerBlock + slot の位置で表す
+// 値の一覧は <インデックス名>_values のテーブルに値とビットマップの先頭のブロック番号の組で保存して、
+// ビットマップは <インデックス名>_bitmap のブロックの chain に保存する
+// 同じテーブルのビットマップインデックスは位置が揃うので、ビットマップのまま AND, OR で組み合わせられる
+
+// bitmap のブロックは先頭に chain の次のブロック番号と語の数をもち、その後ろに圧縮した語を並べる
+// ファイルの先頭のブロックは解放したブロックのリストの先頭のブロック番号だけをもつ
+const (
+	bitmapNextPos     = 0
+	bitmapNumWordsPos = record.IntByteSize
+	bitmapHeader      = 2 * record.IntByteSize
+	bitmapFreeHeadPos = 0
+)
+
+// bitmapNoBlock は chain の終わりや空の free list を表すブロック番号
+// 先頭のブロックは chain に使わないので 0 を使う
+const bitmapNoBlock = 0
+
+// bitmapBlockField は値の一覧のテーブルで、ビットマップの先頭のブロック番号を保存するフィールド名
+const bitmapBlockField = "bitmap_block"
+
+// bitmapEntry は値の一覧のレコード
+type bitmapEntry struct {
+	key  query.Constant
+	head int
+}
+
+type BitmapIndex struct {
+	tx            *tx.Transaction
+	layout        *record.Layout
+	valueLayout   *record.Layout
+	valueTable    string
+	bitmapFile    string
+	slotsPerBlock int
+	// entries は走査する値の一覧で、 cur 番目の値のビットマップの positions を順に返す
+	entries   []bitmapEntry
+	cur       int
+	positions []int
+	pos       int
+}
+
+// NewBitmapIndex はビットマップインデックスを開く
+// slotsPerBlock はテーブルの1ブロックの slot の数で、 rid とビットマップの位置の変換に使う
+func NewBitmapIndex(tx *tx.Transaction, indexName string, layout *record.Layout, slotsPerBlock int) (*BitmapIndex, error) {
+	if slotsPerBlock < 1 {
+		return nil, fmt.Errorf("index %s: invalid slots per block %d", indexName, slotsPerBlock)
+	}
+	schema := record.NewSchema()
+	if err := schema.Add(IndexDataValueField, layout.Schema()); err != nil {
+		return nil, err
+	}
+	schema.AddIntField(bitmapBlockField)
+	bi := &BitmapIndex{
+		tx:            tx,
+		layout:        layout,
+		valueLayout:   record.NewLayout(schema),
+		valueTable:    fmt.Sprintf("%s_values", indexName),
+		bitmapFile:    fmt.Sprintf("%s_bitmap", indexName),
+		slotsPerBlock: slotsPerBlock,
+	}
+	if err := bi.initializeIfNeeded(); err != nil {
+		return nil, err
+	}
+	return bi, nil
+}
+
+// initializeIfNeeded はファイルがない場合に、 free list の先頭のブロックを作成する
+func (bi *BitmapIndex) initializeIfNeeded() error {
+	size, err := bi.tx.Size(bi.bitmapFile)
+	if err != nil {
+		return err
+	}
+	if size != 0 {
+		return nil
+	}
+	blk, err := bi.tx.Append(bi.bitmapFile)
+	if err != nil {
+		return err
+	}
+	if err := bi.tx.Pin(blk); err != nil {
+		return err
+	}
+	if err := bi.tx.SetInt(blk, bitmapFreeHeadPos, bitmapNoBlock, true); err != nil {
+		return err
+	}
+	return bi.tx.Unpin(blk)
+}
+
+// BeforeFirst は searchKey と等しい値のレコードを走査する準備をする
+func (bi *BitmapIndex) BeforeFirst(searchKey query.Constant) error {
+	entry, found, err := bi.findEntry(NormalizeKey(bi.layout, searchKey))
+	if err != nil {
+		return err
+	}
+	bi.entries = nil
+	if found {
+		bi.entries = []bitmapEntry{entry}
+	}
+	bi.resetCursor()
+	return nil
+}
+
+// BeforeFirstAll は全ての値のレコードを、値の一覧の順に走査する準備をする
+func (bi *BitmapIndex) BeforeFirstAll() error {
+	entries, err := bi.allEntries()
+	if err != nil {
+		return err
+	}
+	bi.entries = entries
+	bi.resetCursor()
+	return nil
+}
+
+func (bi *BitmapIndex) resetCursor() {
+	bi.cur = -1
+	bi.positions = nil
+	bi.pos = -1
+}
+
+// Next は現在の値のビットマップの次の位置に進み、ビットマップの終わりでは次の値のビットマップを読む
+func (bi *BitmapIndex) Next() (bool, error) {
+	for bi.pos+1 >= len(bi.positions) {
+		if bi.cur+1 >= len(bi.entries) {
+			return false, nil
+		}
+		bi.cur++
+		b, err := bi.readBitmap(bi.entries[bi.cur].head)
+		if err != nil {
+			return false, err
+		}
+		bi.positions = b.Positions()
+		bi.pos = -1
+	}
+	bi.pos++
+	return true, nil
+}
+
+func (bi *BitmapIndex) GetDataRid() (*record.RecordID, error) {
+	if bi.pos < 0 || bi.pos >= len(bi.positions) {
+		return nil, fmt.Errorf("bitmap index has no current record")
+	}
+	return bi.RecordID(bi.positions[bi.pos]), nil
+}
+
+// GetDataVal は現在のレコードの値を返す
+func (bi *BitmapIndex) GetDataVal() (query.Constant, error) {
+	if bi.cur < 0 || bi.cur >= len(bi.entries) {
+		return query.Constant{}, fmt.Errorf("bitmap index has no current record")
+	}
+	return bi.entries[bi.cur].key, nil
+}
+
+// Bitmap は val と等しい値のレコードの集合を返す
+// そのような値がない場合は空のビットマップを返す
+func (bi *BitmapIndex) Bitmap(val query.Constant) (*Bitmap, error) {
+	entry, found, err := bi.findEntry(NormalizeKey(bi.layout, val))
+	if err != nil || !found {
+		return NewBitmap(), err
+	}
+	return bi.readBitmap(entry.head)
+}
+
+// RecordID はビットマップの位置のレコードの rid を返す
+func (bi *BitmapIndex) RecordID(pos int) *record.RecordID {
+	return record.NewRecordID(pos/bi.slotsPerBlock, pos%bi.slotsPerBlock)
+}
+
+func (bi *BitmapIndex) position(rid *record.RecordID) int {
+	return rid.BlockNumber()*bi.slotsPerBlock + rid.Slot()
+}
+
+// Insert は val のビットマップに rid の位置を追加する
+// 初めての値の場合は、値の一覧にレコードを追加する
+func (bi *BitmapIndex) Insert(val query.Constant, rid *record.RecordID) error {
+	key := NormalizeKey(bi.layout, val)
+	entry, found, err := bi.findEntry(key)
+	if err != nil {
+		return err
+	}
+	b := NewBitmap()
+	if found {
+		b, err = bi.readBitmap(entry.head)
+		if err != nil {
+			return err
+		}
+	}
+	b.Set(bi.position(rid))
+	if found {
+		return bi.writeBitmap(entry.head, b)
+	}
+	head, err := bi.allocateBlock()
+	if err != nil {
+		return err
+	}
+	if err := bi.writeBitmap(head, b); err != nil {
+		return err
+	}
+	return bi.insertEntry(bitmapEntry{key, head})
+}
+
+// Delete は val のビットマップから rid の位置を取り除く
+// ビットマップが空になった値は、値の一覧から削除してビットマップのブロックを解放する
+func (bi *BitmapIndex) Delete(val query.Constant, rid *record.RecordID) error {
+	key := NormalizeKey(bi.layout, val)
+	entry, found, err := bi.findEntry(key)
+	if err != nil || !found {
+		return err
+	}
+	b, err := bi.readBitmap(entry.head)
+	if err != nil {
+		return err
+	}
+	b.Clear(bi.position(rid))
+	if !b.IsEmpty() {
+		return bi.writeBitmap(entry.head, b)
+	}
+	if err := bi.deleteEntry(key); err != nil {
+		return err
+	}
+	return bi.freeChain(entry.head)
+}
+
+// Close は走査の状態を捨てる
+// ブロックは読み書きのたびに unpin しているので、 pin したままのブロックはない
+func (bi *BitmapIndex) Close() error {
+	bi.entries = nil
+	bi.resetCursor()
+	return nil
+}
+
+// findEntry は値の一覧から key のレコードを探す
+func (bi *BitmapIndex) findEntry(key query.Constant) (bitmapEntry, bool, error) {
+	var result bitmapEntry
+	found := false
+	err := bi.scanEntries(func(ts *query.TableScan, e bitmapEntry) (bool, error) {
+		if !e.key.Equals(key) {
+			return true, nil
+		}
+		result, found = e, true
+		return false, nil
+	})
+	return result, found, err
+}
+
+func (bi *BitmapIndex) allEntries() ([]bitmapEntry, error) {
+	entries := make([]bitmapEntry, 0)
+	err := bi.scanEntries(func(ts *query.TableScan, e bitmapEntry) (bool, error) {
+		entries = append(entries, e)
+		return true, nil
+	})
+	return entries, err
+}
+
+func (bi *BitmapIndex) insertEntry(e bitmapEntry) error {
+	ts, err := query.NewTableScan(bi.tx, bi.valueTable, bi.valueLayout)
+	if err != nil {
+		return err
+	}
+	if err := ts.Insert(); err != nil {
+		return err
+	}
+	if err := ts.SetVal(IndexDataValueField, e.key); err != nil {
+		return err
+	}
+	if err := ts.SetInt(bitmapBlockField, e.head); err != nil {
+		return err
+	}
+	return ts.Close()
+}
+
+func (bi *BitmapIndex) deleteEntry(key query.Constant) error {
+	return bi.scanEntries(func(ts *query.TableScan, e bitmapEntry) (bool, error) {
+		if !e.key.Equals(key) {
+			return true, nil
+		}
+		return false, ts.Delete()
+	})
+}
+
+// scanEntries は値の一覧のレコードを順に f に渡して、 f が false を返したら止める
+func (bi *BitmapIndex) scanEntries(f func(ts *query.TableScan, e bitmapEntry) (bool, error)) error {
+	ts, err := query.NewTableScan(bi.tx, bi.valueTable, bi.valueLayout)
+	if err != nil {
+		return err
+	}
+	hasNext, err := ts.Next()
+	if err != nil {
+		return err
+	}
+	for hasNext {
+		key, err := ts.GetVal(IndexDataValueField)
+		if err != nil {
+			return err
+		}
+		head, err := ts.GetInt(bitmapBlockField)
+		if err != nil {
+			return err
+		}
+		cont, err := f(ts, bitmapEntry{key, head})
+		if err != nil {
+			return err
+		}
+		if !cont {
+			break
+		}
+		hasNext, err = ts.Next()
+		if err != nil {
+			return err
+		}
+	}
+	return ts.Close()
+}
+
+// wordsPerBlock は1つのブロックに保存できる語の数を返す
+func (bi *BitmapIndex) wordsPerBlock() int {
+	return (bi.tx.BlockSize() - bitmapHeader) / record.IntByteSize
+}
+
+// readBitmap は head から始まる chain の語を全て読む
+func (bi *BitmapIndex) readBitmap(head int) (*Bitmap, error) {
+	words := make([]uint32, 0)
+	for blkNum := head; blkNum != bitmapNoBlock; {
+		blk := file.NewBlockID(bi.bitmapFile, blkNum)
+		if err := bi.tx.Pin(blk); err != nil {
+			return nil, err
+		}
+		n, err := bi.tx.GetInt(blk, bitmapNumWordsPos)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < n; i++ {
+			w, err := bi.tx.GetInt(blk, bitmapHeader+i*record.IntByteSize)
+			if err != nil {
+				return nil, err
+			}
+			words = append(words, uint32(w))
+		}
+		next, err := bi.tx.GetInt(blk, bitmapNextPos)
+		if err != nil {
+			return nil, err
+		}
+		if err := bi.tx.Unpin(blk); err != nil {
+			return nil, err
+		}
+		blkNum = next
+	}
+	return NewBitmapFromWords(words), nil
+}
+
+// writeBitmap は head から始まる chain に b の語を書く
+// chain のブロックが足りない場合は追加して、余ったブロックは解放する
+// 先頭のブロックは値の一覧から参照しているので、空のビットマップでも残す
+func (bi *BitmapIndex) writeBitmap(head int, b *Bitmap) error {
+	words := b.Words()
+	wpb := bi.wordsPerBlock()
+	blkNum := head
+	for {
+		blk := file.NewBlockID(bi.bitmapFile, blkNum)
+		if err := bi.tx.Pin(blk); err != nil {
+			return err
+		}
+		n := len(words)
+		if n > wpb {
+			n = wpb
+		}
+		for i, w := range words[:n] {
+			if err := bi.tx.SetInt(blk, bitmapHeader+i*record.IntByteSize, int(int32(w)), true); err != nil {
+				return err
+			}
+		}
+		if err := bi.tx.SetInt(blk, bitmapNumWordsPos, n, true); err != nil {
+			return err
+		}
+		words = words[n:]
+		next, err := bi.tx.GetInt(blk, bitmapNextPos)
+		if err != nil {
+			return err
+		}
+		if len(words) == 0 {
+			if err := bi.tx.SetInt(blk, bitmapNextPos, bitmapNoBlock, true); err != nil {
+				return err
+			}
+			if err := bi.tx.Unpin(blk); err != nil {
+				return err
+			}
+			return bi.freeChain(next)
+		}
+		if next == bitmapNoBlock {
+			next, err = bi.allocateBlock()
+			if err != nil {
+				return err
+			}
+			if err := bi.tx.SetInt(blk, bitmapNextPos, next, true); err != nil {
+				return err
+			}
+		}
+		if err := bi.tx.Unpin(blk); err != nil {
+			return err
+		}
+		blkNum = next
+	}
+}
+
+// allocateBlock は解放したブロックがあれば再利用して、なければファイルの末尾に追加する
+// 返すブロックは chain の終わりの空のブロックになっている
+func (bi *BitmapIndex) allocateBlock() (int, error) {
+	headBlk := file.NewBlockID(bi.bitmapFile, 0)
+	if err := bi.tx.Pin(headBlk); err != nil {
+		return 0, err
+	}
+	free, err := bi.tx.GetInt(headBlk, bitmapFreeHeadPos)
+	if err != nil {
+		return 0, err
+	}
+	var blk file.BlockID
+	if free == bitmapNoBlock {
+		blk, err = bi.tx.Append(bi.bitmapFile)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		blk = file.NewBlockID(bi.bitmapFile, free)
+		next, err := bi.getInt(blk, bitmapNextPos)
+		if err != nil {
+			return 0, err
+		}
+		if err := bi.tx.SetInt(headBlk, bitmapFreeHeadPos, next, true); err != nil {
+			return 0, err
+		}
+	}
+	if err := bi.tx.Unpin(headBlk); err != nil {
+		return 0, err
+	}
+
+	if err := bi.tx.Pin(blk); err != nil {
+		return 0, err
+	}
+	if err := bi.tx.SetInt(blk, bitmapNextPos, bitmapNoBlock, true); err != nil {
+		return 0, err
+	}
+	if err := bi.tx.SetInt(blk, bitmapNumWordsPos, 0, true); err != nil {
+		return 0, err
+	}
+	return blk.Number(), bi.tx.Unpin(blk)
+}
+
+// getInt は blk を pin して offset の整数を読む
+func (bi *BitmapIndex) getInt(blk file.BlockID, offset int) (int, error) {
+	if err := bi.tx.Pin(blk); err != nil {
+		return 0, err
+	}
+	v, err := bi.tx.GetInt(blk, offset)
+	if err != nil {
+		return 0, err
+	}
+	return v, bi.tx.Unpin(blk)
+}
+
+// freeChain は head から始まる chain のブロックを、解放したブロックのリストにつなぐ
+func (bi *BitmapIndex) freeChain(head int) error {
+	for blkNum := head; blkNum != bitmapNoBlock; {
+		headBlk := file.NewBlockID(bi.bitmapFile, 0)
+		blk := file.NewBlockID(bi.bitmapFile, blkNum)
+		for _, b := range []file.BlockID{headBlk, blk} {
+			if err := bi.tx.Pin(b); err != nil {
+				return err
+			}
+		}
+		free, err := bi.tx.GetInt(headBlk, bitmapFreeHeadPos)
+		if err != nil {
+			return err
+		}
+		next, err := bi.tx.GetInt(blk, bitmapNextPos)
+		if err != nil {
+			return err
+		}
+		if err := bi.tx.SetInt(blk, bitmapNextPos, free, true); err != nil {
+			return err
+		}
+		if err := bi.tx.SetInt(blk, bitmapNumWordsPos, 0, true); err != nil {
+			return err
+		}
+		if err := bi.tx.SetInt(headBlk, bitmapFreeHeadPos, blkNum, true); err != nil {
+			return err
+		}
+		for _, b := range []file.BlockID{headBlk, blk} {
+			if err := bi.tx.Unpin(b); err != nil {
+				return err
+			}
+		}
+		blkNum = next
+	}
+	return nil
+}
+
+// SearchCostBitmapIndex は値の一覧と、1つの値のビットマップを読むブロックアクセス数を返す
+// ビットマップはレコードごとに1ビットを圧縮せずに保存した大きさとみなす
+func SearchCostBitmapIndex(numRecords int, blockSize int) int {
+	wordsPerBlock := (blockSize - bitmapHeader) / record.IntByteSize
+	if wordsPerBlock < 1 {
+		wordsPerBlock = 1
+	}
+	bitmapBlocks := ceilDiv(ceilDiv(numRecords, groupBits), wordsPerBlock)
+	if bitmapBlocks < 1 {
+		bitmapBlocks = 1
+	}
+	return 1 + bitmapBlocks
+}
@@ -0,0 +1,329 @@
+package btree
+
+import (
+	"errors"
+
+	"github.com/ksrnnb/go-rdb/file"
+	"github.com/ksrnnb/go-rdb/index"
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/record"
+	"github.com/ksrnnb/go-rdb/sqlstate"
+)
+
+var (
+	ErrNotEmpty   = errors.New("btree: bulk load requires an empty index")
+	ErrKeyTooLong = errors.New("btree: key is too long to store two entries in a page")
+)
+
+// BulkLoad は空の B-tree に、キーの昇順に並んだ src のインデックスレコードを全て登録する
+// leaf を先頭から順に埋めてから、各 leaf の先頭のキーでディレクトリを下の階層から順に作るので、
+// Insert のようにレコードごとにルートから探索したり、ページ内のレコードをずらしたりしない
+func (bti *BTreeIndex) BulkLoad(src query.Scanner, unique bool) error {
+	if err := bti.Close(); err != nil {
+		return err
+	}
+
+	root, err := NewBTreePage(bti.tx, bti.rootBlk, bti.dirLayout)
+	if err != nil {
+		return err
+	}
+	minVal, err := root.GetDataValue(0)
+	if err != nil {
+		return err
+	}
+	if err := root.Close(); err != nil {
+		return err
+	}
+
+	leaf, err := NewBTreePage(bti.tx, file.NewBlockID(bti.leafTable, 0), bti.leafLayout)
+	if err != nil {
+		return err
+	}
+	empty, err := bti.isEmpty(leaf)
+	if err != nil {
+		return err
+	}
+	if !empty {
+		if err := leaf.Close(); err != nil {
+			return err
+		}
+		return ErrNotEmpty
+	}
+	if leaf.maxRecords() < 2 {
+		if err := leaf.Close(); err != nil {
+			return err
+		}
+		return ErrKeyTooLong
+	}
+	bl := &bulkLoader{
+		leaf:    leaf,
+		entries: []DirectoryEntry{NewDirectoryEntry(minVal, 0)},
+	}
+
+	if err := src.BeforeFirst(); err != nil {
+		return err
+	}
+	keyFields := index.DataValueFields(bti.leafLayout.Schema())
+	hasNext, err := src.Next()
+	if err != nil {
+		return err
+	}
+	for hasNext {
+		key, rid, err := indexRecord(src, keyFields)
+		if err != nil {
+			return err
+		}
+		if unique && !bl.lastKey.IsUnknown() && key.Equals(bl.lastKey) {
+			return sqlstate.Errorf(sqlstate.UniqueViolation, "could not create unique index: key %s is duplicated", key)
+		}
+		if err := bl.add(key, rid); err != nil {
+			return err
+		}
+		hasNext, err = src.Next()
+		if err != nil {
+			return err
+		}
+	}
+	if err := bl.close(); err != nil {
+		return err
+	}
+	return bti.buildDirectory(bl.entries)
+}
+
+// isEmpty は leaf が1つだけで、レコードが登録されていないかどうかを返す
+func (bti *BTreeIndex) isEmpty(leaf *BTreePage) (bool, error) {
+	size, err := fileSize(bti.tx, bti.leafTable)
+	if err != nil {
+		return false, err
+	}
+	numRecords, err := leaf.getNumRecords()
+	if err != nil {
+		return false, err
+	}
+	return size == 1 && numRecords == 0, nil
+}
+
+// buildDirectory は level-0 のディレクトリエントリから、1つのページに収まるまで上の階層のディレクトリを作る
+// ルートのブロックは変えられないので、最後に残ったエントリをルートに書き込む
+func (bti *BTreeIndex) buildDirectory(entries []DirectoryEntry) error {
+	root, err := NewBTreePage(bti.tx, bti.rootBlk, bti.dirLayout)
+	if err != nil {
+		return err
+	}
+	maxRecords := root.maxRecords()
+	if maxRecords < 2 {
+		return ErrKeyTooLong
+	}
+	level := 0
+	for len(entries) > maxRecords {
+		parents := make([]DirectoryEntry, 0, len(entries)/maxRecords+1)
+		for i := 0; i < len(entries); i += maxRecords {
+			end := i + maxRecords
+			if end > len(entries) {
+				end = len(entries)
+			}
+			blk, err := root.AppendNew(PageFlag(level))
+			if err != nil {
+				return err
+			}
+			page, err := NewBTreePage(bti.tx, blk, bti.dirLayout)
+			if err != nil {
+				return err
+			}
+			if err := appendDirectories(page, entries[i:end]); err != nil {
+				return err
+			}
+			if err := page.Close(); err != nil {
+				return err
+			}
+			parents = append(parents, NewDirectoryEntry(entries[i].DataValue(), blk.Number()))
+		}
+		entries = parents
+		level++
+	}
+
+	if err := root.setNumRecords(0); err != nil {
+		return err
+	}
+	if err := root.SetFlag(PageFlag(level)); err != nil {
+		return err
+	}
+	if err := appendDirectories(root, entries); err != nil {
+		return err
+	}
+	return root.Close()
+}
+
+func appendDirectories(page *BTreePage, entries []DirectoryEntry) error {
+	for i, de := range entries {
+		if err := page.insertDirectory(i, de.DataValue(), de.BlockNumber()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexRecord は src の現在のレコードのキーと、データレコードの ID を返す
+func indexRecord(src query.Scanner, keyFields []string) (query.Constant, *record.RecordID, error) {
+	vals := make([]query.Constant, len(keyFields))
+	for i, fn := range keyFields {
+		v, err := src.GetVal(fn)
+		if err != nil {
+			return query.Constant{}, nil, err
+		}
+		vals[i] = v
+	}
+	key := vals[0]
+	if len(vals) > 1 {
+		key = query.NewTupleConstant(vals)
+	}
+	blkNum, err := src.GetInt(index.IndexBlockNumberField)
+	if err != nil {
+		return query.Constant{}, nil, err
+	}
+	id, err := src.GetInt(index.IndexIdField)
+	if err != nil {
+		return query.Constant{}, nil, err
+	}
+	return key, record.NewRecordID(blkNum, id), nil
+}
+
+// bulkLoader は leaf を先頭から順に埋める
+// 同じキーのレコードは Insert と同じように1つの leaf に収めて、収まらない場合は overflow block に移す
+type bulkLoader struct {
+	leaf *BTreePage
+	// overflow は lastKey のレコードを書き込んでいる overflow block で、ない場合は nil
+	overflow *BTreePage
+	lastKey  query.Constant
+	// groupStart は leaf の中で lastKey のレコードが始まる slot
+	groupStart int
+	// entries は level-0 のディレクトリエントリ
+	entries []DirectoryEntry
+}
+
+func (bl *bulkLoader) add(key query.Constant, rid *record.RecordID) error {
+	sameKey := !bl.lastKey.IsUnknown() && key.Equals(bl.lastKey)
+	if bl.overflow != nil {
+		if sameKey {
+			return bl.addOverflow(key, rid)
+		}
+		if err := bl.overflow.Close(); err != nil {
+			return err
+		}
+		bl.overflow = nil
+	}
+
+	numRecords, err := bl.leaf.getNumRecords()
+	if err != nil {
+		return err
+	}
+	if !sameKey {
+		bl.groupStart = numRecords
+	}
+	bl.lastKey = key
+	if numRecords < bl.leaf.maxRecords() {
+		return bl.leaf.insertLeaf(numRecords, key, rid)
+	}
+
+	switch {
+	case !sameKey:
+		// 新しいキーは新しい leaf の先頭に書き込む
+		blk, err := bl.leaf.AppendNew(NoOverFlow)
+		if err != nil {
+			return err
+		}
+		if err := bl.switchLeaf(key, blk); err != nil {
+			return err
+		}
+	case bl.groupStart > 0:
+		// 同じキーのレコードが2つの leaf にまたがらないように、キーの先頭から新しい leaf に移す
+		blk, err := bl.leaf.Split(bl.groupStart, NoOverFlow)
+		if err != nil {
+			return err
+		}
+		if err := bl.switchLeaf(key, blk); err != nil {
+			return err
+		}
+	default:
+		// leaf の全てのレコードが同じキーの場合は、先頭のレコード以外を overflow block に移す
+		flag, err := bl.leaf.GetFlag()
+		if err != nil {
+			return err
+		}
+		blk, err := bl.leaf.Split(1, flag)
+		if err != nil {
+			return err
+		}
+		if err := bl.leaf.SetFlag(PageFlag(blk.Number())); err != nil {
+			return err
+		}
+		overflow, err := NewBTreePage(bl.leaf.tx, blk, bl.leaf.layout)
+		if err != nil {
+			return err
+		}
+		bl.overflow = overflow
+		return bl.addOverflow(key, rid)
+	}
+
+	numRecords, err = bl.leaf.getNumRecords()
+	if err != nil {
+		return err
+	}
+	return bl.leaf.insertLeaf(numRecords, key, rid)
+}
+
+// addOverflow は overflow block にレコードを書き込む
+// overflow block がいっぱいの場合は、新しい overflow block を leaf の overflow chain の先頭につなぐ
+func (bl *bulkLoader) addOverflow(key query.Constant, rid *record.RecordID) error {
+	numRecords, err := bl.overflow.getNumRecords()
+	if err != nil {
+		return err
+	}
+	if numRecords >= bl.overflow.maxRecords() {
+		flag, err := bl.leaf.GetFlag()
+		if err != nil {
+			return err
+		}
+		blk, err := bl.overflow.AppendNew(flag)
+		if err != nil {
+			return err
+		}
+		if err := bl.leaf.SetFlag(PageFlag(blk.Number())); err != nil {
+			return err
+		}
+		if err := bl.overflow.Close(); err != nil {
+			return err
+		}
+		overflow, err := NewBTreePage(bl.leaf.tx, blk, bl.leaf.layout)
+		if err != nil {
+			return err
+		}
+		bl.overflow = overflow
+		numRecords = 0
+	}
+	return bl.overflow.insertLeaf(numRecords, key, rid)
+}
+
+// switchLeaf は書き込む leaf を blk に変えて、 key を先頭のキーとするディレクトリエントリを追加する
+func (bl *bulkLoader) switchLeaf(key query.Constant, blk file.BlockID) error {
+	if err := bl.leaf.Close(); err != nil {
+		return err
+	}
+	leaf, err := NewBTreePage(bl.leaf.tx, blk, bl.leaf.layout)
+	if err != nil {
+		return err
+	}
+	bl.leaf = leaf
+	bl.groupStart = 0
+	bl.entries = append(bl.entries, NewDirectoryEntry(key, blk.Number()))
+	return nil
+}
+
+func (bl *bulkLoader) close() error {
+	if bl.overflow != nil {
+		if err := bl.overflow.Close(); err != nil {
+			return err
+		}
+	}
+	return bl.leaf.Close()
+}
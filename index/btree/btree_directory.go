@@ -14,58 +14,29 @@ type BTreeDirectory struct {
 	fileName string
 }
 
+// NewBTreeDirectory は blk のディレクトリに共有の latch をかけて、探索するために開く
 func NewBTreeDirectory(tx *tx.Transaction, blk file.BlockID, layout *record.Layout) (*BTreeDirectory, error) {
-	btp, err := NewBTreePage(tx, blk, layout)
+	btp, err := NewBTreePageForRead(tx, blk, layout)
 	if err != nil {
 		return nil, err
 	}
+	return newBTreeDirectoryFromPage(btp, layout), nil
+}
+
+// newBTreeDirectoryFromPage は排他の latch をかけて開いたページを、エントリを挿入するディレクトリとして扱う
+func newBTreeDirectoryFromPage(btp *BTreePage, layout *record.Layout) *BTreeDirectory {
 	return &BTreeDirectory{
-		tx:       tx,
+		tx:       btp.tx,
 		layout:   layout,
 		contents: btp,
-		fileName: blk.FileName(),
-	}, nil
+		fileName: btp.currentBlk.FileName(),
+	}
 }
 
 func (btd *BTreeDirectory) Close() error {
 	return btd.contents.Close()
 }
 
-// Search はルートから開始して tree を level-0 のディレクトリのブロックまで下る
-// searchKey を含む leaf のブロック番号を返す
-func (btd *BTreeDirectory) Search(searchKey query.Constant) (int, error) {
-	childBlk, err := btd.findChildBlock(searchKey)
-	if err != nil {
-		return 0, err
-	}
-	level, err := btd.contents.GetFlag()
-	if err != nil {
-		return 0, err
-	}
-	for level > 0 {
-		err := btd.contents.Close()
-		if err != nil {
-			return 0, err
-		}
-		btp, err := NewBTreePage(btd.tx, childBlk, btd.layout)
-		if err != nil {
-			return 0, err
-		}
-		btd.contents = btp
-		childBlk, err = btd.findChildBlock(searchKey)
-		if err != nil {
-			return 0, err
-		}
-		newLevel, err := btd.contents.GetFlag()
-		if err != nil {
-			return 0, err
-		}
-		level = newLevel
-	}
-
-	return childBlk.Number(), nil
-}
-
 // MakeNewRoot は root page への insert が non null だった場合に呼ばれる
 func (btd *BTreeDirectory) MakeNewRoot(de DirectoryEntry) error {
 	firstVal, err := btd.contents.GetDataValue(0)
@@ -92,37 +63,6 @@ func (btd *BTreeDirectory) MakeNewRoot(de DirectoryEntry) error {
 	return btd.contents.SetFlag(level + 1)
 }
 
-// Insert はルートから開始して再帰的に tree を level-0 のディレクトリのブロックまで下って
-// ディレクトリエントリを追加する
-func (btd *BTreeDirectory) Insert(de DirectoryEntry) (DirectoryEntry, error) {
-	level, err := btd.contents.GetFlag()
-	if err != nil {
-		return emptyDir, err
-	}
-	if level == 0 {
-		return btd.insertEntry(de)
-	}
-	childBlk, err := btd.findChildBlock(de.DataValue())
-	if err != nil {
-		return emptyDir, err
-	}
-	child, err := NewBTreeDirectory(btd.tx, childBlk, btd.layout)
-	if err != nil {
-		return emptyDir, err
-	}
-	myEntry, err := child.Insert(de)
-	if err != nil {
-		return emptyDir, err
-	}
-	if err := child.Close(); err != nil {
-		return emptyDir, err
-	}
-	if myEntry.IsZero() {
-		return emptyDir, nil
-	}
-	return btd.insertEntry(myEntry)
-}
-
 // insertEntry はページにディレクトリエントリを挿入する
 // 分割が発生した場合は新しいディレクトリエントリを返す
 func (btd *BTreeDirectory) insertEntry(de DirectoryEntry) (DirectoryEntry, error) {
@@ -149,10 +89,11 @@ func (btd *BTreeDirectory) insertEntry(de DirectoryEntry) (DirectoryEntry, error
 	if err != nil {
 		return emptyDir, err
 	}
-	splitPos, err := btd.contents.getNumRecords()
+	numRecords, err := btd.contents.getNumRecords()
 	if err != nil {
 		return emptyDir, err
 	}
+	splitPos := numRecords / 2
 	splitVal, err := btd.contents.GetDataValue(splitPos)
 	if err != nil {
 		return emptyDir, err
@@ -164,21 +105,175 @@ func (btd *BTreeDirectory) insertEntry(de DirectoryEntry) (DirectoryEntry, error
 	return NewDirectoryEntry(splitVal, newBlk.Number()), nil
 }
 
-func (btd *BTreeDirectory) findChildBlock(searchKey query.Constant) (file.BlockID, error) {
-	slot, err := btd.contents.FindSlotBefore(searchKey)
+// SearchEntry は searchKey を含む leaf まで下って、 level-0 のディレクトリエントリを visit に渡す
+// 子のページに latch をかけてから親のページの latch を外すので、 visit は level-0 のディレクトリに latch をかけたまま呼ぶ
+// visit で leaf を読み込めば、途中で他のトランザクションが leaf を分割したり併合したりしない
+func (btd *BTreeDirectory) SearchEntry(searchKey query.Constant, visit func(DirectoryEntry) error) error {
+	return btd.descend(btd.contents.currentBlk, func(page *BTreePage) (int, error) {
+		return childSlot(page, searchKey)
+	}, visit)
+}
+
+// EdgeEntry は先頭か末尾の leaf の level-0 のディレクトリエントリを visit に渡す
+func (btd *BTreeDirectory) EdgeEntry(last bool, visit func(DirectoryEntry) error) error {
+	return btd.descend(btd.contents.currentBlk, func(page *BTreePage) (int, error) {
+		return edgeSlot(page, last)
+	}, visit)
+}
+
+// SiblingEntry は leafKey のディレクトリエントリが指す leaf の、次 (forward が false の場合は前) の leaf のエントリを visit に渡す
+// leaf は兄弟へのポインタを持たないので、親のディレクトリをたどって探す
+// 兄弟がない場合は visit を呼ばずに false を返す
+func (btd *BTreeDirectory) SiblingEntry(leafKey query.Constant, forward bool, visit func(DirectoryEntry) error) (bool, error) {
+	slot, err := childSlot(btd.contents, leafKey)
 	if err != nil {
-		return file.BlockID{}, err
+		return false, err
 	}
-	v, err := btd.contents.GetDataValue(slot + 1)
+	level, err := btd.contents.GetFlag()
 	if err != nil {
-		return file.BlockID{}, err
+		return false, err
 	}
-	if v.Equals(searchKey) {
+	if level > 0 {
+		childBlk, err := btd.contents.getChildNum(slot)
+		if err != nil {
+			return false, err
+		}
+		child, err := NewBTreeDirectory(btd.tx, file.NewBlockID(btd.fileName, childBlk), btd.layout)
+		if err != nil {
+			return false, err
+		}
+		ok, err := child.SiblingEntry(leafKey, forward, visit)
+		if err != nil {
+			child.Close()
+			return false, err
+		}
+		if err := child.Close(); err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+
+	numRecords, err := btd.contents.getNumRecords()
+	if err != nil {
+		return false, err
+	}
+	if forward {
 		slot++
+	} else {
+		slot--
 	}
-	blkNum, err := btd.contents.getChildNum(slot)
+	if slot < 0 || slot >= numRecords {
+		return false, nil
+	}
+	if level == 0 {
+		de, err := entry(btd.contents, slot)
+		if err != nil {
+			return false, err
+		}
+		return true, visit(de)
+	}
+
+	// 隣の部分木の端の leaf を探す
+	childBlk, err := btd.contents.getChildNum(slot)
 	if err != nil {
-		return file.BlockID{}, err
+		return false, err
 	}
-	return file.NewBlockID(btd.fileName, blkNum), nil
+	return true, btd.descend(file.NewBlockID(btd.fileName, childBlk), func(page *BTreePage) (int, error) {
+		return edgeSlot(page, !forward)
+	}, visit)
+}
+
+// descend は blk のディレクトリから chooseSlot で選んだ子をたどって level-0 のディレクトリまで下り、選んだエントリを visit に渡す
+// visit がエラーを返した場合は、ロックを待てるように level-0 のディレクトリを閉じてから返す
+func (btd *BTreeDirectory) descend(blk file.BlockID, chooseSlot func(page *BTreePage) (int, error), visit func(DirectoryEntry) error) error {
+	page, err := NewBTreePageForRead(btd.tx, blk, btd.layout)
+	if err != nil {
+		return err
+	}
+	for {
+		slot, err := chooseSlot(page)
+		if err != nil {
+			return err
+		}
+		level, err := page.GetFlag()
+		if err != nil {
+			return err
+		}
+		if level == 0 {
+			de, err := entry(page, slot)
+			if err != nil {
+				return err
+			}
+			if err := visit(de); err != nil {
+				page.Close()
+				return err
+			}
+			return page.Close()
+		}
+		childBlk, err := page.getChildNum(slot)
+		if err != nil {
+			return err
+		}
+		child, err := NewBTreePageForRead(btd.tx, file.NewBlockID(btd.fileName, childBlk), btd.layout)
+		if err != nil {
+			return err
+		}
+		if err := page.Close(); err != nil {
+			return err
+		}
+		page = child
+	}
+}
+
+func entry(page *BTreePage, slot int) (DirectoryEntry, error) {
+	val, err := page.GetDataValue(slot)
+	if err != nil {
+		return emptyDir, err
+	}
+	blkNum, err := page.getChildNum(slot)
+	if err != nil {
+		return emptyDir, err
+	}
+	return NewDirectoryEntry(val, blkNum), nil
+}
+
+func edgeSlot(page *BTreePage, last bool) (int, error) {
+	if !last {
+		return 0, nil
+	}
+	numRecords, err := page.getNumRecords()
+	if err != nil {
+		return 0, err
+	}
+	return numRecords - 1, nil
+}
+
+// childSlot は searchKey を含む子を指す slot を返す
+func childSlot(page *BTreePage, searchKey query.Constant) (int, error) {
+	slot, err := page.FindSlotBefore(searchKey)
+	if err != nil {
+		return 0, err
+	}
+	numRecords, err := page.getNumRecords()
+	if err != nil {
+		return 0, err
+	}
+	if slot+1 < numRecords {
+		v, err := page.GetDataValue(slot + 1)
+		if err != nil {
+			return 0, err
+		}
+		if v.Equals(searchKey) {
+			slot++
+		}
+	}
+	return slot, nil
+}
+
+// pathEntry は更新するためにルートから leaf まで下るときに、 latch をかけたまま残したディレクトリのページと、選んだ子の slot
+type pathEntry struct {
+	page *BTreePage
+	slot int
 }
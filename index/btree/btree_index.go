@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 
+	"github.com/google/uuid"
 	"github.com/ksrnnb/go-rdb/file"
 	"github.com/ksrnnb/go-rdb/index"
 	"github.com/ksrnnb/go-rdb/query"
@@ -11,21 +12,28 @@ import (
 	"github.com/ksrnnb/go-rdb/tx"
 )
 
-// BTreeIndex は leaf を保持する
-// leaf は現在のインデックスレコードを track している
+// BTreeIndex はルートから leaf まで latch をかけながら下って、インデックスレコードを読み書きする
+// 読み込むときは共有の latch を、書き込むときは排他の latch をかけて、子のページに latch をかけてから親の latch を外す
+// 書き込みでは子のページが分割や併合をしないとわかった時点で、それより上のページの latch を外す
+// トランザクションの間の分離は、 leaf が受け持つキーの範囲のロックで保つ
+// ページはトランザクションの終わりを待たずに他のトランザクションと共有するので、
+// 書き込みは StartAction と EndAction で囲んで、ロールバックではページを元に戻さずにインデックスレコードを論理的に削除するか追加し直す
 type BTreeIndex struct {
 	tx         *tx.Transaction
+	indexName  string
 	dirLayout  *record.Layout
 	leafLayout *record.Layout
 	dirTable   string
 	leafTable  string
-	leaf       *BTreeLeaf
 	rootBlk    file.BlockID
+	// cursor は BeforeFirst か BeforeFirstRange で走査を始めている場合だけ nil でない
+	cursor *rangeCursor
 }
 
 func NewBTreeIndex(tx *tx.Transaction, indexName string, leafLayout *record.Layout) (*BTreeIndex, error) {
 	bti := &BTreeIndex{
 		tx:         tx,
+		indexName:  indexName,
 		leafLayout: leafLayout,
 		leafTable:  fmt.Sprintf("%s_leaf", indexName),
 		dirTable:   fmt.Sprintf("%s_directory", indexName),
@@ -42,102 +50,297 @@ func NewBTreeIndex(tx *tx.Transaction, indexName string, leafLayout *record.Layo
 	return bti, nil
 }
 
-// BeforeFirst はルートからブロックを探索して leaf を初期化する
+// BeforeFirst は searchKey のインデックスレコードを走査する準備をする
 func (bti *BTreeIndex) BeforeFirst(searchKey query.Constant) error {
-	err := bti.Close()
-	if err != nil {
-		return err
-	}
-	rootDir, err := NewBTreeDirectory(bti.tx, bti.rootBlk, bti.dirLayout)
-	if err != nil {
-		return err
-	}
-	blkNum, err := rootDir.Search(searchKey)
-	if err != nil {
-		return err
-	}
-	// leaf を生成したら rootDir は不要なのでクローズする
-	err = rootDir.Close()
-	if err != nil {
+	return bti.BeforeFirstRange(query.NewPointRange(searchKey), false)
+}
+
+// BeforeFirstRange は走査を始める端の leaf を探して、範囲の走査を始める
+// 昇順の場合は下端、降順の場合は上端を含む leaf から始めて、端がない場合は先頭か末尾の leaf から始める
+func (bti *BTreeIndex) BeforeFirstRange(r query.Range, descending bool) error {
+	if err := bti.Close(); err != nil {
 		return err
 	}
-	leafBlk := file.NewBlockID(bti.leafTable, blkNum)
-	leaf, err := NewBTreeLeaf(bti.tx, leafBlk, bti.leafLayout, searchKey)
+	r = index.NormalizeRange(bti.leafLayout, r)
+	start := r.Low()
+	if descending {
+		start = r.High()
+	}
+	cursor := &rangeCursor{
+		tx:         bti.tx,
+		leafLayout: bti.leafLayout,
+		dirLayout:  bti.dirLayout,
+		leafTable:  bti.leafTable,
+		rootBlk:    bti.rootBlk,
+		r:          r,
+		descending: descending,
+	}
+	err := withLockWait(bti.tx, func() error {
+		rootDir, err := NewBTreeDirectory(bti.tx, bti.rootBlk, bti.dirLayout)
+		if err != nil {
+			return err
+		}
+		if start.IsOpen() {
+			err = rootDir.EdgeEntry(descending, cursor.load)
+		} else {
+			err = rootDir.SearchEntry(start.Value(), cursor.load)
+		}
+		if err != nil {
+			rootDir.Close()
+			return err
+		}
+		return rootDir.Close()
+	})
 	if err != nil {
 		return err
 	}
-	bti.leaf = leaf
+	bti.cursor = cursor
 	return nil
 }
 
+// BeforeFirstAll は先頭の leaf から全てのインデックスレコードを走査する準備をする
+func (bti *BTreeIndex) BeforeFirstAll() error {
+	return bti.BeforeFirstRange(query.NewRange(query.Bound{}, query.Bound{}), false)
+}
+
 func (bti *BTreeIndex) Next() (bool, error) {
-	return bti.leaf.HasNext()
+	return bti.cursor.next()
 }
 
 func (bti *BTreeIndex) GetDataRid() (*record.RecordID, error) {
-	return bti.leaf.GetDataRid()
+	return bti.cursor.current.rid, nil
+}
+
+// GetDataVal は現在のインデックスレコードの data_value を返す
+func (bti *BTreeIndex) GetDataVal() (query.Constant, error) {
+	return bti.cursor.current.key, nil
 }
 
 // Insert はインデックスレコードを追加し
 // split された場合は新しい leaf のインデックスレコードを追加する
 func (bti *BTreeIndex) Insert(dataVal query.Constant, rid *record.RecordID) error {
-	if err := bti.BeforeFirst(dataVal); err != nil {
+	if err := bti.Close(); err != nil {
 		return err
 	}
-	de, err := bti.leaf.Insert(rid)
-	if err != nil {
+	dataVal = index.NormalizeKey(bti.leafLayout, dataVal)
+	if err := bti.tx.StartAction(); err != nil {
 		return err
 	}
-	if err := bti.leaf.Close(); err != nil {
+	err := withLockWait(bti.tx, func() error {
+		return bti.insert(dataVal, rid)
+	})
+	if err != nil {
 		return err
 	}
-	if de.IsZero() {
-		return nil
-	}
+	return bti.tx.EndAction(undoName, encodeUndo(bti.indexName, bti.leafLayout, dataVal, rid, true))
+}
 
-	// leaf page が split された場合は、新しい leaf のインデックスレコードを追加する
-	rootDir, err := NewBTreeDirectory(bti.tx, bti.rootBlk, bti.dirLayout)
+func (bti *BTreeIndex) insert(dataVal query.Constant, rid *record.RecordID) (err error) {
+	path, leaf, err := bti.latchPath(dataVal, func(page *BTreePage, isLeaf bool) (bool, error) {
+		return page.canInsert(isLeaf)
+	})
 	if err != nil {
 		return err
 	}
-	e2, err := rootDir.Insert(de)
+	// 途中で失敗した場合は、まだ閉じていないページを閉じて latch を外す
+	defer func() {
+		if err != nil {
+			leaf.Close()
+			closePath(path)
+		}
+	}()
+	de, err := leaf.Insert(rid)
 	if err != nil {
 		return err
 	}
-	if !e2.IsZero() {
-		err := rootDir.MakeNewRoot(e2)
-		if err != nil {
+	if err := leaf.Close(); err != nil {
+		return err
+	}
+
+	// leaf page が split された場合は、新しい leaf のインデックスレコードを latch をかけたまま残したディレクトリに追加する
+	for i := len(path) - 1; i >= 0; i-- {
+		dir := newBTreeDirectoryFromPage(path[i].page, bti.dirLayout)
+		if !de.IsZero() {
+			de, err = dir.insertEntry(de)
+			if err != nil {
+				return err
+			}
+			if !de.IsZero() && i == 0 {
+				// 分割しないページが見つからなかった場合は、ルートまで latch をかけたままにしている
+				if err := dir.MakeNewRoot(de); err != nil {
+					return err
+				}
+			}
+		}
+		if err := dir.Close(); err != nil {
 			return err
 		}
 	}
-	return rootDir.Close()
+	return nil
 }
 
 // Delete() は leaf からインデックスレコードを削除する
-// ディレクトリの再構築は行わない
+// leaf のレコードが少なくなった場合は、兄弟の leaf と併合するか再分配して、必要であればディレクトリも併合する
 func (bti *BTreeIndex) Delete(dataVal query.Constant, rid *record.RecordID) error {
-	if err := bti.BeforeFirst(dataVal); err != nil {
+	if err := bti.Close(); err != nil {
 		return err
 	}
-	if err := bti.leaf.Delete(rid); err != nil {
+	dataVal = index.NormalizeKey(bti.leafLayout, dataVal)
+	if err := bti.tx.StartAction(); err != nil {
+		return err
+	}
+	err := withLockWait(bti.tx, func() error {
+		return bti.delete(dataVal, rid)
+	})
+	if err != nil {
 		return err
 	}
-	return bti.leaf.Close()
+	return bti.tx.EndAction(undoName, encodeUndo(bti.indexName, bti.leafLayout, dataVal, rid, false))
 }
 
-func (bti *BTreeIndex) Close() error {
-	if bti.leaf == nil {
-		return nil
+func (bti *BTreeIndex) delete(dataVal query.Constant, rid *record.RecordID) (err error) {
+	path, leaf, err := bti.latchPath(dataVal, func(page *BTreePage, isLeaf bool) (bool, error) {
+		return page.canDelete(isLeaf)
+	})
+	if err != nil {
+		return err
+	}
+	// 途中で失敗した場合は、まだ閉じていないページを閉じて latch を外す
+	defer func() {
+		if err != nil {
+			leaf.Close()
+			closePath(path)
+		}
+	}()
+	if err := leaf.Delete(rid); err != nil {
+		return err
+	}
+	if err := leaf.Close(); err != nil {
+		return err
+	}
+	if err := bti.rebalance(path, bti.leafTable, bti.leafLayout, true); err != nil {
+		return err
 	}
-	return bti.leaf.Close()
+	return closePath(path)
 }
 
+// latchPath はルートから searchKey を含む leaf まで排他の latch をかけながら下って、 leaf を開いて排他ロックをかける
+// ロックをかけられなかった場合は、 latch を全て外して lockWait を返す
+// isSafe が true を返したページより上のディレクトリは latch を外して、残したディレクトリをルートに近い順に返す
+// 返したディレクトリのページは呼び出し側で閉じる
+// 途中で失敗した場合は、 latch をかけたページを全て閉じてからエラーを返す
+func (bti *BTreeIndex) latchPath(searchKey query.Constant, isSafe func(page *BTreePage, isLeaf bool) (bool, error)) ([]pathEntry, *BTreeLeaf, error) {
+	path := make([]pathEntry, 0)
+	// page と leaf は path に入れる前に開いたページで、失敗した場合は path と一緒に閉じる
+	var page *BTreePage
+	var leaf *BTreeLeaf
+	done := false
+	defer func() {
+		if done {
+			return
+		}
+		if leaf != nil {
+			leaf.Close()
+		}
+		if page != nil {
+			page.Close()
+		}
+		closePath(path)
+	}()
+	releaseAncestors := func() error {
+		err := closePath(path)
+		path = path[:0]
+		return err
+	}
+
+	page, err := NewBTreePage(bti.tx, bti.rootBlk, bti.dirLayout)
+	if err != nil {
+		return nil, nil, err
+	}
+	for {
+		slot, err := childSlot(page, searchKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		level, err := page.GetFlag()
+		if err != nil {
+			return nil, nil, err
+		}
+		childNum, err := page.getChildNum(slot)
+		if err != nil {
+			return nil, nil, err
+		}
+		path = append(path, pathEntry{page, slot})
+		page = nil
+		if level == 0 {
+			leaf, err = NewBTreeLeaf(bti.tx, file.NewBlockID(bti.leafTable, childNum), bti.leafLayout, searchKey)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := lockLeaf(bti.tx, leaf.contents, true); err != nil {
+				return nil, nil, err
+			}
+			safe, err := isSafe(leaf.contents, true)
+			if err != nil {
+				return nil, nil, err
+			}
+			if safe {
+				if err := releaseAncestors(); err != nil {
+					return nil, nil, err
+				}
+			}
+			done = true
+			return path, leaf, nil
+		}
+		page, err = NewBTreePage(bti.tx, file.NewBlockID(bti.dirTable, childNum), bti.dirLayout)
+		if err != nil {
+			return nil, nil, err
+		}
+		safe, err := isSafe(page, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if safe {
+			if err := releaseAncestors(); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+}
+
+// closePath は残したディレクトリのページを閉じて latch を外す
+// 閉じたページをもう一度閉じても何もしないので、途中で閉じたページが含まれていてもよい
+// 閉じられないページがあっても残りのページは閉じて、最初のエラーを返す
+func closePath(path []pathEntry) error {
+	var firstErr error
+	for _, pe := range path {
+		if err := pe.page.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Close は走査を終える
+// leaf は BeforeFirst で読み込んだ後は latch をかけたままにしないので、閉じるページはない
+func (bti *BTreeIndex) Close() error {
+	bti.cursor = nil
+	return nil
+}
+
+// initializeLeafTableIfNeeded は leaf のファイルが空であれば、先頭の leaf を作る
+// 他のトランザクションが同時に作らないように、ファイルの末尾に排他の latch をかけたまま作る
 func (bti *BTreeIndex) initializeLeafTableIfNeeded() error {
+	eof := bti.tx.EndOfFile(bti.leafTable)
+	if err := bti.tx.XLatch(eof); err != nil {
+		return err
+	}
 	ls, err := bti.tx.Size(bti.leafTable)
 	if err != nil {
 		return err
 	}
 	if ls != 0 {
+		bti.tx.Unlatch(eof)
 		return nil
 	}
 
@@ -153,6 +356,10 @@ func (bti *BTreeIndex) initializeLeafTableIfNeeded() error {
 	if err != nil {
 		return err
 	}
+	if err := node.Close(); err != nil {
+		return err
+	}
+	bti.tx.Unlatch(eof)
 	return nil
 }
 
@@ -163,18 +370,26 @@ func (bti *BTreeIndex) initializeDirectory() error {
 	if err := dirSchema.Add(index.IndexBlockNumberField, bti.leafLayout.Schema()); err != nil {
 		return err
 	}
-	if err := dirSchema.Add(index.IndexDataValueField, bti.leafLayout.Schema()); err != nil {
-		return err
+	keyFields := index.DataValueFields(bti.leafLayout.Schema())
+	for _, fn := range keyFields {
+		if err := dirSchema.Add(fn, bti.leafLayout.Schema()); err != nil {
+			return err
+		}
 	}
 
 	bti.dirLayout = record.NewLayout(dirSchema)
 	bti.rootBlk = file.NewBlockID(bti.dirTable, 0)
 
+	eof := bti.tx.EndOfFile(bti.dirTable)
+	if err := bti.tx.XLatch(eof); err != nil {
+		return err
+	}
 	ds, err := bti.tx.Size(bti.dirTable)
 	if err != nil {
 		return err
 	}
 	if ds != 0 {
+		bti.tx.Unlatch(eof)
 		return nil
 	}
 
@@ -191,19 +406,27 @@ func (bti *BTreeIndex) initializeDirectory() error {
 	}
 
 	// insert initial directory entry
-	ft, err := dirSchema.FieldType(index.IndexDataValueField)
-	if err != nil {
-		return err
+	// 複合インデックスの場合は、全ての列の最小値の組を入れる
+	minVals := make([]query.Constant, len(keyFields))
+	for i, fn := range keyFields {
+		ft, err := dirSchema.FieldType(fn)
+		if err != nil {
+			return err
+		}
+		switch ft {
+		case record.Integer:
+			minVals[i] = query.NewConstant(math.MinInt32)
+		case record.String:
+			minVals[i] = query.NewConstant("")
+		case record.UUID:
+			minVals[i] = query.NewUUIDConstant(uuid.Nil)
+		default:
+			return fmt.Errorf("invalid field type %v", ft)
+		}
 	}
-
-	var minVal query.Constant
-	switch ft {
-	case record.Integer:
-		minVal = query.NewConstant(0)
-	case record.String:
-		minVal = query.NewConstant("")
-	default:
-		return fmt.Errorf("invalid field type %v", ft)
+	minVal := minVals[0]
+	if len(minVals) > 1 {
+		minVal = query.NewTupleConstant(minVals)
 	}
 
 	if err := node.insertDirectory(0, minVal, 0); err != nil {
@@ -212,9 +435,6 @@ func (bti *BTreeIndex) initializeDirectory() error {
 	if err := node.Close(); err != nil {
 		return err
 	}
+	bti.tx.Unlatch(eof)
 	return nil
 }
-
-func SearchCost(numBlocks int, rpb int) int {
-	return int(math.Log(float64(numBlocks)) / math.Log(float64(rpb)))
-}
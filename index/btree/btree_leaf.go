@@ -16,7 +16,8 @@ type BTreeLeaf struct {
 	fileName    string
 }
 
-// NewBTreeLeaf は特定のブロックの B-tree page を作成し、searchKey の前のレコード位置に移動する
+// NewBTreeLeaf は特定のブロックの B-tree page に排他の latch をかけて開き、searchKey の前のレコード位置に移動する
+// leaf のレコードは BTreeIndex の走査で読み込むので、 BTreeLeaf はレコードを追加したり削除したりするときだけ使う
 func NewBTreeLeaf(tx *tx.Transaction, blk file.BlockID, layout *record.Layout, searchKey query.Constant) (*BTreeLeaf, error) {
 	btp, err := NewBTreePage(tx, blk, layout)
 	if err != nil {
@@ -42,52 +43,173 @@ func (btl *BTreeLeaf) Close() error {
 	return btl.contents.Close()
 }
 
-// HasNext は currentSlot を次に進めて、同じ値をもつかどうかを返す
-func (btl *BTreeLeaf) HasNext() (bool, error) {
+// Delete は searchKey のインデックスレコードから指定のレコード ID を探して削除する
+// overflow chain がある leaf の先頭のレコードがなくなった場合は overflow block のレコードで埋めて、
+// 空になった overflow block は chain から外して解放する
+func (btl *BTreeLeaf) Delete(target *record.RecordID) error {
 	numRecords, err := btl.contents.getNumRecords()
 	if err != nil {
-		return false, err
+		return err
 	}
-	btl.currentSlot++
-	if btl.currentSlot >= numRecords {
-		return btl.tryOverflow()
+	for slot := btl.currentSlot + 1; slot < numRecords; slot++ {
+		val, err := btl.contents.GetDataValue(slot)
+		if err != nil {
+			return err
+		}
+		if !val.Equals(btl.searchKey) {
+			break
+		}
+		rid, err := btl.contents.getDataRid(slot)
+		if err != nil {
+			return err
+		}
+		if rid.Equals(target) {
+			if err := btl.contents.delete(slot); err != nil {
+				return err
+			}
+			return btl.refillFromOverflow()
+		}
 	}
-	val, err := btl.contents.GetDataValue(btl.currentSlot)
+	return btl.deleteOverflow(target)
+}
+
+// refillFromOverflow は overflow chain がある leaf の先頭に chain と同じキーがなくなった場合に、
+// overflow block のレコードを1つ leaf の先頭に移す
+func (btl *BTreeLeaf) refillFromOverflow() error {
+	flag, err := btl.contents.GetFlag()
 	if err != nil {
-		return false, err
+		return err
 	}
-	if val.Equals(btl.searchKey) {
-		return true, nil
+	if !flag.HasOverflow() {
+		return nil
+	}
+	numRecords, err := btl.contents.getNumRecords()
+	if err != nil {
+		return err
+	}
+	if numRecords > 0 {
+		firstKey, err := btl.contents.GetDataValue(0)
+		if err != nil {
+			return err
+		}
+		if firstKey.Equals(btl.searchKey) {
+			return nil
+		}
 	}
-	return btl.tryOverflow()
-}
 
-func (btl *BTreeLeaf) GetDataRid() (*record.RecordID, error) {
-	return btl.contents.getDataRid(btl.currentSlot)
+	overflow, err := NewBTreePage(btl.tx, file.NewBlockID(btl.fileName, flag.AsInt()), btl.layout)
+	if err != nil {
+		return err
+	}
+	n, err := overflow.getNumRecords()
+	if err != nil {
+		return err
+	}
+	rid, err := overflow.getDataRid(n - 1)
+	if err != nil {
+		return err
+	}
+	if err := overflow.delete(n - 1); err != nil {
+		return err
+	}
+	if err := btl.contents.insertLeaf(0, btl.searchKey, rid); err != nil {
+		return err
+	}
+	return btl.releaseIfEmpty(btl.contents, overflow)
 }
 
-// Insert は繰り返し HasNext を呼び、指定のレコード ID を探して削除する
-// 実行される前に BeforeFirst が呼ばれていると仮定している
-func (btl *BTreeLeaf) Delete(target *record.RecordID) error {
-	hasNext, err := btl.HasNext()
+// deleteOverflow は leaf の overflow chain から指定のレコード ID を探して削除する
+func (btl *BTreeLeaf) deleteOverflow(target *record.RecordID) error {
+	numRecords, err := btl.contents.getNumRecords()
+	if err != nil {
+		return err
+	}
+	if numRecords == 0 {
+		return nil
+	}
+	firstKey, err := btl.contents.GetDataValue(0)
 	if err != nil {
 		return err
 	}
-	for hasNext {
-		rid, err := btl.GetDataRid()
+	if !firstKey.Equals(btl.searchKey) {
+		return nil
+	}
+
+	prev := btl.contents
+	flag, err := prev.GetFlag()
+	if err != nil {
+		return err
+	}
+	for flag.HasOverflow() {
+		page, err := NewBTreePage(btl.tx, file.NewBlockID(btl.fileName, flag.AsInt()), btl.layout)
 		if err != nil {
 			return err
 		}
-		if rid.Equals(target) {
-			btl.contents.delete(btl.currentSlot)
-			return nil
+		n, err := page.getNumRecords()
+		if err != nil {
+			return err
+		}
+		for slot := 0; slot < n; slot++ {
+			rid, err := page.getDataRid(slot)
+			if err != nil {
+				return err
+			}
+			if !rid.Equals(target) {
+				continue
+			}
+			if err := page.delete(slot); err != nil {
+				return err
+			}
+			err = btl.releaseIfEmpty(prev, page)
+			if prev != btl.contents {
+				if cerr := prev.Close(); cerr != nil {
+					return cerr
+				}
+			}
+			return err
+		}
+		if prev != btl.contents {
+			if err := prev.Close(); err != nil {
+				return err
+			}
+		}
+		prev = page
+		flag, err = page.GetFlag()
+		if err != nil {
+			return err
 		}
 	}
+	if prev != btl.contents {
+		return prev.Close()
+	}
 	return nil
 }
 
+// releaseIfEmpty は overflow block が空になった場合に、 chain で1つ前のページからつなぎ替えて解放する
+// overflow はクローズする
+func (btl *BTreeLeaf) releaseIfEmpty(prev *BTreePage, overflow *BTreePage) error {
+	n, err := overflow.getNumRecords()
+	if err != nil {
+		return err
+	}
+	blk := overflow.currentBlk
+	next, err := overflow.GetFlag()
+	if err != nil {
+		return err
+	}
+	if err := overflow.Close(); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	if err := prev.SetFlag(next); err != nil {
+		return err
+	}
+	return freeBlock(btl.tx, blk)
+}
+
 // Insert は次のレコードに移動し、引数で渡したレコードを挿入する
-// 実行される前に BeforeFirst が呼ばれていると仮定している
 func (btl *BTreeLeaf) Insert(rid *record.RecordID) (DirectoryEntry, error) {
 	f, err := btl.contents.GetFlag()
 	if err != nil {
@@ -187,15 +309,17 @@ func (btl *BTreeLeaf) Insert(rid *record.RecordID) (DirectoryEntry, error) {
 			}
 			v = nextV
 		}
+		// 新しいブロックの先頭の値をディレクトリエントリのキーにする
+		splitKey = v
 	} else {
-		// move left, looking for the next key
+		// move left, looking for first entry having that key
 		v, err := btl.contents.GetDataValue(splitPos - 1)
 		if err != nil {
 			return DirectoryEntry{}, err
 		}
 		for v.Equals(splitKey) {
 			splitPos--
-			nextV, err := btl.contents.GetDataValue(splitPos)
+			nextV, err := btl.contents.GetDataValue(splitPos - 1)
 			if err != nil {
 				return DirectoryEntry{}, err
 			}
@@ -208,30 +332,3 @@ func (btl *BTreeLeaf) Insert(rid *record.RecordID) (DirectoryEntry, error) {
 	}
 	return NewDirectoryEntry(splitKey, newBlk.Number()), nil
 }
-
-// tryOverflow は overflow chain を含む leaf block を扱う
-// overflow がない場合は false, ある場合は B-Tree page と currentSlot を更新して true を返す
-func (btl *BTreeLeaf) tryOverflow() (bool, error) {
-	firstKey, err := btl.contents.GetDataValue(0)
-	if err != nil {
-		return false, err
-	}
-	flag, err := btl.contents.GetFlag()
-	if err != nil {
-		return false, err
-	}
-	if !(btl.searchKey.Equals(firstKey) && flag.HasOverflow()) {
-		return false, nil
-	}
-	if err := btl.contents.Close(); err != nil {
-		return false, err
-	}
-	nextBlk := file.NewBlockID(btl.fileName, flag.AsInt())
-	newBTreePage, err := NewBTreePage(btl.tx, nextBlk, btl.layout)
-	if err != nil {
-		return false, err
-	}
-	btl.contents = newBTreePage
-	btl.currentSlot = 0
-	return true, nil
-}
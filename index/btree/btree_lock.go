@@ -0,0 +1,62 @@
+package btree
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ksrnnb/go-rdb/file"
+	"github.com/ksrnnb/go-rdb/tx"
+)
+
+// ページの latch は読み書きする間だけかけるので、トランザクションの間の分離は leaf のロックで保つ
+// leaf は親のディレクトリのエントリのキーから次のエントリのキーまでの範囲を受け持つので、 leaf のブロックのロックをキーの範囲のロックとして使う
+// 読み込むトランザクションは leaf に共有ロックを、書き込むトランザクションは排他ロックをかけて、トランザクションの終わりまで保持する
+// leaf を分割、併合、再分配するときは関係する leaf 全てに排他ロックをかけるので、ロックしている間は leaf が受け持つ範囲は変わらない
+// ディレクトリのページは木の構造だけを表すので、 latch だけで保護してロックはかけない
+
+// lockWait は leaf のロックをかけられなかったので、 latch を全て外してからロックを待つことを表す
+// latch をかけたまま待つと、ロックを持つトランザクションが同じ latch を待って deadlock するので、 latch をかけている間はロックを待たない
+type lockWait struct {
+	blk       file.BlockID
+	exclusive bool
+}
+
+func (lw *lockWait) Error() string {
+	return fmt.Sprintf("btree: waiting for the lock of block %d in %s", lw.blk.Number(), lw.blk.FileName())
+}
+
+// lockLeaf は latch をかけた leaf のページに待たずにロックをかけて、かけられなかった場合は lockWait を返す
+// lockWait を返した場合は、呼び出し側で latch をかけたページを全て閉じる
+func lockLeaf(tx *tx.Transaction, page *BTreePage, exclusive bool) error {
+	blk := page.currentBlk
+	var locked bool
+	if exclusive {
+		locked = tx.TryXLock(blk)
+	} else {
+		locked = tx.TrySLock(blk)
+	}
+	if locked {
+		return nil
+	}
+	return &lockWait{blk: blk, exclusive: exclusive}
+}
+
+// withLockWait は f が lockWait を返した場合に、ロックをかけられるまで待ってから f を呼び直す
+// f は lockWait を返すまでに latch を全て外して、ページを書き換えていない状態に戻す
+func withLockWait(tx *tx.Transaction, f func() error) error {
+	for {
+		err := f()
+		var lw *lockWait
+		if !errors.As(err, &lw) {
+			return err
+		}
+		if lw.exclusive {
+			err = tx.XLock(lw.blk)
+		} else {
+			err = tx.SLock(lw.blk)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
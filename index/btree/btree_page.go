@@ -3,6 +3,7 @@ package btree
 import (
 	"fmt"
 
+	"github.com/google/uuid"
 	"github.com/ksrnnb/go-rdb/file"
 	"github.com/ksrnnb/go-rdb/index"
 	"github.com/ksrnnb/go-rdb/query"
@@ -35,44 +36,55 @@ func (pf PageFlag) HasOverflow() bool {
 // | page flag (int32) | numRecords (int32) | record 1 (slot 0) | ... | record n (slot n-1) | ... |
 // ------------------------------------------------------------------------------------------------
 // TODO： directory と leaf で page 構造体を分けた方がいいかも？
+// ページは開いている間 latch をかけて、 Close で外す
 type BTreePage struct {
 	tx         *tx.Transaction
 	currentBlk file.BlockID
 	layout     *record.Layout
+	// keyFields はキーを保存するフィールドで、複合インデックスの場合は2つ以上になる
+	keyFields []string
 }
 
+// NewBTreePage はブロックに排他の latch をかけて、読み書きするページを開く
 func NewBTreePage(tx *tx.Transaction, currentBlk file.BlockID, layout *record.Layout) (*BTreePage, error) {
+	if err := tx.XLatch(currentBlk); err != nil {
+		return nil, err
+	}
+	return openBTreePage(tx, currentBlk, layout)
+}
+
+// NewBTreePageForRead はブロックに共有の latch をかけて、読み込むだけのページを開く
+func NewBTreePageForRead(tx *tx.Transaction, currentBlk file.BlockID, layout *record.Layout) (*BTreePage, error) {
+	if err := tx.SLatch(currentBlk); err != nil {
+		return nil, err
+	}
+	return openBTreePage(tx, currentBlk, layout)
+}
+
+func openBTreePage(tx *tx.Transaction, currentBlk file.BlockID, layout *record.Layout) (*BTreePage, error) {
 	err := tx.Pin(currentBlk)
 	if err != nil {
 		return nil, err
 	}
-	return &BTreePage{tx, currentBlk, layout}, nil
+	return &BTreePage{tx, currentBlk, layout, index.DataValueFields(layout.Schema())}, nil
 }
 
-// FindSlotBefore は searchKey <= dataValue(x) を満たす最小の slot x を探す
-func (btp *BTreePage) FindSlotBefore(searchKey query.Constant) (slot int, err error) {
+// FindSlotBefore は searchKey <= dataValue(x) を満たす最小の slot x を探して、その 1 つ前の slot を返す
+func (btp *BTreePage) FindSlotBefore(searchKey query.Constant) (int, error) {
 	numRecords, err := btp.getNumRecords()
 	if err != nil {
 		return 0, err
 	}
-	v, err := btp.GetDataValue(slot)
-	if err != nil {
-		return 0, err
-	}
-
-	slot = 0
-	for slot < numRecords && v.IsLessThan(searchKey) {
-		slot++
-		newNumRecords, err := btp.getNumRecords()
+	slot := 0
+	for slot < numRecords {
+		v, err := btp.GetDataValue(slot)
 		if err != nil {
 			return 0, err
 		}
-		newV, err := btp.GetDataValue(slot)
-		if err != nil {
-			return 0, err
+		if !v.IsLessThan(searchKey) {
+			break
 		}
-		numRecords = newNumRecords
-		v = newV
+		slot++
 	}
 	return slot - 1, nil
 }
@@ -85,6 +97,7 @@ func (btp *BTreePage) Close() error {
 	if err != nil {
 		return err
 	}
+	btp.tx.Unlatch(btp.currentBlk)
 	btp.currentBlk = file.BlockID{}
 	return nil
 }
@@ -98,6 +111,47 @@ func (btp *BTreePage) IsFull() (bool, error) {
 	return btp.slotPos(numRecords+1) >= btp.tx.BlockSize(), nil
 }
 
+// maxRecords は full にならずに保存できるレコードの最大数を返す
+func (btp *BTreePage) maxRecords() int {
+	n := 0
+	for btp.slotPos(n+2) < btp.tx.BlockSize() {
+		n++
+	}
+	return n
+}
+
+// canInsert はページにレコードを1つ追加しても、分割しないかどうかを返す
+// overflow chain がある leaf は、先頭より小さいキーを追加すると分割するので分割するとみなす
+func (btp *BTreePage) canInsert(isLeaf bool) (bool, error) {
+	if isLeaf {
+		flag, err := btp.GetFlag()
+		if err != nil || flag.HasOverflow() {
+			return false, err
+		}
+	}
+	numRecords, err := btp.getNumRecords()
+	if err != nil {
+		return false, err
+	}
+	return numRecords+1 <= btp.maxRecords(), nil
+}
+
+// canDelete はページからレコードを1つ削除しても、隣のページと併合したり再分配したりしないかどうかを返す
+// overflow chain がある leaf は、 chain がなくなると少なくなるので併合するとみなす
+func (btp *BTreePage) canDelete(isLeaf bool) (bool, error) {
+	if isLeaf {
+		flag, err := btp.GetFlag()
+		if err != nil || flag.HasOverflow() {
+			return false, err
+		}
+	}
+	numRecords, err := btp.getNumRecords()
+	if err != nil {
+		return false, err
+	}
+	return numRecords-1 >= btp.maxRecords()/2, nil
+}
+
 // Split は新しくブロックを作成し、splitPos 以降のデータを新しい page に移す
 // 処理に成功した場合は、新しく作成したブロックを返す
 func (btp *BTreePage) Split(splitPos int, flag PageFlag) (file.BlockID, error) {
@@ -124,8 +178,39 @@ func (btp *BTreePage) Split(splitPos int, flag PageFlag) (file.BlockID, error) {
 	return newBlk, err
 }
 
+// GetDataValue は slot のインデックスレコードのキーを返す
+// 複合インデックスの場合は、列の値を並べた組の定数を返すので、キーは列の順に辞書式で比べられる
 func (btp *BTreePage) GetDataValue(slot int) (query.Constant, error) {
-	return btp.getVal(slot, index.IndexDataValueField)
+	if len(btp.keyFields) == 1 {
+		return btp.getVal(slot, index.IndexDataValueField)
+	}
+	vals := make([]query.Constant, len(btp.keyFields))
+	for i, fn := range btp.keyFields {
+		v, err := btp.getVal(slot, fn)
+		if err != nil {
+			return query.Constant{}, err
+		}
+		vals[i] = v
+	}
+	return query.NewTupleConstant(vals), nil
+}
+
+// setDataValue は slot のインデックスレコードにキーを保存する
+// 複合インデックスの場合は、組の値をそれぞれの列のフィールドに保存する
+func (btp *BTreePage) setDataValue(slot int, val query.Constant) error {
+	if len(btp.keyFields) == 1 {
+		return btp.setVal(slot, index.IndexDataValueField, val)
+	}
+	vals := val.AsTuple()
+	if len(vals) != len(btp.keyFields) {
+		return fmt.Errorf("key %s must have %d values", val, len(btp.keyFields))
+	}
+	for i, fn := range btp.keyFields {
+		if err := btp.setVal(slot, fn, vals[i]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (btp *BTreePage) GetFlag() (PageFlag, error) {
@@ -141,16 +226,36 @@ func (btp *BTreePage) SetFlag(flag PageFlag) error {
 }
 
 // AppendNew はファイルに新しいブロックを作成し、フォーマットして返す
+// 解放したブロックがある場合は再利用する
 func (btp *BTreePage) AppendNew(flag PageFlag) (file.BlockID, error) {
-	blk, err := btp.tx.Append(btp.currentBlk.FileName())
+	blk, reused, err := allocateBlock(btp.tx, btp.currentBlk.FileName())
 	if err != nil {
 		return file.BlockID{}, err
 	}
+	// 追加したブロックはまだどのページからも指していないので、 latch をかけても他のトランザクションを待たない
+	if err := btp.tx.XLatch(blk); err != nil {
+		return file.BlockID{}, err
+	}
 	err = btp.tx.Pin(blk)
 	if err != nil {
 		return file.BlockID{}, err
 	}
-	btp.Format(blk, flag)
+	if reused {
+		// 再利用したブロックはロールバックで解放したときの状態に戻せるように、ログに残して初期化する
+		// レコードは挿入するときに上書きするので、フラグとレコード数だけ初期化すればよい
+		if err := btp.tx.SetInt(blk, flagPos, flag.AsInt(), true); err != nil {
+			return file.BlockID{}, err
+		}
+		if err := btp.tx.SetInt(blk, numRecordPos, 0, true); err != nil {
+			return file.BlockID{}, err
+		}
+	} else if err := btp.Format(blk, flag); err != nil {
+		return file.BlockID{}, err
+	}
+	if err := btp.tx.Unpin(blk); err != nil {
+		return file.BlockID{}, err
+	}
+	btp.tx.Unlatch(blk)
 	return blk, nil
 }
 
@@ -196,6 +301,11 @@ func (btp *BTreePage) makeDefaultDecord(blk file.BlockID, pos int) error {
 			if err != nil {
 				return err
 			}
+		case record.UUID:
+			err := record.WriteUUID(btp.tx, blk, pos+offset, uuid.Nil, false)
+			if err != nil {
+				return err
+			}
 		default:
 			return fmt.Errorf("invalid field type %v", ft)
 		}
@@ -212,7 +322,7 @@ func (btp *BTreePage) insertDirectory(slot int, val query.Constant, blkNum int)
 	if err != nil {
 		return err
 	}
-	err = btp.setVal(slot, index.IndexDataValueField, val)
+	err = btp.setDataValue(slot, val)
 	if err != nil {
 		return err
 	}
@@ -236,7 +346,7 @@ func (btp *BTreePage) insertLeaf(slot int, val query.Constant, rid *record.Recor
 	if err != nil {
 		return err
 	}
-	err = btp.setVal(slot, index.IndexDataValueField, val)
+	err = btp.setDataValue(slot, val)
 	if err != nil {
 		return err
 	}
@@ -303,6 +413,16 @@ func (btp *BTreePage) getVal(slot int, fieldName string) (query.Constant, error)
 			return query.Constant{}, err
 		}
 		return query.NewConstant(v), nil
+	case record.UUID:
+		pos, err := btp.fieldPos(slot, fieldName)
+		if err != nil {
+			return query.Constant{}, err
+		}
+		v, err := record.ReadUUID(btp.tx, btp.currentBlk, pos)
+		if err != nil {
+			return query.Constant{}, err
+		}
+		return query.NewUUIDConstant(v), nil
 	}
 	return query.Constant{}, fmt.Errorf("invalid field type %v", ft)
 }
@@ -334,6 +454,12 @@ func (btp *BTreePage) setVal(slot int, fieldName string, val query.Constant) err
 		return btp.setInt(slot, fieldName, val.AsInt())
 	case record.String:
 		return btp.setString(slot, fieldName, val.AsString())
+	case record.UUID:
+		pos, err := btp.fieldPos(slot, fieldName)
+		if err != nil {
+			return err
+		}
+		return record.WriteUUID(btp.tx, btp.currentBlk, pos, val.AsUUID(), true)
 	}
 	return fmt.Errorf("invalid field type %v", ft)
 }
@@ -375,6 +501,44 @@ func (btp *BTreePage) copyRecord(from int, to int) error {
 	return nil
 }
 
+// appendRecords はレシーバーの from から to の手前の slot のレコードを、 dest の末尾に追加する
+func (btp *BTreePage) appendRecords(from int, to int, dest *BTreePage) error {
+	destSlot, err := dest.getNumRecords()
+	if err != nil {
+		return err
+	}
+	for slot := from; slot < to; slot++ {
+		if err := dest.insert(destSlot); err != nil {
+			return err
+		}
+		for _, fn := range btp.layout.Schema().Fields() {
+			v, err := btp.getVal(slot, fn)
+			if err != nil {
+				return err
+			}
+			if err := dest.setVal(destSlot, fn, v); err != nil {
+				return err
+			}
+		}
+		destSlot++
+	}
+	return nil
+}
+
+// removeRecords は from から to の手前の slot のレコードを削除して、後ろのレコードを前に詰める
+func (btp *BTreePage) removeRecords(from int, to int) error {
+	numRecords, err := btp.getNumRecords()
+	if err != nil {
+		return err
+	}
+	for i := to; i < numRecords; i++ {
+		if err := btp.copyRecord(i, from+i-to); err != nil {
+			return err
+		}
+	}
+	return btp.setNumRecords(numRecords - (to - from))
+}
+
 // transferRecords はレシーバーの B-Tree page の slot 以降のレコードを dest にコピーする
 func (btp *BTreePage) transferRecords(slot int, dest *BTreePage) error {
 	destSlot := 0
@@ -387,9 +551,14 @@ func (btp *BTreePage) transferRecords(slot int, dest *BTreePage) error {
 		if err != nil {
 			return err
 		}
-		err = btp.copyRecord(slot, destSlot)
-		if err != nil {
-			return err
+		for _, fn := range btp.layout.Schema().Fields() {
+			v, err := btp.getVal(slot, fn)
+			if err != nil {
+				return err
+			}
+			if err := dest.setVal(destSlot, fn, v); err != nil {
+				return err
+			}
 		}
 		// delete すると次のイテレーションで btp の slot が指すレコードも変わる
 		err = btp.delete(slot)
@@ -412,7 +581,7 @@ func (btp *BTreePage) fieldPos(slot int, fieldName string) (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	return btp.slotPos(offset), nil
+	return btp.slotPos(slot) + offset, nil
 }
 
 func (btp *BTreePage) slotPos(slot int) int {
@@ -0,0 +1,177 @@
+package btree
+
+import (
+	"github.com/ksrnnb/go-rdb/file"
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/record"
+	"github.com/ksrnnb/go-rdb/tx"
+)
+
+type leafEntry struct {
+	key query.Constant
+	rid *record.RecordID
+}
+
+// rangeCursor は範囲に含まれるインデックスレコードを、キーの順に leaf ごとに読み込んで返す
+// leaf は兄弟へのポインタを持たないので、ディレクトリのエントリの値をたどって次の leaf を探す
+// leaf は親のディレクトリに latch をかけている間に読み込んで、 Next の間は latch をかけたままにしない
+// 読み込んだ leaf の共有ロックはトランザクションの終わりまで保持するので、他のトランザクションは読み込んだ範囲にレコードを追加できない
+type rangeCursor struct {
+	tx         *tx.Transaction
+	leafLayout *record.Layout
+	dirLayout  *record.Layout
+	leafTable  string
+	rootBlk    file.BlockID
+	r          query.Range
+	descending bool
+
+	// leafKey は読み込んだ leaf を指す level-0 のディレクトリエントリの値
+	leafKey query.Constant
+	entries []leafEntry
+	pos     int
+	done    bool
+	current leafEntry
+}
+
+func (rc *rangeCursor) next() (bool, error) {
+	for !rc.done {
+		for rc.pos < len(rc.entries) {
+			e := rc.entries[rc.pos]
+			rc.pos++
+			// 走査する向きの先の端を超えたら終わり、手前の端より前なら読み飛ばす
+			if rc.descending {
+				if !rc.r.AboveLow(e.key) {
+					rc.done = true
+					return false, nil
+				}
+				if !rc.r.BelowHigh(e.key) {
+					continue
+				}
+			} else {
+				if !rc.r.BelowHigh(e.key) {
+					rc.done = true
+					return false, nil
+				}
+				if !rc.r.AboveLow(e.key) {
+					continue
+				}
+			}
+			rc.current = e
+			return true, nil
+		}
+
+		// 同じキーのレコードは1つの leaf と overflow chain に収まるので、1点の範囲は次の leaf を探さない
+		if rc.r.IsPoint() {
+			rc.done = true
+			return false, nil
+		}
+		ok := false
+		err := withLockWait(rc.tx, func() error {
+			root, err := NewBTreeDirectory(rc.tx, rc.rootBlk, rc.dirLayout)
+			if err != nil {
+				return err
+			}
+			ok, err = root.SiblingEntry(rc.leafKey, !rc.descending, rc.load)
+			if err != nil {
+				root.Close()
+				return err
+			}
+			return root.Close()
+		})
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			rc.done = true
+			return false, nil
+		}
+	}
+	return false, nil
+}
+
+// load は de が指す leaf のインデックスレコードを、 overflow chain も含めてキーの順に読み込む
+// overflow chain のレコードは leaf の先頭と同じキーなので、先頭のレコードの直後に並べる
+// 読み込んでいる間は leaf と overflow chain に共有の latch をかけて、 leaf にはトランザクションの終わりまで共有ロックをかける
+// ロックをかけられなかった場合は、読み込む前の状態のまま lockWait を返す
+func (rc *rangeCursor) load(de DirectoryEntry) error {
+	page, err := NewBTreePageForRead(rc.tx, file.NewBlockID(rc.leafTable, de.BlockNumber()), rc.leafLayout)
+	if err != nil {
+		return err
+	}
+	if err := lockLeaf(rc.tx, page, false); err != nil {
+		page.Close()
+		return err
+	}
+	rc.leafKey = de.DataValue()
+	rc.entries = rc.entries[:0]
+	rc.pos = 0
+
+	numRecords, err := page.getNumRecords()
+	if err != nil {
+		return err
+	}
+	if numRecords > 0 {
+		if err := rc.appendEntries(page, 0, 1); err != nil {
+			return err
+		}
+		flag, err := page.GetFlag()
+		if err != nil {
+			return err
+		}
+		if err := rc.appendOverflow(flag); err != nil {
+			return err
+		}
+		if err := rc.appendEntries(page, 1, numRecords); err != nil {
+			return err
+		}
+	}
+	if err := page.Close(); err != nil {
+		return err
+	}
+
+	if rc.descending {
+		for i, j := 0, len(rc.entries)-1; i < j; i, j = i+1, j-1 {
+			rc.entries[i], rc.entries[j] = rc.entries[j], rc.entries[i]
+		}
+	}
+	return nil
+}
+
+func (rc *rangeCursor) appendOverflow(flag PageFlag) error {
+	for flag.HasOverflow() {
+		page, err := NewBTreePageForRead(rc.tx, file.NewBlockID(rc.leafTable, flag.AsInt()), rc.leafLayout)
+		if err != nil {
+			return err
+		}
+		numRecords, err := page.getNumRecords()
+		if err != nil {
+			return err
+		}
+		if err := rc.appendEntries(page, 0, numRecords); err != nil {
+			return err
+		}
+		flag, err = page.GetFlag()
+		if err != nil {
+			return err
+		}
+		if err := page.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rc *rangeCursor) appendEntries(page *BTreePage, from int, to int) error {
+	for slot := from; slot < to; slot++ {
+		key, err := page.GetDataValue(slot)
+		if err != nil {
+			return err
+		}
+		rid, err := page.getDataRid(slot)
+		if err != nil {
+			return err
+		}
+		rc.entries = append(rc.entries, leafEntry{key, rid})
+	}
+	return nil
+}
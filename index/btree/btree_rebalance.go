@@ -0,0 +1,298 @@
+package btree
+
+import (
+	"github.com/ksrnnb/go-rdb/file"
+	"github.com/ksrnnb/go-rdb/record"
+)
+
+// 削除でレコードが半分より少なくなったページは、隣のページと併合するか、隣のページからレコードを移して均す
+// 併合して空いたブロックは解放して、後でページを追加するときに再利用する
+// leaf では同じキーのレコードが2つの leaf にまたがらないように、キーの境目でだけレコードを移す
+
+// rebalance は path の最後のディレクトリが指す子のページ (childFile のブロック) を隣の子と併合するか、レコードを再分配する
+// 併合して親のエントリが減った場合は、親のディレクトリについて同じ処理を path の先頭まで繰り返す
+// path の先頭はルートか、エントリが減っても少なくならないディレクトリなので、それより上はたどらない
+func (bti *BTreeIndex) rebalance(path []pathEntry, childFile string, childLayout *record.Layout, isLeaf bool) error {
+	if len(path) == 0 {
+		return nil
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		merged, err := bti.rebalanceChild(path[i], childFile, childLayout, isLeaf)
+		if err != nil {
+			return err
+		}
+		if !merged {
+			return nil
+		}
+		childFile, childLayout, isLeaf = bti.dirTable, bti.dirLayout, false
+	}
+	if !path[0].page.currentBlk.Equals(bti.rootBlk) {
+		return nil
+	}
+	return bti.collapseRoot()
+}
+
+// rebalanceChild は pe のディレクトリの slot が指す子が少なくなっていれば、隣の子と併合するか再分配する
+// 親のディレクトリには排他の latch をかけているので、兄弟のページには左から順に latch をかける
+// 併合した場合は true を返す
+func (bti *BTreeIndex) rebalanceChild(pe pathEntry, childFile string, childLayout *record.Layout, isLeaf bool) (bool, error) {
+	parent := pe.page
+	numEntries, err := parent.getNumRecords()
+	if err != nil {
+		return false, err
+	}
+	if numEntries < 2 {
+		return false, nil
+	}
+
+	// 右の兄弟がない場合は左の兄弟と組にする
+	leftSlot := pe.slot
+	if leftSlot+1 >= numEntries {
+		leftSlot--
+	}
+	rightSlot := leftSlot + 1
+	left, err := bti.openChild(parent, leftSlot, childFile, childLayout)
+	if err != nil {
+		return false, err
+	}
+	right, err := bti.openChild(parent, rightSlot, childFile, childLayout)
+	if err != nil {
+		return false, err
+	}
+	// 他のトランザクションがロックしている leaf は受け持つ範囲を変えられないので、併合も再分配もしない
+	if isLeaf && (!bti.tx.TryXLock(left.currentBlk) || !bti.tx.TryXLock(right.currentBlk)) {
+		for _, page := range []*BTreePage{left, right} {
+			if err := page.Close(); err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	}
+	child := left
+	if pe.slot == rightSlot {
+		child = right
+	}
+
+	merged := false
+	underflow, err := isUnderflow(child, isLeaf)
+	if err != nil {
+		return false, err
+	}
+	if underflow {
+		merged, err = bti.mergeOrRedistribute(parent, left, right, rightSlot, child == left, isLeaf)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	for _, page := range []*BTreePage{left, right} {
+		if err := page.Close(); err != nil {
+			return false, err
+		}
+	}
+	return merged, nil
+}
+
+func (bti *BTreeIndex) openChild(parent *BTreePage, slot int, childFile string, childLayout *record.Layout) (*BTreePage, error) {
+	blkNum, err := parent.getChildNum(slot)
+	if err != nil {
+		return nil, err
+	}
+	return NewBTreePage(bti.tx, file.NewBlockID(childFile, blkNum), childLayout)
+}
+
+// isUnderflow はページのレコードが保存できる最大数の半分より少ないかどうかを返す
+// overflow chain がある leaf は、同じキーのレコードが chain にあるので少なくなったとはみなさない
+func isUnderflow(page *BTreePage, isLeaf bool) (bool, error) {
+	if isLeaf {
+		flag, err := page.GetFlag()
+		if err != nil {
+			return false, err
+		}
+		if flag.HasOverflow() {
+			return false, nil
+		}
+	}
+	n, err := page.getNumRecords()
+	if err != nil {
+		return false, err
+	}
+	return n < page.maxRecords()/2, nil
+}
+
+// mergeOrRedistribute は2つのページのレコードが1つのページに入る場合は right を left に併合して、
+// 入らない場合は child の方にレコードを移す
+// 併合した場合は right のブロックを解放して、親から right のエントリを削除して true を返す
+func (bti *BTreeIndex) mergeOrRedistribute(parent, left, right *BTreePage, rightSlot int, childIsLeft bool, isLeaf bool) (bool, error) {
+	nl, err := left.getNumRecords()
+	if err != nil {
+		return false, err
+	}
+	nr, err := right.getNumRecords()
+	if err != nil {
+		return false, err
+	}
+	maxRecords := left.maxRecords()
+	rightFlag, err := right.GetFlag()
+	if err != nil {
+		return false, err
+	}
+	// overflow chain は leaf の先頭のキーのレコードなので、 right の chain は left が空の場合だけ引き継げる
+	rightOverflow := isLeaf && rightFlag.HasOverflow()
+
+	if nl+nr <= maxRecords && (!rightOverflow || nl == 0) {
+		if err := right.appendRecords(0, nr, left); err != nil {
+			return false, err
+		}
+		if rightOverflow {
+			if err := left.SetFlag(rightFlag); err != nil {
+				return false, err
+			}
+		}
+		rightBlk := right.currentBlk
+		if err := right.Close(); err != nil {
+			return false, err
+		}
+		if err := freeBlock(bti.tx, rightBlk); err != nil {
+			return false, err
+		}
+		if err := parent.delete(rightSlot); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	if rightOverflow {
+		return false, nil
+	}
+
+	if childIsLeft {
+		// right の先頭のレコードを left の末尾に移す
+		hi := nr - 1
+		if maxRecords-nl < hi {
+			hi = maxRecords - nl
+		}
+		n, ok, err := groupBoundary(right, (nr-nl)/2, 1, hi, isLeaf)
+		if err != nil || !ok {
+			return false, err
+		}
+		if err := right.appendRecords(0, n, left); err != nil {
+			return false, err
+		}
+		if err := right.removeRecords(0, n); err != nil {
+			return false, err
+		}
+	} else {
+		// left の末尾のレコードを right の先頭に移す
+		lo := nl - (maxRecords - nr)
+		if lo < 1 {
+			lo = 1
+		}
+		start, ok, err := groupBoundary(left, nl-(nl-nr)/2, lo, nl-1, isLeaf)
+		if err != nil || !ok {
+			return false, err
+		}
+		if err := left.transferRecords(start, right); err != nil {
+			return false, err
+		}
+	}
+
+	// right の先頭のキーが変わったので、親のエントリのキーを合わせる
+	firstKey, err := right.GetDataValue(0)
+	if err != nil {
+		return false, err
+	}
+	return false, parent.setDataValue(rightSlot, firstKey)
+}
+
+// groupBoundary は lo から hi の slot のうち、 want に近いレコードを分けられる位置を返す
+// leaf の場合は同じキーのレコードを分けないように、1つ前の slot とキーが異なる位置だけを選ぶ
+// 位置がない場合は false を返す
+func groupBoundary(page *BTreePage, want int, lo int, hi int, isLeaf bool) (int, bool, error) {
+	if lo > hi {
+		return 0, false, nil
+	}
+	if want < lo {
+		want = lo
+	}
+	if want > hi {
+		want = hi
+	}
+	isBoundary := func(slot int) (bool, error) {
+		if !isLeaf {
+			return true, nil
+		}
+		prev, err := page.GetDataValue(slot - 1)
+		if err != nil {
+			return false, err
+		}
+		v, err := page.GetDataValue(slot)
+		if err != nil {
+			return false, err
+		}
+		return !v.Equals(prev), nil
+	}
+	for slot := want; slot <= hi; slot++ {
+		ok, err := isBoundary(slot)
+		if err != nil || ok {
+			return slot, ok, err
+		}
+	}
+	for slot := want - 1; slot >= lo; slot-- {
+		ok, err := isBoundary(slot)
+		if err != nil || ok {
+			return slot, ok, err
+		}
+	}
+	return 0, false, nil
+}
+
+// collapseRoot はルートのエントリが1つだけになった場合に、子のディレクトリをルートのブロックに移して tree を低くする
+// ルートは常にディレクトリの先頭のブロックに置くので、子の内容をコピーして子のブロックを解放する
+func (bti *BTreeIndex) collapseRoot() error {
+	root, err := NewBTreePage(bti.tx, bti.rootBlk, bti.dirLayout)
+	if err != nil {
+		return err
+	}
+	for {
+		level, err := root.GetFlag()
+		if err != nil {
+			return err
+		}
+		numRecords, err := root.getNumRecords()
+		if err != nil {
+			return err
+		}
+		if level == 0 || numRecords != 1 {
+			return root.Close()
+		}
+
+		child, err := bti.openChild(root, 0, bti.dirTable, bti.dirLayout)
+		if err != nil {
+			return err
+		}
+		childBlk := child.currentBlk
+		n, err := child.getNumRecords()
+		if err != nil {
+			return err
+		}
+		childLevel, err := child.GetFlag()
+		if err != nil {
+			return err
+		}
+		if err := root.setNumRecords(0); err != nil {
+			return err
+		}
+		if err := child.appendRecords(0, n, root); err != nil {
+			return err
+		}
+		if err := root.SetFlag(childLevel); err != nil {
+			return err
+		}
+		if err := child.Close(); err != nil {
+			return err
+		}
+		if err := freeBlock(bti.tx, childBlk); err != nil {
+			return err
+		}
+	}
+}
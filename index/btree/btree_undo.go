@@ -0,0 +1,182 @@
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/ksrnnb/go-rdb/index"
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/record"
+	"github.com/ksrnnb/go-rdb/tx"
+)
+
+// Insert と Delete は他のトランザクションと共有するページを書き換えるので、ロールバックではページを物理的に戻さずに、
+// 追加したインデックスレコードを削除するか、削除したインデックスレコードを追加し直して取り消す
+// 取り消しに必要な情報は EndAction で undoData としてログに残す
+//
+// ---------------------------------------------------------------------------------------------------------------
+// | inserted (byte) | rid block (int32) | rid slot (int32) | index name | numKeys (int32) | key 1 | ... | key n |
+// ---------------------------------------------------------------------------------------------------------------
+// key は data_value のフィールドの型 (int32) と長さ (int32) と値で、文字列は長さ (int32) とバイト列で保存する
+// leaf のスキーマは id, block_number, data_value のフィールドの順に並ぶので、フィールドの型と長さから作り直せる
+
+const undoName = "btree"
+
+var errInvalidUndoData = errors.New("btree: invalid undo data")
+
+func init() {
+	tx.RegisterUndo(undoName, undo)
+}
+
+// undo は undoData のインデックスレコードを、追加した場合は削除して、削除した場合は追加し直す
+func undo(tx *tx.Transaction, undoData []byte) error {
+	u, err := decodeUndo(undoData)
+	if err != nil {
+		return err
+	}
+	bti, err := NewBTreeIndex(tx, u.indexName, u.leafLayout)
+	if err != nil {
+		return err
+	}
+	if u.inserted {
+		err = bti.Delete(u.key, u.rid)
+	} else {
+		err = bti.Insert(u.key, u.rid)
+	}
+	if err != nil {
+		return err
+	}
+	return bti.Close()
+}
+
+type undoRecord struct {
+	inserted   bool
+	rid        *record.RecordID
+	indexName  string
+	leafLayout *record.Layout
+	key        query.Constant
+}
+
+// encodeUndo は leafLayout のインデックス indexName に、 key と rid のインデックスレコードを追加 (inserted が false の場合は削除) したことを表す undoData を返す
+func encodeUndo(indexName string, leafLayout *record.Layout, key query.Constant, rid *record.RecordID, inserted bool) []byte {
+	b := make([]byte, 0)
+	if inserted {
+		b = append(b, 1)
+	} else {
+		b = append(b, 0)
+	}
+	b = binary.BigEndian.AppendUint32(b, uint32(rid.BlockNumber()))
+	b = binary.BigEndian.AppendUint32(b, uint32(rid.Slot()))
+	b = appendString(b, indexName)
+
+	schema := leafLayout.Schema()
+	keyFields := index.DataValueFields(schema)
+	vals := []query.Constant{key}
+	if len(keyFields) > 1 {
+		vals = key.AsTuple()
+	}
+	b = binary.BigEndian.AppendUint32(b, uint32(len(keyFields)))
+	for i, fn := range keyFields {
+		// フィールドはスキーマに含まれているので、エラーにならない
+		ft, _ := schema.FieldType(fn)
+		length, _ := schema.Length(fn)
+		b = binary.BigEndian.AppendUint32(b, uint32(ft))
+		b = binary.BigEndian.AppendUint32(b, uint32(length))
+		switch ft {
+		case record.Integer:
+			b = binary.BigEndian.AppendUint32(b, uint32(vals[i].AsInt()))
+		case record.UUID:
+			u := vals[i].AsUUID()
+			b = append(b, u[:]...)
+		default:
+			b = appendString(b, vals[i].AsString())
+		}
+	}
+	return b
+}
+
+func decodeUndo(b []byte) (*undoRecord, error) {
+	d := &undoDecoder{b: b}
+	u := &undoRecord{inserted: d.byte() == 1}
+	blkNum := d.int()
+	slot := d.int()
+	u.rid = record.NewRecordID(blkNum, slot)
+	u.indexName = d.string()
+
+	schema := record.NewSchema()
+	schema.AddIntField(index.IndexIdField)
+	schema.AddIntField(index.IndexBlockNumberField)
+	numKeys := d.int()
+	if numKeys <= 0 || numKeys > len(b) {
+		return nil, fmt.Errorf("%w: index %s has %d keys", errInvalidUndoData, u.indexName, numKeys)
+	}
+	vals := make([]query.Constant, 0, numKeys)
+	for i := 0; i < numKeys && d.err == nil; i++ {
+		fn := index.DataValueField(i)
+		ft := record.FieldType(d.int())
+		length := d.int()
+		schema.AddField(fn, ft, length)
+		switch ft {
+		case record.Integer:
+			vals = append(vals, query.NewConstant(d.int()))
+		case record.UUID:
+			u, err := uuid.FromBytes(d.bytes(16))
+			if err != nil {
+				return nil, err
+			}
+			vals = append(vals, query.NewUUIDConstant(u))
+		default:
+			vals = append(vals, query.NewConstant(d.string()))
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	u.leafLayout = record.NewLayout(schema)
+	u.key = vals[0]
+	if numKeys > 1 {
+		u.key = query.NewTupleConstant(vals)
+	}
+	return u, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// undoDecoder は undoData を先頭から読み込む
+// 途中で足りなくなった場合は err を設定して、以降はゼロ値を返す
+type undoDecoder struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (d *undoDecoder) bytes(n int) []byte {
+	if n < 0 {
+		n = 0
+	}
+	if d.err != nil || d.pos+n > len(d.b) {
+		d.err = errInvalidUndoData
+		return make([]byte, n)
+	}
+	v := d.b[d.pos : d.pos+n]
+	d.pos += n
+	return v
+}
+
+func (d *undoDecoder) byte() byte {
+	return d.bytes(1)[0]
+}
+
+func (d *undoDecoder) int() int {
+	return int(int32(binary.BigEndian.Uint32(d.bytes(4))))
+}
+
+func (d *undoDecoder) string() string {
+	return string(d.bytes(d.int()))
+}
@@ -21,6 +21,7 @@ func (de DirectoryEntry) BlockNumber() int {
 	return de.blkNum
 }
 
+// IsZero はエントリがない (split されなかった) かどうかを返す
 func (de DirectoryEntry) IsZero() bool {
-	return de == DirectoryEntry{}
+	return de.dataVal.IsUnknown() && de.blkNum == 0
 }
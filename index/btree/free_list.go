@@ -0,0 +1,180 @@
+package btree
+
+import (
+	"fmt"
+
+	"github.com/ksrnnb/go-rdb/file"
+	"github.com/ksrnnb/go-rdb/tx"
+)
+
+// 解放したブロックは、ページのフラグに次に解放したブロックの番号を入れてつないだリストで管理する
+// リストの先頭のブロック番号は <ファイル名>_free の先頭のブロックに保存する
+// ファイルの先頭のブロック (ルートと先頭の leaf) は解放しないので、 0 をリストの終わりの印に使う
+// ロールバックとリカバリでリストも元に戻るように、全ての書き込みをログに残す
+// リストは先頭のブロックに排他の latch をかけて読み書きして、 latch をかけている間にリストのブロックにも latch をかける
+// latch は常にリストの先頭、リストのブロック、ファイルの末尾の順にかけて、トランザクションどうしで待ち合わないようにする
+
+const freeListHeadPos = 0
+
+func freeListFile(fileName string) string {
+	return fmt.Sprintf("%s_free", fileName)
+}
+
+func freeListHeadBlock(fileName string) file.BlockID {
+	return file.NewBlockID(freeListFile(fileName), 0)
+}
+
+// allocateBlock は fileName の解放したブロックがあれば再利用して、なければファイルの末尾に追加する
+// 追加した leaf に他のトランザクションが読み込めないように、返すブロックには排他ロックをかける
+// 解放したトランザクションがまだロックしているブロックは再利用しないで、ファイルの末尾に追加する
+// 再利用したブロックの場合は true を返す
+func allocateBlock(tx *tx.Transaction, fileName string) (file.BlockID, bool, error) {
+	headBlk := freeListHeadBlock(fileName)
+	if err := tx.XLatch(headBlk); err != nil {
+		return file.BlockID{}, false, err
+	}
+	head, err := freeListHead(tx, fileName)
+	if err != nil {
+		return file.BlockID{}, false, err
+	}
+	if head == 0 || !tx.TryXLock(file.NewBlockID(fileName, head)) {
+		blk, err := appendBlock(tx, fileName)
+		if err != nil {
+			return file.BlockID{}, false, err
+		}
+		// 追加したブロックは他のトランザクションがまだ知らないので、ロックをかけられる
+		if !tx.TryXLock(blk) {
+			return file.BlockID{}, false, fmt.Errorf("btree: cannot lock the appended block %d in %s", blk.Number(), fileName)
+		}
+		tx.Unlatch(headBlk)
+		return blk, false, nil
+	}
+
+	blk := file.NewBlockID(fileName, head)
+	if err := tx.XLatch(blk); err != nil {
+		return file.BlockID{}, false, err
+	}
+	if err := tx.Pin(blk); err != nil {
+		return file.BlockID{}, false, err
+	}
+	next, err := tx.GetInt(blk, flagPos)
+	if err != nil {
+		return file.BlockID{}, false, err
+	}
+	if err := tx.Unpin(blk); err != nil {
+		return file.BlockID{}, false, err
+	}
+	tx.Unlatch(blk)
+	if err := setFreeListHead(tx, fileName, next); err != nil {
+		return file.BlockID{}, false, err
+	}
+	tx.Unlatch(headBlk)
+	return blk, true, nil
+}
+
+// freeBlock は blk をリストの先頭につなぐ
+// blk は親のページからしかたどれないので、呼び出し側は親のページに latch をかけたまま呼ぶ
+func freeBlock(tx *tx.Transaction, blk file.BlockID) error {
+	if blk.Number() == 0 {
+		return fmt.Errorf("btree: cannot free the first block of %s", blk.FileName())
+	}
+	headBlk := freeListHeadBlock(blk.FileName())
+	if err := tx.XLatch(headBlk); err != nil {
+		return err
+	}
+	head, err := freeListHead(tx, blk.FileName())
+	if err != nil {
+		return err
+	}
+	if err := tx.XLatch(blk); err != nil {
+		return err
+	}
+	if err := tx.Pin(blk); err != nil {
+		return err
+	}
+	if err := tx.SetInt(blk, flagPos, head, true); err != nil {
+		return err
+	}
+	if err := tx.SetInt(blk, numRecordPos, 0, true); err != nil {
+		return err
+	}
+	if err := tx.Unpin(blk); err != nil {
+		return err
+	}
+	tx.Unlatch(blk)
+	if err := setFreeListHead(tx, blk.FileName(), blk.Number()); err != nil {
+		return err
+	}
+	tx.Unlatch(headBlk)
+	return nil
+}
+
+// fileSize はファイルの末尾に共有の latch をかけてブロック数を返す
+// tx.Size と違ってトランザクションの終わりまでロックしないので、他のトランザクションもすぐにブロックを追加できる
+func fileSize(tx *tx.Transaction, fileName string) (int, error) {
+	eof := tx.EndOfFile(fileName)
+	if err := tx.SLatch(eof); err != nil {
+		return 0, err
+	}
+	size, err := tx.Size(fileName)
+	if err != nil {
+		return 0, err
+	}
+	tx.Unlatch(eof)
+	return size, nil
+}
+
+// appendBlock はファイルの末尾に排他の latch をかけてブロックを追加する
+func appendBlock(tx *tx.Transaction, fileName string) (file.BlockID, error) {
+	eof := tx.EndOfFile(fileName)
+	if err := tx.XLatch(eof); err != nil {
+		return file.BlockID{}, err
+	}
+	blk, err := tx.Append(fileName)
+	if err != nil {
+		return file.BlockID{}, err
+	}
+	tx.Unlatch(eof)
+	return blk, nil
+}
+
+// freeListHead はリストの先頭のブロック番号を返す
+// 呼び出し側でリストの先頭のブロックに latch をかけておく
+func freeListHead(tx *tx.Transaction, fileName string) (int, error) {
+	size, err := fileSize(tx, freeListFile(fileName))
+	if err != nil {
+		return 0, err
+	}
+	if size == 0 {
+		return 0, nil
+	}
+	blk := freeListHeadBlock(fileName)
+	if err := tx.Pin(blk); err != nil {
+		return 0, err
+	}
+	head, err := tx.GetInt(blk, freeListHeadPos)
+	if err != nil {
+		return 0, err
+	}
+	return head, tx.Unpin(blk)
+}
+
+func setFreeListHead(tx *tx.Transaction, fileName string, head int) error {
+	size, err := fileSize(tx, freeListFile(fileName))
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		if _, err := appendBlock(tx, freeListFile(fileName)); err != nil {
+			return err
+		}
+	}
+	blk := freeListHeadBlock(fileName)
+	if err := tx.Pin(blk); err != nil {
+		return err
+	}
+	if err := tx.SetInt(blk, freeListHeadPos, head, true); err != nil {
+		return err
+	}
+	return tx.Unpin(blk)
+}
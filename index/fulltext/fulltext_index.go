@@ -0,0 +1,167 @@
+package fulltext
+
+import (
+	"math"
+	"sort"
+
+	"github.com/ksrnnb/go-rdb/index"
+	"github.com/ksrnnb/go-rdb/index/btree"
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/record"
+	"github.com/ksrnnb/go-rdb/tx"
+)
+
+// FullTextIndex は文字列を語に分けて、語ごとにその語を含むレコードを引ける転置インデックス
+// posting list は語をキーにした B-tree に保存して、レコードに同じ語が n 回現れる場合は n 個のインデックスレコードを登録する
+// そのため語の posting list を読むと、レコードごとの語の出現回数も分かる
+type FullTextIndex struct {
+	postings *btree.BTreeIndex
+}
+
+// NewFullTextIndex は全文検索のインデックスを開く
+// layout の data_value は語を保存する文字列のフィールドで、長い語は先頭の部分だけをキーにする
+func NewFullTextIndex(tx *tx.Transaction, indexName string, layout *record.Layout) (*FullTextIndex, error) {
+	postings, err := btree.NewBTreeIndex(tx, indexName, layout)
+	if err != nil {
+		return nil, err
+	}
+	return &FullTextIndex{postings}, nil
+}
+
+// BeforeFirst は語 searchKey を含むレコードを走査する準備をする
+func (fti *FullTextIndex) BeforeFirst(searchKey query.Constant) error {
+	return fti.postings.BeforeFirst(termKey(searchKey.String()))
+}
+
+// BeforeFirstAll は全ての語の posting list を語の順に走査する準備をする
+func (fti *FullTextIndex) BeforeFirstAll() error {
+	return fti.postings.BeforeFirstAll()
+}
+
+func (fti *FullTextIndex) Next() (bool, error) {
+	return fti.postings.Next()
+}
+
+func (fti *FullTextIndex) GetDataRid() (*record.RecordID, error) {
+	return fti.postings.GetDataRid()
+}
+
+// GetDataVal は現在のインデックスレコードの語を返す
+func (fti *FullTextIndex) GetDataVal() (query.Constant, error) {
+	return fti.postings.GetDataVal()
+}
+
+// Insert は dataVal の語ごとに、語と rid のインデックスレコードを追加する
+func (fti *FullTextIndex) Insert(dataVal query.Constant, rid *record.RecordID) error {
+	for _, w := range query.Tokenize(dataVal.String()) {
+		if err := fti.postings.Insert(query.NewConstant(w), rid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete は dataVal の語ごとに、語と rid のインデックスレコードを削除する
+func (fti *FullTextIndex) Delete(dataVal query.Constant, rid *record.RecordID) error {
+	for _, w := range query.Tokenize(dataVal.String()) {
+		if err := fti.postings.Delete(query.NewConstant(w), rid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fti *FullTextIndex) Close() error {
+	return fti.postings.Close()
+}
+
+// Search は terms の全ての語を含むレコードを、関連度の高い順に返す
+// 関連度は語ごとの出現回数 tf に、語を含むレコードが少ないほど大きくなる重み log(1 + numRecords/df) を掛けた和 (TF-IDF)
+// 関連度が同じレコードは rid の順に返す
+func (fti *FullTextIndex) Search(terms string, numRecords int) ([]index.TextMatch, error) {
+	words := distinctWords(terms)
+	if len(words) == 0 {
+		return nil, nil
+	}
+	rids := make(map[string]*record.RecordID)
+	scores := make(map[string]float64)
+	matched := make(map[string]int)
+	for _, w := range words {
+		tf, err := fti.postingCounts(w, rids)
+		if err != nil {
+			return nil, err
+		}
+		idf := math.Log(1 + float64(numRecords)/float64(len(tf)))
+		for r, n := range tf {
+			scores[r] += float64(n) * idf
+			matched[r]++
+		}
+	}
+
+	matches := make([]index.TextMatch, 0)
+	for r, n := range matched {
+		if n == len(words) {
+			matches = append(matches, index.NewTextMatch(rids[r], scores[r]))
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.Score() != b.Score() {
+			return a.Score() > b.Score()
+		}
+		if a.Rid().BlockNumber() != b.Rid().BlockNumber() {
+			return a.Rid().BlockNumber() < b.Rid().BlockNumber()
+		}
+		return a.Rid().Slot() < b.Rid().Slot()
+	})
+	return matches, nil
+}
+
+// postingCounts は語 w の posting list を読んで、レコードごとの出現回数を返す
+// 読んだ rid は rids に追加する
+func (fti *FullTextIndex) postingCounts(w string, rids map[string]*record.RecordID) (map[string]int, error) {
+	if err := fti.postings.BeforeFirst(termKey(w)); err != nil {
+		return nil, err
+	}
+	tf := make(map[string]int)
+	hasNext, err := fti.postings.Next()
+	if err != nil {
+		return nil, err
+	}
+	for hasNext {
+		rid, err := fti.postings.GetDataRid()
+		if err != nil {
+			return nil, err
+		}
+		rids[rid.String()] = rid
+		tf[rid.String()]++
+		hasNext, err = fti.postings.Next()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tf, fti.postings.Close()
+}
+
+// termKey は検索する語をインデックスのキーにする
+// インデックスの語と同じように小文字にそろえる
+func termKey(s string) query.Constant {
+	words := query.Tokenize(s)
+	if len(words) == 0 {
+		return query.NewConstant("")
+	}
+	return query.NewConstant(words[0])
+}
+
+// distinctWords は terms の語を重複を除いて現れた順に返す
+func distinctWords(terms string) []string {
+	seen := make(map[string]bool)
+	words := make([]string, 0)
+	for _, w := range query.Tokenize(terms) {
+		if !seen[w] {
+			seen[w] = true
+			words = append(words, w)
+		}
+	}
+	return words
+}
@@ -0,0 +1,229 @@
+package index
+
+import (
+	"fmt"
+
+	"github.com/ksrnnb/go-rdb/file"
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/record"
+	"github.com/ksrnnb/go-rdb/tx"
+)
+
+// バケットのブロックは先頭にローカル深度、レコード数、 overflow chain の次のブロック番号をもち、その後ろにインデックスレコードを並べる
+// 同じバケットの chain は最後のブロック以外が全て埋まるように詰めておく
+const (
+	localDepthPos  = 0
+	bucketCountPos = record.IntByteSize
+	bucketNextPos  = 2 * record.IntByteSize
+	bucketHeader   = 3 * record.IntByteSize
+)
+
+// hashNoBlock は chain の終わりや空の free list を表すブロック番号
+const hashNoBlock = -1
+
+// hashRecord はバケットに保存するインデックスレコード
+type hashRecord struct {
+	key query.Constant
+	rid *record.RecordID
+}
+
+type hashBucket struct {
+	tx        *tx.Transaction
+	blk       file.BlockID
+	layout    *record.Layout
+	keyFields []string
+}
+
+func newHashBucket(tx *tx.Transaction, blk file.BlockID, layout *record.Layout) (*hashBucket, error) {
+	if err := tx.Pin(blk); err != nil {
+		return nil, err
+	}
+	return &hashBucket{tx, blk, layout, DataValueFields(layout.Schema())}, nil
+}
+
+func (hb *hashBucket) close() error {
+	if hb.blk.IsZero() {
+		return nil
+	}
+	if err := hb.tx.Unpin(hb.blk); err != nil {
+		return err
+	}
+	hb.blk = file.BlockID{}
+	return nil
+}
+
+// bucketCapacity は1つのブロックに保存できるレコード数を返す
+func bucketCapacity(blockSize int, layout *record.Layout) int {
+	return (blockSize - bucketHeader) / layout.SlotSize()
+}
+
+// format はブロックを空のバケットにする
+// 解放したブロックを再利用する場合もあるので、ロールバックで元に戻せるようにログに残す
+func (hb *hashBucket) format(localDepth int) error {
+	if err := hb.setLocalDepth(localDepth); err != nil {
+		return err
+	}
+	if err := hb.setNumRecords(0); err != nil {
+		return err
+	}
+	return hb.setNext(hashNoBlock)
+}
+
+func (hb *hashBucket) localDepth() (int, error) {
+	return hb.tx.GetInt(hb.blk, localDepthPos)
+}
+
+func (hb *hashBucket) setLocalDepth(d int) error {
+	return hb.tx.SetInt(hb.blk, localDepthPos, d, true)
+}
+
+func (hb *hashBucket) numRecords() (int, error) {
+	return hb.tx.GetInt(hb.blk, bucketCountPos)
+}
+
+func (hb *hashBucket) setNumRecords(n int) error {
+	return hb.tx.SetInt(hb.blk, bucketCountPos, n, true)
+}
+
+func (hb *hashBucket) next() (int, error) {
+	return hb.tx.GetInt(hb.blk, bucketNextPos)
+}
+
+func (hb *hashBucket) setNext(blkNum int) error {
+	return hb.tx.SetInt(hb.blk, bucketNextPos, blkNum, true)
+}
+
+// key は slot のレコードのキーを返す
+// 複合インデックスの場合は、列の値を並べた組の定数を返す
+func (hb *hashBucket) key(slot int) (query.Constant, error) {
+	if len(hb.keyFields) == 1 {
+		return hb.getVal(slot, IndexDataValueField)
+	}
+	vals := make([]query.Constant, len(hb.keyFields))
+	for i, fn := range hb.keyFields {
+		v, err := hb.getVal(slot, fn)
+		if err != nil {
+			return query.Constant{}, err
+		}
+		vals[i] = v
+	}
+	return query.NewTupleConstant(vals), nil
+}
+
+func (hb *hashBucket) rid(slot int) (*record.RecordID, error) {
+	blkNum, err := hb.getVal(slot, IndexBlockNumberField)
+	if err != nil {
+		return nil, err
+	}
+	id, err := hb.getVal(slot, IndexIdField)
+	if err != nil {
+		return nil, err
+	}
+	return record.NewRecordID(blkNum.AsInt(), id.AsInt()), nil
+}
+
+func (hb *hashBucket) getRecord(slot int) (hashRecord, error) {
+	key, err := hb.key(slot)
+	if err != nil {
+		return hashRecord{}, err
+	}
+	rid, err := hb.rid(slot)
+	if err != nil {
+		return hashRecord{}, err
+	}
+	return hashRecord{key, rid}, nil
+}
+
+func (hb *hashBucket) setRecord(slot int, rec hashRecord) error {
+	if err := hb.setVal(slot, IndexBlockNumberField, query.NewConstant(rec.rid.BlockNumber())); err != nil {
+		return err
+	}
+	if err := hb.setVal(slot, IndexIdField, query.NewConstant(rec.rid.Slot())); err != nil {
+		return err
+	}
+	if len(hb.keyFields) == 1 {
+		return hb.setVal(slot, IndexDataValueField, rec.key)
+	}
+	vals := rec.key.AsTuple()
+	if len(vals) != len(hb.keyFields) {
+		return fmt.Errorf("key %s must have %d values", rec.key, len(hb.keyFields))
+	}
+	for i, fn := range hb.keyFields {
+		if err := hb.setVal(slot, fn, vals[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendRecord はブロックの末尾にレコードを追加する
+func (hb *hashBucket) appendRecord(rec hashRecord) error {
+	n, err := hb.numRecords()
+	if err != nil {
+		return err
+	}
+	if err := hb.setRecord(n, rec); err != nil {
+		return err
+	}
+	return hb.setNumRecords(n + 1)
+}
+
+func (hb *hashBucket) getVal(slot int, fieldName string) (query.Constant, error) {
+	ft, err := hb.layout.Schema().FieldType(fieldName)
+	if err != nil {
+		return query.Constant{}, err
+	}
+	pos, err := hb.fieldPos(slot, fieldName)
+	if err != nil {
+		return query.Constant{}, err
+	}
+	switch ft {
+	case record.Integer:
+		v, err := hb.tx.GetInt(hb.blk, pos)
+		if err != nil {
+			return query.Constant{}, err
+		}
+		return query.NewConstant(v), nil
+	case record.String:
+		v, err := hb.tx.GetString(hb.blk, pos)
+		if err != nil {
+			return query.Constant{}, err
+		}
+		return query.NewConstant(v), nil
+	case record.UUID:
+		v, err := record.ReadUUID(hb.tx, hb.blk, pos)
+		if err != nil {
+			return query.Constant{}, err
+		}
+		return query.NewUUIDConstant(v), nil
+	}
+	return query.Constant{}, fmt.Errorf("invalid field type %v", ft)
+}
+
+func (hb *hashBucket) setVal(slot int, fieldName string, val query.Constant) error {
+	ft, err := hb.layout.Schema().FieldType(fieldName)
+	if err != nil {
+		return err
+	}
+	pos, err := hb.fieldPos(slot, fieldName)
+	if err != nil {
+		return err
+	}
+	switch ft {
+	case record.Integer:
+		return hb.tx.SetInt(hb.blk, pos, val.AsInt(), true)
+	case record.String:
+		return hb.tx.SetString(hb.blk, pos, val.AsString(), true)
+	case record.UUID:
+		return record.WriteUUID(hb.tx, hb.blk, pos, val.AsUUID(), true)
+	}
+	return fmt.Errorf("invalid field type %v", ft)
+}
+
+func (hb *hashBucket) fieldPos(slot int, fieldName string) (int, error) {
+	offset, err := hb.layout.Offset(fieldName)
+	if err != nil {
+		return 0, err
+	}
+	return bucketHeader + slot*hb.layout.SlotSize() + offset, nil
+}
@@ -0,0 +1,112 @@
+package index
+
+import (
+	"github.com/ksrnnb/go-rdb/file"
+	"github.com/ksrnnb/go-rdb/record"
+	"github.com/ksrnnb/go-rdb/tx"
+)
+
+// 拡張ハッシュのディレクトリのファイル
+// 先頭のブロックはヘッダーで、グローバル深度と解放したバケットのブロックのリストの先頭を保存する
+// 2つ目以降のブロックに、ハッシュ値の下位 (グローバル深度) ビットに対応するバケットのブロック番号を順に並べる
+const (
+	globalDepthPos = 0
+	freeBucketPos  = record.IntByteSize
+)
+
+// maxGlobalDepth はグローバル深度の上限
+// 上限に達したバケットや、全てのレコードのハッシュ値が同じバケットは分割せずに overflow chain をつなぐ
+const maxGlobalDepth = 16
+
+type hashDirectory struct {
+	tx       *tx.Transaction
+	fileName string
+}
+
+func newHashDirectory(tx *tx.Transaction, fileName string) *hashDirectory {
+	return &hashDirectory{tx, fileName}
+}
+
+func (hd *hashDirectory) globalDepth() (int, error) {
+	return hd.getInt(file.NewBlockID(hd.fileName, 0), globalDepthPos)
+}
+
+func (hd *hashDirectory) setGlobalDepth(d int) error {
+	return hd.setInt(file.NewBlockID(hd.fileName, 0), globalDepthPos, d)
+}
+
+func (hd *hashDirectory) freeBucket() (int, error) {
+	return hd.getInt(file.NewBlockID(hd.fileName, 0), freeBucketPos)
+}
+
+func (hd *hashDirectory) setFreeBucket(blkNum int) error {
+	return hd.setInt(file.NewBlockID(hd.fileName, 0), freeBucketPos, blkNum)
+}
+
+// bucket は i 番目のエントリが指すバケットのブロック番号を返す
+func (hd *hashDirectory) bucket(i int) (int, error) {
+	blk, pos := hd.entryPos(i)
+	return hd.getInt(blk, pos)
+}
+
+// setBucket は i 番目のエントリにバケットのブロック番号を保存する
+// エントリのブロックがまだない場合はファイルに追加する
+func (hd *hashDirectory) setBucket(i int, blkNum int) error {
+	blk, pos := hd.entryPos(i)
+	size, err := hd.tx.Size(hd.fileName)
+	if err != nil {
+		return err
+	}
+	for ; size <= blk.Number(); size++ {
+		if _, err := hd.tx.Append(hd.fileName); err != nil {
+			return err
+		}
+	}
+	return hd.setInt(blk, pos, blkNum)
+}
+
+// double はエントリの数を2倍にしてグローバル深度を1つ増やす
+// 増やしたエントリは、下位ビットが同じ元のエントリと同じバケットを指す
+func (hd *hashDirectory) double() error {
+	gd, err := hd.globalDepth()
+	if err != nil {
+		return err
+	}
+	n := 1 << gd
+	for i := 0; i < n; i++ {
+		b, err := hd.bucket(i)
+		if err != nil {
+			return err
+		}
+		if err := hd.setBucket(i+n, b); err != nil {
+			return err
+		}
+	}
+	return hd.setGlobalDepth(gd + 1)
+}
+
+func (hd *hashDirectory) entryPos(i int) (file.BlockID, int) {
+	epb := hd.tx.BlockSize() / record.IntByteSize
+	return file.NewBlockID(hd.fileName, 1+i/epb), (i % epb) * record.IntByteSize
+}
+
+func (hd *hashDirectory) getInt(blk file.BlockID, pos int) (int, error) {
+	if err := hd.tx.Pin(blk); err != nil {
+		return 0, err
+	}
+	v, err := hd.tx.GetInt(blk, pos)
+	if err != nil {
+		return 0, err
+	}
+	return v, hd.tx.Unpin(blk)
+}
+
+func (hd *hashDirectory) setInt(blk file.BlockID, pos int, v int) error {
+	if err := hd.tx.Pin(blk); err != nil {
+		return err
+	}
+	if err := hd.tx.SetInt(blk, pos, v, true); err != nil {
+		return err
+	}
+	return hd.tx.Unpin(blk)
+}
@@ -4,140 +4,631 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/ksrnnb/go-rdb/file"
 	"github.com/ksrnnb/go-rdb/query"
 	"github.com/ksrnnb/go-rdb/record"
 	"github.com/ksrnnb/go-rdb/tx"
 )
 
+// HashIndex は拡張ハッシュのインデックス
+// キーのハッシュ値の下位 (グローバル深度) ビットでディレクトリのエントリを選び、エントリが指すバケットにレコードを保存する
+// バケットが一杯になったら、ローカル深度を1つ増やして次のビットで2つのバケットに分けるので、
+// テーブルが大きくなってもバケットを1つ読めばキーのレコードが見つかる
+// 同じキーのレコードのようにハッシュ値で分けられないレコードは、バケットに overflow chain をつないで保存する
 type HashIndex struct {
-	tx        *tx.Transaction
-	indexName string
-	layout    *record.Layout
-	searchKey query.Constant
-	ts        *query.TableScan
+	tx         *tx.Transaction
+	layout     *record.Layout
+	dir        *hashDirectory
+	bucketFile string
+	capacity   int
+	searchKey  query.Constant
+	page       *hashBucket
+	slot       int
+	// scanAll が true の場合はキーによらず全てのレコードを返し、 pending のバケットを順に走査する
+	scanAll bool
+	pending []int
 }
 
-const NumBuckets = 100
+func NewHashIndex(tx *tx.Transaction, indexName string, layout *record.Layout) (*HashIndex, error) {
+	capacity := bucketCapacity(tx.BlockSize(), layout)
+	if capacity < 1 {
+		return nil, fmt.Errorf("index %s: key is too long for a hash bucket", indexName)
+	}
+	hi := &HashIndex{
+		tx:         tx,
+		layout:     layout,
+		dir:        newHashDirectory(tx, fmt.Sprintf("%s_directory", indexName)),
+		bucketFile: fmt.Sprintf("%s_bucket", indexName),
+		capacity:   capacity,
+	}
+	if err := hi.initializeIfNeeded(); err != nil {
+		return nil, err
+	}
+	return hi, nil
+}
 
-func NewHashIndex(tx *tx.Transaction, indexName string, layout *record.Layout) *HashIndex {
-	return &HashIndex{tx: tx, indexName: indexName, layout: layout}
+// initializeIfNeeded はファイルがない場合に、グローバル深度 0 のディレクトリと空のバケットを1つ作成する
+func (hi *HashIndex) initializeIfNeeded() error {
+	size, err := hi.tx.Size(hi.dir.fileName)
+	if err != nil {
+		return err
+	}
+	if size != 0 {
+		return nil
+	}
+	if _, err := hi.tx.Append(hi.dir.fileName); err != nil {
+		return err
+	}
+	if err := hi.dir.setGlobalDepth(0); err != nil {
+		return err
+	}
+	if err := hi.dir.setFreeBucket(hashNoBlock); err != nil {
+		return err
+	}
+	blkNum, err := hi.allocateBucket()
+	if err != nil {
+		return err
+	}
+	if err := hi.writeChain([]int{blkNum}, nil, 0); err != nil {
+		return err
+	}
+	return hi.dir.setBucket(0, blkNum)
 }
 
 func (hi *HashIndex) BeforeFirst(searchKey query.Constant) error {
-	err := hi.Close()
+	if err := hi.Close(); err != nil {
+		return err
+	}
+	hi.scanAll = false
+	hi.searchKey = NormalizeKey(hi.layout, searchKey)
+	blkNum, err := hi.findBucket(hi.searchKey)
 	if err != nil {
 		return err
 	}
-	hi.searchKey = searchKey
-	bucket := searchKey.HashCode() % NumBuckets
-	tableName := fmt.Sprintf("%s%d", hi.indexName, bucket)
-	hi.ts, err = query.NewTableScan(hi.tx, tableName, hi.layout)
-	return err
+	return hi.openBucket(blkNum)
 }
 
-func (hi *HashIndex) Next() (bool, error) {
-	if hi.ts == nil {
-		return false, errors.New("HashIndex doesn't have TableScan")
+// BeforeFirstAll はディレクトリのエントリが指す全てのバケットを走査する準備をする
+// 複数のエントリが同じバケットを指すので、同じバケットは1度だけ走査する
+func (hi *HashIndex) BeforeFirstAll() error {
+	if err := hi.Close(); err != nil {
+		return err
+	}
+	gd, err := hi.dir.globalDepth()
+	if err != nil {
+		return err
+	}
+	seen := make(map[int]bool)
+	buckets := make([]int, 0)
+	for i := 0; i < 1<<gd; i++ {
+		blkNum, err := hi.dir.bucket(i)
+		if err != nil {
+			return err
+		}
+		if !seen[blkNum] {
+			seen[blkNum] = true
+			buckets = append(buckets, blkNum)
+		}
 	}
+	hi.scanAll = true
+	hi.pending = buckets[1:]
+	return hi.openBucket(buckets[0])
+}
 
-	hasNext, err := hi.ts.Next()
+func (hi *HashIndex) openBucket(blkNum int) error {
+	page, err := newHashBucket(hi.tx, file.NewBlockID(hi.bucketFile, blkNum), hi.layout)
 	if err != nil {
-		return false, err
+		return err
+	}
+	hi.page = page
+	hi.slot = -1
+	return nil
+}
+
+// Next はバケットの chain をたどって、検索キーと等しい次のレコードに移動する
+// BeforeFirstAll で始めた場合は、 chain の終わりで次のバケットに移って全てのレコードを返す
+func (hi *HashIndex) Next() (bool, error) {
+	if hi.page == nil {
+		return false, errors.New("HashIndex is not positioned on a bucket")
 	}
-	for hasNext {
-		v, err := hi.ts.GetVal(IndexDataValueField)
+	for {
+		n, err := hi.page.numRecords()
 		if err != nil {
 			return false, err
 		}
-		if v.Equals(hi.searchKey) {
+		hi.slot++
+		if hi.slot >= n {
+			next, err := hi.page.next()
+			if err != nil {
+				return false, err
+			}
+			if next == hashNoBlock {
+				if !hi.scanAll || len(hi.pending) == 0 {
+					return false, nil
+				}
+				next, hi.pending = hi.pending[0], hi.pending[1:]
+			}
+			if err := hi.page.close(); err != nil {
+				return false, err
+			}
+			if err := hi.openBucket(next); err != nil {
+				return false, err
+			}
+			continue
+		}
+		if hi.scanAll {
 			return true, nil
 		}
-		newHasNext, err := hi.ts.Next()
+		v, err := hi.page.key(hi.slot)
 		if err != nil {
 			return false, err
 		}
-		hasNext = newHasNext
+		if v.Equals(hi.searchKey) {
+			return true, nil
+		}
 	}
-	return false, nil
 }
 
 func (hi *HashIndex) GetDataRid() (*record.RecordID, error) {
-	if hi.ts == nil {
-		return nil, errors.New("HashIndex doesn't have TableScan")
+	if hi.page == nil {
+		return nil, errors.New("HashIndex is not positioned on a bucket")
 	}
+	return hi.page.rid(hi.slot)
+}
 
-	blknum, err := hi.ts.GetInt(IndexBlockNumberField)
-	if err != nil {
-		return nil, err
-	}
-	id, err := hi.ts.GetInt(IndexIdField)
-	if err != nil {
-		return nil, err
+// GetDataVal は現在のインデックスレコードの data_value を返す
+func (hi *HashIndex) GetDataVal() (query.Constant, error) {
+	if hi.page == nil {
+		return query.Constant{}, errors.New("HashIndex is not positioned on a bucket")
 	}
-	return record.NewRecordID(blknum, id), nil
+	return hi.page.key(hi.slot)
 }
 
+// Insert はキーのバケットの chain の末尾にレコードを追加する
+// バケットが一杯の場合は、分割できれば分割してから入れ直し、分割できなければ overflow block を chain につなぐ
 func (hi *HashIndex) Insert(val query.Constant, rid *record.RecordID) error {
-	err := hi.BeforeFirst(val)
+	if err := hi.Close(); err != nil {
+		return err
+	}
+	rec := hashRecord{NormalizeKey(hi.layout, val), rid}
+	for {
+		primary, err := hi.findBucket(rec.key)
+		if err != nil {
+			return err
+		}
+		pages, err := hi.chainPages(primary)
+		if err != nil {
+			return err
+		}
+		last, err := newHashBucket(hi.tx, file.NewBlockID(hi.bucketFile, pages[len(pages)-1]), hi.layout)
+		if err != nil {
+			return err
+		}
+		n, err := last.numRecords()
+		if err != nil {
+			return err
+		}
+		if n < hi.capacity {
+			if err := last.appendRecord(rec); err != nil {
+				return err
+			}
+			return last.close()
+		}
+		if err := last.close(); err != nil {
+			return err
+		}
+
+		recs, err := hi.chainRecords(pages)
+		if err != nil {
+			return err
+		}
+		ld, err := hi.localDepth(primary)
+		if err != nil {
+			return err
+		}
+		if !splittable(recs, rec, ld) {
+			return hi.appendOverflow(pages[len(pages)-1], rec, ld)
+		}
+		if err := hi.split(rec.key.HashCode(), pages, recs, ld); err != nil {
+			return err
+		}
+	}
+}
+
+// Delete はキーのバケットの chain からレコードを削除する
+// chain の最後のレコードを空いた slot に移して、最後のブロックが空になったら chain から外して解放する
+func (hi *HashIndex) Delete(val query.Constant, rid *record.RecordID) error {
+	if err := hi.Close(); err != nil {
+		return err
+	}
+	key := NormalizeKey(hi.layout, val)
+	primary, err := hi.findBucket(key)
 	if err != nil {
 		return err
 	}
-	err = hi.ts.Insert()
+	pages, err := hi.chainPages(primary)
 	if err != nil {
 		return err
 	}
+	for i, blkNum := range pages {
+		page, err := newHashBucket(hi.tx, file.NewBlockID(hi.bucketFile, blkNum), hi.layout)
+		if err != nil {
+			return err
+		}
+		slot, err := findRecord(page, key, rid)
+		if err != nil {
+			return err
+		}
+		if slot < 0 {
+			if err := page.close(); err != nil {
+				return err
+			}
+			continue
+		}
+		err = hi.fillHole(pages, i, page, slot)
+		if cerr := page.close(); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+	return nil
+}
 
-	err = hi.ts.SetInt(IndexBlockNumberField, rid.BlockNumber())
+func findRecord(page *hashBucket, key query.Constant, rid *record.RecordID) (int, error) {
+	n, err := page.numRecords()
 	if err != nil {
-		return err
+		return 0, err
 	}
+	for slot := 0; slot < n; slot++ {
+		v, err := page.key(slot)
+		if err != nil {
+			return 0, err
+		}
+		if !v.Equals(key) {
+			continue
+		}
+		r, err := page.rid(slot)
+		if err != nil {
+			return 0, err
+		}
+		if r.Equals(rid) {
+			return slot, nil
+		}
+	}
+	return -1, nil
+}
 
-	err = hi.ts.SetInt(IndexIdField, rid.Slot())
+// fillHole は pages[i] の page の slot を chain の最後のレコードで埋めて、 chain を詰める
+func (hi *HashIndex) fillHole(pages []int, i int, page *hashBucket, slot int) error {
+	last := page
+	if i != len(pages)-1 {
+		p, err := newHashBucket(hi.tx, file.NewBlockID(hi.bucketFile, pages[len(pages)-1]), hi.layout)
+		if err != nil {
+			return err
+		}
+		last = p
+	}
+	n, err := last.numRecords()
 	if err != nil {
 		return err
 	}
+	if last != page || slot != n-1 {
+		rec, err := last.getRecord(n - 1)
+		if err != nil {
+			return err
+		}
+		if err := page.setRecord(slot, rec); err != nil {
+			return err
+		}
+	}
+	if err := last.setNumRecords(n - 1); err != nil {
+		return err
+	}
+	if last != page {
+		if err := last.close(); err != nil {
+			return err
+		}
+	}
+	if n-1 > 0 || len(pages) == 1 {
+		return nil
+	}
 
-	err = hi.ts.SetVal(IndexDataValueField, val)
+	prev, err := newHashBucket(hi.tx, file.NewBlockID(hi.bucketFile, pages[len(pages)-2]), hi.layout)
 	if err != nil {
 		return err
 	}
-	return nil
+	if err := prev.setNext(hashNoBlock); err != nil {
+		return err
+	}
+	if err := prev.close(); err != nil {
+		return err
+	}
+	return hi.freeBucket(pages[len(pages)-1])
 }
 
-func (hi *HashIndex) Delete(val query.Constant, rid *record.RecordID) error {
-	err := hi.BeforeFirst(val)
+func (hi *HashIndex) Close() error {
+	if hi.page == nil {
+		return nil
+	}
+	err := hi.page.close()
+	hi.page = nil
+	return err
+}
+
+// findBucket はキーのハッシュ値の下位 (グローバル深度) ビットのエントリが指すバケットのブロック番号を返す
+func (hi *HashIndex) findBucket(key query.Constant) (int, error) {
+	gd, err := hi.dir.globalDepth()
+	if err != nil {
+		return 0, err
+	}
+	return hi.dir.bucket(int(key.HashCode() & depthMask(gd)))
+}
+
+func depthMask(depth int) uint32 {
+	return uint32(1)<<depth - 1
+}
+
+// splittable は rec を加えたバケットのレコードを、ハッシュ値の次のビットから maxGlobalDepth ビットまでで分けられるかどうかを返す
+func splittable(recs []hashRecord, rec hashRecord, localDepth int) bool {
+	if localDepth >= maxGlobalDepth {
+		return false
+	}
+	h := rec.key.HashCode() & depthMask(maxGlobalDepth)
+	for _, r := range recs {
+		if r.key.HashCode()&depthMask(maxGlobalDepth) != h {
+			return true
+		}
+	}
+	return false
+}
+
+// split はバケットをローカル深度の次のビットで2つに分ける
+// ビットが 1 のレコードを新しいバケットに移して、そのビットが 1 のディレクトリのエントリを新しいバケットに向ける
+// 必要であれば先にディレクトリを2倍にする
+func (hi *HashIndex) split(hash uint32, pages []int, recs []hashRecord, localDepth int) error {
+	gd, err := hi.dir.globalDepth()
 	if err != nil {
 		return err
 	}
+	if localDepth == gd {
+		if err := hi.dir.double(); err != nil {
+			return err
+		}
+		gd++
+	}
 
-	hasNext, err := hi.ts.Next()
+	stay := make([]hashRecord, 0, len(recs))
+	move := make([]hashRecord, 0, len(recs))
+	for _, r := range recs {
+		if r.key.HashCode()>>localDepth&1 == 1 {
+			move = append(move, r)
+		} else {
+			stay = append(stay, r)
+		}
+	}
+
+	// 元の chain のブロックを先に使って、足りない分だけ新しく割り当てる
+	stayPages, rest, err := hi.takePages(pages, hi.pagesNeeded(len(stay)))
 	if err != nil {
 		return err
 	}
-	for hasNext {
-		v, err := hi.ts.GetVal(IndexDataValueField)
-		if err != nil {
+	movePages, rest, err := hi.takePages(rest, hi.pagesNeeded(len(move)))
+	if err != nil {
+		return err
+	}
+	for _, blkNum := range rest {
+		if err := hi.freeBucket(blkNum); err != nil {
 			return err
 		}
-		if v.Equals(hi.searchKey) {
-			return hi.ts.Delete()
+	}
+	if err := hi.writeChain(stayPages, stay, localDepth+1); err != nil {
+		return err
+	}
+	if err := hi.writeChain(movePages, move, localDepth+1); err != nil {
+		return err
+	}
+
+	// 下位 localDepth ビットがこのバケットと同じエントリのうち、次のビットが 1 のものを新しいバケットに向ける
+	base := int(hash & depthMask(localDepth))
+	for j := 1; j < 1<<(gd-localDepth); j += 2 {
+		if err := hi.dir.setBucket(base|j<<localDepth, movePages[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (hi *HashIndex) pagesNeeded(numRecords int) int {
+	n := (numRecords + hi.capacity - 1) / hi.capacity
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// takePages は pages の先頭から n 個のブロックを取り出し、足りない場合は新しく割り当てる
+// 残ったブロックも返す
+func (hi *HashIndex) takePages(pages []int, n int) ([]int, []int, error) {
+	if n <= len(pages) {
+		return pages[:n], pages[n:], nil
+	}
+	taken := append([]int{}, pages...)
+	for len(taken) < n {
+		blkNum, err := hi.allocateBucket()
+		if err != nil {
+			return nil, nil, err
 		}
-		newHasNext, err := hi.ts.Next()
+		taken = append(taken, blkNum)
+	}
+	return taken, nil, nil
+}
+
+// writeChain は pages のブロックを順につないだ chain に、 recs を先頭のブロックから詰めて書き込む
+func (hi *HashIndex) writeChain(pages []int, recs []hashRecord, localDepth int) error {
+	for i, blkNum := range pages {
+		page, err := newHashBucket(hi.tx, file.NewBlockID(hi.bucketFile, blkNum), hi.layout)
 		if err != nil {
 			return err
 		}
-		hasNext = newHasNext
+		if err := page.format(localDepth); err != nil {
+			return err
+		}
+		for j := i * hi.capacity; j < len(recs) && j < (i+1)*hi.capacity; j++ {
+			if err := page.appendRecord(recs[j]); err != nil {
+				return err
+			}
+		}
+		if i+1 < len(pages) {
+			if err := page.setNext(pages[i+1]); err != nil {
+				return err
+			}
+		}
+		if err := page.close(); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func (hi *HashIndex) Close() error {
-	if hi.ts == nil {
-		return nil
+// appendOverflow は chain の最後のブロック lastBlk の後ろに、 rec だけを入れたブロックをつなぐ
+func (hi *HashIndex) appendOverflow(lastBlk int, rec hashRecord, localDepth int) error {
+	blkNum, err := hi.allocateBucket()
+	if err != nil {
+		return err
+	}
+	if err := hi.writeChain([]int{blkNum}, []hashRecord{rec}, localDepth); err != nil {
+		return err
+	}
+	last, err := newHashBucket(hi.tx, file.NewBlockID(hi.bucketFile, lastBlk), hi.layout)
+	if err != nil {
+		return err
+	}
+	if err := last.setNext(blkNum); err != nil {
+		return err
+	}
+	return last.close()
+}
+
+func (hi *HashIndex) localDepth(blkNum int) (int, error) {
+	page, err := newHashBucket(hi.tx, file.NewBlockID(hi.bucketFile, blkNum), hi.layout)
+	if err != nil {
+		return 0, err
+	}
+	ld, err := page.localDepth()
+	if err != nil {
+		return 0, err
 	}
-	return hi.ts.Close()
+	return ld, page.close()
 }
 
-func SearchCostHashIndex(numBlocks int, rpb int) int {
-	return numBlocks / NumBuckets
+// chainPages はバケットの chain のブロック番号を先頭から順に返す
+func (hi *HashIndex) chainPages(primary int) ([]int, error) {
+	pages := make([]int, 0)
+	for blkNum := primary; blkNum != hashNoBlock; {
+		pages = append(pages, blkNum)
+		page, err := newHashBucket(hi.tx, file.NewBlockID(hi.bucketFile, blkNum), hi.layout)
+		if err != nil {
+			return nil, err
+		}
+		next, err := page.next()
+		if err != nil {
+			return nil, err
+		}
+		if err := page.close(); err != nil {
+			return nil, err
+		}
+		blkNum = next
+	}
+	return pages, nil
+}
+
+// chainRecords は pages のブロックの全てのレコードを返す
+func (hi *HashIndex) chainRecords(pages []int) ([]hashRecord, error) {
+	recs := make([]hashRecord, 0)
+	for _, blkNum := range pages {
+		page, err := newHashBucket(hi.tx, file.NewBlockID(hi.bucketFile, blkNum), hi.layout)
+		if err != nil {
+			return nil, err
+		}
+		n, err := page.numRecords()
+		if err != nil {
+			return nil, err
+		}
+		for slot := 0; slot < n; slot++ {
+			rec, err := page.getRecord(slot)
+			if err != nil {
+				return nil, err
+			}
+			recs = append(recs, rec)
+		}
+		if err := page.close(); err != nil {
+			return nil, err
+		}
+	}
+	return recs, nil
+}
+
+// allocateBucket は解放したバケットのブロックがあれば再利用して、なければファイルの末尾に追加する
+// ブロックの中身は呼び出し側で writeChain して初期化する
+func (hi *HashIndex) allocateBucket() (int, error) {
+	head, err := hi.dir.freeBucket()
+	if err != nil {
+		return 0, err
+	}
+	if head == hashNoBlock {
+		blk, err := hi.tx.Append(hi.bucketFile)
+		if err != nil {
+			return 0, err
+		}
+		return blk.Number(), nil
+	}
+	page, err := newHashBucket(hi.tx, file.NewBlockID(hi.bucketFile, head), hi.layout)
+	if err != nil {
+		return 0, err
+	}
+	next, err := page.next()
+	if err != nil {
+		return 0, err
+	}
+	if err := page.close(); err != nil {
+		return 0, err
+	}
+	return head, hi.dir.setFreeBucket(next)
+}
+
+// freeBucket はブロックを空にして、解放したブロックのリストの先頭につなぐ
+func (hi *HashIndex) freeBucket(blkNum int) error {
+	head, err := hi.dir.freeBucket()
+	if err != nil {
+		return err
+	}
+	page, err := newHashBucket(hi.tx, file.NewBlockID(hi.bucketFile, blkNum), hi.layout)
+	if err != nil {
+		return err
+	}
+	if err := page.setNumRecords(0); err != nil {
+		return err
+	}
+	if err := page.setNext(head); err != nil {
+		return err
+	}
+	if err := page.close(); err != nil {
+		return err
+	}
+	return hi.dir.setFreeBucket(blkNum)
+}
+
+// SearchCostHashIndex は1つのキーの numRecords 個のインデックスレコードを読むときのブロックアクセス数を返す
+// ディレクトリのヘッダーとエントリのブロックを1つずつ読んでから、バケットの chain を読む
+// 同じキーのレコードは chain に詰めて保存するので、 rpb 個ごとに1ブロック (少なくとも1ブロック) 読めばよく、
+// インデックスの大きさにはよらない
+func SearchCostHashIndex(numRecords int, rpb int) int {
+	if rpb < 1 {
+		rpb = 1
+	}
+	bucketBlocks := ceilDiv(numRecords, rpb)
+	if bucketBlocks < 1 {
+		bucketBlocks = 1
+	}
+	return 2 + bucketBlocks
 }
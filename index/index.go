@@ -1,6 +1,9 @@
 package index
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/ksrnnb/go-rdb/query"
 	"github.com/ksrnnb/go-rdb/record"
 )
@@ -13,6 +16,28 @@ const (
 	IndexDataValueField   = "data_value"
 )
 
+// DataValueField は複合インデックスの i 番目 (0 から始まる) の列を保存するフィールド名を返す
+// 先頭の列は 1 列のインデックスと同じ data_value に、2 列目以降は data_value_2, data_value_3, ... に保存する
+func DataValueField(i int) string {
+	if i == 0 {
+		return IndexDataValueField
+	}
+	return fmt.Sprintf("%s_%d", IndexDataValueField, i+1)
+}
+
+// DataValueFields はインデックスレコードのキーを保存するフィールド名を列の順に返す
+func DataValueFields(schema *record.Schema) []string {
+	fns := make([]string, 0)
+	for i := 0; schema.HasField(DataValueField(i)); i++ {
+		fns = append(fns, DataValueField(i))
+	}
+	return fns
+}
+
+// MaxKeyLength は式や JSON のインデックスのキーとして保存する最大文字数
+// キーが切り詰められるので、インデックスを使う側で条件を再度確認する必要がある
+const MaxKeyLength = 32
+
 type Index interface {
 	Next() (bool, error)
 	BeforeFirst(searchKey query.Constant) error
@@ -22,12 +47,202 @@ type Index interface {
 	Close() error
 }
 
+// RangeIndex はキーの範囲を指定して、キーの順にインデックスレコードを走査できるインデックス
+// descending が true の場合は大きいキーから順に走査する
+type RangeIndex interface {
+	Index
+	BeforeFirstRange(r query.Range, descending bool) error
+}
+
+// CoveringIndex は現在のインデックスレコードのキーを返せるインデックス
+// キーがフィールドの値そのものの場合は、データレコードを読まずにクエリに答えられる
+type CoveringIndex interface {
+	Index
+	GetDataVal() (query.Constant, error)
+}
+
+// FullScanIndex はキーを指定せずに、全てのインデックスレコードを走査できるインデックス
+// 整合性の確認で、テーブルにないレコードを指すインデックスレコードを探すときに使う
+type FullScanIndex interface {
+	CoveringIndex
+	BeforeFirstAll() error
+}
+
+// TextSearcher は語を全て含むレコードを、関連度の高い順に返せるインデックス
+type TextSearcher interface {
+	Index
+	// Search は terms の全ての語を含むレコードを関連度の高い順に返す
+	// numRecords はテーブルのレコード数で、多くのレコードに現れる語ほど関連度への寄与を小さくするために使う
+	Search(terms string, numRecords int) ([]TextMatch, error)
+}
+
+// BitmapSearcher は値が等しいレコードの集合をビットマップで返せるインデックス
+// 同じテーブルのインデックスのビットマップは AND, OR で組み合わせてから、テーブルのレコードを読める
+type BitmapSearcher interface {
+	Index
+	// Bitmap は val と等しい値のレコードの集合を返す
+	Bitmap(val query.Constant) (*Bitmap, error)
+	// RecordID はビットマップの位置のレコードの rid を返す
+	RecordID(pos int) *record.RecordID
+}
+
+// TextMatch は全文検索に一致したレコードと関連度
+type TextMatch struct {
+	rid   *record.RecordID
+	score float64
+}
+
+func NewTextMatch(rid *record.RecordID, score float64) TextMatch {
+	return TextMatch{rid, score}
+}
+
+func (tm TextMatch) Rid() *record.RecordID {
+	return tm.rid
+}
+
+func (tm TextMatch) Score() float64 {
+	return tm.score
+}
+
+// BulkLoader は空のインデックスに、キーの順に並んだインデックスレコードをまとめて登録できるインデックス
+// 1件ずつ Insert するより速く構築できる
+type BulkLoader interface {
+	Index
+	// BulkLoad は src の全てのレコードを登録する
+	// src はインデックスレコードと同じフィールドを持ち、キーの昇順に並んでいる必要がある
+	// unique の場合は、同じキーのレコードがあるとエラーを返す
+	BulkLoad(src query.Scanner, unique bool) error
+}
+
+// NormalizeRange は範囲の端を NormalizeKey で変換する
+// 切り詰めた端は元の値より小さくなるので、切り詰めたキーのレコードも含むように端を含む範囲にする
+func NormalizeRange(layout *record.Layout, r query.Range) query.Range {
+	normalize := func(b query.Bound) query.Bound {
+		if b.IsOpen() {
+			return b
+		}
+		v := NormalizeKey(layout, b.Value())
+		if v.String() != b.Value().String() {
+			return query.NewBound(v, true)
+		}
+		return query.NewBound(v, b.IsInclusive())
+	}
+	return query.NewRange(normalize(r.Low()), normalize(r.High()))
+}
+
+// NormalizeKey は検索キーをインデックスレコードの data_value に保存できる形に変換する
+// data_value が文字列の場合は文字列の定数に変換して、長さを超える部分を切り詰める
+// data_value が UUID の場合は文字列のリテラルを UUID に変換する
+// 複合インデックスの組は、それぞれの値を対応する列のフィールドに合わせて変換する
+func NormalizeKey(layout *record.Layout, key query.Constant) query.Constant {
+	if key.ConstantType() != query.TupleConstant {
+		return normalizeValue(layout, IndexDataValueField, key)
+	}
+	vals := make([]query.Constant, len(key.AsTuple()))
+	for i, v := range key.AsTuple() {
+		vals[i] = normalizeValue(layout, DataValueField(i), v)
+	}
+	if key.IsTupleUpperBound() {
+		return query.NewTupleUpperBound(vals)
+	}
+	return query.NewTupleConstant(vals)
+}
+
+func normalizeValue(layout *record.Layout, fieldName string, key query.Constant) query.Constant {
+	ft, err := layout.Schema().FieldType(fieldName)
+	if err != nil || key.IsUnknown() {
+		return key
+	}
+	if ft == record.UUID && key.ConstantType() == query.StringConstant {
+		u, err := query.ParseUUID(key.AsString())
+		if err != nil {
+			return key
+		}
+		return u
+	}
+	if ft != record.String {
+		return key
+	}
+	l, err := layout.Schema().Length(fieldName)
+	if err != nil {
+		return key
+	}
+	rs := []rune(key.String())
+	if len(rs) > l {
+		rs = rs[:l]
+	}
+	return query.NewConstant(string(rs))
+}
+
 type IndexType uint8
 
 const (
 	HashIndexType IndexType = iota + 1
+	BTreeIndexType
+	// FullTextIndexType は文字列を語に分けて、語からレコードを引く転置インデックス
+	FullTextIndexType
+	// BitmapIndexType は値ごとにレコードの集合をビットマップで保存するインデックス
+	BitmapIndexType
 )
 
-func SearchCost(it IndexType, numBlocks int, rpb int) int {
-	return 0
+// ParseIndexType は CREATE INDEX ... USING に指定した方式名 (大文字小文字は区別しない) のインデックスの種類を返す
+// 方式名が空の場合は B-tree にする
+func ParseIndexType(method string) (IndexType, bool) {
+	switch strings.ToLower(method) {
+	case "", "btree":
+		return BTreeIndexType, true
+	case "hash":
+		return HashIndexType, true
+	case "fulltext":
+		return FullTextIndexType, true
+	case "bitmap":
+		return BitmapIndexType, true
+	}
+	return 0, false
+}
+
+// String はカタログに保存する方式名を返す
+func (it IndexType) String() string {
+	switch it {
+	case HashIndexType:
+		return "hash"
+	case BTreeIndexType:
+		return "btree"
+	case FullTextIndexType:
+		return "fulltext"
+	case BitmapIndexType:
+		return "bitmap"
+	}
+	return fmt.Sprintf("IndexType(%d)", it)
+}
+
+// SearchCost は numRecords 個のレコードをもつインデックスから、キーが条件に合う matchRecords 個のインデックスレコードを
+// 読むときのブロックアクセス数を返す
+// rpb は1つのブロックに入るインデックスレコードの数
+func SearchCost(it IndexType, numRecords int, matchRecords int, rpb int) int {
+	if it == HashIndexType {
+		return SearchCostHashIndex(matchRecords, rpb)
+	}
+	return SearchCostBTreeIndex(numRecords, matchRecords, rpb)
+}
+
+// SearchCostBTreeIndex は B-tree のルートから leaf まで下り、条件に合うレコードの leaf を順に読むときのブロックアクセス数を返す
+// ディレクトリの1ブロックには leaf のレコード以上のエントリが入るので、 rpb 個ずつに分かれるとみなして段数を見積もる
+func SearchCostBTreeIndex(numRecords int, matchRecords int, rpb int) int {
+	if rpb < 2 {
+		rpb = 2
+	}
+	levels := 1
+	for n := ceilDiv(numRecords, rpb); n > rpb; n = ceilDiv(n, rpb) {
+		levels++
+	}
+	leafBlocks := ceilDiv(matchRecords, rpb)
+	if leafBlocks < 1 {
+		leafBlocks = 1
+	}
+	return levels + leafBlocks
+}
+
+func ceilDiv(a int, b int) int {
+	return (a + b - 1) / b
 }
@@ -3,11 +3,14 @@ package index_test
 import (
 	"fmt"
 	"os"
+	"sort"
+	"sync"
 	"testing"
 
 	"github.com/ksrnnb/go-rdb/index"
 	"github.com/ksrnnb/go-rdb/planner"
 	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/record"
 	"github.com/ksrnnb/go-rdb/server"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -68,7 +71,7 @@ func TestIndexRetrieval(t *testing.T) {
 	// Open the index on MajorId
 	indexes, err := mdm.GetIndexInfo("student", tx)
 	require.NoError(t, err)
-	ii, ok := indexes["major_id"]
+	ii, ok := indexes["student_major_id_index"]
 	require.True(t, ok)
 	idx, err := ii.Open()
 	require.NoError(t, err)
@@ -125,10 +128,10 @@ func TestIndexUpdate(t *testing.T) {
 	indexes := make(map[string]index.Index)
 	idxInfo, err := mdm.GetIndexInfo("student", tx)
 	require.NoError(t, err)
-	for fn, ii := range idxInfo {
+	for _, ii := range idxInfo {
 		idx, err := ii.Open()
 		require.NoError(t, err)
-		indexes[fn] = idx
+		indexes[ii.Key()] = idx
 	}
 
 	// Task1: insert a new student record for sam
@@ -195,3 +198,545 @@ func TestIndexUpdate(t *testing.T) {
 	}
 	require.NoError(t, tx.Commit())
 }
+
+func TestBTreeIndex_BeforeFirstRange(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	mdm := db.MetadataManager()
+	pe := planner.NewPlanExecuter(planner.NewBasicQueryPlanner(mdm), planner.NewIndexUpdatePlanner(mdm))
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+	_, err = pe.ExecuteScript("create table nums (n int, k varchar(20)); create index nums_k_index on nums (k);", tx)
+	require.NoError(t, err)
+
+	// キーが長いと1ブロックに入るレコードが少ないので、少ないレコードでディレクトリが何段にもなる
+	// 同じキーのレコードも入れて overflow chain を作る
+	ps, err := pe.Prepare("insert into nums (n, k) values (?, ?)")
+	require.NoError(t, err)
+	keys := make([]string, 0)
+	for i := 0; i < 600; i++ {
+		k := fmt.Sprintf("k%04d", i%150)
+		if i%20 == 0 {
+			k = "k0050"
+		}
+		_, err := ps.ExecuteUpdate([]query.Constant{query.NewConstant(i), query.NewConstant(k)}, tx)
+		require.NoError(t, err)
+		keys = append(keys, k)
+	}
+	require.NoError(t, tx.Commit())
+
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	tp, err := planner.NewTablePlan(tx, "nums", mdm)
+	require.NoError(t, err)
+	s, err := tp.Open()
+	require.NoError(t, err)
+	ts := s.(query.UpdateScanner)
+	indexes, err := mdm.GetIndexInfo("nums", tx)
+	require.NoError(t, err)
+	idx, err := indexes["nums_k_index"].Open()
+	require.NoError(t, err)
+	ri, ok := idx.(index.RangeIndex)
+	require.True(t, ok)
+
+	bound := func(v string, inclusive bool) query.Bound {
+		return query.NewBound(query.NewConstant(v), inclusive)
+	}
+	tests := []struct {
+		name string
+		r    query.Range
+	}{
+		{"closed", query.NewRange(bound("k0010", true), bound("k0080", true))},
+		{"half open", query.NewRange(bound("k0049", false), bound("k0051", false))},
+		{"no low", query.NewRange(query.Bound{}, bound("k0030", true))},
+		{"no high", query.NewRange(bound("k0120", false), query.Bound{})},
+		{"unbounded", query.NewRange(query.Bound{}, query.Bound{})},
+		{"point", query.NewPointRange(query.NewConstant("k0050"))},
+		{"empty", query.NewRange(bound("k9999", true), query.Bound{})},
+	}
+	for _, tt := range tests {
+		for _, descending := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s descending=%v", tt.name, descending), func(t *testing.T) {
+				want := 0
+				for _, k := range keys {
+					if tt.r.Contains(query.NewConstant(k)) {
+						want++
+					}
+				}
+
+				require.NoError(t, ri.BeforeFirstRange(tt.r, descending))
+				got := 0
+				prev := ""
+				for {
+					ok, err := ri.Next()
+					require.NoError(t, err)
+					if !ok {
+						break
+					}
+					rid, err := ri.GetDataRid()
+					require.NoError(t, err)
+					require.NoError(t, ts.MoveToRid(rid))
+					k, err := ts.GetString("k")
+					require.NoError(t, err)
+					assert.True(t, tt.r.Contains(query.NewConstant(k)))
+					if got > 0 {
+						if descending {
+							assert.LessOrEqual(t, k, prev)
+						} else {
+							assert.GreaterOrEqual(t, k, prev)
+						}
+					}
+					prev = k
+					got++
+				}
+				assert.Equal(t, want, got)
+			})
+		}
+	}
+
+	// 範囲の走査の後でも、キーを指定した走査ができる
+	require.NoError(t, idx.BeforeFirst(query.NewConstant("k0050")))
+	n := 0
+	for {
+		ok, err := idx.Next()
+		require.NoError(t, err)
+		if !ok {
+			break
+		}
+		n++
+	}
+	want := 0
+	for _, k := range keys {
+		if k == "k0050" {
+			want++
+		}
+	}
+	assert.Equal(t, want, n)
+	require.NoError(t, idx.Close())
+	require.NoError(t, ts.Close())
+	require.NoError(t, tx.Commit())
+}
+
+func TestBTreeIndex_DeleteMerge(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	mdm := db.MetadataManager()
+	pe := planner.NewPlanExecuter(planner.NewBasicQueryPlanner(mdm), planner.NewIndexUpdatePlanner(mdm))
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+	_, err = pe.ExecuteScript("create table nums (n int, k varchar(20)); create index nums_k_index on nums (k);", tx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	// 同じキーのレコードも入れて overflow chain を作る
+	insertAll := func() {
+		tx, err := db.NewTransaction()
+		require.NoError(t, err)
+		ps, err := pe.Prepare("insert into nums (n, k) values (?, ?)")
+		require.NoError(t, err)
+		for i := 0; i < 600; i++ {
+			k := fmt.Sprintf("k%04d", i%150)
+			if i%20 == 0 {
+				k = "k0050"
+			}
+			_, err := ps.ExecuteUpdate([]query.Constant{query.NewConstant(i), query.NewConstant(k)}, tx)
+			require.NoError(t, err)
+		}
+		require.NoError(t, tx.Commit())
+	}
+	deleteRows := func(pred string, commit bool) {
+		tx, err := db.NewTransaction()
+		require.NoError(t, err)
+		_, err = pe.ExecuteUpdate("delete from nums"+pred, tx)
+		require.NoError(t, err)
+		if commit {
+			require.NoError(t, tx.Commit())
+		} else {
+			require.NoError(t, tx.Rollback())
+		}
+	}
+	// インデックスを昇順に走査して、キーが順に並んでいてデータレコードと一致することを確認する
+	// 全てのキーで検索できることも確認して、レコード数と leaf のファイルのブロック数を返す
+	check := func() (int, int) {
+		tx, err := db.NewTransaction()
+		require.NoError(t, err)
+		tp, err := planner.NewTablePlan(tx, "nums", mdm)
+		require.NoError(t, err)
+		s, err := tp.Open()
+		require.NoError(t, err)
+		ts := s.(query.UpdateScanner)
+		want := make(map[string]int)
+		for {
+			ok, err := ts.Next()
+			require.NoError(t, err)
+			if !ok {
+				break
+			}
+			k, err := ts.GetString("k")
+			require.NoError(t, err)
+			want[k]++
+		}
+
+		indexes, err := mdm.GetIndexInfo("nums", tx)
+		require.NoError(t, err)
+		idx, err := indexes["nums_k_index"].Open()
+		require.NoError(t, err)
+		ri := idx.(index.RangeIndex)
+		require.NoError(t, ri.BeforeFirstRange(query.NewRange(query.Bound{}, query.Bound{}), false))
+		got := make(map[string]int)
+		prev := ""
+		n := 0
+		for {
+			ok, err := ri.Next()
+			require.NoError(t, err)
+			if !ok {
+				break
+			}
+			rid, err := ri.GetDataRid()
+			require.NoError(t, err)
+			require.NoError(t, ts.MoveToRid(rid))
+			k, err := ts.GetString("k")
+			require.NoError(t, err)
+			assert.GreaterOrEqual(t, k, prev)
+			prev = k
+			got[k]++
+			n++
+		}
+		assert.Equal(t, want, got)
+
+		for k, c := range want {
+			require.NoError(t, idx.BeforeFirst(query.NewConstant(k)))
+			found := 0
+			for {
+				ok, err := idx.Next()
+				require.NoError(t, err)
+				if !ok {
+					break
+				}
+				found++
+			}
+			assert.Equal(t, c, found, k)
+		}
+		require.NoError(t, idx.Close())
+		require.NoError(t, ts.Close())
+		size, err := tx.Size("nums_k_index_leaf")
+		require.NoError(t, err)
+		require.NoError(t, tx.Commit())
+		return n, size
+	}
+
+	insertAll()
+	n, fullSize := check()
+	assert.Equal(t, 600, n)
+
+	// 大半のレコードを削除すると leaf が併合される
+	deleteRows(" where n >= 60", true)
+	n, _ = check()
+	assert.Equal(t, 60, n)
+
+	// 削除をロールバックすると、併合したページも元に戻る
+	deleteRows("", false)
+	n, _ = check()
+	assert.Equal(t, 60, n)
+
+	// 全て削除してから入れ直すと、解放したブロックを再利用するのでファイルは大きくならない
+	deleteRows("", true)
+	n, _ = check()
+	assert.Equal(t, 0, n)
+	insertAll()
+	n, size := check()
+	assert.Equal(t, 600, n)
+	assert.Equal(t, fullSize, size)
+}
+
+func TestBTreeIndex_ConcurrentInsert(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data", server.WithBufferSize(32))
+	mdm := db.MetadataManager()
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+	_, err = db.PlanExecuter().ExecuteScript("create table nums (n int, k varchar(20)); create index nums_k_index on nums (k);", tx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	// 複数のトランザクションが同じインデックスに同時に挿入して、 leaf とディレクトリを分割する
+	// leaf はトランザクションの終わりまでロックするので、トランザクションごとに挿入するキーの範囲を分けて、
+	// 範囲ごとに別の leaf になるように、あらかじめ各範囲にレコードを入れておく
+	// 最初のトランザクションはロールバックするので、他のトランザクションが同じページに挿入したレコードは残して、自分のレコードだけを削除する
+	const workers = 4
+	const perWorker = 150
+	const seedPerWorker = 30
+	key := func(w, i int) string {
+		return fmt.Sprintf("k%d-%04d", w, i)
+	}
+	seed := func(w, i int) string {
+		return fmt.Sprintf("k%d-%04da", w, i*perWorker/seedPerWorker)
+	}
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	indexes, err := mdm.GetIndexInfo("nums", tx)
+	require.NoError(t, err)
+	idx, err := indexes["nums_k_index"].Open()
+	require.NoError(t, err)
+	want := make([]string, 0)
+	for w := 0; w < workers; w++ {
+		for i := 0; i < seedPerWorker; i++ {
+			require.NoError(t, idx.Insert(query.NewConstant(seed(w, i)), record.NewRecordID(workers, i)))
+			want = append(want, seed(w, i))
+		}
+	}
+	require.NoError(t, idx.Close())
+	require.NoError(t, tx.Commit())
+
+	insert := func(w int) error {
+		tx, err := db.NewTransaction()
+		if err != nil {
+			return err
+		}
+		indexes, err := mdm.GetIndexInfo("nums", tx)
+		if err != nil {
+			return err
+		}
+		idx, err := indexes["nums_k_index"].Open()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < perWorker; i++ {
+			if err := idx.Insert(query.NewConstant(key(w, i)), record.NewRecordID(w, i)); err != nil {
+				return err
+			}
+		}
+		if err := idx.Close(); err != nil {
+			return err
+		}
+		if w == 0 {
+			return tx.Rollback()
+		}
+		return tx.Commit()
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			errs[w] = insert(w)
+		}(w)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	indexes, err = mdm.GetIndexInfo("nums", tx)
+	require.NoError(t, err)
+	idx, err = indexes["nums_k_index"].Open()
+	require.NoError(t, err)
+	fi := idx.(index.FullScanIndex)
+	require.NoError(t, fi.BeforeFirstAll())
+	got := make([]string, 0)
+	for {
+		ok, err := fi.Next()
+		require.NoError(t, err)
+		if !ok {
+			break
+		}
+		v, err := fi.GetDataVal()
+		require.NoError(t, err)
+		got = append(got, v.AsString())
+	}
+	for w := 1; w < workers; w++ {
+		for i := 0; i < perWorker; i++ {
+			want = append(want, key(w, i))
+		}
+	}
+	sort.Strings(want)
+	assert.Equal(t, want, got)
+
+	for w := 0; w < workers; w++ {
+		require.NoError(t, idx.BeforeFirst(query.NewConstant(key(w, 7))))
+		ok, err := idx.Next()
+		require.NoError(t, err)
+		assert.Equal(t, w != 0, ok, w)
+		if ok {
+			rid, err := idx.GetDataRid()
+			require.NoError(t, err)
+			assert.Equal(t, record.NewRecordID(w, 7), rid)
+		}
+	}
+	require.NoError(t, idx.Close())
+	require.NoError(t, tx.Commit())
+}
+
+func TestHashIndex(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	mdm := db.MetadataManager()
+	pe := planner.NewPlanExecuter(planner.NewBasicQueryPlanner(mdm), planner.NewIndexUpdatePlanner(mdm))
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+	_, err = pe.ExecuteScript("create table nums (n int, k varchar(20)); create index nums_k_index on nums using hash (k);", tx)
+	require.NoError(t, err)
+
+	// バケットが何度も分割されるようにキーを増やして、同じキーのレコードで overflow chain も作る
+	ps, err := pe.Prepare("insert into nums (n, k) values (?, ?)")
+	require.NoError(t, err)
+	want := make(map[string]int)
+	for i := 0; i < 600; i++ {
+		k := fmt.Sprintf("k%04d", i%300)
+		if i%10 == 0 {
+			k = "k0050"
+		}
+		_, err := ps.ExecuteUpdate([]query.Constant{query.NewConstant(i), query.NewConstant(k)}, tx)
+		require.NoError(t, err)
+		want[k]++
+	}
+	require.NoError(t, tx.Commit())
+
+	// 全てのキーについて、インデックスで見つかるレコードのキーと数がテーブルと一致することを確認する
+	check := func(want map[string]int) {
+		tx, err := db.NewTransaction()
+		require.NoError(t, err)
+		tp, err := planner.NewTablePlan(tx, "nums", mdm)
+		require.NoError(t, err)
+		s, err := tp.Open()
+		require.NoError(t, err)
+		ts := s.(query.UpdateScanner)
+		indexes, err := mdm.GetIndexInfo("nums", tx)
+		require.NoError(t, err)
+		assert.Equal(t, index.HashIndexType, indexes["nums_k_index"].IndexType())
+		idx, err := indexes["nums_k_index"].Open()
+		require.NoError(t, err)
+		for i := 0; i < 300; i++ {
+			k := fmt.Sprintf("k%04d", i)
+			require.NoError(t, idx.BeforeFirst(query.NewConstant(k)))
+			found := 0
+			for {
+				ok, err := idx.Next()
+				require.NoError(t, err)
+				if !ok {
+					break
+				}
+				rid, err := idx.GetDataRid()
+				require.NoError(t, err)
+				require.NoError(t, ts.MoveToRid(rid))
+				v, err := ts.GetString("k")
+				require.NoError(t, err)
+				assert.Equal(t, k, v)
+				found++
+			}
+			assert.Equal(t, want[k], found, k)
+		}
+		require.NoError(t, idx.Close())
+		require.NoError(t, ts.Close())
+		require.NoError(t, tx.Commit())
+	}
+	check(want)
+
+	// 削除をロールバックすると、分割や chain の変更も元に戻る
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("delete from nums where n >= 100", tx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Rollback())
+	check(want)
+
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("delete from nums where n >= 100", tx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+	want = make(map[string]int)
+	for i := 0; i < 100; i++ {
+		k := fmt.Sprintf("k%04d", i%300)
+		if i%10 == 0 {
+			k = "k0050"
+		}
+		want[k]++
+	}
+	check(want)
+
+	// 範囲の条件ではハッシュインデックスを使わずに、テーブルを読んで答える
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	p, err := pe.CreateQueryPlan("select n from nums where k > 'k0090'", tx)
+	require.NoError(t, err)
+	s, err := p.Open()
+	require.NoError(t, err)
+	n := 0
+	for {
+		ok, err := s.Next()
+		require.NoError(t, err)
+		if !ok {
+			break
+		}
+		n++
+	}
+	assert.Equal(t, 9, n)
+	require.NoError(t, s.Close())
+	require.NoError(t, tx.Commit())
+}
+
+func TestSearchCost(t *testing.T) {
+	// B-tree は leaf が増えるとディレクトリの段数が増える
+	assert.Equal(t, 2, index.SearchCost(index.BTreeIndexType, 100, 1, 50))
+	assert.Equal(t, 3, index.SearchCost(index.BTreeIndexType, 10000, 1, 50))
+	assert.Equal(t, 4, index.SearchCost(index.BTreeIndexType, 1000000, 1, 50))
+	// 条件に合うレコードが多いと、読む leaf も増える
+	assert.Equal(t, 5, index.SearchCost(index.BTreeIndexType, 10000, 150, 50))
+
+	// ハッシュはインデックスの大きさによらない
+	assert.Equal(t, 3, index.SearchCost(index.HashIndexType, 100, 1, 50))
+	assert.Equal(t, 3, index.SearchCost(index.HashIndexType, 1000000, 1, 50))
+	assert.Equal(t, 5, index.SearchCost(index.HashIndexType, 10000, 150, 50))
+}
+
+func TestBitmap(t *testing.T) {
+	b := index.NewBitmap()
+	for _, pos := range []int{3, 40, 100, 5000} {
+		b.Set(pos)
+	}
+	assert.Equal(t, []int{3, 40, 100, 5000}, b.Positions())
+	assert.Equal(t, 4, b.Count())
+	assert.True(t, b.Contains(100))
+	assert.False(t, b.Contains(99))
+	// 0 のグループが続く部分は1つの語にまとめる
+	assert.Len(t, b.Words(), 6)
+
+	b.Clear(5000)
+	b.Clear(40)
+	assert.Equal(t, []int{3, 100}, b.Positions())
+	assert.Len(t, b.Words(), 3)
+	b.Clear(3)
+	b.Clear(100)
+	assert.True(t, b.IsEmpty())
+
+	// 全て 1 のグループが続く部分も1つの語にまとめる
+	evens, all := index.NewBitmap(), index.NewBitmap()
+	for i := 0; i < 1000; i++ {
+		all.Set(i)
+		if i%2 == 0 {
+			evens.Set(i)
+		}
+	}
+	assert.Len(t, all.Words(), 2)
+	assert.Equal(t, 1000, all.Count())
+
+	tail := index.NewBitmap()
+	for i := 990; i < 1010; i++ {
+		tail.Set(i)
+	}
+	and := evens.And(tail)
+	assert.Equal(t, []int{990, 992, 994, 996, 998}, and.Positions())
+	or := evens.Or(tail)
+	assert.Equal(t, 500+15, or.Count())
+	assert.True(t, or.Contains(1009))
+	assert.Equal(t, all.Count(), all.And(all).Count())
+	assert.True(t, evens.And(index.NewBitmap()).IsEmpty())
+}
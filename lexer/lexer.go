@@ -2,6 +2,7 @@ package lexer
 
 import (
 	"bufio"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -10,13 +11,33 @@ import (
 )
 
 type Lexer struct {
-	reader io.RuneScanner
+	reader *bufio.Reader
 	pos    int
 	tokens []Token
+
+	// cur は次に読み込む文字の位置で、 prev は1文字前の位置 (unreadRune で戻すため)
+	cur  position
+	prev position
+	// start は読み込み中のトークンの開始位置
+	start position
+
+	// numParams はプレースホルダの数 (? の個数か $n の n の最大値)
+	numParams int
+	// positional は ? のプレースホルダを使っているかどうか
+	positional bool
+	// numbered は $n のプレースホルダを使っているかどうか
+	numbered bool
 }
 
 var ErrEatToken = errors.New("eat token error")
 
+// position はクエリの中の位置で、行と列は 1 から始まる
+type position struct {
+	line   int
+	column int
+}
+
+// keywords は予約語で、識別子には使えない
 var keywords = []string{
 	"select",
 	"from",
@@ -38,14 +59,41 @@ var keywords = []string{
 	"on",
 }
 
+// nonReservedKeywords は予約語ではないキーワードで、識別子を書ける位置ではテーブル名や列名として使える
+// 予約語を増やすと既存のスキーマが解析できなくなるので、後から加えたキーワードはこちらに入れる
+var nonReservedKeywords = []string{
+	"json",
+	"text",
+	"blob",
+	"uuid",
+	"default",
+	"explain",
+	"analyze",
+	"between",
+	"like",
+	"order",
+	"group",
+	"by",
+	"asc",
+	"desc",
+	"unique",
+	"using",
+	"match",
+	"in",
+}
+
 func NewLexer(query string) (*Lexer, error) {
 	l := &Lexer{
 		reader: bufio.NewReader(strings.NewReader(query)),
 		pos:    0,
+		cur:    position{line: 1, column: 1},
 	}
 	if err := l.Tokenize(); err != nil {
 		return nil, err
 	}
+	if len(l.tokens) == 0 {
+		return nil, &ParseError{Line: 1, Column: 1, Message: "query is empty"}
+	}
 	return l, nil
 }
 
@@ -66,18 +114,43 @@ func (l *Lexer) MatchStringConstant() bool {
 	return l.currentToken().ttype == String
 }
 
+func (l *Lexer) MatchBlobConstant() bool {
+	return l.currentToken().ttype == Blob
+}
+
+func (l *Lexer) MatchParameter() bool {
+	return l.currentToken().ttype == Parameter
+}
+
+// MatchConstant は定数かプレースホルダかどうかを返す
+func (l *Lexer) MatchConstant() bool {
+	return l.MatchStringConstant() || l.MatchIntConstant() || l.MatchBlobConstant() || l.MatchParameter()
+}
+
+// AtEnd はすべてのトークンを読み込んだかどうかを返す
+func (l *Lexer) AtEnd() bool {
+	return l.currentToken().ttype == EOF
+}
+
 func (l *Lexer) MatchKeyword(k string) bool {
 	tok := l.currentToken()
 	return tok.ttype == Keyword && tok.val == k
 }
 
+// MatchIdentifier は現在のトークンが識別子か、識別子として使える予約語ではないキーワードかどうかを返す
 func (l *Lexer) MatchIdentifier() bool {
-	return l.currentToken().ttype == Identifier
+	tok := l.currentToken()
+	return tok.ttype == Identifier || (tok.ttype == Keyword && !isReserved(tok.val.(string)))
+}
+
+func (l *Lexer) MatchOperator(op string) bool {
+	tok := l.currentToken()
+	return tok.ttype == Operator && tok.val == op
 }
 
 func (l *Lexer) EatDelimiter(d rune) error {
 	if !l.MatchDelimiter(d) {
-		return ErrEatToken
+		return l.eatError(string(d))
 	}
 	l.nextToken()
 	return nil
@@ -85,7 +158,7 @@ func (l *Lexer) EatDelimiter(d rune) error {
 
 func (l *Lexer) EatIntConstant() (int, error) {
 	if !l.MatchIntConstant() {
-		return 0, ErrEatToken
+		return 0, l.eatError("integer")
 	}
 	i := l.currentToken().val.(int)
 	l.nextToken()
@@ -94,16 +167,40 @@ func (l *Lexer) EatIntConstant() (int, error) {
 
 func (l *Lexer) EatStringConstant() (string, error) {
 	if !l.MatchStringConstant() {
-		return "", ErrEatToken
+		return "", l.eatError("string")
+	}
+	tok := l.currentToken()
+	s := tok.val.(string)
+	if tok.ttype == Keyword {
+		s = tok.text
 	}
-	s := l.currentToken().val.(string)
 	l.nextToken()
 	return s, nil
 }
 
+func (l *Lexer) EatBlobConstant() ([]byte, error) {
+	if !l.MatchBlobConstant() {
+		return nil, l.eatError("blob")
+	}
+	b := l.currentToken().val.([]byte)
+	l.nextToken()
+	return b, nil
+}
+
+// EatParameter はプレースホルダの番号を返す
+// 番号は 1 から始まる
+func (l *Lexer) EatParameter() (int, error) {
+	if !l.MatchParameter() {
+		return 0, l.eatError("parameter")
+	}
+	n := l.currentToken().val.(int)
+	l.nextToken()
+	return n, nil
+}
+
 func (l *Lexer) EatKeyword(w string) error {
 	if !l.MatchKeyword(w) {
-		return ErrEatToken
+		return l.eatError(w)
 	}
 	l.nextToken()
 	return nil
@@ -111,13 +208,38 @@ func (l *Lexer) EatKeyword(w string) error {
 
 func (l *Lexer) EatIdentifier() (string, error) {
 	if !l.MatchIdentifier() {
-		return "", ErrEatToken
+		return "", l.eatError("identifier")
+	}
+	tok := l.currentToken()
+	s := tok.val.(string)
+	if tok.ttype == Keyword {
+		s = tok.text
 	}
-	s := l.currentToken().val.(string)
 	l.nextToken()
 	return s, nil
 }
 
+func (l *Lexer) EatOperator(op string) error {
+	if !l.MatchOperator(op) {
+		return l.eatError(op)
+	}
+	l.nextToken()
+	return nil
+}
+
+// Unexpected は現在のトークンが expected のいずれでもないことを表す ParseError を返す
+func (l *Lexer) Unexpected(expected ...string) error {
+	tok := l.currentToken()
+	return &ParseError{Line: tok.line, Column: tok.column, Token: tok.String(), Expected: expected}
+}
+
+// eatError は Eat*** でトークンを読み込めなかった場合のエラーを返す
+func (l *Lexer) eatError(expected string) error {
+	err := l.Unexpected(expected).(*ParseError)
+	err.cause = ErrEatToken
+	return err
+}
+
 func (l *Lexer) Tokenize() error {
 	for {
 		err := l.tokenize()
@@ -135,12 +257,48 @@ func (l *Lexer) CurrentTokenValue() interface{} {
 	return l.currentToken().val
 }
 
+// NumParameters はクエリに含まれるプレースホルダの数を返す
+func (l *Lexer) NumParameters() int {
+	return l.numParams
+}
+
+// tokenize は空白とコメントを読み飛ばして、次のトークンを読み込む
+// 読み込んだトークンには開始位置を記録して、エラーの場合は開始位置の ParseError を返す
 func (l *Lexer) tokenize() error {
 	err := l.skipWhiteSpace()
 	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return err
+		}
+		return l.syntaxError(l.cur, err)
+	}
+
+	l.start = l.cur
+	n := len(l.tokens)
+	if err := l.readToken(); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = errors.New("unexpected end of input")
+		}
+		return l.syntaxError(l.start, err)
+	}
+	if len(l.tokens) > n {
+		l.tokens[n].line = l.start.line
+		l.tokens[n].column = l.start.column
+	}
+	return nil
+}
+
+// syntaxError は pos の位置で発生したエラーを ParseError に変換する
+func (l *Lexer) syntaxError(pos position, err error) error {
+	var pe *ParseError
+	if errors.As(err, &pe) {
 		return err
 	}
+	return &ParseError{Line: pos.line, Column: pos.column, Message: err.Error(), cause: err}
+}
 
+// readToken は1つのトークンを読み込む
+func (l *Lexer) readToken() error {
 	r, _, err := l.readRune()
 	if err != nil {
 		return err
@@ -151,14 +309,28 @@ func (l *Lexer) tokenize() error {
 		return nil
 	}
 
+	// 先頭文字が - の場合は ->, ->> 演算子か負の数値
+	if r == '-' {
+		return l.readMinus()
+	}
+
+	// <, <=, <>, >, >=, != は比較演算子
+	if r == '<' || r == '>' || r == '!' {
+		return l.readComparison(r)
+	}
+
+	// ? と $1, $2, ... はプリペアドステートメントのプレースホルダ
+	if r == '?' || r == '$' {
+		return l.readParameter(r)
+	}
+
 	err = l.unreadRune()
 	if err != nil {
 		return err
 	}
 
-	// 先頭文字が - の場合は負の数値
-	if isNumeric(r) || r == '-' {
-		num, err := l.readInteger()
+	if isNumeric(r) {
+		num, err := l.readInteger(false)
 		if err != nil {
 			return err
 		}
@@ -172,9 +344,23 @@ func (l *Lexer) tokenize() error {
 			return err
 		}
 
+		// x'0a1b' は 16 進数で表した BLOB のリテラル
+		if idt == "x" || idt == "X" {
+			b, ok, err := l.readBlob()
+			if err != nil {
+				return err
+			}
+			if ok {
+				l.tokens = append(l.tokens, NewToken(Blob, b))
+				return nil
+			}
+		}
+
 		lowerIdt := strings.ToLower(idt)
 		if isKeyword(lowerIdt) {
-			l.tokens = append(l.tokens, NewToken(Keyword, lowerIdt))
+			tok := NewToken(Keyword, lowerIdt)
+			tok.text = idt
+			l.tokens = append(l.tokens, tok)
 			return nil
 		}
 
@@ -191,23 +377,126 @@ func (l *Lexer) tokenize() error {
 		return nil
 	}
 
-	return fmt.Errorf("rune %s cannot tokenize", string(r))
+	// "..." で囲んだ識別子は大文字小文字を区別して、キーワードとしても扱わない
+	if r == '"' {
+		idt, err := l.readQuotedIdentifier()
+		if err != nil {
+			return err
+		}
+		l.tokens = append(l.tokens, NewToken(Identifier, idt))
+		return nil
+	}
+
+	return &ParseError{
+		Line:    l.start.line,
+		Column:  l.start.column,
+		Token:   string(r),
+		Message: fmt.Sprintf("unexpected character %q", string(r)),
+	}
 }
 
-// readInteger は rune 配列に数値を読み込んで、最後に数値に変換する
-func (l *Lexer) readInteger() (int, error) {
-	rs := make([]rune, 0)
+// readMinus は - に続く文字によって、演算子か負の数値を読み込む
+func (l *Lexer) readMinus() error {
 	r, _, err := l.readRune()
 	if err != nil {
-		return 0, err
+		return err
+	}
+	if r != '>' {
+		if err := l.unreadRune(); err != nil {
+			return err
+		}
+		num, err := l.readInteger(true)
+		if err != nil {
+			return err
+		}
+		l.tokens = append(l.tokens, NewToken(Integer, num))
+		return nil
 	}
 
-	if r == '-' {
-		rs = append(rs, r)
-	} else {
+	r, _, err = l.readRune()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if err == nil && r == '>' {
+		l.tokens = append(l.tokens, NewToken(Operator, "->>"))
+		return nil
+	}
+	if err == nil {
 		if err := l.unreadRune(); err != nil {
-			return 0, err
+			return err
+		}
+	}
+	l.tokens = append(l.tokens, NewToken(Operator, "->"))
+	return nil
+}
+
+// readComparison は比較演算子を読み込む
+// != は <> と同じ演算子として扱う
+func (l *Lexer) readComparison(r rune) error {
+	next, _, err := l.readRune()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	op := string(r)
+	switch {
+	case err == nil && next == '=' && r == '!':
+		op = "<>"
+	case err == nil && next == '=':
+		op += "="
+	case err == nil && next == '>' && r == '<':
+		op = "<>"
+	default:
+		if r == '!' {
+			return errors.New("= is required after !")
+		}
+		if err == nil {
+			if err := l.unreadRune(); err != nil {
+				return err
+			}
+		}
+	}
+	l.tokens = append(l.tokens, NewToken(Operator, op))
+	return nil
+}
+
+// readParameter はプレースホルダを読み込んで、1 から始まる番号のトークンにする
+// ? は出現した順に番号を振り、 $n は n をそのまま番号にする
+// 同じクエリで ? と $n を混在させることはできない
+func (l *Lexer) readParameter(r rune) error {
+	if r == '?' {
+		if l.numbered {
+			return errors.New("cannot mix ? and $n placeholders")
 		}
+		l.positional = true
+		l.numParams++
+		l.tokens = append(l.tokens, NewToken(Parameter, l.numParams))
+		return nil
+	}
+
+	if l.positional {
+		return errors.New("cannot mix ? and $n placeholders")
+	}
+	l.numbered = true
+	n, err := l.readInteger(false)
+	if err != nil {
+		return fmt.Errorf("invalid placeholder: %w", err)
+	}
+	if n < 1 {
+		return fmt.Errorf("placeholder number must be greater than 0, but got $%d", n)
+	}
+	if n > l.numParams {
+		l.numParams = n
+	}
+	l.tokens = append(l.tokens, NewToken(Parameter, n))
+	return nil
+}
+
+// readInteger は rune 配列に数値を読み込んで、最後に数値に変換する
+// 負の数値の場合は - を読み込んだ後に呼び出す
+func (l *Lexer) readInteger(negative bool) (int, error) {
+	rs := make([]rune, 0)
+	if negative {
+		rs = append(rs, '-')
 	}
 
 	for {
@@ -219,7 +508,8 @@ func (l *Lexer) readInteger() (int, error) {
 			return 0, err
 		}
 
-		if isDelimiter(r) || isWhiteSpace(r) {
+		// - と / はコメントの開始、 <, >, ! は比較演算子の開始なので数値の終わりとみなす
+		if isDelimiter(r) || isWhiteSpace(r) || r == '-' || r == '/' || r == '<' || r == '>' || r == '!' {
 			err := l.unreadRune()
 			if err != nil {
 				return 0, err
@@ -264,13 +554,33 @@ func (l *Lexer) readIdentifier() (string, error) {
 }
 
 // readString は文字列を読み込む
+// 文字列の中で ' を2つ続けた場合は ' 1文字として扱う
 func (l *Lexer) readString() (string, error) {
+	return l.readQuoted('\'')
+}
+
+// readQuotedIdentifier は "..." で囲んだ識別子を読み込む
+// 識別子の中の "" は " 1文字として扱う
+func (l *Lexer) readQuotedIdentifier() (string, error) {
+	idt, err := l.readQuoted('"')
+	if err != nil {
+		return "", err
+	}
+	if idt == "" {
+		return "", errors.New("quoted identifier must not be empty")
+	}
+	return idt, nil
+}
+
+// readQuoted は quote で囲まれた文字列を読み込む
+// quote を2つ続けた場合は quote 1文字として扱う
+func (l *Lexer) readQuoted(quote rune) (string, error) {
 	r, _, err := l.readRune()
 	if err != nil {
 		return "", err
 	}
-	if r != '\'' {
-		return "", fmt.Errorf("start rune must be \"'\", but got %s", string(r))
+	if r != quote {
+		return "", fmt.Errorf("start rune must be %q, but got %s", quote, string(r))
 	}
 
 	rs := make([]rune, 0)
@@ -283,16 +593,75 @@ func (l *Lexer) readString() (string, error) {
 			return "", err
 		}
 
-		if r == '\'' {
-			break
+		if r != quote {
+			rs = append(rs, r)
+			continue
 		}
-		rs = append(rs, r)
+
+		next, _, err := l.readRune()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return "", err
+		}
+		if next == quote {
+			rs = append(rs, quote)
+			continue
+		}
+		if err := l.unreadRune(); err != nil {
+			return "", err
+		}
+		break
 	}
 	return string(rs), nil
 }
 
+// readBlob は x の直後に文字列が続く場合に、16 進数の文字列を読み込んでバイト列に変換する
+// 文字列が続かない場合は x を識別子として扱うので false を返す
+func (l *Lexer) readBlob() ([]byte, bool, error) {
+	r, _, err := l.readRune()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if err := l.unreadRune(); err != nil {
+		return nil, false, err
+	}
+	if r != '\'' {
+		return nil, false, nil
+	}
+	s, err := l.readString()
+	if err != nil {
+		return nil, false, err
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid blob literal x'%s': %w", s, err)
+	}
+	return b, true, nil
+}
+
+// skipWhiteSpace は空白とコメントを読み飛ばす
+// コメントは -- から行末までと、 /* から */ まで
 func (l *Lexer) skipWhiteSpace() error {
 	for {
+		// コメントかどうかは読み込む前に確認する (Peek の後は UnreadRune できないため)
+		if l.hasPrefix("--") {
+			if err := l.skipLineComment(); err != nil {
+				return err
+			}
+			continue
+		}
+		if l.hasPrefix("/*") {
+			if err := l.skipBlockComment(); err != nil {
+				return err
+			}
+			continue
+		}
+
 		r, _, err := l.readRune()
 		if err != nil {
 			return err
@@ -312,30 +681,106 @@ func (l *Lexer) skipWhiteSpace() error {
 	return nil
 }
 
+// skipLineComment は行末までを読み飛ばす
+func (l *Lexer) skipLineComment() error {
+	for {
+		r, _, err := l.readRune()
+		if err != nil {
+			return err
+		}
+		if r == '\n' {
+			return nil
+		}
+	}
+}
+
+// skipBlockComment は /* から */ までを読み飛ばす
+func (l *Lexer) skipBlockComment() error {
+	start := l.cur
+	for i := 0; i < len("/*"); i++ {
+		if _, _, err := l.readRune(); err != nil {
+			return err
+		}
+	}
+	for {
+		if l.hasPrefix("*/") {
+			for i := 0; i < len("*/"); i++ {
+				if _, _, err := l.readRune(); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		_, _, err := l.readRune()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return l.syntaxError(start, errors.New("comment is not terminated"))
+			}
+			return err
+		}
+	}
+}
+
+// hasPrefix は読み込み位置を進めずに、次の文字列が prefix かどうかを返す
+func (l *Lexer) hasPrefix(prefix string) bool {
+	b, err := l.reader.Peek(len(prefix))
+	if err != nil {
+		return false
+	}
+	return string(b) == prefix
+}
+
 // readRune は現在の位置から1文字読み取って、読み込み位置を1文字進める
 func (l *Lexer) readRune() (rune, int, error) {
-	return l.reader.ReadRune()
+	r, size, err := l.reader.ReadRune()
+	if err != nil {
+		return r, size, err
+	}
+	l.prev = l.cur
+	if r == '\n' {
+		l.cur = position{line: l.cur.line + 1, column: 1}
+	} else {
+		l.cur.column++
+	}
+	return r, size, nil
 }
 
 // unreadRune は読み込み位置を1文字戻す
 func (l *Lexer) unreadRune() error {
-	return l.reader.UnreadRune()
+	if err := l.reader.UnreadRune(); err != nil {
+		return err
+	}
+	l.cur = l.prev
+	return nil
 }
 
+// currentToken は現在のトークンを返す
+// すべてのトークンを読み込んだ後は、入力の終わりの位置の EOF トークンを返す
 func (l *Lexer) currentToken() Token {
+	if l.pos >= len(l.tokens) {
+		return Token{ttype: EOF, line: l.cur.line, column: l.cur.column}
+	}
 	return l.tokens[l.pos]
 }
 
 func (l *Lexer) nextToken() {
-	if l.pos == len(l.tokens)-1 {
-		return
+	if l.pos < len(l.tokens) {
+		l.pos++
 	}
-	l.pos++
 }
 
 func isKeyword(k string) bool {
-	for _, kw := range keywords {
-		if k == kw {
+	return isReserved(k) || contains(nonReservedKeywords, k)
+}
+
+// isReserved は k が識別子に使えない予約語かどうかを返す
+func isReserved(k string) bool {
+	return contains(keywords, k)
+}
+
+func contains(words []string, w string) bool {
+	for _, word := range words {
+		if w == word {
 			return true
 		}
 	}
@@ -344,7 +789,7 @@ func isKeyword(k string) bool {
 
 func isDelimiter(r rune) bool {
 	switch r {
-	case '=', ',', '(', ')', '*':
+	case '=', ',', '(', ')', '*', ';':
 		return true
 	}
 	return false
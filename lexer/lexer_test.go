@@ -32,6 +32,48 @@ func TestLexer(t *testing.T) {
 			want:     []interface{}{"select", "a", ',', "b", "from", "users", "where", "id", '=', 3},
 			wantType: []TokenType{Keyword, Identifier, Delimiter, Identifier, Keyword, Identifier, Keyword, Identifier, Delimiter, Integer},
 		},
+		{
+			name:     "json operators and negative number",
+			query:    "select a from users where payload->'tags'->0=-1 and payload->>'name'='hoge'",
+			want:     []interface{}{"select", "a", "from", "users", "where", "payload", "->", "tags", "->", 0, '=', -1, "and", "payload", "->>", "name", '=', "hoge"},
+			wantType: []TokenType{Keyword, Identifier, Keyword, Identifier, Keyword, Identifier, Operator, String, Operator, Integer, Delimiter, Integer, Keyword, Identifier, Operator, String, Delimiter, String},
+		},
+		{
+			name:     "blob literal",
+			query:    "insert into files (x, data) values (1, X'00ff')",
+			want:     []interface{}{"insert", "into", "files", '(', "x", ',', "data", ')', "values", '(', 1, ',', []byte{0x00, 0xff}, ')'},
+			wantType: []TokenType{Keyword, Keyword, Identifier, Delimiter, Identifier, Delimiter, Identifier, Delimiter, Keyword, Delimiter, Integer, Delimiter, Blob, Delimiter},
+		},
+		{
+			name:     "positional placeholders",
+			query:    "select a from users where id=? and name=?",
+			want:     []interface{}{"select", "a", "from", "users", "where", "id", '=', 1, "and", "name", '=', 2},
+			wantType: []TokenType{Keyword, Identifier, Keyword, Identifier, Keyword, Identifier, Delimiter, Parameter, Keyword, Identifier, Delimiter, Parameter},
+		},
+		{
+			name:     "numbered placeholders",
+			query:    "update users set a=$2 where id=$1",
+			want:     []interface{}{"update", "users", "set", "a", '=', 2, "where", "id", '=', 1},
+			wantType: []TokenType{Keyword, Identifier, Keyword, Identifier, Delimiter, Parameter, Keyword, Identifier, Delimiter, Parameter},
+		},
+		{
+			name:     "comments",
+			query:    "select a -- line comment\nfrom /* block\ncomment */ users where id=1-- trailing",
+			want:     []interface{}{"select", "a", "from", "users", "where", "id", '=', 1},
+			wantType: []TokenType{Keyword, Identifier, Keyword, Identifier, Keyword, Identifier, Delimiter, Integer},
+		},
+		{
+			name:     "escaped quotes and quoted identifiers",
+			query:    `insert into "Users" ("select", name) values (1, 'it''s');`,
+			want:     []interface{}{"insert", "into", "Users", '(', "select", ',', "name", ')', "values", '(', 1, ',', "it's", ')', ';'},
+			wantType: []TokenType{Keyword, Keyword, Identifier, Delimiter, Identifier, Delimiter, Identifier, Delimiter, Keyword, Delimiter, Integer, Delimiter, String, Delimiter, Delimiter},
+		},
+		{
+			name:     "comparison operators",
+			query:    "select a from users where id<3 and id>=1 and b<>2 and c!=4 order by a desc",
+			want:     []interface{}{"select", "a", "from", "users", "where", "id", "<", 3, "and", "id", ">=", 1, "and", "b", "<>", 2, "and", "c", "<>", 4, "order", "by", "a", "desc"},
+			wantType: []TokenType{Keyword, Identifier, Keyword, Identifier, Keyword, Identifier, Operator, Integer, Keyword, Identifier, Operator, Integer, Keyword, Identifier, Operator, Integer, Keyword, Identifier, Operator, Integer, Keyword, Keyword, Identifier, Keyword},
+		},
 	}
 
 	for _, tt := range tests {
@@ -46,3 +88,99 @@ func TestLexer(t *testing.T) {
 		})
 	}
 }
+
+func TestLexer_Placeholder(t *testing.T) {
+	lex, err := NewLexer("select a from users where id=$3 and b=$1")
+	require.NoError(t, err)
+	assert.Equal(t, 3, lex.NumParameters())
+
+	_, err = NewLexer("select a from users where id=? and b=$1")
+	assert.Error(t, err)
+	_, err = NewLexer("select a from users where id=$0")
+	assert.Error(t, err)
+	_, err = NewLexer("select a from users where id=$a")
+	assert.Error(t, err)
+}
+
+func TestLexer_Invalid(t *testing.T) {
+	for _, q := range []string{
+		"",
+		"-- only comment",
+		"select a from users /* not terminated",
+		"select a from users where name='not terminated",
+		`select "" from users`,
+	} {
+		_, err := NewLexer(q)
+		assert.Error(t, err, q)
+	}
+}
+
+func TestLexer_ParseError(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantLine   int
+		wantColumn int
+		wantToken  string
+	}{
+		{
+			name:       "unexpected character",
+			query:      "select a\nfrom users where id # 1",
+			wantLine:   2,
+			wantColumn: 21,
+			wantToken:  "#",
+		},
+		{
+			name:       "unterminated string",
+			query:      "select a from users where name='abc",
+			wantLine:   1,
+			wantColumn: 32,
+		},
+		{
+			name:       "unterminated comment",
+			query:      "select a\n  /* comment",
+			wantLine:   2,
+			wantColumn: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewLexer(tt.query)
+			var pe *ParseError
+			require.ErrorAs(t, err, &pe)
+			assert.Equal(t, tt.wantLine, pe.Line)
+			assert.Equal(t, tt.wantColumn, pe.Column)
+			assert.Equal(t, tt.wantToken, pe.Token)
+		})
+	}
+}
+
+func TestLexer_EatError(t *testing.T) {
+	lex, err := NewLexer("select a\n  frm users")
+	require.NoError(t, err)
+	require.NoError(t, lex.EatKeyword("select"))
+	_, err = lex.EatIdentifier()
+	require.NoError(t, err)
+
+	err = lex.EatKeyword("from")
+	require.ErrorIs(t, err, ErrEatToken)
+	var pe *ParseError
+	require.ErrorAs(t, err, &pe)
+	assert.Equal(t, 2, pe.Line)
+	assert.Equal(t, 3, pe.Column)
+	assert.Equal(t, "frm", pe.Token)
+	assert.Equal(t, []string{"from"}, pe.Expected)
+	assert.Equal(t, `syntax error at line 2, column 3: unexpected "frm", expected from`, pe.Error())
+
+	// 入力の終わりでは空のトークンになる
+	_, err = lex.EatIdentifier()
+	require.NoError(t, err)
+	_, err = lex.EatIdentifier()
+	require.NoError(t, err)
+	_, err = lex.EatIdentifier()
+	require.ErrorAs(t, err, &pe)
+	assert.True(t, lex.AtEnd())
+	assert.Equal(t, "", pe.Token)
+	assert.Equal(t, 12, pe.Column)
+}
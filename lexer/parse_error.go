@@ -0,0 +1,50 @@
+package lexer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ksrnnb/go-rdb/sqlstate"
+)
+
+// ParseError はクエリの字句解析、構文解析のエラー
+// 行と列は 1 から始まる
+type ParseError struct {
+	Line   int
+	Column int
+	// Token は問題のあったトークンで、入力の終わりの場合は空文字
+	Token string
+	// Expected はその位置で期待していたトークンの候補
+	Expected []string
+	// Message はトークンを読み込めなかった場合などの詳細
+	Message string
+
+	cause error
+}
+
+func (e *ParseError) Error() string {
+	var msg string
+	switch {
+	case e.Message != "":
+		msg = e.Message
+	case e.Token == "":
+		msg = "unexpected end of input"
+	default:
+		msg = fmt.Sprintf("unexpected %q", e.Token)
+	}
+	if len(e.Expected) == 1 {
+		msg = fmt.Sprintf("%s, expected %s", msg, e.Expected[0])
+	} else if len(e.Expected) > 1 {
+		msg = fmt.Sprintf("%s, expected one of %s", msg, strings.Join(e.Expected, ", "))
+	}
+	return fmt.Sprintf("syntax error at line %d, column %d: %s", e.Line, e.Column, msg)
+}
+
+// Unwrap はトークンを読み込めなかった場合に ErrEatToken を返す
+func (e *ParseError) Unwrap() error {
+	return e.cause
+}
+
+func (e *ParseError) SQLState() sqlstate.Code {
+	return sqlstate.SyntaxError
+}
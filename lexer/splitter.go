@@ -0,0 +1,90 @@
+package lexer
+
+import "strings"
+
+// SplitStatements はスクリプトを ; で区切って、文ごとの文字列に分割する
+// 文字列、 "..." の識別子、コメントの中の ; では区切らない
+// 空白とコメントしか含まない文は結果に含めない
+func SplitStatements(script string) ([]string, error) {
+	rs := []rune(script)
+	stmts := make([]string, 0)
+	start := 0
+	hasToken := false
+
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case r == '\'' || r == '"':
+			end := skipQuoted(rs, i)
+			if end < 0 {
+				return nil, splitError(rs, i, "string is not terminated")
+			}
+			i = end
+			hasToken = true
+		case r == '-' && i+1 < len(rs) && rs[i+1] == '-':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(rs) && rs[i+1] == '*':
+			end := skipBlockComment(rs, i)
+			if end < 0 {
+				return nil, splitError(rs, i, "comment is not terminated")
+			}
+			i = end
+		case r == ';':
+			if hasToken {
+				stmts = append(stmts, strings.TrimSpace(string(rs[start:i])))
+			}
+			start = i + 1
+			hasToken = false
+		case !isWhiteSpace(r):
+			hasToken = true
+		}
+	}
+	if hasToken {
+		stmts = append(stmts, strings.TrimSpace(string(rs[start:])))
+	}
+	return stmts, nil
+}
+
+// skipQuoted は rs[start] の引用符で始まる文字列の、閉じる引用符の位置を返す
+// 引用符を2つ続けた場合は文字列の一部とみなす
+// 閉じる引用符がない場合は -1 を返す
+func skipQuoted(rs []rune, start int) int {
+	quote := rs[start]
+	for i := start + 1; i < len(rs); i++ {
+		if rs[i] != quote {
+			continue
+		}
+		if i+1 < len(rs) && rs[i+1] == quote {
+			i++
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+// skipBlockComment は rs[start] から始まる /* コメントの、閉じる / の位置を返す
+// コメントが閉じていない場合は -1 を返す
+func skipBlockComment(rs []rune, start int) int {
+	for i := start + 2; i+1 < len(rs); i++ {
+		if rs[i] == '*' && rs[i+1] == '/' {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// splitError は rs[i] の位置の ParseError を返す
+func splitError(rs []rune, i int, msg string) error {
+	pos := position{line: 1, column: 1}
+	for _, r := range rs[:i] {
+		if r == '\n' {
+			pos = position{line: pos.line + 1, column: 1}
+		} else {
+			pos.column++
+		}
+	}
+	return &ParseError{Line: pos.line, Column: pos.column, Message: msg}
+}
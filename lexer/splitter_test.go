@@ -0,0 +1,58 @@
+package lexer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{
+			name:   "single statement without semicolon",
+			script: "select a from users",
+			want:   []string{"select a from users"},
+		},
+		{
+			name: "multiple statements",
+			script: `create table users (id int, name varchar(16));
+insert into users (id, name) values (1, 'a;b');
+select id from users;`,
+			want: []string{
+				"create table users (id int, name varchar(16))",
+				"insert into users (id, name) values (1, 'a;b')",
+				"select id from users",
+			},
+		},
+		{
+			name: "semicolons in comments, escaped strings and quoted identifiers",
+			script: `-- migration; v1
+insert into "a;b" (id, name) values (1, 'it''s;'); /* ; */
+;;
+-- trailing comment;`,
+			want: []string{
+				"-- migration; v1\ninsert into \"a;b\" (id, name) values (1, 'it''s;')",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SplitStatements(tt.script)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSplitStatements_Invalid(t *testing.T) {
+	_, err := SplitStatements("select 'a; from users")
+	assert.Error(t, err)
+	_, err = SplitStatements("select a from users; /* comment")
+	assert.Error(t, err)
+}
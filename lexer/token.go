@@ -1,5 +1,11 @@
 package lexer
 
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
 type TokenType uint8
 
 const (
@@ -8,13 +14,43 @@ const (
 	Delimiter
 	Keyword
 	Identifier
+	Operator
+	Blob
+	Parameter
+	// EOF は入力の終わりを表す
+	EOF
 )
 
 type Token struct {
 	ttype TokenType
 	val   interface{}
+	// text はキーワードのクエリに書かれていた綴りで、 val は小文字にした値
+	// 予約語ではないキーワードを識別子として使う場合は、この綴りを識別子にする
+	text   string
+	line   int
+	column int
 }
 
 func NewToken(ttype TokenType, val interface{}) Token {
-	return Token{ttype, val}
+	return Token{ttype: ttype, val: val}
+}
+
+// String はクエリに書かれていた形でトークンを表す
+// 入力の終わりの場合は空文字を返す
+func (t Token) String() string {
+	switch t.ttype {
+	case Integer:
+		return fmt.Sprintf("%d", t.val)
+	case String:
+		return fmt.Sprintf("'%s'", strings.ReplaceAll(t.val.(string), "'", "''"))
+	case Delimiter:
+		return string(t.val.(rune))
+	case Blob:
+		return fmt.Sprintf("x'%s'", hex.EncodeToString(t.val.([]byte)))
+	case Parameter:
+		return fmt.Sprintf("$%d", t.val)
+	case EOF:
+		return ""
+	}
+	return fmt.Sprintf("%v", t.val)
 }
@@ -75,6 +75,8 @@ func NewLogManager(fm *file.FileManager, logFileName string) (*LogManager, error
 // 指定したLSNのほうが小さい場合は、既にディスクに書き込まれている。
 // それ以外の場合は、ページをディスクに書き込む
 func (lm *LogManager) Flush(lsn int) error {
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
 	if lsn >= lm.lastSavedLSN {
 		return lm.flush()
 	}
@@ -177,6 +179,8 @@ func (lm *LogManager) appendNewBlock() (file.BlockID, error) {
 
 // ログのイテレータを返す
 func (lm *LogManager) Iterator() (*LogIterator, error) {
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
 	err := lm.flush()
 
 	if err != nil {
@@ -2,26 +2,37 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strings"
+	"sync"
 
 	"github.com/google/uuid"
-	"github.com/ksrnnb/go-rdb/parser"
+	"github.com/ksrnnb/go-rdb/lexer"
 	"github.com/ksrnnb/go-rdb/planner"
 	q "github.com/ksrnnb/go-rdb/query"
 	"github.com/ksrnnb/go-rdb/server"
+	"github.com/ksrnnb/go-rdb/sqlstate"
 	"github.com/ksrnnb/go-rdb/tx"
 )
 
 var db *server.SimpleDB
+
+// txns と stmts はハンドラから並行に読み書きするので、 mu で保護する
+var mu sync.Mutex
 var txns = make(map[string]*tx.Transaction)
+var stmts = make(map[string]*planner.PreparedStatement)
 
 func main() {
 	db = server.NewSimpleDBWithMetadata("simpledb")
 
 	http.HandleFunc("/", HandleQuery)
+	http.HandleFunc("/prepare", HandlePrepare)
+	http.HandleFunc("/execute", HandleExecute)
+	http.HandleFunc("/deallocate", HandleDeallocate)
+	http.HandleFunc("/script", HandleScript)
 
 	fmt.Println("DB is running at localhost:8888")
 	log.Fatal(http.ListenAndServe(":8888", nil))
@@ -32,18 +43,13 @@ type QueryRequest struct {
 	TransactionID string `json:"transaction_id"`
 }
 
-func (qr QueryRequest) IsSelect() bool {
-	q := strings.ToLower(qr.Query)
-	return strings.HasPrefix(q, "select")
-}
-
 func (qr QueryRequest) IsStartTransaction() bool {
 	q := strings.ToLower(qr.Query)
 	return q == "start transaction" || q == "begin"
 }
 
 func (qr QueryRequest) IsInTransaction() bool {
-	_, ok := txns[qr.TransactionID]
+	_, ok := getTransaction(qr.TransactionID)
 	return ok
 }
 
@@ -52,6 +58,41 @@ func (qr QueryRequest) IsCommit() bool {
 	return q == "commit"
 }
 
+// ScriptRequest は ; で区切った複数の文を実行するリクエスト
+type ScriptRequest struct {
+	Script        string `json:"script"`
+	TransactionID string `json:"transaction_id"`
+}
+
+type PrepareRequest struct {
+	Query string `json:"query"`
+}
+
+type PrepareResponse struct {
+	StatementID   string `json:"statement_id"`
+	NumParameters int    `json:"num_parameters"`
+}
+
+// DeallocateRequest はプリペアドステートメントを削除するリクエスト
+type DeallocateRequest struct {
+	StatementID string `json:"statement_id"`
+}
+
+// ExecuteRequest はプリペアドステートメントを実行するリクエスト
+// Params はプレースホルダの番号順に並べた値で、数値か文字列を指定する
+type ExecuteRequest struct {
+	StatementID   string        `json:"statement_id"`
+	Params        []interface{} `json:"params"`
+	TransactionID string        `json:"transaction_id"`
+}
+
+// ExplainResponse は EXPLAIN の結果で、インデントしたテキストと JSON の木の両方を返す
+// EXPLAIN ANALYZE の場合は各ノードに実績値が含まれる
+type ExplainResponse struct {
+	Text string            `json:"text"`
+	Plan *planner.PlanNode `json:"plan"`
+}
+
 type MessageResponse struct {
 	Message string `json:"message"`
 }
@@ -68,18 +109,22 @@ func HandleQuery(w http.ResponseWriter, r *http.Request) {
 	var req QueryRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		handleError(w, r, invalidRequest(err))
 		return
 	}
 
 	if req.IsCommit() {
-		tx := txns[req.TransactionID]
+		tx, ok := getTransaction(req.TransactionID)
+		if !ok {
+			handleError(w, r, sqlstate.Errorf(sqlstate.NoActiveTransaction, "transaction %s is not found", req.TransactionID))
+			return
+		}
 		if err := tx.Commit(); err != nil {
 			handleError(w, r, err)
 			return
 		}
 
-		delete(txns, req.TransactionID)
+		deleteTransaction(req.TransactionID)
 		fmt.Fprint(w, MakeMessageResponse("Commit!!"))
 		return
 	}
@@ -91,7 +136,7 @@ func HandleQuery(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		tid := generateTransactionID()
-		txns[tid] = tx
+		putTransaction(tid, tx)
 		res := make(map[string]string)
 		res["transaction_id"] = tid
 		res["message"] = "start transaction"
@@ -100,10 +145,8 @@ func HandleQuery(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var tx *tx.Transaction
-	if req.IsInTransaction() {
-		tx = txns[req.TransactionID]
-	} else {
+	tx, inTransaction := getTransaction(req.TransactionID)
+	if !inTransaction {
 		tx, err = db.NewTransaction()
 		if err != nil {
 			handleError(w, r, err)
@@ -111,83 +154,307 @@ func HandleQuery(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	pe := db.PlanExecuter()
-	if req.IsSelect() {
-		p, err := pe.CreateQueryPlan(req.Query, tx)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+	res, err := prepareAndExecute(db.PlanExecuter(), req.Query, tx)
+	if err == nil && !inTransaction {
+		err = tx.Commit()
+	}
+	if err != nil {
+		if !inTransaction {
+			if rerr := tx.Rollback(); rerr != nil {
+				handleError(w, r, rerr)
+				return
+			}
 		}
-		values, err := selectValues(p, req.Query)
+		handleError(w, r, err)
+		return
+	}
+	fmt.Fprint(w, res)
+}
+
+// prepareAndExecute はクエリを解析して実行し、レスポンスの JSON を返す
+func prepareAndExecute(pe *planner.PlanExecuter, query string, tx *tx.Transaction) (string, error) {
+	ps, err := pe.Prepare(query)
+	if err != nil {
+		return "", err
+	}
+	return executeStatement(ps, nil, tx)
+}
+
+// HandleScript は ; で区切った複数の文を1つのトランザクションで実行して、文ごとの結果を返す
+// トランザクションの外で実行した場合は、エラーが発生するとすべての文をロールバックする
+func HandleScript(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	var req ScriptRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, r, invalidRequest(err))
+		return
+	}
+
+	tx, inTransaction := getTransaction(req.TransactionID)
+	if !inTransaction {
+		var err error
+		tx, err = db.NewTransaction()
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			handleError(w, r, err)
 			return
 		}
-		if !req.IsInTransaction() {
-			if err := tx.Commit(); err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+
+	results, err := db.PlanExecuter().ExecuteScript(req.Script, tx)
+	if err != nil {
+		if !inTransaction {
+			if rerr := tx.Rollback(); rerr != nil {
+				handleError(w, r, rerr)
 				return
 			}
 		}
-		res, _ := json.Marshal(values)
-		fmt.Fprint(w, string(res))
+		handleError(w, r, err)
 		return
 	}
-	num, err := pe.ExecuteUpdate(req.Query, tx)
+	if !inTransaction {
+		if err := tx.Commit(); err != nil {
+			handleError(w, r, err)
+			return
+		}
+	}
+
+	values := make([]map[string]interface{}, len(results))
+	for i, res := range results {
+		v := map[string]interface{}{"statement": res.Statement}
+		if res.Plan != nil {
+			v["text"] = res.Plan.String()
+			v["plan"] = res.Plan
+		} else if res.IsQuery() {
+			records := make([]map[string]interface{}, len(res.Records))
+			for j, rec := range res.Records {
+				records[j] = make(map[string]interface{})
+				for k, fn := range res.Fields {
+					records[j][fn] = jsonValue(rec[k])
+				}
+			}
+			v["records"] = records
+		} else {
+			v["rows_affected"] = res.RowsAffected
+		}
+		values[i] = v
+	}
+	b, _ := json.Marshal(values)
+	fmt.Fprint(w, string(b))
+}
+
+// HandlePrepare はクエリをパースして、プリペアドステートメントの ID を返す
+func HandlePrepare(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	var req PrepareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, r, invalidRequest(err))
+		return
+	}
+	ps, err := db.PlanExecuter().Prepare(req.Query)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		handleError(w, r, err)
 		return
 	}
-	if !req.IsInTransaction() {
-		err = tx.Commit()
+	sid := uuid.New().String()
+	mu.Lock()
+	stmts[sid] = ps
+	mu.Unlock()
+	res, _ := json.Marshal(PrepareResponse{StatementID: sid, NumParameters: ps.NumParameters()})
+	fmt.Fprint(w, string(res))
+}
+
+// HandleExecute はプリペアドステートメントに値を割り当てて実行する
+func HandleExecute(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	var req ExecuteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, r, invalidRequest(err))
+		return
+	}
+	mu.Lock()
+	ps, ok := stmts[req.StatementID]
+	mu.Unlock()
+	if !ok {
+		handleError(w, r, sqlstate.Errorf(sqlstate.InvalidStatementName, "statement %s is not found", req.StatementID))
+		return
+	}
+	params, err := toConstants(req.Params)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	tx, inTransaction := getTransaction(req.TransactionID)
+	if !inTransaction {
+		tx, err = db.NewTransaction()
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			handleError(w, r, err)
 			return
 		}
 	}
-	fmt.Fprintf(w, MakeMessageResponse("%d records has changed"), num)
-}
 
-func handleError(w http.ResponseWriter, r *http.Request, err error) {
-	fmt.Fprint(w, MakeMessageResponse(err.Error()))
+	res, err := executeStatement(ps, params, tx)
+	if err == nil && !inTransaction {
+		err = tx.Commit()
+	}
+	if err != nil {
+		if !inTransaction {
+			if rerr := tx.Rollback(); rerr != nil {
+				handleError(w, r, rerr)
+				return
+			}
+		}
+		handleError(w, r, err)
+		return
+	}
+	fmt.Fprint(w, res)
 }
 
-func selectValues(pl planner.Planner, query string) ([]map[string]interface{}, error) {
-	pas, err := parser.NewParser(query)
-	if err != nil {
-		return nil, err
+// executeStatement はプリペアドステートメントを実行して、レスポンスの JSON を返す
+func executeStatement(ps *planner.PreparedStatement, params []q.Constant, tx *tx.Transaction) (string, error) {
+	if ps.IsExplain() {
+		plan, err := ps.Explain(params, tx)
+		if err != nil {
+			return "", err
+		}
+		b, _ := json.Marshal(ExplainResponse{Text: plan.String(), Plan: plan})
+		return string(b), nil
+	}
+	if ps.IsQuery() {
+		p, err := ps.CreateQueryPlan(params, tx)
+		if err != nil {
+			return "", err
+		}
+		values, err := selectValues(p, p.Schema().Fields())
+		if err != nil {
+			return "", err
+		}
+		b, _ := json.Marshal(values)
+		return string(b), nil
 	}
-	qd, err := pas.Query()
+	num, err := ps.ExecuteUpdate(params, tx)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	return MakeMessageResponse(fmt.Sprintf("%d records has changed", num)), nil
+}
+
+// HandleDeallocate はプリペアドステートメントを削除する
+func HandleDeallocate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	var req DeallocateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, r, invalidRequest(err))
+		return
+	}
+	mu.Lock()
+	_, ok := stmts[req.StatementID]
+	delete(stmts, req.StatementID)
+	mu.Unlock()
+	if !ok {
+		handleError(w, r, sqlstate.Errorf(sqlstate.InvalidStatementName, "statement %s is not found", req.StatementID))
+		return
 	}
+	fmt.Fprint(w, MakeMessageResponse("Deallocate!!"))
+}
+
+// toConstants は JSON の値をプレースホルダに割り当てる定数に変換する
+func toConstants(params []interface{}) ([]q.Constant, error) {
+	consts := make([]q.Constant, len(params))
+	for i, p := range params {
+		switch v := p.(type) {
+		case float64:
+			if v != float64(int(v)) {
+				return nil, sqlstate.Errorf(sqlstate.InvalidParameterValue, "parameter $%d must be an integer, but got %v", i+1, v)
+			}
+			consts[i] = q.NewConstant(int(v))
+		case string:
+			consts[i] = q.NewConstant(v)
+		default:
+			return nil, sqlstate.Errorf(sqlstate.InvalidParameterValue, "parameter $%d must be a number or a string, but got %v", i+1, v)
+		}
+	}
+	return consts, nil
+}
+
+// ErrorResponse はエラーのレスポンス
+// 構文エラーの場合は位置と期待していたトークンも返す
+type ErrorResponse struct {
+	Error ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	Code     sqlstate.Code `json:"code"`
+	Message  string        `json:"message"`
+	Line     int           `json:"line,omitempty"`
+	Column   int           `json:"column,omitempty"`
+	Token    string        `json:"token,omitempty"`
+	Expected []string      `json:"expected,omitempty"`
+}
+
+// handleError はエラーを SQLSTATE のコードと対応する HTTP ステータスの JSON で返す
+func handleError(w http.ResponseWriter, r *http.Request, err error) {
+	code := sqlstate.CodeOf(err)
+	detail := ErrorDetail{Code: code, Message: err.Error()}
+	var pe *lexer.ParseError
+	if errors.As(err, &pe) {
+		detail.Line = pe.Line
+		detail.Column = pe.Column
+		detail.Token = pe.Token
+		detail.Expected = pe.Expected
+	}
+	b, _ := json.Marshal(ErrorResponse{Error: detail})
+	w.WriteHeader(httpStatus(code))
+	fmt.Fprint(w, string(b))
+}
+
+// httpStatus は SQLSTATE のクラスから HTTP のステータスコードを決める
+func httpStatus(code sqlstate.Code) int {
+	switch code.Class() {
+	case "08", "0A", "22", "25", "42":
+		return http.StatusBadRequest
+	case "26":
+		return http.StatusNotFound
+	case "40", "55":
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// invalidRequest はリクエストの JSON を読み込めなかった場合のエラーを返す
+func invalidRequest(err error) error {
+	return sqlstate.Errorf(sqlstate.ProtocolViolation, "invalid request: %w", err)
+}
+
+func selectValues(pl planner.Planner, fields []string) ([]map[string]interface{}, error) {
 	s, err := pl.Open()
 	if err != nil {
 		return nil, err
 	}
 	hasNext, err := s.Next()
 	if err != nil {
+		s.Close()
 		return nil, err
 	}
-	fields := qd.Fields()
 	values := make([]map[string]interface{}, 0)
 	for hasNext {
 		rec := make(map[string]interface{})
 		for _, fn := range fields {
 			val, err := s.GetVal(fn)
 			if err != nil {
+				s.Close()
 				return nil, err
 			}
-			switch val.ConstantType() {
-			case q.IntConstant:
-				rec[fn] = val.AsInt()
-			case q.StringConstant:
-				rec[fn] = val.AsString()
-			}
+			rec[fn] = jsonValue(val)
 		}
 		values = append(values, rec)
 		newHasNext, err := s.Next()
 		if err != nil {
+			s.Close()
 			return nil, err
 		}
 		hasNext = newHasNext
@@ -199,6 +466,43 @@ func selectValues(pl planner.Planner, query string) ([]map[string]interface{}, e
 	return values, err
 }
 
+// jsonValue は定数を JSON で表す値に変換する
+func jsonValue(val q.Constant) interface{} {
+	switch val.ConstantType() {
+	case q.IntConstant:
+		return val.AsInt()
+	case q.StringConstant:
+		return val.AsString()
+	case q.JSONConstant:
+		return json.RawMessage(val.AsString())
+	case q.UUIDConstant:
+		return val.String()
+	case q.BlobConstant:
+		// []byte は base64 の文字列としてエンコードされる
+		return val.AsBytes()
+	}
+	return nil
+}
+
+func getTransaction(tid string) (*tx.Transaction, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	tx, ok := txns[tid]
+	return tx, ok
+}
+
+func putTransaction(tid string, tx *tx.Transaction) {
+	mu.Lock()
+	defer mu.Unlock()
+	txns[tid] = tx
+}
+
+func deleteTransaction(tid string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(txns, tid)
+}
+
 func generateTransactionID() string {
 	return uuid.New().String()
 }
@@ -1,8 +1,12 @@
 package metadata
 
 import (
+	"strings"
+
 	"github.com/ksrnnb/go-rdb/index"
 	"github.com/ksrnnb/go-rdb/index/btree"
+	"github.com/ksrnnb/go-rdb/index/fulltext"
+	"github.com/ksrnnb/go-rdb/query"
 	"github.com/ksrnnb/go-rdb/record"
 	"github.com/ksrnnb/go-rdb/tx"
 )
@@ -10,65 +14,394 @@ import (
 type IndexInfo struct {
 	indexName   string
 	fieldName   string
+	exprs       []query.Expression
+	pred        *query.Predicate
+	indexType   index.IndexType
+	unique      bool
 	tx          *tx.Transaction
 	tableSchema *record.Schema
 	indexLayout *record.Layout
 	si          StatInfo
 }
 
-func NewIndexInfo(indexName string, fieldName string, tableSchema *record.Schema, tx *tx.Transaction, si StatInfo) (*IndexInfo, error) {
-	indexLayout, err := createIndexLayout(tableSchema, fieldName)
+// NewIndexInfo はインデックスの情報を生成する
+// exprs はインデックスの列の式で、それぞれフィールド名か payload->>'name' のような式
+// 2つ以上の場合は、列の値の組をキーにする複合インデックスになる
+// pred は部分インデックスの条件で、条件を満たすレコードだけを登録する (nil の場合は全てのレコードを登録する)
+// indexType は B-tree, ハッシュ, 全文検索, ビットマップのどの方式でキーを保存するか
+// unique の場合は、同じキーのレコードを1つしか登録できないユニークインデックスになる
+func NewIndexInfo(indexName string, exprs []query.Expression, pred *query.Predicate, indexType index.IndexType, unique bool, tableSchema *record.Schema, tx *tx.Transaction, si StatInfo) (*IndexInfo, error) {
+	indexLayout, err := createIndexLayout(tableSchema, exprs, indexType)
 	if err != nil {
 		return nil, err
 	}
-	return &IndexInfo{indexName, fieldName, tx, tableSchema, indexLayout, si}, nil
+	return &IndexInfo{indexName, keyString(exprs), exprs, pred, indexType, unique, tx, tableSchema, indexLayout, si}, nil
+}
+
+// keyString は列の式をカンマで区切った文字列を返す
+func keyString(exprs []query.Expression) string {
+	fns := make([]string, len(exprs))
+	for i, e := range exprs {
+		fns[i] = e.String()
+	}
+	return strings.Join(fns, ", ")
+}
+
+func (ii *IndexInfo) IndexName() string {
+	return ii.indexName
+}
+
+// Key はインデックスの式の文字列を返す
+// フィールド名のインデックスの場合はフィールド名と同じで、複合インデックスの場合は列をカンマで区切った文字列
+func (ii *IndexInfo) Key() string {
+	return ii.fieldName
+}
+
+// Expressions はインデックスの列の式を列の順に返す
+func (ii *IndexInfo) Expressions() []query.Expression {
+	return ii.exprs
+}
+
+// Layout はインデックスレコードのレイアウトを返す
+func (ii *IndexInfo) Layout() *record.Layout {
+	return ii.indexLayout
+}
+
+// IndexType はインデックスの種類を返す
+func (ii *IndexInfo) IndexType() index.IndexType {
+	return ii.indexType
+}
+
+// IsUnique はユニークインデックスかどうかを返す
+func (ii *IndexInfo) IsUnique() bool {
+	return ii.unique
+}
+
+// Predicate は部分インデックスの条件を返す
+// 全てのレコードを登録するインデックスの場合は nil を返す
+func (ii *IndexInfo) Predicate() *query.Predicate {
+	return ii.pred
+}
+
+// IsPartial は条件を満たすレコードだけを登録する部分インデックスかどうかを返す
+func (ii *IndexInfo) IsPartial() bool {
+	return ii.pred != nil
+}
+
+// IsComposite は2つ以上の列の複合インデックスかどうかを返す
+func (ii *IndexInfo) IsComposite() bool {
+	return len(ii.exprs) > 1
+}
+
+// RefersTo はインデックスの列の式か部分インデックスの条件が fieldName を参照しているかどうかを返す
+// 条件のフィールドを更新すると、レコードを登録するかどうかが変わる
+func (ii *IndexInfo) RefersTo(fieldName string) bool {
+	fns := make([]string, 0)
+	for _, e := range ii.exprs {
+		fns = append(fns, e.FieldNames()...)
+	}
+	if ii.pred != nil {
+		fns = append(fns, ii.pred.FieldNames()...)
+	}
+	for _, fn := range fns {
+		if fn == fieldName {
+			return true
+		}
+	}
+	return false
 }
 
+// KeyValue は s の現在のレコードで列の式を評価して、インデックスに登録するキーを返す
+// 複合インデックスの場合は列の値の組を返して、値が存在しない列がある場合は登録しないように Unknown を返す
+// 部分インデックスの条件を満たさないレコードも、登録しないように Unknown を返す
+func (ii *IndexInfo) KeyValue(s query.Scanner) (query.Constant, error) {
+	if ii.pred != nil {
+		ok, err := ii.pred.IsSatisfied(s)
+		if err != nil || !ok {
+			return query.Constant{}, err
+		}
+	}
+	if !ii.IsComposite() {
+		return ii.exprs[0].Evaluate(s)
+	}
+	vals := make([]query.Constant, len(ii.exprs))
+	for i, e := range ii.exprs {
+		v, err := e.Evaluate(s)
+		if err != nil {
+			return query.Constant{}, err
+		}
+		if v.IsUnknown() {
+			return query.Constant{}, nil
+		}
+		vals[i] = v
+	}
+	return query.NewTupleConstant(vals), nil
+}
+
+// IsFullText は語からレコードを引く全文検索のインデックスかどうかを返す
+func (ii *IndexInfo) IsFullText() bool {
+	return ii.indexType == index.FullTextIndexType
+}
+
+// IsBitmap は値ごとにレコードの集合をビットマップで保存するインデックスかどうかを返す
+func (ii *IndexInfo) IsBitmap() bool {
+	return ii.indexType == index.BitmapIndexType
+}
+
+// IndexKeys は s の現在のレコードをインデックスに登録するキーを返す
+// 全文検索のインデックスは値の語ごとにキーを登録するので、語を出現した数だけ返す
+// それ以外のインデックスは KeyValue の1つのキーを返す
+func (ii *IndexInfo) IndexKeys(s query.Scanner) ([]query.Constant, error) {
+	key, err := ii.KeyValue(s)
+	if err != nil {
+		return nil, err
+	}
+	if !ii.IsFullText() {
+		return []query.Constant{key}, nil
+	}
+	if key.IsUnknown() {
+		return nil, nil
+	}
+	words := query.Tokenize(key.String())
+	keys := make([]query.Constant, len(words))
+	for i, w := range words {
+		keys[i] = query.NewConstant(w)
+	}
+	return keys, nil
+}
+
+// Open はインデックスの種類に合わせてインデックスを開く
 func (ii *IndexInfo) Open() (index.Index, error) {
-	// return index.NewHashIndex(ii.tx, ii.indexName, ii.indexLayout), nil
+	switch ii.indexType {
+	case index.HashIndexType:
+		return index.NewHashIndex(ii.tx, ii.indexName, ii.indexLayout)
+	case index.FullTextIndexType:
+		return fulltext.NewFullTextIndex(ii.tx, ii.indexName, ii.indexLayout)
+	case index.BitmapIndexType:
+		slotsPerBlock := ii.tx.BlockSize() / record.NewLayout(ii.tableSchema).SlotSize()
+		return index.NewBitmapIndex(ii.tx, ii.indexName, ii.indexLayout, slotsPerBlock)
+	}
 	return btree.NewBTreeIndex(ii.tx, ii.indexName, ii.indexLayout)
 }
 
+// BlocksAccessed はキーが1つの値と等しいインデックスレコードを全て読むときのブロックアクセス数を見積もる
+// データレコードを読むブロックは含まない
 func (ii *IndexInfo) BlocksAccessed() int {
+	return ii.searchCost(ii.RecordsOutput())
+}
+
+// RangeBlocksAccessed はキーが r の範囲に含まれるインデックスレコードを全て読むときのブロックアクセス数を見積もる
+// B-tree はルートから leaf まで下る段数と範囲の leaf の数、ハッシュはディレクトリとバケットの chain を読む数になる
+func (ii *IndexInfo) RangeBlocksAccessed(r query.Range) int {
+	return ii.searchCost(ii.RangeRecordsOutput(r))
+}
+
+// searchCost はキーが matchRecords 件のレコードに一致するインデックスレコードを読むブロックアクセス数を見積もる
+// ビットマップは一致するレコードの数によらず、1つの値のビットマップを全て読む
+func (ii *IndexInfo) searchCost(matchRecords int) int {
+	if ii.IsBitmap() {
+		return index.SearchCostBitmapIndex(ii.si.RecordsOutput(), ii.tx.BlockSize())
+	}
 	rpb := ii.calculateRecordsPerBlock()
-	numBlocks := ii.si.RecordsOutput() / rpb
-	return index.SearchCost(index.HashIndexType, numBlocks, rpb)
+	return index.SearchCost(ii.indexType, ii.si.RecordsOutput(), matchRecords, rpb)
 }
 
 func (ii *IndexInfo) RecordsOutput() int {
-	return ii.si.RecordsOutput() / ii.si.DistinctValues(ii.fieldName)
+	return ii.prefixRecordsOutput(len(ii.exprs))
+}
+
+// prefixRecordsOutput は先頭の n 列が等しいレコード数を見積もる
+func (ii *IndexInfo) prefixRecordsOutput(n int) int {
+	records := ii.si.RecordsOutput()
+	for _, e := range ii.exprs[:n] {
+		records /= ii.si.DistinctValues(e.String())
+	}
+	return records
+}
+
+// RangeRecordsOutput は r の範囲のキーをもつレコード数を見積もる
+// 1つの値の範囲は RecordsOutput と、複合インデックスの先頭の列を等しい値に限る範囲はその列の数で絞り込んだ数と同じ
+// それ以外は端が1つあるごとに 1/3 になるとみなす
+func (ii *IndexInfo) RangeRecordsOutput(r query.Range) int {
+	if r.IsPoint() {
+		return ii.RecordsOutput()
+	}
+	if n, ok := prefixLength(r); ok {
+		return ii.prefixRecordsOutput(n)
+	}
+	records := ii.si.RecordsOutput()
+	for _, b := range []query.Bound{r.Low(), r.High()} {
+		if !b.IsOpen() {
+			records /= 3
+		}
+	}
+	return records
+}
+
+// prefixLength は r が複合インデックスの先頭の列を等しい値に限る範囲の場合に、その列の数を返す
+// そのような範囲は、下端が値の組で上端がその組から始まる全ての組より大きい組になる
+func prefixLength(r query.Range) (int, bool) {
+	low, high := r.Low(), r.High()
+	if low.IsOpen() || high.IsOpen() || !low.IsInclusive() || !high.IsInclusive() {
+		return 0, false
+	}
+	lv, hv := low.Value(), high.Value()
+	if lv.ConstantType() != query.TupleConstant || hv.ConstantType() != query.TupleConstant {
+		return 0, false
+	}
+	if lv.IsTupleUpperBound() || !hv.IsTupleUpperBound() || len(lv.AsTuple()) != len(hv.AsTuple()) {
+		return 0, false
+	}
+	for i, v := range lv.AsTuple() {
+		if !v.Equals(hv.AsTuple()[i]) {
+			return 0, false
+		}
+	}
+	return len(lv.AsTuple()), true
+}
+
+// AcceptsRange は r の端の値をインデックスのキーと同じ順で比べられるかどうかを返す
+// 数値のキーと文字列の定数のように型が違う場合は、インデックスの順と条件の順が一致しない
+// 複合インデックスの組は、それぞれの値を対応する列と比べる
+// ハッシュとビットマップのインデックスはキーの順を保たないので、1つの値の範囲だけを受け付ける
+// 全文検索のインデックスのキーは値ではなく語なので、範囲は受け付けない
+func (ii *IndexInfo) AcceptsRange(r query.Range) bool {
+	if ii.IsFullText() {
+		return false
+	}
+	if (ii.indexType == index.HashIndexType || ii.IsBitmap()) && !r.IsPoint() {
+		return false
+	}
+	for _, b := range []query.Bound{r.Low(), r.High()} {
+		if b.IsOpen() {
+			continue
+		}
+		vals := []query.Constant{b.Value()}
+		if b.Value().ConstantType() == query.TupleConstant {
+			vals = b.Value().AsTuple()
+		}
+		for i, v := range vals {
+			ft, err := ii.indexLayout.Schema().FieldType(index.DataValueField(i))
+			if err != nil {
+				return false
+			}
+			if !acceptsValue(ft, v) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func acceptsValue(ft record.FieldType, v query.Constant) bool {
+	ct := v.ConstantType()
+	switch ft {
+	case record.Integer:
+		return ct == query.IntConstant
+	case record.String:
+		return ct == query.StringConstant
+	case record.UUID:
+		return ct == query.UUIDConstant || ct == query.StringConstant
+	}
+	return true
+}
+
+// IsOrdered はインデックスのキーの順に読むとフィールドの値の順になるかどうかを返す
+// 式や JSON, TEXT, BLOB のキーは切り詰めているので、キーが同じでも値の順が決まらない
+// 複合インデックスは1つのフィールドの順にならないので false
+// ハッシュインデックスはキーの順に読めないので false
+func (ii *IndexInfo) IsOrdered() bool {
+	if ii.indexType != index.BTreeIndexType || ii.IsComposite() || !ii.exprs[0].IsFieldName() {
+		return false
+	}
+	ft, err := ii.tableSchema.FieldType(ii.fieldName)
+	if err != nil {
+		return false
+	}
+	return ft == record.Integer || ft == record.String || ft == record.UUID
+}
+
+// Covers は fieldName の値をインデックスのキーからそのまま読めるかどうかを返す
+// 切り詰めたキーや式のキーは元の値に戻せないので、キーの順が値の順になる場合だけ true
+func (ii *IndexInfo) Covers(fieldName string) bool {
+	return ii.fieldName == fieldName && ii.IsOrdered()
+}
+
+// TruncatesKey はインデックスのキーが値を切り詰めることがあるかどうかを返す
+// 式や JSON, TEXT, BLOB の列は先頭の index.MaxKeyLength 文字だけを保存するので、キーが同じでも値が違うことがある
+func (ii *IndexInfo) TruncatesKey() bool {
+	for _, e := range ii.exprs {
+		ft, err := e.ResultType(ii.tableSchema)
+		if err != nil {
+			return true
+		}
+		switch ft {
+		case record.String:
+			if !e.IsFieldName() {
+				return true
+			}
+		case record.JSON, record.Text, record.Blob:
+			return true
+		}
+	}
+	return false
+}
+
+// IndexOnlyBlocksAccessed はデータレコードを読まずに、 r の範囲のインデックスレコードだけを読むブロック数を見積もる
+func (ii *IndexInfo) IndexOnlyBlocksAccessed(r query.Range) int {
+	return ii.RangeBlocksAccessed(r)
 }
 
 func (ii *IndexInfo) DistinctValues(fieldName string) int {
-	if ii.fieldName == fieldName {
-		return 1
+	for _, e := range ii.exprs {
+		if e.String() == fieldName {
+			return 1
+		}
 	}
-	return ii.si.DistinctValues(ii.fieldName)
+	return ii.si.DistinctValues(ii.exprs[0].String())
 }
 
 func (ii *IndexInfo) calculateRecordsPerBlock() int {
 	return ii.tx.BlockSize() / ii.indexLayout.SlotSize()
 }
 
-func createIndexLayout(tableSchema *record.Schema, fieldName string) (*record.Layout, error) {
+// createIndexLayout はインデックスレコードのレイアウトを生成する
+// 式や JSON, TEXT, BLOB の値は長さが決まっていないので、先頭の index.MaxKeyLength 文字をキーにする
+// 複合インデックスの2つ目以降の列は index.DataValueField のフィールドに保存する
+// 全文検索のインデックスは値ではなく語を保存するので、先頭の index.MaxKeyLength 文字をキーにする
+func createIndexLayout(tableSchema *record.Schema, exprs []query.Expression, indexType index.IndexType) (*record.Layout, error) {
 	schema := record.NewSchema()
 	schema.AddIntField(index.IndexIdField)
 	schema.AddIntField(index.IndexBlockNumberField)
-
-	ft, err := tableSchema.FieldType(fieldName)
-	if err != nil {
-		return nil, err
+	if indexType == index.FullTextIndexType {
+		schema.AddStringField(index.IndexDataValueField, index.MaxKeyLength)
+		return record.NewLayout(schema), nil
 	}
 
-	switch ft {
-	case record.Integer:
-		schema.AddIntField(index.IndexDataValueField)
-	case record.String:
-		l, err := tableSchema.Length(fieldName)
+	for i, expr := range exprs {
+		fn := index.DataValueField(i)
+		ft, err := expr.ResultType(tableSchema)
 		if err != nil {
 			return nil, err
 		}
-		schema.AddStringField(index.IndexDataValueField, l)
+
+		switch ft {
+		case record.Integer:
+			schema.AddIntField(fn)
+		case record.String:
+			l := index.MaxKeyLength
+			if expr.IsFieldName() {
+				l, err = tableSchema.Length(expr.AsFieldName())
+				if err != nil {
+					return nil, err
+				}
+			}
+			schema.AddStringField(fn, l)
+		case record.JSON, record.Text, record.Blob:
+			schema.AddStringField(fn, index.MaxKeyLength)
+		case record.UUID:
+			schema.AddUUIDField(fn)
+		}
 	}
 
 	return record.NewLayout(schema), nil
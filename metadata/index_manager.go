@@ -1,52 +1,169 @@
 package metadata
 
 import (
+	"github.com/ksrnnb/go-rdb/index"
+	"github.com/ksrnnb/go-rdb/parser"
 	"github.com/ksrnnb/go-rdb/query"
 	"github.com/ksrnnb/go-rdb/record"
+	"github.com/ksrnnb/go-rdb/sqlstate"
 	"github.com/ksrnnb/go-rdb/tx"
 )
 
-// --------------------------
-// |     index_catalogs     |
-// --------------------------
-// | index_name varchar(16) |
-// | table_name varchar(16) |
-// | field_name varchar(16) |
-// --------------------------
+// --------------------------------
+// |        index_catalogs        |
+// --------------------------------
+// | index_name       varchar(16) |
+// | table_name       varchar(16) |
+// | field_name       varchar(16) |
+// | index_expression varchar(32) |
+// | is_unique        int         |
+// | index_method     varchar(8)  |
+// --------------------------------
+// field_name は式が参照する最初のフィールド名
+// index_expression はフィールド名か payload->>'name' のような式で、複合インデックスの場合はカンマで区切った列の式
+// is_unique はユニークインデックスの場合は 1, それ以外は 0
+// index_method は btree, hash, fulltext, bitmap のどれか
+
+// --------------------------------
+// |       index_predicates       |
+// --------------------------------
+// | index_name       varchar(16) |
+// | table_name       varchar(16) |
+// | index_predicate  text        |
+// --------------------------------
+// 部分インデックスの条件で、部分インデックスの場合だけレコードを追加する
+// index_catalogs のレコードは小さいブロックにも入るように、長くなる条件は別のテーブルに保存する
+// 古い形式の index_catalogs と条件のテーブルがないデータベースは、開いたときに今の形式に書き換える
+
+// MaxIndexExpressionLength はインデックスの式の最大文字数
+const MaxIndexExpressionLength = 32
+
+// maxIndexMethodLength はインデックスの方式名の最大文字数
+const maxIndexMethodLength = 8
 
 const (
-	indexCatalogTableName = "index_catalogs"
+	indexCatalogTableName     = "index_catalogs"
+	indexPredicateCatalogName = "index_predicates"
 )
 const (
-	indexNameField = "index_name"
+	indexNameField       = "index_name"
+	indexExpressionField = "index_expression"
+	isUniqueField        = "is_unique"
+	indexMethodField     = "index_method"
+	indexPredicateField  = "index_predicate"
 )
 
 type IndexManager struct {
-	layout *record.Layout
-	tm     *TableManager
-	sm     *StatisticManager
+	layout     *record.Layout
+	predLayout *record.Layout
+	tm         *TableManager
+	sm         *StatisticManager
 }
 
 func NewIndexManager(isNew bool, tm *TableManager, sm *StatisticManager, tx *tx.Transaction) (*IndexManager, error) {
 	if isNew {
-		schema := record.NewSchema()
-		schema.AddStringField(indexNameField, MaxFieldNameLength)
-		schema.AddStringField(tableNameField, MaxFieldNameLength)
-		schema.AddStringField(fieldNameField, MaxFieldNameLength)
-		err := tm.CreateTable(indexCatalogTableName, schema, tx)
+		err := tm.CreateTable(indexCatalogTableName, indexCatalogSchema(), tx)
+		if err != nil {
+			return nil, err
+		}
+		err = tm.CreateTable(indexPredicateCatalogName, indexPredicateSchema(), tx)
 		if err != nil {
 			return nil, err
 		}
 	}
-	layout, err := tm.Layout(indexCatalogTableName, tx)
+	layout, err := tm.migrateTable(indexCatalogTableName, indexCatalogSchema(), migrateIndexCatalog, tx)
 	if err != nil {
 		return nil, err
 	}
-	return &IndexManager{layout, tm, sm}, nil
+	predLayout, err := tm.Layout(indexPredicateCatalogName, tx)
+	if sqlstate.CodeOf(err) == sqlstate.UndefinedTable {
+		// 部分インデックスを追加する前のデータベースには、条件のテーブルがない
+		err = tm.CreateTable(indexPredicateCatalogName, indexPredicateSchema(), tx)
+		if err != nil {
+			return nil, err
+		}
+		predLayout, err = tm.Layout(indexPredicateCatalogName, tx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &IndexManager{layout, predLayout, tm, sm}, nil
+}
+
+func indexCatalogSchema() *record.Schema {
+	schema := record.NewSchema()
+	schema.AddStringField(indexNameField, MaxFieldNameLength)
+	schema.AddStringField(tableNameField, MaxFieldNameLength)
+	schema.AddStringField(fieldNameField, MaxFieldNameLength)
+	schema.AddStringField(indexExpressionField, MaxIndexExpressionLength)
+	schema.AddIntField(isUniqueField)
+	schema.AddStringField(indexMethodField, maxIndexMethodLength)
+	return schema
+}
+
+func indexPredicateSchema() *record.Schema {
+	schema := record.NewSchema()
+	schema.AddStringField(indexNameField, MaxFieldNameLength)
+	schema.AddStringField(tableNameField, MaxFieldNameLength)
+	schema.AddTextField(indexPredicateField)
+	return schema
+}
+
+// migrateIndexCatalog は古い形式の index_catalogs のレコードに、後から追加したフィールドの値を追加する
+// 式のインデックスを追加する前はフィールド名がキーで、ユニークインデックスと方式を追加する前は全て B-tree のインデックス
+func migrateIndexCatalog(row map[string]query.Constant) bool {
+	if _, ok := row[indexExpressionField]; !ok {
+		row[indexExpressionField] = row[fieldNameField]
+	}
+	if _, ok := row[isUniqueField]; !ok {
+		row[isUniqueField] = query.NewConstant(0)
+	}
+	if _, ok := row[indexMethodField]; !ok {
+		row[indexMethodField] = query.NewConstant(index.BTreeIndexType.String())
+	}
+	return true
 }
 
 // CreateIndex は indexCatalogTableName テーブルにインデックスのレコードを追加する
-func (im *IndexManager) CreateIndex(indexName string, tableName string, fieldName string, tx *tx.Transaction) error {
+// fieldName にはフィールド名の他に payload->>'name' のような式や、 a, b のようなカンマで区切った列も指定できる
+// method はインデックスの方式名 (btree, hash, fulltext, bitmap) で、空の場合は btree になる
+// predicate は部分インデックスの条件で、空の場合は全てのレコードを登録する
+// unique の場合は、同じキーのレコードを1つしか登録できないユニークインデックスになる
+func (im *IndexManager) CreateIndex(indexName string, tableName string, fieldName string, method string, predicate string, unique bool, tx *tx.Transaction) error {
+	if len([]rune(fieldName)) > MaxIndexExpressionLength {
+		return sqlstate.Errorf(sqlstate.InvalidObjectDefinition, "index expression %s is too long", fieldName)
+	}
+	it, ok := index.ParseIndexType(method)
+	if !ok {
+		return sqlstate.Errorf(sqlstate.UndefinedObject, "index method %s does not exist", method)
+	}
+	exprs, err := parseIndexExpressions(fieldName)
+	if err != nil {
+		return err
+	}
+	layout, err := im.tm.Layout(tableName, tx)
+	if err != nil {
+		return err
+	}
+	for _, expr := range exprs {
+		if len(expr.FieldNames()) == 0 {
+			return sqlstate.Errorf(sqlstate.InvalidObjectDefinition, "index expression %s must refer to a field", expr)
+		}
+		if !expr.AppliesTo(layout.Schema()) {
+			return sqlstate.Errorf(sqlstate.UndefinedColumn, "index expression %s does not apply to table %s", expr, tableName)
+		}
+	}
+	if err := validateIndexMethod(it, exprs, unique, layout.Schema()); err != nil {
+		return err
+	}
+	pred, err := parseIndexPredicate(predicate)
+	if err != nil {
+		return err
+	}
+	if pred != nil && !pred.AppliesTo(layout.Schema()) {
+		return sqlstate.Errorf(sqlstate.UndefinedColumn, "index predicate %s does not apply to table %s", pred, tableName)
+	}
+
 	ts, err := query.NewTableScan(tx, indexCatalogTableName, im.layout)
 	if err != nil {
 		return err
@@ -65,16 +182,152 @@ func (im *IndexManager) CreateIndex(indexName string, tableName string, fieldNam
 	if err != nil {
 		return err
 	}
-	err = ts.SetString(fieldNameField, fieldName)
+	err = ts.SetString(fieldNameField, exprs[0].FieldNames()[0])
+	if err != nil {
+		return err
+	}
+	err = ts.SetString(indexExpressionField, keyString(exprs))
+	if err != nil {
+		return err
+	}
+	isUnique := 0
+	if unique {
+		isUnique = 1
+	}
+	err = ts.SetInt(isUniqueField, isUnique)
+	if err != nil {
+		return err
+	}
+	err = ts.SetString(indexMethodField, it.String())
+	if err != nil {
+		return err
+	}
+	err = ts.Close()
+	if err != nil {
+		return err
+	}
+	if pred == nil {
+		return nil
+	}
+	return im.createPredicate(indexName, tableName, predicate, tx)
+}
+
+// validateIndexMethod はインデックスの方式で、指定した列のインデックスを作れるかどうかを確認する
+// 全文検索とビットマップのインデックスは1つの列だけを指定できて、同じ値のレコードをまとめて保存するのでユニークにはできない
+// 全文検索のインデックスは語に分ける文字列の列だけに作れる
+func validateIndexMethod(it index.IndexType, exprs []query.Expression, unique bool, tableSchema *record.Schema) error {
+	if it != index.FullTextIndexType && it != index.BitmapIndexType {
+		return nil
+	}
+	if len(exprs) != 1 {
+		return sqlstate.Errorf(sqlstate.FeatureNotSupported, "%s index must have exactly one column", it)
+	}
+	if unique {
+		return sqlstate.Errorf(sqlstate.FeatureNotSupported, "%s index cannot be unique", it)
+	}
+	if it != index.FullTextIndexType {
+		return nil
+	}
+	ft, err := exprs[0].ResultType(tableSchema)
+	if err != nil {
+		return err
+	}
+	if ft != record.String && ft != record.Text && ft != record.JSON {
+		return sqlstate.Errorf(sqlstate.DatatypeMismatch, "fulltext index expression %s must be a string", exprs[0])
+	}
+	return nil
+}
+
+// createPredicate は indexPredicateCatalogName テーブルに部分インデックスの条件のレコードを追加する
+func (im *IndexManager) createPredicate(indexName string, tableName string, predicate string, tx *tx.Transaction) error {
+	ts, err := query.NewTableScan(tx, indexPredicateCatalogName, im.predLayout)
 	if err != nil {
 		return err
 	}
+	if err := ts.Insert(); err != nil {
+		return err
+	}
+	if err := ts.SetString(indexNameField, indexName); err != nil {
+		return err
+	}
+	if err := ts.SetString(tableNameField, tableName); err != nil {
+		return err
+	}
+	if err := ts.SetString(indexPredicateField, predicate); err != nil {
+		return err
+	}
 	return ts.Close()
 }
 
+// predicates は指定したテーブルの部分インデックスの条件を、インデックス名をキーにして返す
+func (im *IndexManager) predicates(tableName string, tx *tx.Transaction) (map[string]*query.Predicate, error) {
+	preds := make(map[string]*query.Predicate)
+	ts, err := query.NewTableScan(tx, indexPredicateCatalogName, im.predLayout)
+	if err != nil {
+		return nil, err
+	}
+	hasNext, err := ts.Next()
+	if err != nil {
+		return nil, err
+	}
+	for hasNext {
+		tn, err := ts.GetString(tableNameField)
+		if err != nil {
+			return nil, err
+		}
+		if tn == tableName {
+			indexName, err := ts.GetString(indexNameField)
+			if err != nil {
+				return nil, err
+			}
+			s, err := ts.GetString(indexPredicateField)
+			if err != nil {
+				return nil, err
+			}
+			pred, err := parseIndexPredicate(s)
+			if err != nil {
+				return nil, err
+			}
+			preds[indexName] = pred
+		}
+		hasNext, err = ts.Next()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return preds, ts.Close()
+}
+
+// parseIndexExpressions はカンマで区切ったインデックスの列の式をパースする
+func parseIndexExpressions(s string) ([]query.Expression, error) {
+	p, err := parser.NewParser(s)
+	if err != nil {
+		return nil, err
+	}
+	return p.ExpressionList()
+}
+
+// parseIndexPredicate は部分インデックスの条件をパースする
+// 空の場合は nil を返す
+func parseIndexPredicate(s string) (*query.Predicate, error) {
+	if s == "" {
+		return nil, nil
+	}
+	p, err := parser.NewParser(s)
+	if err != nil {
+		return nil, err
+	}
+	return p.Predicate()
+}
+
 // IndexInfo は indexCatalogTableName をスキャンして、指定したテーブルのインデックス情報を取得する
+// map のキーはインデックス名で、同じ式に B-tree とハッシュのように複数のインデックスがあっても全て返す
 func (im *IndexManager) IndexInfo(tableName string, tx *tx.Transaction) (map[string]*IndexInfo, error) {
 	iis := make(map[string]*IndexInfo)
+	preds, err := im.predicates(tableName, tx)
+	if err != nil {
+		return nil, err
+	}
 	ts, err := query.NewTableScan(tx, indexCatalogTableName, im.layout)
 	if err != nil {
 		return nil, err
@@ -100,10 +353,26 @@ func (im *IndexManager) IndexInfo(tableName string, tx *tx.Transaction) (map[str
 		if err != nil {
 			return nil, err
 		}
-		fieldName, err := ts.GetString(fieldNameField)
+		exprString, err := ts.GetString(indexExpressionField)
+		if err != nil {
+			return nil, err
+		}
+		exprs, err := parseIndexExpressions(exprString)
+		if err != nil {
+			return nil, err
+		}
+		isUnique, err := ts.GetInt(isUniqueField)
 		if err != nil {
 			return nil, err
 		}
+		method, err := ts.GetString(indexMethodField)
+		if err != nil {
+			return nil, err
+		}
+		it, ok := index.ParseIndexType(method)
+		if !ok {
+			return nil, sqlstate.Errorf(sqlstate.UndefinedObject, "index method %s does not exist", method)
+		}
 		layout, err := im.tm.Layout(tableName, tx)
 		if err != nil {
 			return nil, err
@@ -112,11 +381,11 @@ func (im *IndexManager) IndexInfo(tableName string, tx *tx.Transaction) (map[str
 		if err != nil {
 			return nil, err
 		}
-		ii, err := NewIndexInfo(indexName, fieldName, layout.Schema(), tx, si)
+		ii, err := NewIndexInfo(indexName, exprs, preds[indexName], it, isUnique == 1, layout.Schema(), tx, si)
 		if err != nil {
 			return nil, err
 		}
-		iis[fieldName] = ii
+		iis[indexName] = ii
 
 		newHasNext, err := ts.Next()
 		if err != nil {
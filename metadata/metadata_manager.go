@@ -51,8 +51,8 @@ func (mm *MetadataManager) GetViewDefinition(viewName string, tx *tx.Transaction
 	return mm.vm.Definition(viewName, tx)
 }
 
-func (mm *MetadataManager) CreateIndex(indexName, tableName, fieldName string, tx *tx.Transaction) error {
-	return mm.im.CreateIndex(indexName, tableName, fieldName, tx)
+func (mm *MetadataManager) CreateIndex(indexName, tableName, fieldName, method, predicate string, unique bool, tx *tx.Transaction) error {
+	return mm.im.CreateIndex(indexName, tableName, fieldName, method, predicate, unique, tx)
 }
 
 func (mm *MetadataManager) GetIndexInfo(tableName string, tx *tx.Transaction) (map[string]*IndexInfo, error) {
@@ -6,10 +6,12 @@ import (
 	"os"
 	"testing"
 
+	"github.com/ksrnnb/go-rdb/index"
 	"github.com/ksrnnb/go-rdb/metadata"
 	"github.com/ksrnnb/go-rdb/query"
 	"github.com/ksrnnb/go-rdb/record"
 	"github.com/ksrnnb/go-rdb/server"
+	"github.com/ksrnnb/go-rdb/sqlstate"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -97,26 +99,100 @@ func TestMetadataManager(t *testing.T) {
 	assert.Equal(t, definition, gotDef)
 
 	// Part4: Index Metadata
-	err = mm.CreateIndex("indexA", "MyTable", "A", tx)
+	err = mm.CreateIndex("indexA", "MyTable", "A", "", "", false, tx)
 
 	require.NoError(t, err)
-	err = mm.CreateIndex("indexB", "MyTable", "B", tx)
+	err = mm.CreateIndex("indexB", "MyTable", "B", "", "", false, tx)
 	require.NoError(t, err)
 
 	iis, err := mm.GetIndexInfo("MyTable", tx)
 	require.NoError(t, err)
 
-	ii, ok := iis["A"]
+	ii, ok := iis["indexA"]
 	require.True(t, ok)
 	fmt.Printf("B(indexA) = %d\n", ii.BlocksAccessed())
 	fmt.Printf("R(indexA) = %d\n", ii.RecordsOutput())
 	fmt.Printf("V(indexA, A) = %d\n", ii.DistinctValues("A"))
 	fmt.Printf("V(indexA, B) = %d\n", ii.DistinctValues("B"))
 
-	ii, ok = iis["B"]
+	ii, ok = iis["indexB"]
 	require.True(t, ok)
 	fmt.Printf("B(indexB) = %d\n", ii.BlocksAccessed())
 	fmt.Printf("R(indexB) = %d\n", ii.RecordsOutput())
 	fmt.Printf("V(indexB, A) = %d\n", ii.DistinctValues("A"))
 	fmt.Printf("V(indexB, B) = %d\n", ii.DistinctValues("B"))
+
+	// 部分インデックスも同じ式のインデックスと別に、インデックス名で取得する
+	pred := "A>=10 and A<20 and B like 'rec%' and B<>'rec15'"
+	err = mm.CreateIndex("indexC", "MyTable", "A", "", pred, false, tx)
+	require.NoError(t, err)
+	err = mm.CreateIndex("indexD", "MyTable", "A", "", "C = 1", false, tx)
+	assert.Equal(t, sqlstate.UndefinedColumn, sqlstate.CodeOf(err))
+	iis, err = mm.GetIndexInfo("MyTable", tx)
+	require.NoError(t, err)
+	assert.Len(t, iis, 3)
+	ii, ok = iis["indexC"]
+	require.True(t, ok)
+	assert.Equal(t, "indexC", ii.IndexName())
+	assert.Equal(t, pred, ii.Predicate().String())
+	assert.False(t, iis["indexA"].IsPartial())
+}
+
+func TestMetadataManager_MigrateIndexCatalog(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDB("data", 400, 8)
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+	createLegacyCatalogs(t, tx)
+	icatSchema := record.NewSchema()
+	icatSchema.AddStringField("index_name", metadata.MaxFieldNameLength)
+	icatSchema.AddStringField("table_name", metadata.MaxFieldNameLength)
+	icatSchema.AddStringField("field_name", metadata.MaxFieldNameLength)
+	ts, err := query.NewTableScan(tx, "index_catalogs", record.NewLayout(icatSchema))
+	require.NoError(t, err)
+	for _, fn := range []string{"id", "name"} {
+		require.NoError(t, ts.Insert())
+		require.NoError(t, ts.SetString("index_name", "users_"+fn+"_idx"))
+		require.NoError(t, ts.SetString("table_name", "users"))
+		require.NoError(t, ts.SetString("field_name", fn))
+	}
+	require.NoError(t, ts.Close())
+	require.NoError(t, tx.Commit())
+
+	// 既存のデータベースを開くと、 index_catalogs を今の形式に書き換えて index_predicates を作る
+	for i := 0; i < 2; i++ {
+		tx, err = db.NewTransaction()
+		require.NoError(t, err)
+		mm, err := metadata.NewMetadataManager(false, tx)
+		require.NoError(t, err)
+		iis, err := mm.GetIndexInfo("users", tx)
+		require.NoError(t, err)
+		require.Len(t, iis, 2)
+		for _, fn := range []string{"id", "name"} {
+			ii, ok := iis["users_"+fn+"_idx"]
+			require.True(t, ok)
+			assert.Equal(t, "users_"+fn+"_idx", ii.IndexName())
+			assert.Equal(t, fn, ii.Key())
+			assert.Equal(t, index.BTreeIndexType, ii.IndexType())
+			assert.False(t, ii.IsUnique())
+			assert.False(t, ii.IsPartial())
+		}
+		require.NoError(t, tx.Commit())
+	}
+
+	// 書き換えた後は部分インデックスやユニークインデックスを登録できる
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	mm, err := metadata.NewMetadataManager(false, tx)
+	require.NoError(t, err)
+	require.NoError(t, mm.CreateIndex("users_pid_idx", "users", "id", "hash", "id > 10", true, tx))
+	iis, err := mm.GetIndexInfo("users", tx)
+	require.NoError(t, err)
+	require.Len(t, iis, 3)
+	ii, ok := iis["users_pid_idx"]
+	require.True(t, ok)
+	assert.Equal(t, index.HashIndexType, ii.IndexType())
+	assert.True(t, ii.IsUnique())
+	require.NoError(t, tx.Commit())
 }
@@ -1,10 +1,9 @@
 package metadata
 
 import (
-	"fmt"
-
 	"github.com/ksrnnb/go-rdb/query"
 	"github.com/ksrnnb/go-rdb/record"
+	"github.com/ksrnnb/go-rdb/sqlstate"
 	"github.com/ksrnnb/go-rdb/tx"
 )
 
@@ -15,19 +14,22 @@ import (
 // | slot_size  int         |
 // --------------------------
 
-// --------------------------
-// |     field_catalogs     |
-// --------------------------
-// | table_name varchar(16) |
-// | field_name varchar(16) |
-// | field_type int         |
-// | length     int         |
-// | offset     int         |
-// --------------------------
+// -----------------------------
+// |      field_catalogs       |
+// -----------------------------
+// | table_name    varchar(16) |
+// | field_name    varchar(16) |
+// | field_type    int         |
+// | length        int         |
+// | offset        int         |
+// | default_value varchar(32) |
+// -----------------------------
+// default_value を追加する前の field_catalogs は、既存のデータベースを開いたときに今の形式に書き換える
 
 const (
-	MaxFieldNameLength = 16
-	MaxTableNameLength = 16
+	MaxFieldNameLength    = 16
+	MaxTableNameLength    = 16
+	MaxDefaultValueLength = 32
 )
 
 const (
@@ -42,6 +44,7 @@ const (
 	fieldTypeField = "field_type"
 	lengthField    = "length"
 	offsetField    = "offset"
+	defaultField   = "default_value"
 )
 
 type TableManager struct {
@@ -55,12 +58,8 @@ func NewTableManager(isNew bool, tx *tx.Transaction) (*TableManager, error) {
 	tcatSchema.AddIntField(slotSizeField)
 	tcatLayout := record.NewLayout(tcatSchema)
 
-	fcatSchema := record.NewSchema()
-	fcatSchema.AddStringField(tableNameField, MaxTableNameLength)
-	fcatSchema.AddStringField(fieldNameField, MaxFieldNameLength)
-	fcatSchema.AddIntField(fieldTypeField)
-	fcatSchema.AddIntField(lengthField)
-	fcatSchema.AddIntField(offsetField)
+	fcatSchema := legacyFieldCatalogSchema()
+	fcatSchema.AddStringField(defaultField, MaxDefaultValueLength)
 	fcatLayout := record.NewLayout(fcatSchema)
 
 	tm := &TableManager{tcatLayout, fcatLayout}
@@ -74,11 +73,268 @@ func NewTableManager(isNew bool, tx *tx.Transaction) (*TableManager, error) {
 		if err != nil {
 			return nil, err
 		}
+		return tm, nil
+	}
+	if err := tm.migrateFieldCatalog(tx); err != nil {
+		return nil, err
 	}
 	return tm, nil
 }
 
+// legacyFieldCatalogSchema は default_value を追加する前の field_catalogs のスキーマを返す
+func legacyFieldCatalogSchema() *record.Schema {
+	schema := record.NewSchema()
+	schema.AddStringField(tableNameField, MaxTableNameLength)
+	schema.AddStringField(fieldNameField, MaxFieldNameLength)
+	schema.AddIntField(fieldTypeField)
+	schema.AddIntField(lengthField)
+	schema.AddIntField(offsetField)
+	return schema
+}
+
+// migrateFieldCatalog は default_value を追加する前の形式の field_catalogs を、今の形式に書き換える
+// field_catalogs のレイアウトは field_catalogs 自身から読めないので、 table_catalogs に記録した slot_size で形式を見分ける
+// どちらでもない場合はエラーを返す
+// 既存のフィールドにはデフォルト値がないので、 default_value は空にする
+func (tm *TableManager) migrateFieldCatalog(tx *tx.Transaction) error {
+	size, err := tm.slotSize(fieldCatalogTableName, tx)
+	if err != nil {
+		return err
+	}
+	if size == tm.fcatLayout.SlotSize() {
+		return nil
+	}
+	legacyLayout := record.NewLayout(legacyFieldCatalogSchema())
+	if size != legacyLayout.SlotSize() {
+		return sqlstate.Errorf(sqlstate.DataCorrupted, "unknown %s format: slot size %d", fieldCatalogTableName, size)
+	}
+
+	err = rewriteRecords(tx, fieldCatalogTableName, legacyLayout, tm.fcatLayout, func(row map[string]query.Constant) bool {
+		row[defaultField] = query.NewConstant("")
+		// field_catalogs 自身のフィールドは default_value を含めて書き込み直す
+		return row[tableNameField].AsString() != fieldCatalogTableName
+	})
+	if err != nil {
+		return err
+	}
+	if err := tm.createFieldCatalogTable(fieldCatalogTableName, tm.fcatLayout.Schema(), tx, tm.fcatLayout); err != nil {
+		return err
+	}
+	return tm.setSlotSize(fieldCatalogTableName, tm.fcatLayout.SlotSize(), tx)
+}
+
+// migrateTable はカタログに記録した tableName のフィールドが schema と違う場合に、
+// レコードを schema の形式に書き換えて、カタログに記録したレイアウトも書き換える
+// convert は古い形式のレコードの値に schema にしかないフィールドの値を追加して、書き込まないレコードの場合は false を返す
+// 同じ場合は何もせずに、今のレイアウトを返す
+func (tm *TableManager) migrateTable(tableName string, schema *record.Schema, convert func(row map[string]query.Constant) bool, tx *tx.Transaction) (*record.Layout, error) {
+	oldLayout, err := tm.Layout(tableName, tx)
+	if err != nil {
+		return nil, err
+	}
+	if equalFields(oldLayout.Schema(), schema) {
+		return oldLayout, nil
+	}
+	layout := record.NewLayout(schema)
+	if err := rewriteRecords(tx, tableName, oldLayout, layout, convert); err != nil {
+		return nil, err
+	}
+	if err := tm.deleteFieldCatalogs(tableName, tx); err != nil {
+		return nil, err
+	}
+	if err := tm.createFieldCatalogTable(tableName, schema, tx, layout); err != nil {
+		return nil, err
+	}
+	if err := tm.setSlotSize(tableName, layout.SlotSize(), tx); err != nil {
+		return nil, err
+	}
+	return layout, nil
+}
+
+// equalFields は2つのスキーマのフィールドの名前と型と長さが同じ順に並んでいるかどうかを返す
+func equalFields(s1 *record.Schema, s2 *record.Schema) bool {
+	fields1, fields2 := s1.Fields(), s2.Fields()
+	if len(fields1) != len(fields2) {
+		return false
+	}
+	for i, fn := range fields1 {
+		if fields2[i] != fn {
+			return false
+		}
+		ft1, err1 := s1.FieldType(fn)
+		ft2, err2 := s2.FieldType(fn)
+		l1, err3 := s1.Length(fn)
+		l2, err4 := s2.Length(fn)
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil || ft1 != ft2 || l1 != l2 {
+			return false
+		}
+	}
+	return true
+}
+
+// rewriteRecords は oldLayout で書き込んだ tableName のレコードを全て読んで、 newLayout の形式で書き込み直す
+// convert は古いレコードのフィールド名と値の組に新しい形式のフィールドの値を追加して、書き込まないレコードの場合は false を返す
+// 古い形式のブロックを新しい形式で読むと古いレコードの値が Used のフラグに見える slot があるので、全て削除してから書き込む
+func rewriteRecords(tx *tx.Transaction, tableName string, oldLayout *record.Layout, newLayout *record.Layout, convert func(row map[string]query.Constant) bool) error {
+	ts, err := query.NewTableScan(tx, tableName, oldLayout)
+	if err != nil {
+		return err
+	}
+	rows := make([]map[string]query.Constant, 0)
+	for {
+		ok, err := ts.Next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		row := make(map[string]query.Constant)
+		for _, fn := range oldLayout.Schema().Fields() {
+			v, err := ts.GetVal(fn)
+			if err != nil {
+				return err
+			}
+			row[fn] = v
+		}
+		rows = append(rows, row)
+	}
+	if err := ts.Close(); err != nil {
+		return err
+	}
+
+	ts, err = query.NewTableScan(tx, tableName, newLayout)
+	if err != nil {
+		return err
+	}
+	for {
+		ok, err := ts.Next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		if err := ts.Delete(); err != nil {
+			return err
+		}
+	}
+	if err := ts.BeforeFirst(); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if !convert(row) {
+			continue
+		}
+		if err := ts.Insert(); err != nil {
+			return err
+		}
+		for _, fn := range newLayout.Schema().Fields() {
+			if err := ts.SetVal(fn, row[fn]); err != nil {
+				return err
+			}
+		}
+	}
+	return ts.Close()
+}
+
+// deleteFieldCatalogs は field_catalogs から tableName のフィールドのレコードを全て削除する
+func (tm *TableManager) deleteFieldCatalogs(tableName string, tx *tx.Transaction) error {
+	fcatTs, err := query.NewTableScan(tx, fieldCatalogTableName, tm.fcatLayout)
+	if err != nil {
+		return err
+	}
+	for {
+		ok, err := fcatTs.Next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		str, err := fcatTs.GetString(tableNameField)
+		if err != nil {
+			return err
+		}
+		if str == tableName {
+			if err := fcatTs.Delete(); err != nil {
+				return err
+			}
+		}
+	}
+	return fcatTs.Close()
+}
+
+// slotSize は table_catalogs に記録した tableName の slot_size を返す
+func (tm *TableManager) slotSize(tableName string, tx *tx.Transaction) (int, error) {
+	tcatTs, err := query.NewTableScan(tx, tableCatalogTableName, tm.tcatLayout)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		ok, err := tcatTs.Next()
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			break
+		}
+		str, err := tcatTs.GetString(tableNameField)
+		if err != nil {
+			return 0, err
+		}
+		if str != tableName {
+			continue
+		}
+		size, err := tcatTs.GetInt(slotSizeField)
+		if err != nil {
+			return 0, err
+		}
+		return size, tcatTs.Close()
+	}
+	if err := tcatTs.Close(); err != nil {
+		return 0, err
+	}
+	return 0, sqlstate.Errorf(sqlstate.UndefinedTable, "table is not found: %s", tableName)
+}
+
+// setSlotSize は table_catalogs に記録した tableName の slot_size を size に書き換える
+func (tm *TableManager) setSlotSize(tableName string, size int, tx *tx.Transaction) error {
+	tcatTs, err := query.NewTableScan(tx, tableCatalogTableName, tm.tcatLayout)
+	if err != nil {
+		return err
+	}
+	for {
+		ok, err := tcatTs.Next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		str, err := tcatTs.GetString(tableNameField)
+		if err != nil {
+			return err
+		}
+		if str == tableName {
+			if err := tcatTs.SetInt(slotSizeField, size); err != nil {
+				return err
+			}
+		}
+	}
+	return tcatTs.Close()
+}
+
 func (tm *TableManager) CreateTable(tableName string, schema *record.Schema, tx *tx.Transaction) error {
+	for _, fn := range schema.Fields() {
+		dv, err := schema.DefaultValue(fn)
+		if err != nil {
+			return err
+		}
+		if len([]rune(dv)) > MaxDefaultValueLength {
+			return sqlstate.Errorf(sqlstate.StringDataRightTruncation, "default value of %s must be less than or equal to %d characters", fn, MaxDefaultValueLength)
+		}
+	}
 	layout := record.NewLayout(schema)
 	err := tm.createTableCatalogTable(tableName, tx, layout)
 	if err != nil {
@@ -162,6 +418,16 @@ func (tm *TableManager) createFieldCatalogTable(tableName string, schema *record
 		if err != nil {
 			return err
 		}
+
+		dv, err := schema.DefaultValue(fn)
+		if err != nil {
+			return err
+		}
+
+		err = fcatTs.SetString(defaultField, dv)
+		if err != nil {
+			return err
+		}
 	}
 	return fcatTs.Close()
 }
@@ -205,7 +471,7 @@ func (tm *TableManager) Layout(tableName string, tx *tx.Transaction) (*record.La
 	}
 
 	if size == -1 {
-		return nil, fmt.Errorf("table is not found: %s", tableName)
+		return nil, sqlstate.Errorf(sqlstate.UndefinedTable, "table is not found: %s", tableName)
 	}
 
 	schema := record.NewSchema()
@@ -244,8 +510,15 @@ func (tm *TableManager) Layout(tableName string, tx *tx.Transaction) (*record.La
 			if err != nil {
 				return nil, err
 			}
+			dv, err := fcatTs.GetString(defaultField)
+			if err != nil {
+				return nil, err
+			}
 			offsets[fn] = fo
 			schema.AddField(fn, record.FieldType(ft), fl)
+			if err := schema.SetDefaultValue(fn, dv); err != nil {
+				return nil, err
+			}
 		}
 		newHasNext, err := fcatTs.Next()
 		if err != nil {
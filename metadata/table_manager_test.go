@@ -5,8 +5,12 @@ import (
 	"testing"
 
 	"github.com/ksrnnb/go-rdb/metadata"
+	"github.com/ksrnnb/go-rdb/query"
 	"github.com/ksrnnb/go-rdb/record"
 	"github.com/ksrnnb/go-rdb/server"
+	"github.com/ksrnnb/go-rdb/sqlstate"
+	"github.com/ksrnnb/go-rdb/tx"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -52,3 +56,145 @@ func TestTableManager(t *testing.T) {
 	err = tx.Commit()
 	require.NoError(t, err)
 }
+
+// createLegacyCatalogs は default_value を追加する前の形式のカタログに、 users テーブルと
+// 式やユニーク、方式を追加する前の形式の index_catalogs を登録する
+// index_predicates はまだないので登録しない
+func createLegacyCatalogs(t *testing.T, tx *tx.Transaction) {
+	t.Helper()
+
+	tcatSchema := record.NewSchema()
+	tcatSchema.AddStringField("table_name", metadata.MaxTableNameLength)
+	tcatSchema.AddIntField("slot_size")
+	fcatSchema := record.NewSchema()
+	fcatSchema.AddStringField("table_name", metadata.MaxTableNameLength)
+	fcatSchema.AddStringField("field_name", metadata.MaxFieldNameLength)
+	fcatSchema.AddIntField("field_type")
+	fcatSchema.AddIntField("length")
+	fcatSchema.AddIntField("offset")
+	icatSchema := record.NewSchema()
+	icatSchema.AddStringField("index_name", metadata.MaxFieldNameLength)
+	icatSchema.AddStringField("table_name", metadata.MaxFieldNameLength)
+	icatSchema.AddStringField("field_name", metadata.MaxFieldNameLength)
+	usersSchema := record.NewSchema()
+	usersSchema.AddIntField("id")
+	usersSchema.AddStringField("name", 8)
+
+	tables := []struct {
+		name   string
+		schema *record.Schema
+	}{
+		{"table_catalogs", tcatSchema},
+		{"field_catalogs", fcatSchema},
+		{"index_catalogs", icatSchema},
+		{"users", usersSchema},
+	}
+	tcatTs, err := query.NewTableScan(tx, "table_catalogs", record.NewLayout(tcatSchema))
+	require.NoError(t, err)
+	fcatTs, err := query.NewTableScan(tx, "field_catalogs", record.NewLayout(fcatSchema))
+	require.NoError(t, err)
+	for _, table := range tables {
+		layout := record.NewLayout(table.schema)
+		require.NoError(t, tcatTs.Insert())
+		require.NoError(t, tcatTs.SetString("table_name", table.name))
+		require.NoError(t, tcatTs.SetInt("slot_size", layout.SlotSize()))
+		for _, fn := range table.schema.Fields() {
+			ft, err := table.schema.FieldType(fn)
+			require.NoError(t, err)
+			length, err := table.schema.Length(fn)
+			require.NoError(t, err)
+			offset, err := layout.Offset(fn)
+			require.NoError(t, err)
+			require.NoError(t, fcatTs.Insert())
+			require.NoError(t, fcatTs.SetString("table_name", table.name))
+			require.NoError(t, fcatTs.SetString("field_name", fn))
+			require.NoError(t, fcatTs.SetInt("field_type", ft.AsInt()))
+			require.NoError(t, fcatTs.SetInt("length", length))
+			require.NoError(t, fcatTs.SetInt("offset", offset))
+		}
+	}
+	require.NoError(t, tcatTs.Close())
+	require.NoError(t, fcatTs.Close())
+}
+
+func TestTableManager_MigrateFieldCatalog(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDB("data", 400, 8)
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+	createLegacyCatalogs(t, tx)
+	require.NoError(t, tx.Commit())
+
+	// 既存のデータベースを開くと、 field_catalogs を今の形式に書き換える
+	for i := 0; i < 2; i++ {
+		tx, err = db.NewTransaction()
+		require.NoError(t, err)
+		tm, err := metadata.NewTableManager(false, tx)
+		require.NoError(t, err)
+
+		layout, err := tm.Layout("users", tx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"id", "name"}, layout.Schema().Fields())
+		length, err := layout.Schema().Length("name")
+		require.NoError(t, err)
+		assert.Equal(t, 8, length)
+		dv, err := layout.Schema().DefaultValue("id")
+		require.NoError(t, err)
+		assert.Empty(t, dv)
+
+		fcatLayout, err := tm.Layout("field_catalogs", tx)
+		require.NoError(t, err)
+		assert.True(t, fcatLayout.Schema().HasField("default_value"))
+		require.NoError(t, tx.Commit())
+	}
+
+	// 書き換えた後はデフォルト値のあるテーブルを登録できる
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	tm, err := metadata.NewTableManager(false, tx)
+	require.NoError(t, err)
+	schema := record.NewSchema()
+	schema.AddIntField("pid")
+	require.NoError(t, schema.SetDefaultValue("pid", "1"))
+	require.NoError(t, tm.CreateTable("pictures", schema, tx))
+	layout, err := tm.Layout("pictures", tx)
+	require.NoError(t, err)
+	dv, err := layout.Schema().DefaultValue("pid")
+	require.NoError(t, err)
+	assert.Equal(t, "1", dv)
+	require.NoError(t, tx.Commit())
+}
+
+func TestTableManager_UnknownFieldCatalogFormat(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDB("data", 400, 8)
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+	createLegacyCatalogs(t, tx)
+
+	// field_catalogs の slot_size がどの形式とも違う場合は、読み込まずにエラーを返す
+	tcatSchema := record.NewSchema()
+	tcatSchema.AddStringField("table_name", metadata.MaxTableNameLength)
+	tcatSchema.AddIntField("slot_size")
+	ts, err := query.NewTableScan(tx, "table_catalogs", record.NewLayout(tcatSchema))
+	require.NoError(t, err)
+	for {
+		ok, err := ts.Next()
+		require.NoError(t, err)
+		if !ok {
+			break
+		}
+		name, err := ts.GetString("table_name")
+		require.NoError(t, err)
+		if name == "field_catalogs" {
+			require.NoError(t, ts.SetInt("slot_size", 7))
+		}
+	}
+	require.NoError(t, ts.Close())
+
+	_, err = metadata.NewTableManager(false, tx)
+	assert.Equal(t, sqlstate.DataCorrupted, sqlstate.CodeOf(err))
+	require.NoError(t, tx.Commit())
+}
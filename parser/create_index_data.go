@@ -1,13 +1,39 @@
 package parser
 
+import (
+	"strings"
+
+	"github.com/ksrnnb/go-rdb/query"
+)
+
 type CreateIndexData struct {
 	indexName string
 	tableName string
-	fieldName string
+	exprs     []query.Expression
+	unique    bool
+	// method は USING に指定したインデックスの方式名で、省略した場合は空
+	method string
+	// pred は WHERE に指定した部分インデックスの条件で、省略した場合は nil
+	pred *query.Predicate
 }
 
 func NewCreateIndexData(indexName, tableName, fieldName string) *CreateIndexData {
-	return &CreateIndexData{indexName, tableName, fieldName}
+	return &CreateIndexData{indexName, tableName, []query.Expression{query.NewExpressionFromFieldName(fieldName)}, false, "", nil}
+}
+
+// NewCreateIndexDataFromExpression は payload->>'name' のような式に対するインデックスを作成する
+func NewCreateIndexDataFromExpression(indexName, tableName string, expr query.Expression) *CreateIndexData {
+	return &CreateIndexData{indexName, tableName, []query.Expression{expr}, false, "", nil}
+}
+
+// NewCreateIndexDataFromExpressions は (a, b) のような複数の列に対する複合インデックスを作成する
+func NewCreateIndexDataFromExpressions(indexName, tableName string, exprs []query.Expression) *CreateIndexData {
+	return &CreateIndexData{indexName, tableName, exprs, false, "", nil}
+}
+
+// NewCreateUniqueIndexData は同じキーのレコードを1つしか登録できないユニークインデックスを作成する
+func NewCreateUniqueIndexData(indexName, tableName string, exprs []query.Expression) *CreateIndexData {
+	return &CreateIndexData{indexName, tableName, exprs, true, "", nil}
 }
 
 func (c *CreateIndexData) IndexName() string {
@@ -18,6 +44,48 @@ func (c *CreateIndexData) TableName() string {
 	return c.tableName
 }
 
+// FieldName はインデックスを作成するフィールド名を返す
+// 式の場合は式の文字列を、複合インデックスの場合は列をカンマで区切った文字列を返す
 func (c *CreateIndexData) FieldName() string {
-	return c.fieldName
+	fns := make([]string, len(c.exprs))
+	for i, e := range c.exprs {
+		fns[i] = e.String()
+	}
+	return strings.Join(fns, ", ")
+}
+
+// Expression は先頭の列の式を返す
+func (c *CreateIndexData) Expression() query.Expression {
+	return c.exprs[0]
+}
+
+// Expressions は全ての列の式を列の順に返す
+func (c *CreateIndexData) Expressions() []query.Expression {
+	return c.exprs
+}
+
+// IsUnique はユニークインデックスかどうかを返す
+func (c *CreateIndexData) IsUnique() bool {
+	return c.unique
+}
+
+// Method は USING に指定したインデックスの方式名を返す
+// 省略した場合は空文字列を返す
+func (c *CreateIndexData) Method() string {
+	return c.method
+}
+
+// Predicate は部分インデックスの条件を返す
+// 条件を省略した場合は nil を返す
+func (c *CreateIndexData) Predicate() *query.Predicate {
+	return c.pred
+}
+
+// PredicateString は部分インデックスの条件の文字列を返す
+// 条件を省略した場合は空文字列を返す
+func (c *CreateIndexData) PredicateString() string {
+	if c.pred == nil {
+		return ""
+	}
+	return c.pred.String()
 }
@@ -18,3 +18,12 @@ func (dd *DeleteData) TableName() string {
 func (dd *DeleteData) Predicate() *query.Predicate {
 	return dd.pred
 }
+
+// Bind はプレースホルダを params の値に置き換えた DeleteData を返す
+func (dd *DeleteData) Bind(params []query.Constant) (*DeleteData, error) {
+	pred, err := dd.pred.Bind(params)
+	if err != nil {
+		return nil, err
+	}
+	return NewDeleteData(dd.tableName, pred), nil
+}
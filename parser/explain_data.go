@@ -0,0 +1,20 @@
+package parser
+
+// ExplainData は EXPLAIN [ANALYZE] <query> の対象のクエリを保持する
+type ExplainData struct {
+	qd      *QueryData
+	analyze bool
+}
+
+func NewExplainData(qd *QueryData, analyze bool) *ExplainData {
+	return &ExplainData{qd, analyze}
+}
+
+func (ed *ExplainData) Query() *QueryData {
+	return ed.qd
+}
+
+// Analyze は EXPLAIN ANALYZE でクエリを実行するかどうかを返す
+func (ed *ExplainData) Analyze() bool {
+	return ed.analyze
+}
@@ -23,3 +23,16 @@ func (id *InsertData) Fields() []string {
 func (id *InsertData) Values() []query.Constant {
 	return id.values
 }
+
+// Bind はプレースホルダを params の値に置き換えた InsertData を返す
+func (id *InsertData) Bind(params []query.Constant) (*InsertData, error) {
+	values := make([]query.Constant, len(id.values))
+	for i, v := range id.values {
+		bv, err := v.Bind(params)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = bv
+	}
+	return NewInsertData(id.tableName, id.fields, values), nil
+}
@@ -28,3 +28,16 @@ func (md *ModifyData) NewValue() query.Expression {
 func (md *ModifyData) Predicate() *query.Predicate {
 	return md.pred
 }
+
+// Bind はプレースホルダを params の値に置き換えた ModifyData を返す
+func (md *ModifyData) Bind(params []query.Constant) (*ModifyData, error) {
+	newVal, err := md.newVal.Bind(params)
+	if err != nil {
+		return nil, err
+	}
+	pred, err := md.pred.Bind(params)
+	if err != nil {
+		return nil, err
+	}
+	return NewModifyData(md.tableName, md.fieldName, newVal, pred), nil
+}
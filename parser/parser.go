@@ -1,17 +1,21 @@
 package parser
 
 import (
-	"errors"
+	"strings"
 
 	"github.com/ksrnnb/go-rdb/lexer"
 	"github.com/ksrnnb/go-rdb/query"
 	"github.com/ksrnnb/go-rdb/record"
+	"github.com/ksrnnb/go-rdb/sqlstate"
 )
 
 type Parser struct {
 	lex *lexer.Lexer
 }
 
+// constantTokens は定数として書けるトークンの種類
+var constantTokens = []string{"string", "integer", "blob", "parameter"}
+
 func NewParser(query string) (*Parser, error) {
 	lex, err := lexer.NewLexer(query)
 	if err != nil {
@@ -20,6 +24,55 @@ func NewParser(query string) (*Parser, error) {
 	return &Parser{lex}, nil
 }
 
+// NumParameters はクエリに含まれるプレースホルダの数を返す
+func (p *Parser) NumParameters() int {
+	return p.lex.NumParameters()
+}
+
+// IsQuery は select 文かどうかを返す
+func (p *Parser) IsQuery() bool {
+	return p.lex.MatchKeyword("select")
+}
+
+// Statement は1つの文をパースして、 select 文の場合は *QueryData を、 EXPLAIN の場合は *ExplainData を、
+// それ以外は UpdateCommand の結果を返す
+// 文の後には ; だけを書くことができる
+func (p *Parser) Statement() (interface{}, error) {
+	var stmt interface{}
+	var err error
+	switch {
+	case p.IsQuery():
+		stmt, err = p.Query()
+	case p.lex.MatchKeyword("explain"):
+		stmt, err = p.Explain()
+	case p.lex.MatchKeyword("insert"), p.lex.MatchKeyword("delete"), p.lex.MatchKeyword("update"), p.lex.MatchKeyword("create"):
+		stmt, err = p.UpdateCommand()
+	default:
+		return nil, p.lex.Unexpected("select", "explain", "insert", "delete", "update", "create")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := p.End(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// End は文の終わりを読み込む
+// 文の終わりの ; は省略できる
+func (p *Parser) End() error {
+	if p.lex.MatchDelimiter(';') {
+		if err := p.lex.EatDelimiter(';'); err != nil {
+			return err
+		}
+	}
+	if !p.lex.AtEnd() {
+		return p.lex.Unexpected(";", "end of input")
+	}
+	return nil
+}
+
 func (p *Parser) Field() (string, error) {
 	return p.lex.EatIdentifier()
 }
@@ -37,19 +90,62 @@ func (p *Parser) Constant() (query.Constant, error) {
 			return query.Constant{}, err
 		}
 		return query.NewConstant(ic), nil
+	} else if p.lex.MatchBlobConstant() {
+		bc, err := p.lex.EatBlobConstant()
+		if err != nil {
+			return query.Constant{}, err
+		}
+		return query.NewConstant(bc), nil
+	} else if p.lex.MatchParameter() {
+		n, err := p.lex.EatParameter()
+		if err != nil {
+			return query.Constant{}, err
+		}
+		return query.NewParameterConstant(n), nil
 	} else {
-		return query.Constant{}, errors.New("invalid constant type")
+		return query.Constant{}, p.lex.Unexpected(constantTokens...)
 	}
 
 }
 
+// Expression は式をパースする
+// フィールド名、定数、関数呼び出しのあとに ->, ->> 演算子が続く場合は左から順に結合する
 func (p *Parser) Expression() (query.Expression, error) {
+	expr, err := p.primaryExpression()
+	if err != nil {
+		return query.Expression{}, err
+	}
+	return p.jsonOperators(expr)
+}
+
+// jsonOperators は expr に続く -> と ->> の演算子をパースする
+func (p *Parser) jsonOperators(expr query.Expression) (query.Expression, error) {
+	for p.lex.MatchOperator("->") || p.lex.MatchOperator("->>") {
+		op := p.lex.CurrentTokenValue().(string)
+		if err := p.lex.EatOperator(op); err != nil {
+			return query.Expression{}, err
+		}
+		c, err := p.Constant()
+		if err != nil {
+			return query.Expression{}, err
+		}
+		expr, err = query.NewExpressionFromFunction(op, []query.Expression{expr, query.NewExpressionFromConstant(c)})
+		if err != nil {
+			return query.Expression{}, err
+		}
+	}
+	return expr, nil
+}
+
+func (p *Parser) primaryExpression() (query.Expression, error) {
 	if p.lex.MatchIdentifier() {
 		field, err := p.Field()
 		if err != nil {
 			return query.Expression{}, err
 		}
-		return query.NewExpressionFromFieldName(field), nil
+		return p.identifierExpression(field)
+	} else if !p.lex.MatchConstant() {
+		return query.Expression{}, p.lex.Unexpected(append([]string{"identifier"}, constantTokens...)...)
 	} else {
 		c, err := p.Constant()
 		if err != nil {
@@ -59,29 +155,219 @@ func (p *Parser) Expression() (query.Expression, error) {
 	}
 }
 
+// identifierExpression は識別子 name に続く関数呼び出しをパースして、関数呼び出しでなければフィールド名の式を返す
+func (p *Parser) identifierExpression(name string) (query.Expression, error) {
+	if p.lex.MatchDelimiter('(') {
+		return p.function(name)
+	}
+	return query.NewExpressionFromFieldName(name), nil
+}
+
+// function は関数呼び出し name(arg1, arg2, ...) をパースする
+func (p *Parser) function(name string) (query.Expression, error) {
+	err := p.lex.EatDelimiter('(')
+	if err != nil {
+		return query.Expression{}, err
+	}
+	args := make([]query.Expression, 0)
+	if !p.lex.MatchDelimiter(')') {
+		args, err = p.ExpressionList()
+		if err != nil {
+			return query.Expression{}, err
+		}
+	}
+	err = p.lex.EatDelimiter(')')
+	if err != nil {
+		return query.Expression{}, err
+	}
+	return query.NewExpressionFromFunction(strings.ToLower(name), args)
+}
+
+// ExpressionList はカンマで区切った式のリストをパースする
+func (p *Parser) ExpressionList() ([]query.Expression, error) {
+	e, err := p.Expression()
+	if err != nil {
+		return nil, err
+	}
+	list := []query.Expression{e}
+	if p.lex.MatchDelimiter(',') {
+		err := p.lex.EatDelimiter(',')
+		if err != nil {
+			return nil, err
+		}
+		remainList, err := p.ExpressionList()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, remainList...)
+	}
+	return list, nil
+}
+
+// comparisonOperators は Term に書ける比較演算子
+var comparisonOperators = []string{"<", "<=", ">", ">=", "<>"}
+
 func (p *Parser) Term() (query.Term, error) {
 	lhs, err := p.Expression()
 	if err != nil {
 		return query.Term{}, err
 	}
-	err = p.lex.EatDelimiter('=')
+	return p.comparison(lhs)
+}
+
+// comparison は lhs に続く比較演算子と rhs をパースする
+func (p *Parser) comparison(lhs query.Expression) (query.Term, error) {
+	var op query.Operator
+	switch {
+	case p.lex.MatchDelimiter('='):
+		if err := p.lex.EatDelimiter('='); err != nil {
+			return query.Term{}, err
+		}
+		op = query.Equal
+	case p.lex.MatchKeyword("like"):
+		if err := p.lex.EatKeyword("like"); err != nil {
+			return query.Term{}, err
+		}
+		op = query.Like
+	default:
+		for _, o := range comparisonOperators {
+			if p.lex.MatchOperator(o) {
+				op = query.Operator(o)
+			}
+		}
+		if op == "" {
+			return query.Term{}, p.lex.Unexpected(append([]string{"=", "like"}, comparisonOperators...)...)
+		}
+		if err := p.lex.EatOperator(string(op)); err != nil {
+			return query.Term{}, err
+		}
+	}
+	rhs, err := p.Expression()
+	if err != nil {
+		return query.Term{}, err
+	}
+	return query.NewTermWithOperator(lhs, op, rhs), nil
+}
+
+// condition は1つの条件をパースする
+// F BETWEEN a AND b は F>=a と F<=b の2つの Term になる
+// F IN (c1, c2, ...) は値の組の定数を rhs にした1つの Term になる
+// MATCH は ( が続く場合だけ全文検索の条件で、それ以外は match という名前のフィールドとみなす
+func (p *Parser) condition() (*query.Predicate, error) {
+	var lhs query.Expression
+	if p.lex.MatchKeyword("match") {
+		name, err := p.Field()
+		if err != nil {
+			return nil, err
+		}
+		if p.lex.MatchDelimiter('(') {
+			t, err := p.match()
+			if err != nil {
+				return nil, err
+			}
+			return query.NewPredicateFromTerm(t), nil
+		}
+		lhs, err = p.jsonOperators(query.NewExpressionFromFieldName(name))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		lhs, err = p.Expression()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.lex.MatchKeyword("in") {
+		t, err := p.in(lhs)
+		if err != nil {
+			return nil, err
+		}
+		return query.NewPredicateFromTerm(t), nil
+	}
+	if !p.lex.MatchKeyword("between") {
+		t, err := p.comparison(lhs)
+		if err != nil {
+			return nil, err
+		}
+		return query.NewPredicateFromTerm(t), nil
+	}
+	if err := p.lex.EatKeyword("between"); err != nil {
+		return nil, err
+	}
+	low, err := p.Expression()
 	if err != nil {
+		return nil, err
+	}
+	if err := p.lex.EatKeyword("and"); err != nil {
+		return nil, err
+	}
+	high, err := p.Expression()
+	if err != nil {
+		return nil, err
+	}
+	pred := query.NewPredicateFromTerm(query.NewTermWithOperator(lhs, query.GreaterOrEqual, low))
+	pred.ConJoinWith(query.NewPredicateFromTerm(query.NewTermWithOperator(lhs, query.LessOrEqual, high)))
+	return pred, nil
+}
+
+// in は lhs に続く IN (c1, c2, ...) をパースする
+func (p *Parser) in(lhs query.Expression) (query.Term, error) {
+	if err := p.lex.EatKeyword("in"); err != nil {
+		return query.Term{}, err
+	}
+	if err := p.lex.EatDelimiter('('); err != nil {
+		return query.Term{}, err
+	}
+	vals := make([]query.Constant, 0)
+	for {
+		c, err := p.Constant()
+		if err != nil {
+			return query.Term{}, err
+		}
+		vals = append(vals, c)
+		if !p.lex.MatchDelimiter(',') {
+			break
+		}
+		if err := p.lex.EatDelimiter(','); err != nil {
+			return query.Term{}, err
+		}
+	}
+	if err := p.lex.EatDelimiter(')'); err != nil {
+		return query.Term{}, err
+	}
+	rhs := query.NewExpressionFromConstant(query.NewTupleConstant(vals))
+	return query.NewTermWithOperator(lhs, query.In, rhs), nil
+}
+
+// match は全文検索の条件 MATCH(F, 'terms') の MATCH に続く (F, 'terms') をパースする
+func (p *Parser) match() (query.Term, error) {
+	if err := p.lex.EatDelimiter('('); err != nil {
+		return query.Term{}, err
+	}
+	lhs, err := p.Expression()
+	if err != nil {
+		return query.Term{}, err
+	}
+	if err := p.lex.EatDelimiter(','); err != nil {
 		return query.Term{}, err
 	}
 	rhs, err := p.Expression()
 	if err != nil {
 		return query.Term{}, err
 	}
-	return query.NewTerm(lhs, rhs), nil
+	if err := p.lex.EatDelimiter(')'); err != nil {
+		return query.Term{}, err
+	}
+	return query.NewTermWithOperator(lhs, query.Match, rhs), nil
 }
 
 func (p *Parser) Predicate() (*query.Predicate, error) {
-	t, err := p.Term()
+	pred, err := p.condition()
 	if err != nil {
 		return nil, err
 	}
 
-	pred := query.NewPredicateFromTerm(t)
 	if p.lex.MatchKeyword("and") {
 		err = p.lex.EatKeyword("and")
 		if err != nil {
@@ -103,7 +389,7 @@ func (p *Parser) Query() (*QueryData, error) {
 		return nil, err
 	}
 
-	fields, err := p.selectList()
+	exprs, aggregates, aggregatePos, err := p.selectList()
 	if err != nil {
 		return nil, err
 	}
@@ -129,7 +415,178 @@ func (p *Parser) Query() (*QueryData, error) {
 			return nil, err
 		}
 	}
-	return NewQueryData(fields, tables, pred), nil
+	qd := &QueryData{tables: tables, pred: pred}
+	qd.setSelectList(exprs, aggregates, aggregatePos)
+	if p.lex.MatchKeyword("group") {
+		groupBy, err := p.groupBy()
+		if err != nil {
+			return nil, err
+		}
+		qd.groupBy = groupBy
+	}
+	if err := qd.checkGrouping(); err != nil {
+		return nil, err
+	}
+	if p.lex.MatchKeyword("order") {
+		orderBy, err := p.orderBy()
+		if err != nil {
+			return nil, err
+		}
+		qd.orderBy = orderBy
+	}
+	return qd, nil
+}
+
+// aggregateFunctions は select 句に書ける集計関数
+var aggregateFunctions = []string{"count", "max"}
+
+// selectList は select 句の式と集計関数をパースする
+// 集計関数は式ではないので別に返して、 aggregatePos に select 句の中の位置を返す
+func (p *Parser) selectList() ([]query.Expression, []AggregateField, []int, error) {
+	exprs := make([]query.Expression, 0)
+	aggregates := make([]AggregateField, 0)
+	aggregatePos := make([]int, 0)
+	for i := 0; ; i++ {
+		if !p.lex.MatchIdentifier() {
+			e, err := p.Expression()
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			exprs = append(exprs, e)
+		} else {
+			name, err := p.Field()
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			if p.lex.MatchDelimiter('(') && contains(aggregateFunctions, strings.ToLower(name)) {
+				af, err := p.aggregate(strings.ToLower(name))
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				aggregates = append(aggregates, af)
+				aggregatePos = append(aggregatePos, i)
+			} else {
+				e, err := p.identifierExpression(name)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				e, err = p.jsonOperators(e)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				exprs = append(exprs, e)
+			}
+		}
+		if !p.lex.MatchDelimiter(',') {
+			return exprs, aggregates, aggregatePos, nil
+		}
+		if err := p.lex.EatDelimiter(','); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+}
+
+// aggregate は集計関数の引数 (*) か (F) をパースする
+// * を書けるのは COUNT だけ
+func (p *Parser) aggregate(function string) (AggregateField, error) {
+	if err := p.lex.EatDelimiter('('); err != nil {
+		return AggregateField{}, err
+	}
+	var fn string
+	if function == "count" && p.lex.MatchDelimiter('*') {
+		if err := p.lex.EatDelimiter('*'); err != nil {
+			return AggregateField{}, err
+		}
+	} else {
+		var err error
+		fn, err = p.Field()
+		if err != nil {
+			return AggregateField{}, err
+		}
+	}
+	if err := p.lex.EatDelimiter(')'); err != nil {
+		return AggregateField{}, err
+	}
+	return NewAggregateField(function, fn), nil
+}
+
+// groupBy は GROUP BY F1, F2, ... をパースする
+func (p *Parser) groupBy() ([]string, error) {
+	if err := p.lex.EatKeyword("group"); err != nil {
+		return nil, err
+	}
+	if err := p.lex.EatKeyword("by"); err != nil {
+		return nil, err
+	}
+	fields := make([]string, 0)
+	for {
+		fn, err := p.Field()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, fn)
+		if !p.lex.MatchDelimiter(',') {
+			return fields, nil
+		}
+		if err := p.lex.EatDelimiter(','); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// orderBy は ORDER BY F1 [ASC | DESC], F2 [ASC | DESC], ... をパースする
+func (p *Parser) orderBy() ([]OrderField, error) {
+	if err := p.lex.EatKeyword("order"); err != nil {
+		return nil, err
+	}
+	if err := p.lex.EatKeyword("by"); err != nil {
+		return nil, err
+	}
+	fields := make([]OrderField, 0)
+	for {
+		fn, err := p.Field()
+		if err != nil {
+			return nil, err
+		}
+		descending := false
+		switch {
+		case p.lex.MatchKeyword("asc"):
+			if err := p.lex.EatKeyword("asc"); err != nil {
+				return nil, err
+			}
+		case p.lex.MatchKeyword("desc"):
+			if err := p.lex.EatKeyword("desc"); err != nil {
+				return nil, err
+			}
+			descending = true
+		}
+		fields = append(fields, NewOrderField(fn, descending))
+		if !p.lex.MatchDelimiter(',') {
+			return fields, nil
+		}
+		if err := p.lex.EatDelimiter(','); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Explain は EXPLAIN [ANALYZE] <query> をパースする
+func (p *Parser) Explain() (*ExplainData, error) {
+	err := p.lex.EatKeyword("explain")
+	if err != nil {
+		return nil, err
+	}
+	analyze := p.lex.MatchKeyword("analyze")
+	if analyze {
+		if err := p.lex.EatKeyword("analyze"); err != nil {
+			return nil, err
+		}
+	}
+	qd, err := p.Query()
+	if err != nil {
+		return nil, err
+	}
+	return NewExplainData(qd, analyze), nil
 }
 
 func (p *Parser) UpdateCommand() (interface{}, error) {
@@ -306,11 +763,11 @@ func (p *Parser) Create() (interface{}, error) {
 		return p.createTable()
 	} else if p.lex.MatchKeyword("view") {
 		return p.createView()
-	} else if p.lex.MatchKeyword("index") {
+	} else if p.lex.MatchKeyword("index") || p.lex.MatchKeyword("unique") {
 		return p.createIndex()
 	}
 
-	return nil, errors.New("invalid create keyword")
+	return nil, p.lex.Unexpected("table", "view", "index", "unique")
 }
 
 func (p *Parser) createTable() (*CreateTableData, error) {
@@ -358,6 +815,12 @@ func (p *Parser) createView() (*CreateViewData, error) {
 }
 
 func (p *Parser) createIndex() (*CreateIndexData, error) {
+	unique := p.lex.MatchKeyword("unique")
+	if unique {
+		if err := p.lex.EatKeyword("unique"); err != nil {
+			return nil, err
+		}
+	}
 	err := p.lex.EatKeyword("index")
 	if err != nil {
 		return nil, err
@@ -374,11 +837,21 @@ func (p *Parser) createIndex() (*CreateIndexData, error) {
 	if err != nil {
 		return nil, err
 	}
+	method := ""
+	if p.lex.MatchKeyword("using") {
+		if err := p.lex.EatKeyword("using"); err != nil {
+			return nil, err
+		}
+		method, err = p.lex.EatIdentifier()
+		if err != nil {
+			return nil, err
+		}
+	}
 	err = p.lex.EatDelimiter('(')
 	if err != nil {
 		return nil, err
 	}
-	fieldName, err := p.Field()
+	exprs, err := p.ExpressionList()
 	if err != nil {
 		return nil, err
 	}
@@ -386,7 +859,23 @@ func (p *Parser) createIndex() (*CreateIndexData, error) {
 	if err != nil {
 		return nil, err
 	}
-	return NewCreateIndexData(indexName, tableName, fieldName), nil
+	var pred *query.Predicate
+	if p.lex.MatchKeyword("where") {
+		if err := p.lex.EatKeyword("where"); err != nil {
+			return nil, err
+		}
+		pred, err = p.Predicate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	data := NewCreateIndexDataFromExpressions(indexName, tableName, exprs)
+	if unique {
+		data = NewCreateUniqueIndexData(indexName, tableName, exprs)
+	}
+	data.method = method
+	data.pred = pred
+	return data, nil
 }
 
 func (p *Parser) fieldDefinitions() (*record.Schema, error) {
@@ -414,7 +903,40 @@ func (p *Parser) fieldDefinition() (*record.Schema, error) {
 	if err != nil {
 		return nil, err
 	}
-	return p.fieldType(fn)
+	schema, err := p.fieldType(fn)
+	if err != nil {
+		return nil, err
+	}
+	if p.lex.MatchKeyword("default") {
+		expr, err := p.defaultValue()
+		if err != nil {
+			return nil, err
+		}
+		if err := schema.SetDefaultValue(fn, expr.String()); err != nil {
+			return nil, err
+		}
+	}
+	return schema, nil
+}
+
+// defaultValue は DEFAULT 句の式をパースする
+// デフォルト値はレコードがない状態で評価するので、フィールドを参照できない
+func (p *Parser) defaultValue() (query.Expression, error) {
+	err := p.lex.EatKeyword("default")
+	if err != nil {
+		return query.Expression{}, err
+	}
+	expr, err := p.Expression()
+	if err != nil {
+		return query.Expression{}, err
+	}
+	if len(expr.FieldNames()) > 0 {
+		return query.Expression{}, sqlstate.Errorf(sqlstate.InvalidObjectDefinition, "default value %s cannot refer to fields", expr.String())
+	}
+	if expr.HasParameters() {
+		return query.Expression{}, sqlstate.Errorf(sqlstate.FeatureNotSupported, "default value %s cannot contain placeholders", expr.String())
+	}
+	return expr, nil
 }
 
 func (p *Parser) fieldType(fieldName string) (*record.Schema, error) {
@@ -443,31 +965,35 @@ func (p *Parser) fieldType(fieldName string) (*record.Schema, error) {
 			return nil, err
 		}
 		schema.AddStringField(fieldName, length)
-	} else {
-		return nil, errors.New("invalid field type")
-	}
-
-	return schema, nil
-}
-
-func (p *Parser) selectList() ([]string, error) {
-	f, err := p.Field()
-	if err != nil {
-		return nil, err
-	}
-	list := []string{f}
-	if p.lex.MatchDelimiter(',') {
-		err := p.lex.EatDelimiter(',')
+	} else if p.lex.MatchKeyword("json") {
+		err := p.lex.EatKeyword("json")
 		if err != nil {
 			return nil, err
 		}
-		remainList, err := p.selectList()
+		schema.AddJSONField(fieldName)
+	} else if p.lex.MatchKeyword("text") {
+		err := p.lex.EatKeyword("text")
 		if err != nil {
 			return nil, err
 		}
-		list = append(list, remainList...)
+		schema.AddTextField(fieldName)
+	} else if p.lex.MatchKeyword("blob") {
+		err := p.lex.EatKeyword("blob")
+		if err != nil {
+			return nil, err
+		}
+		schema.AddBlobField(fieldName)
+	} else if p.lex.MatchKeyword("uuid") {
+		err := p.lex.EatKeyword("uuid")
+		if err != nil {
+			return nil, err
+		}
+		schema.AddUUIDField(fieldName)
+	} else {
+		return nil, p.lex.Unexpected("int", "varchar", "json", "text", "blob", "uuid")
 	}
-	return list, nil
+
+	return schema, nil
 }
 
 func (p *Parser) tableList() ([]string, error) {
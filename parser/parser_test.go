@@ -3,8 +3,10 @@ package parser
 import (
 	"testing"
 
+	"github.com/ksrnnb/go-rdb/lexer"
 	"github.com/ksrnnb/go-rdb/query"
 	"github.com/ksrnnb/go-rdb/record"
+	"github.com/ksrnnb/go-rdb/sqlstate"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -82,6 +84,96 @@ func TestParser_Query(t *testing.T) {
 	}
 }
 
+func TestParser_Query_Comparison(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"less than", "select a from users where id < 3", "select a from users where id<3"},
+		{"not equal", "select a from users where id != 3", "select a from users where id<>3"},
+		{"between", "select a from users where id between 1 and 10 and name='hoge'", "select a from users where id>=1 and id<=10 and name='hoge'"},
+		{"like", "select a from users where name like 'ho%'", "select a from users where name like 'ho%'"},
+		{"match", "select a from pictures where MATCH(title, 'blue sky') and id > 1", "select a from pictures where match(title, 'blue sky') and id>1"},
+		{"in", "select a from users where status IN ('active', 'new') and id in (1, 2, 3)", "select a from users where status in ('active', 'new') and id in (1, 2, 3)"},
+		{"order by", "select a, b from users where id>1 order by b desc, a asc", "select a, b from users where id>1 order by b desc, a"},
+		{"group by", "select a, COUNT(*), max(b) from users where id>1 group by a order by a", "select a, count(*), max(b) from users where id>1 group by a order by a"},
+		{"aggregate", "select count(b) from users", "select count(b) from users"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewParser(tt.query)
+			require.NoError(t, err)
+			qd, err := p.Query()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, qd.String())
+
+			// 文字列にした QueryData はパースし直しても同じになる
+			p, err = NewParser(qd.String())
+			require.NoError(t, err)
+			reparsed, err := p.Query()
+			require.NoError(t, err)
+			assert.Equal(t, qd.String(), reparsed.String())
+		})
+	}
+
+	p, err := NewParser("select a from users order by b desc")
+	require.NoError(t, err)
+	qd, err := p.Query()
+	require.NoError(t, err)
+	require.Len(t, qd.OrderBy(), 1)
+	assert.Equal(t, "b", qd.OrderBy()[0].FieldName())
+	assert.True(t, qd.OrderBy()[0].IsDescending())
+}
+
+func TestParser_Query_GroupBy(t *testing.T) {
+	p, err := NewParser("select a, count(*), max(b) from users group by a")
+	require.NoError(t, err)
+	qd, err := p.Query()
+	require.NoError(t, err)
+	assert.True(t, qd.IsGrouped())
+	assert.Equal(t, []string{"a"}, qd.GroupBy())
+	assert.Equal(t, []string{"a", "count", "max_of_b"}, qd.Fields())
+	require.Len(t, qd.Aggregates(), 2)
+	assert.Equal(t, "count", qd.Aggregates()[0].Function())
+	assert.Empty(t, qd.Aggregates()[0].FieldName())
+	assert.Equal(t, "max", qd.Aggregates()[1].Function())
+	assert.Equal(t, "b", qd.Aggregates()[1].FieldName())
+
+	// 集計しないフィールドは GROUP BY に書く
+	p, err = NewParser("select a, b, count(*) from users group by a")
+	require.NoError(t, err)
+	_, err = p.Query()
+	assert.Equal(t, sqlstate.GroupingError, sqlstate.CodeOf(err))
+
+	// MAX は * を集計できない
+	p, err = NewParser("select max(*) from users")
+	require.NoError(t, err)
+	_, err = p.Query()
+	assert.Equal(t, sqlstate.SyntaxError, sqlstate.CodeOf(err))
+}
+
+func TestParser_Query_KeywordIdentifiers(t *testing.T) {
+	// 予約語ではないキーワードは、識別子を書ける位置ではテーブル名や列名として使える
+	p, err := NewParser("select text, Desc from order where in = 1 and like like 'a%' and match = 2 and by in (1, 2) and match(text, 'go') group by text, Desc order by Desc desc")
+	require.NoError(t, err)
+	qd, err := p.Query()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"order"}, qd.Tables())
+	assert.Equal(t, []string{"text", "Desc"}, qd.Fields())
+	assert.Equal(t, "in=1 and like like 'a%' and match=2 and by in (1, 2) and match(text, 'go')", qd.Predicate().String())
+	assert.Equal(t, []string{"text", "Desc"}, qd.GroupBy())
+	require.Len(t, qd.OrderBy(), 1)
+	assert.Equal(t, "Desc", qd.OrderBy()[0].FieldName())
+	assert.True(t, qd.OrderBy()[0].IsDescending())
+
+	// 予約語は識別子に使えない
+	p, err = NewParser("select select from users")
+	require.NoError(t, err)
+	_, err = p.Query()
+	assert.Equal(t, sqlstate.SyntaxError, sqlstate.CodeOf(err))
+}
+
 func TestParser_Insert(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -99,6 +191,28 @@ func TestParser_Insert(t *testing.T) {
 				)
 			},
 		},
+		{
+			name:  "insert query with blob literal",
+			query: "insert into files (id, data) values (1, x'00ff1a')",
+			wantFunc: func() *InsertData {
+				return NewInsertData(
+					"files",
+					[]string{"id", "data"},
+					[]query.Constant{query.NewConstant(1), query.NewConstant([]byte{0x00, 0xff, 0x1a})},
+				)
+			},
+		},
+		{
+			name:  "insert query with placeholders",
+			query: "insert into users (id, name) values (?, ?)",
+			wantFunc: func() *InsertData {
+				return NewInsertData(
+					"users",
+					[]string{"id", "name"},
+					[]query.Constant{query.NewParameterConstant(1), query.NewParameterConstant(2)},
+				)
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -219,6 +333,49 @@ func TestParser_createTable(t *testing.T) {
 				)
 			},
 		},
+		{
+			name:  "create table query with text and blob",
+			query: "create table files (id int, body text, data blob)",
+			wantFunc: func(t *testing.T) *CreateTableData {
+				schema := record.NewSchema()
+				schema.AddIntField("id")
+				schema.AddTextField("body")
+				schema.AddBlobField("data")
+				return NewCreateTableData(
+					"files",
+					schema,
+				)
+			},
+		},
+		{
+			name:  "create table query with non-reserved keywords as names",
+			query: "create table order (text text, desc varchar(8), by int, Match json)",
+			wantFunc: func(t *testing.T) *CreateTableData {
+				schema := record.NewSchema()
+				schema.AddTextField("text")
+				schema.AddStringField("desc", 8)
+				schema.AddIntField("by")
+				schema.AddJSONField("Match")
+				return NewCreateTableData(
+					"order",
+					schema,
+				)
+			},
+		},
+		{
+			name:  "create table query with uuid default value",
+			query: "create table sessions (id uuid default gen_random_uuid(), user_id int)",
+			wantFunc: func(t *testing.T) *CreateTableData {
+				schema := record.NewSchema()
+				schema.AddUUIDField("id")
+				require.NoError(t, schema.SetDefaultValue("id", "gen_random_uuid()"))
+				schema.AddIntField("user_id")
+				return NewCreateTableData(
+					"sessions",
+					schema,
+				)
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -235,11 +392,23 @@ func TestParser_createTable(t *testing.T) {
 			for i, f := range ctd.Schema().Fields() {
 				assert.Equal(t, f, wantCTD.Schema().Fields()[i])
 
+				ft, err := ctd.Schema().FieldType(f)
+				require.NoError(t, err)
+				wantft, err := wantCTD.Schema().FieldType(f)
+				require.NoError(t, err)
+				assert.Equal(t, ft, wantft)
+
 				l, err := ctd.Schema().Length(f)
 				require.NoError(t, err)
 				wantl, err := wantCTD.Schema().Length(f)
 				require.NoError(t, err)
 				assert.Equal(t, l, wantl)
+
+				dv, err := ctd.Schema().DefaultValue(f)
+				require.NoError(t, err)
+				wantdv, err := wantCTD.Schema().DefaultValue(f)
+				require.NoError(t, err)
+				assert.Equal(t, dv, wantdv)
 			}
 
 		})
@@ -263,6 +432,52 @@ func TestParser_createIndex(t *testing.T) {
 				)
 			},
 		},
+		{
+			name:  "create composite index query",
+			query: "create index picture_user_id_created_at_idx on pictures (user_id, created_at)",
+			wantFunc: func(t *testing.T) *CreateIndexData {
+				return NewCreateIndexDataFromExpressions(
+					"picture_user_id_created_at_idx",
+					"pictures",
+					[]query.Expression{
+						query.NewExpressionFromFieldName("user_id"),
+						query.NewExpressionFromFieldName("created_at"),
+					},
+				)
+			},
+		},
+		{
+			name:  "create unique index query",
+			query: "create unique index user_email_idx on users (email)",
+			wantFunc: func(t *testing.T) *CreateIndexData {
+				return NewCreateUniqueIndexData(
+					"user_email_idx",
+					"users",
+					[]query.Expression{query.NewExpressionFromFieldName("email")},
+				)
+			},
+		},
+		{
+			name:  "create hash index query",
+			query: "create index user_email_idx on users using hash (email)",
+			wantFunc: func(t *testing.T) *CreateIndexData {
+				cid := NewCreateIndexData("user_email_idx", "users", "email")
+				cid.method = "hash"
+				return cid
+			},
+		},
+		{
+			name:  "create partial expression index query",
+			query: "create index user_email_idx on users (lower(email)) where active = 1 and age >= 20",
+			wantFunc: func(t *testing.T) *CreateIndexData {
+				expr, err := query.NewExpressionFromFunction("lower", []query.Expression{query.NewExpressionFromFieldName("email")})
+				require.NoError(t, err)
+				cid := NewCreateIndexDataFromExpression("user_email_idx", "users", expr)
+				cid.pred = newPred(t, "active", 1)
+				cid.pred.ConJoinWith(query.NewPredicateFromTerm(query.NewTermWithOperator(query.NewExpressionFromFieldName("age"), query.GreaterOrEqual, query.NewExpressionFromConstant(query.NewConstant(20)))))
+				return cid
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -278,6 +493,10 @@ func TestParser_createIndex(t *testing.T) {
 			assert.Equal(t, cid.IndexName(), wandCID.IndexName())
 			assert.Equal(t, cid.TableName(), wandCID.TableName())
 			assert.Equal(t, cid.FieldName(), wandCID.FieldName())
+			assert.Equal(t, len(cid.Expressions()), len(wandCID.Expressions()))
+			assert.Equal(t, cid.IsUnique(), wandCID.IsUnique())
+			assert.Equal(t, cid.Method(), wandCID.Method())
+			assert.Equal(t, cid.PredicateString(), wandCID.PredicateString())
 		})
 	}
 }
@@ -319,3 +538,115 @@ func TestParser_createView(t *testing.T) {
 		})
 	}
 }
+
+func TestParser_Bind(t *testing.T) {
+	p, err := NewParser("select a, b from users where id=$1 and name=$2")
+	require.NoError(t, err)
+	assert.Equal(t, 2, p.NumParameters())
+	qd, err := p.Query()
+	require.NoError(t, err)
+	assert.Equal(t, "id=$1 and name=$2", qd.Predicate().String())
+
+	bound, err := qd.Bind([]query.Constant{query.NewConstant(3), query.NewConstant("hoge")})
+	require.NoError(t, err)
+	assert.Equal(t, "id=3 and name='hoge'", bound.Predicate().String())
+	// 元のパース結果は変更されない
+	assert.Equal(t, "id=$1 and name=$2", qd.Predicate().String())
+
+	_, err = qd.Bind([]query.Constant{query.NewConstant(3)})
+	assert.Error(t, err)
+
+	p, err = NewParser("create table users (id int default ?)")
+	require.NoError(t, err)
+	_, err = p.UpdateCommand()
+	assert.Error(t, err)
+}
+
+func TestParser_Explain(t *testing.T) {
+	tests := []struct {
+		query       string
+		wantAnalyze bool
+	}{
+		{"explain select a from users", false},
+		{"explain analyze select a from users", true},
+		{"EXPLAIN ANALYZE select a from users;", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			p, err := NewParser(tt.query)
+			require.NoError(t, err)
+			stmt, err := p.Statement()
+			require.NoError(t, err)
+			ed, ok := stmt.(*ExplainData)
+			require.True(t, ok)
+			assert.Equal(t, tt.wantAnalyze, ed.Analyze())
+			assert.Equal(t, []string{"a"}, ed.Query().Fields())
+		})
+	}
+}
+
+func TestParser_Statement_Error(t *testing.T) {
+	tests := []struct {
+		name         string
+		query        string
+		wantToken    string
+		wantExpected []string
+	}{
+		{
+			name:         "unknown statement",
+			query:        "selct a from users",
+			wantToken:    "selct",
+			wantExpected: []string{"select", "explain", "insert", "delete", "update", "create"},
+		},
+		{
+			name:         "explain analyze without query",
+			query:        "explain analyze insert into users (id) values (1)",
+			wantToken:    "insert",
+			wantExpected: []string{"select"},
+		},
+		{
+			name:         "invalid create keyword",
+			query:        "create tabel users (id int)",
+			wantToken:    "tabel",
+			wantExpected: []string{"table", "view", "index", "unique"},
+		},
+		{
+			name:         "invalid field type",
+			query:        "create table users (id integer)",
+			wantToken:    "integer",
+			wantExpected: []string{"int", "varchar", "json", "text", "blob", "uuid"},
+		},
+		{
+			name:         "invalid expression",
+			query:        "select a from users where id=,",
+			wantToken:    ",",
+			wantExpected: []string{"identifier", "string", "integer", "blob", "parameter"},
+		},
+		{
+			name:         "trailing tokens",
+			query:        "select a from users; select",
+			wantToken:    "select",
+			wantExpected: []string{";", "end of input"},
+		},
+		{
+			name:         "unexpected end of input",
+			query:        "insert into users (id) values (",
+			wantToken:    "",
+			wantExpected: []string{"string", "integer", "blob", "parameter"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewParser(tt.query)
+			require.NoError(t, err)
+			_, err = p.Statement()
+			var pe *lexer.ParseError
+			require.ErrorAs(t, err, &pe)
+			assert.Equal(t, tt.wantToken, pe.Token)
+			assert.Equal(t, tt.wantExpected, pe.Expected)
+			assert.Equal(t, sqlstate.SyntaxError, sqlstate.CodeOf(err))
+		})
+	}
+}
@@ -4,22 +4,109 @@ import (
 	"fmt"
 
 	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/sqlstate"
 )
 
 type QueryData struct {
-	fields []string
-	tables []string
-	pred   *query.Predicate
+	fields  []string
+	exprs   []query.Expression
+	tables  []string
+	pred    *query.Predicate
+	orderBy []OrderField
+	groupBy []string
+	// aggregates は select 句の集計関数で、 aggregatePos は fields の中の位置
+	aggregates   []AggregateField
+	aggregatePos []int
+}
+
+// OrderField は ORDER BY に書いたフィールドと並べる向き
+type OrderField struct {
+	fieldName  string
+	descending bool
+}
+
+func NewOrderField(fieldName string, descending bool) OrderField {
+	return OrderField{fieldName, descending}
+}
+
+func (of OrderField) FieldName() string {
+	return of.fieldName
+}
+
+func (of OrderField) IsDescending() bool {
+	return of.descending
+}
+
+func (of OrderField) String() string {
+	if of.descending {
+		return fmt.Sprintf("%s desc", of.fieldName)
+	}
+	return of.fieldName
+}
+
+// AggregateField は select 句に書いた集計関数 COUNT(*), COUNT(F), MAX(F)
+// COUNT(*) の場合は fieldName が空
+type AggregateField struct {
+	function  string
+	fieldName string
+}
+
+func NewAggregateField(function string, fieldName string) AggregateField {
+	return AggregateField{function, fieldName}
+}
+
+func (af AggregateField) Function() string {
+	return af.function
+}
+
+// FieldName は集計するフィールド名を返す
+func (af AggregateField) FieldName() string {
+	return af.fieldName
+}
+
+// ResultFieldName は集計した値のフィールド名を返す
+// COUNT(*) は count、それ以外は max_of_F のように関数名とフィールド名をつなげる
+func (af AggregateField) ResultFieldName() string {
+	if af.fieldName == "" {
+		return af.function
+	}
+	return fmt.Sprintf("%s_of_%s", af.function, af.fieldName)
+}
+
+func (af AggregateField) String() string {
+	if af.fieldName == "" {
+		return fmt.Sprintf("%s(*)", af.function)
+	}
+	return fmt.Sprintf("%s(%s)", af.function, af.fieldName)
 }
 
 func NewQueryData(fields []string, tables []string, pred *query.Predicate) *QueryData {
-	return &QueryData{fields, tables, pred}
+	exprs := make([]query.Expression, len(fields))
+	for i, fn := range fields {
+		exprs[i] = query.NewExpressionFromFieldName(fn)
+	}
+	return &QueryData{fields: fields, exprs: exprs, tables: tables, pred: pred}
+}
+
+// NewQueryDataFromExpressions は select 句に式を含む QueryData を生成する
+// 式のフィールド名は式の文字列になる
+func NewQueryDataFromExpressions(exprs []query.Expression, tables []string, pred *query.Predicate) *QueryData {
+	fields := make([]string, len(exprs))
+	for i, e := range exprs {
+		fields[i] = e.String()
+	}
+	return &QueryData{fields: fields, exprs: exprs, tables: tables, pred: pred}
 }
 
 func (qd *QueryData) Fields() []string {
 	return qd.fields
 }
 
+// Expressions は select 句の式を返す
+func (qd *QueryData) Expressions() []query.Expression {
+	return qd.exprs
+}
+
 func (qd *QueryData) Tables() []string {
 	return qd.tables
 }
@@ -28,9 +115,99 @@ func (qd *QueryData) Predicate() *query.Predicate {
 	return qd.pred
 }
 
+// setSelectList は select 句の式と集計関数から、 select 句に書いた順のフィールド名を設定する
+// 集計関数は aggregatePos の位置に、式はそれ以外の位置に順に並ぶ
+func (qd *QueryData) setSelectList(exprs []query.Expression, aggregates []AggregateField, aggregatePos []int) {
+	fields := make([]string, 0, len(exprs)+len(aggregates))
+	ei, ai := 0, 0
+	for len(fields) < len(exprs)+len(aggregates) {
+		if ai < len(aggregatePos) && aggregatePos[ai] == len(fields) {
+			fields = append(fields, aggregates[ai].ResultFieldName())
+			ai++
+			continue
+		}
+		fields = append(fields, exprs[ei].String())
+		ei++
+	}
+	qd.fields = fields
+	qd.exprs = exprs
+	qd.aggregates = aggregates
+	qd.aggregatePos = aggregatePos
+}
+
+// GroupBy は GROUP BY に書いたフィールドを返す
+func (qd *QueryData) GroupBy() []string {
+	return qd.groupBy
+}
+
+// Aggregates は select 句の集計関数を返す
+func (qd *QueryData) Aggregates() []AggregateField {
+	return qd.aggregates
+}
+
+// IsGrouped は GROUP BY か集計関数があるかどうかを返す
+// GROUP BY がなく集計関数だけがある場合は、全てのレコードを1つのグループとして集計する
+func (qd *QueryData) IsGrouped() bool {
+	return len(qd.groupBy) > 0 || len(qd.aggregates) > 0
+}
+
+// checkGrouping は集計する場合に、 select 句の集計関数以外の式が GROUP BY のフィールドかどうかを確認する
+func (qd *QueryData) checkGrouping() error {
+	if !qd.IsGrouped() {
+		return nil
+	}
+	for _, e := range qd.exprs {
+		if !e.IsFieldName() || !contains(qd.groupBy, e.AsFieldName()) {
+			return sqlstate.Errorf(sqlstate.GroupingError, "%s must appear in the GROUP BY clause or be used in an aggregate function", e.String())
+		}
+	}
+	return nil
+}
+
+func contains(fields []string, fieldName string) bool {
+	for _, fn := range fields {
+		if fn == fieldName {
+			return true
+		}
+	}
+	return false
+}
+
+// OrderBy は ORDER BY に書いたフィールドを返す
+// ORDER BY がない場合は空
+func (qd *QueryData) OrderBy() []OrderField {
+	return qd.orderBy
+}
+
+// Bind はプレースホルダを params の値に置き換えた QueryData を返す
+func (qd *QueryData) Bind(params []query.Constant) (*QueryData, error) {
+	exprs := make([]query.Expression, len(qd.exprs))
+	for i, e := range qd.exprs {
+		be, err := e.Bind(params)
+		if err != nil {
+			return nil, err
+		}
+		exprs[i] = be
+	}
+	pred, err := qd.pred.Bind(params)
+	if err != nil {
+		return nil, err
+	}
+	bound := &QueryData{tables: qd.tables, pred: pred}
+	bound.setSelectList(exprs, qd.aggregates, qd.aggregatePos)
+	bound.orderBy = qd.orderBy
+	bound.groupBy = qd.groupBy
+	return bound, nil
+}
+
 func (qd *QueryData) String() string {
 	var s string
 	for i, fn := range qd.fields {
+		for j, pos := range qd.aggregatePos {
+			if pos == i {
+				fn = qd.aggregates[j].String()
+			}
+		}
 		if i == 0 {
 			s = fmt.Sprintf("select %s", fn)
 		} else {
@@ -46,9 +223,24 @@ func (qd *QueryData) String() string {
 		}
 	}
 
-	ps := qd.pred.String()
-	if ps == "" {
-		return s
+	if ps := qd.pred.String(); ps != "" {
+		s = fmt.Sprintf("%s where %s", s, ps)
+	}
+
+	for i, fn := range qd.groupBy {
+		if i == 0 {
+			s = fmt.Sprintf("%s group by %s", s, fn)
+		} else {
+			s = fmt.Sprintf("%s, %s", s, fn)
+		}
+	}
+
+	for i, of := range qd.orderBy {
+		if i == 0 {
+			s = fmt.Sprintf("%s order by %s", s, of)
+		} else {
+			s = fmt.Sprintf("%s, %s", s, of)
+		}
 	}
-	return fmt.Sprintf("%s where %s", s, ps)
+	return s
 }
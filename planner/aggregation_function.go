@@ -1,10 +1,28 @@
 package planner
 
-import "github.com/ksrnnb/go-rdb/query"
+import (
+	"github.com/ksrnnb/go-rdb/parser"
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/sqlstate"
+)
 
 type AggregationFunction interface {
 	ProcessFirst(s query.Scanner) error
 	ProcessNext(s query.Scanner) error
 	FieldName() string
 	Value() query.Constant
+	// Clone は同じフィールドを集計する、まだ何も処理していない関数を返す
+	// HashGroupScan がグループごとに集計するために使う
+	Clone() AggregationFunction
+}
+
+// newAggregationFunction は select 句の集計関数を集計する AggregationFunction を返す
+func newAggregationFunction(af parser.AggregateField) (AggregationFunction, error) {
+	switch af.Function() {
+	case "count":
+		return NewCountFunction(af.FieldName()), nil
+	case "max":
+		return NewMaxFunction(af.FieldName()), nil
+	}
+	return nil, sqlstate.Errorf(sqlstate.UndefinedFunction, "aggregate function %s is not supported", af.Function())
 }
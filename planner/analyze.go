@@ -0,0 +1,222 @@
+package planner
+
+import (
+	"io"
+	"time"
+
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/tx"
+)
+
+// ActualStats は EXPLAIN ANALYZE でクエリを実行して計測した1つの plan の実績値
+// Loops は先頭から走査した回数、 Rows は全ての走査で出力したレコード数の合計
+// 時間と I/O の回数は子の plan の分も含む
+type ActualStats struct {
+	Loops         int           `json:"loops"`
+	Rows          int           `json:"rows"`
+	Time          time.Duration `json:"time_ns"`
+	BufferPins    int           `json:"buffer_pins"`
+	BlocksRead    int           `json:"blocks_read"`
+	BlocksWritten int           `json:"blocks_written"`
+}
+
+func (as *ActualStats) addIO(ios tx.IOStats) {
+	as.BufferPins += ios.Pins
+	as.BlocksRead += ios.BlocksRead
+	as.BlocksWritten += ios.BlocksWritten
+}
+
+// analyze は p を計測用の plan で包んで最後まで実行し、実績値つきの plan の木を返す
+// 出力するレコードは全てのフィールドを読み込んでから捨てる
+func analyze(p Planner, tx *tx.Transaction) (*PlanNode, error) {
+	root := instrument(p, tx)
+	s, err := root.Open()
+	if err != nil {
+		return nil, err
+	}
+	fields := root.Schema().Fields()
+	for {
+		ok, err := s.Next()
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		for _, fn := range fields {
+			if _, err := s.GetVal(fn); err != nil {
+				s.Close()
+				return nil, err
+			}
+		}
+	}
+	if err := s.Close(); err != nil {
+		return nil, err
+	}
+	return root.Explain(), nil
+}
+
+// instrument は p とその子孫の plan を、実績値を計測する plan で包む
+func instrument(p Planner, tx *tx.Transaction) Planner {
+	wrap := func(c Planner) Planner {
+		return instrument(c, tx)
+	}
+	switch v := p.(type) {
+	case *SelectPlan:
+		v.p = wrap(v.p)
+	case *ProjectPlan:
+		v.p = wrap(v.p)
+	case *ExtendPlan:
+		v.p = wrap(v.p)
+	case *ProductPlan:
+		v.p1, v.p2 = wrap(v.p1), wrap(v.p2)
+	case *MultiBufferProductPlan:
+		v.lhs, v.rhs = wrap(v.lhs), wrap(v.rhs)
+	case *SortPlan:
+		v.p = wrap(v.p)
+	case *GroupPlan:
+		v.p = wrap(v.p)
+	case *HashGroupPlan:
+		v.p = wrap(v.p)
+	case *MaterializePlan:
+		v.srcPlan = wrap(v.srcPlan)
+	case *IndexSelectPlan, *FullTextSearchPlan, *BitmapSelectPlan:
+		// 子の TableScan を直接使うので、子は包まない
+	case *IndexJoinPlan:
+		// 右側の TableScan を直接使うので、左側だけ包む
+		v.p1 = wrap(v.p1)
+	case *HashJoinPlan:
+		v.p1, v.p2 = wrap(v.p1), wrap(v.p2)
+	case *MergeJoinPlan:
+		// SortScan を直接使うので、ソートの入力を包む
+		instrumentChildren(v.p1, wrap)
+		instrumentChildren(v.p2, wrap)
+	}
+	return &analyzedPlan{Planner: p, tx: tx, stats: &ActualStats{}}
+}
+
+// instrumentChildren は p 自身は包まずに、 p の子を包む
+func instrumentChildren(p Planner, wrap func(Planner) Planner) {
+	if sp, ok := p.(*SortPlan); ok {
+		sp.p = wrap(sp.p)
+	}
+}
+
+// analyzedPlan は Open した scan を計測用の scan で包む plan
+type analyzedPlan struct {
+	Planner
+	tx    *tx.Transaction
+	stats *ActualStats
+}
+
+func (ap *analyzedPlan) Open() (query.Scanner, error) {
+	as := &analyzedScan{tx: ap.tx, stats: ap.stats, rewound: true}
+	var s query.Scanner
+	err := as.measure(func() error {
+		var err error
+		s, err = ap.Planner.Open()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	as.scan = s
+	return as, nil
+}
+
+func (ap *analyzedPlan) Explain() *PlanNode {
+	node := ap.Planner.Explain()
+	node.Actual = ap.stats
+	return node
+}
+
+// analyzedScan は scan の出力したレコード数と、かかった時間と I/O を計測する
+type analyzedScan struct {
+	scan  query.Scanner
+	tx    *tx.Transaction
+	stats *ActualStats
+	// 先頭に戻ってからまだ Next していないかどうか
+	rewound bool
+}
+
+func (as *analyzedScan) measure(fn func() error) error {
+	before := as.tx.IOStats()
+	start := time.Now()
+	err := fn()
+	as.stats.Time += time.Since(start)
+	as.stats.addIO(as.tx.IOStats().Sub(before))
+	return err
+}
+
+func (as *analyzedScan) BeforeFirst() error {
+	as.rewound = true
+	return as.measure(as.scan.BeforeFirst)
+}
+
+// Next は先頭に戻ってから最初に呼ばれたときに loops を数える
+// 先頭に戻しただけで走査しなかった場合は数えない
+func (as *analyzedScan) Next() (bool, error) {
+	if as.rewound {
+		as.stats.Loops++
+		as.rewound = false
+	}
+	var ok bool
+	err := as.measure(func() error {
+		var err error
+		ok, err = as.scan.Next()
+		return err
+	})
+	if ok {
+		as.stats.Rows++
+	}
+	return ok, err
+}
+
+func (as *analyzedScan) GetInt(fieldName string) (int, error) {
+	var val int
+	err := as.measure(func() error {
+		var err error
+		val, err = as.scan.GetInt(fieldName)
+		return err
+	})
+	return val, err
+}
+
+func (as *analyzedScan) GetString(fieldName string) (string, error) {
+	var val string
+	err := as.measure(func() error {
+		var err error
+		val, err = as.scan.GetString(fieldName)
+		return err
+	})
+	return val, err
+}
+
+func (as *analyzedScan) GetVal(fieldName string) (query.Constant, error) {
+	var val query.Constant
+	err := as.measure(func() error {
+		var err error
+		val, err = as.scan.GetVal(fieldName)
+		return err
+	})
+	return val, err
+}
+
+func (as *analyzedScan) GetReader(fieldName string) (io.Reader, error) {
+	var r io.Reader
+	err := as.measure(func() error {
+		var err error
+		r, err = query.GetReader(as.scan, fieldName)
+		return err
+	})
+	return r, err
+}
+
+func (as *analyzedScan) HasField(fieldName string) bool {
+	return as.scan.HasField(fieldName)
+}
+
+func (as *analyzedScan) Close() error {
+	return as.measure(as.scan.Close)
+}
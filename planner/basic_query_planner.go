@@ -7,11 +7,12 @@ import (
 )
 
 type BasicQueryPlanner struct {
-	mdm *metadata.MetadataManager
+	mdm       *metadata.MetadataManager
+	generator *NextTableNameGenerator
 }
 
 func NewBasicQueryPlanner(mdm *metadata.MetadataManager) *BasicQueryPlanner {
-	return &BasicQueryPlanner{mdm}
+	return &BasicQueryPlanner{mdm, NewNextTableNameGenerator()}
 }
 
 func (bqp *BasicQueryPlanner) CreatePlan(qd *parser.QueryData, tx *tx.Transaction) (Planner, error) {
@@ -58,6 +59,18 @@ func (bqp *BasicQueryPlanner) CreatePlan(qd *parser.QueryData, tx *tx.Transactio
 	// Step3: Add a selection plan for the predicate
 	p = NewSelectPlan(p, qd.Predicate())
 
-	// Step4: Project on the field names
+	// Step4: Group, extend the expressions, sort by the order by fields and project on the field names
+	p, err = groupPlan(tx, p, qd, bqp.generator)
+	if err != nil {
+		return nil, err
+	}
+	p, err = extendPlan(p, qd.Expressions())
+	if err != nil {
+		return nil, err
+	}
+	p, err = orderPlan(tx, p, qd.OrderBy(), bqp.generator)
+	if err != nil {
+		return nil, err
+	}
 	return NewProjectPlan(p, qd.Fields())
 }
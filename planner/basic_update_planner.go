@@ -119,9 +119,12 @@ func (bup *BasicUpdatePlanner) ExecuteInsert(id *parser.InsertData, tx *tx.Trans
 		val := vals[i]
 		err = us.SetVal(fn, val)
 		if err != nil {
-			return 0, err
+			return 0, abortInsert(us, err)
 		}
 	}
+	if err := setDefaultValues(us, tp.Schema(), id); err != nil {
+		return 0, abortInsert(us, err)
+	}
 	err = us.Close()
 	if err != nil {
 		return 0, err
@@ -138,5 +141,5 @@ func (bup *BasicUpdatePlanner) ExecuteCreateView(cvd *parser.CreateViewData, tx
 }
 
 func (bup *BasicUpdatePlanner) ExecuteCreateIndex(cid *parser.CreateIndexData, tx *tx.Transaction) (int, error) {
-	return 0, bup.mdm.CreateIndex(cid.IndexName(), cid.TableName(), cid.FieldName(), tx)
+	return 0, bup.mdm.CreateIndex(cid.IndexName(), cid.TableName(), cid.FieldName(), cid.Method(), cid.PredicateString(), cid.IsUnique(), tx)
 }
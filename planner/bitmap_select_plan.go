@@ -0,0 +1,151 @@
+package planner
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ksrnnb/go-rdb/index"
+	"github.com/ksrnnb/go-rdb/metadata"
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/record"
+)
+
+// BitmapCondition はビットマップインデックスのキーが vals のどれかと等しいという条件
+type BitmapCondition struct {
+	ii   *metadata.IndexInfo
+	vals []query.Constant
+}
+
+func NewBitmapCondition(ii *metadata.IndexInfo, vals []query.Constant) BitmapCondition {
+	return BitmapCondition{ii, vals}
+}
+
+// String は条件を "key=c" か "key in (c1, c2, ...)" の形で返す
+func (bc BitmapCondition) String() string {
+	lhs := bc.ii.Expressions()[0]
+	if len(bc.vals) == 1 {
+		return query.NewTerm(lhs, query.NewExpressionFromConstant(bc.vals[0])).String()
+	}
+	rhs := query.NewExpressionFromConstant(query.NewTupleConstant(bc.vals))
+	return query.NewTermWithOperator(lhs, query.In, rhs).String()
+}
+
+// bitmap は条件の値ごとのビットマップを OR で組み合わせる
+func (bc BitmapCondition) bitmap() (*index.Bitmap, index.BitmapSearcher, error) {
+	idx, err := bc.ii.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	bs, ok := idx.(index.BitmapSearcher)
+	if !ok {
+		return nil, nil, fmt.Errorf("index %s does not support bitmap scans", bc.ii.IndexName())
+	}
+	result := index.NewBitmap()
+	for _, v := range bc.vals {
+		b, err := bs.Bitmap(v)
+		if err != nil {
+			return nil, nil, err
+		}
+		result = result.Or(b)
+	}
+	return result, bs, idx.Close()
+}
+
+// BitmapSelectPlan は複数のビットマップインデックスの条件を、ビットマップのまま組み合わせてからレコードを返す plan
+// 1つの条件の値のビットマップは OR で、条件どうしは AND で組み合わせるので、テーブルは全ての条件を満たすレコードだけを読む
+// レコードはテーブルの rid の順に返す
+type BitmapSelectPlan struct {
+	p     Planner
+	conds []BitmapCondition
+}
+
+func NewBitmapSelectPlan(p Planner, conds []BitmapCondition) *BitmapSelectPlan {
+	return &BitmapSelectPlan{p, conds}
+}
+
+func (bsp *BitmapSelectPlan) Open() (query.Scanner, error) {
+	s, err := bsp.p.Open()
+	if err != nil {
+		return nil, err
+	}
+	ts, ok := s.(*query.TableScan)
+	if !ok {
+		return nil, errors.New("scanner must be TableScan")
+	}
+	var result *index.Bitmap
+	var searcher index.BitmapSearcher
+	for _, cond := range bsp.conds {
+		b, bs, err := cond.bitmap()
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			result = b
+		} else {
+			result = result.And(b)
+		}
+		searcher = bs
+	}
+	positions := result.Positions()
+	rids := make([]*record.RecordID, len(positions))
+	for i, pos := range positions {
+		rids[i] = searcher.RecordID(pos)
+	}
+	return NewBitmapSelectScan(ts, rids), nil
+}
+
+// BlocksAccessed は全ての値のビットマップを読むブロック数に、データレコードごとに1ブロック読む数を足して見積もる
+func (bsp *BitmapSelectPlan) BlocksAccessed() int {
+	blocks := 0
+	for _, cond := range bsp.conds {
+		blocks += cond.ii.BlocksAccessed() * len(cond.vals)
+	}
+	return blocks + bsp.RecordsOutput()
+}
+
+// RecordsOutput は条件ごとに一致する割合を掛けて見積もる
+// 1つの条件に一致するレコード数は、値ごとのレコード数を値の数だけ足した数とみなす
+func (bsp *BitmapSelectPlan) RecordsOutput() int {
+	numRecords := bsp.p.RecordsOutput()
+	if numRecords == 0 {
+		return 0
+	}
+	records := numRecords
+	for _, cond := range bsp.conds {
+		matches := cond.ii.RecordsOutput() * len(cond.vals)
+		if matches > numRecords {
+			matches = numRecords
+		}
+		records = records * matches / numRecords
+	}
+	return records
+}
+
+func (bsp *BitmapSelectPlan) DistinctValues(fieldName string) int {
+	for _, cond := range bsp.conds {
+		if cond.ii.Key() == fieldName {
+			return len(cond.vals)
+		}
+	}
+	v := bsp.p.DistinctValues(fieldName)
+	if n := bsp.RecordsOutput(); n < v {
+		return n
+	}
+	return v
+}
+
+func (bsp *BitmapSelectPlan) Schema() *record.Schema {
+	return bsp.p.Schema()
+}
+
+func (bsp *BitmapSelectPlan) Explain() *PlanNode {
+	names := make([]string, len(bsp.conds))
+	conds := make([]string, len(bsp.conds))
+	for i, cond := range bsp.conds {
+		names[i] = cond.ii.IndexName()
+		conds[i] = cond.String()
+	}
+	props := map[string]string{"index": strings.Join(names, ", "), "condition": strings.Join(conds, " and ")}
+	return newPlanNode(bsp, "BitmapSelect", props, bsp.p)
+}
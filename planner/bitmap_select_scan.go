@@ -0,0 +1,53 @@
+package planner
+
+import (
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/record"
+)
+
+// BitmapSelectScan はビットマップを組み合わせて求めた rid のレコードを順に返す scan
+type BitmapSelectScan struct {
+	ts   *query.TableScan
+	rids []*record.RecordID
+	pos  int
+}
+
+func NewBitmapSelectScan(ts *query.TableScan, rids []*record.RecordID) *BitmapSelectScan {
+	return &BitmapSelectScan{ts: ts, rids: rids, pos: -1}
+}
+
+func (bss *BitmapSelectScan) BeforeFirst() error {
+	bss.pos = -1
+	return nil
+}
+
+func (bss *BitmapSelectScan) Next() (bool, error) {
+	if bss.pos+1 >= len(bss.rids) {
+		return false, nil
+	}
+	bss.pos++
+	if err := bss.ts.MoveToRid(bss.rids[bss.pos]); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (bss *BitmapSelectScan) GetInt(fieldName string) (int, error) {
+	return bss.ts.GetInt(fieldName)
+}
+
+func (bss *BitmapSelectScan) GetString(fieldName string) (string, error) {
+	return bss.ts.GetString(fieldName)
+}
+
+func (bss *BitmapSelectScan) GetVal(fieldName string) (query.Constant, error) {
+	return bss.ts.GetVal(fieldName)
+}
+
+func (bss *BitmapSelectScan) HasField(fieldName string) bool {
+	return bss.ts.HasField(fieldName)
+}
+
+func (bss *BitmapSelectScan) Close() error {
+	return bss.ts.Close()
+}
@@ -105,6 +105,33 @@ func (cs *ChunkScan) GetVal(fieldName string) (query.Constant, error) {
 			return query.Constant{}, err
 		}
 		return query.NewConstant(v), nil
+	case record.Text:
+		v, err := cs.GetString(fieldName)
+		if err != nil {
+			return query.Constant{}, err
+		}
+		return query.NewConstant(v), nil
+	case record.Blob:
+		v, err := cs.GetString(fieldName)
+		if err != nil {
+			return query.Constant{}, err
+		}
+		return query.NewConstant([]byte(v)), nil
+	case record.UUID:
+		v, err := cs.rp.GetUUID(cs.currentSlot, fieldName)
+		if err != nil {
+			return query.Constant{}, err
+		}
+		return query.NewUUIDConstant(v), nil
+	case record.JSON:
+		v, err := cs.GetString(fieldName)
+		if err != nil {
+			return query.Constant{}, err
+		}
+		if v == "" {
+			return query.Constant{}, nil
+		}
+		return query.NewJSONConstant(v)
 	}
 	return query.Constant{}, fmt.Errorf("invalid field type %v", ft)
 }
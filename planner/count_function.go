@@ -0,0 +1,43 @@
+package planner
+
+import (
+	"fmt"
+
+	"github.com/ksrnnb/go-rdb/query"
+)
+
+// CountFunction はグループのレコード数を数える
+// fieldName が空の場合は COUNT(*) を表す
+type CountFunction struct {
+	fieldName string
+	count     int
+}
+
+func NewCountFunction(fieldName string) *CountFunction {
+	return &CountFunction{fieldName: fieldName}
+}
+
+func (f *CountFunction) ProcessFirst(s query.Scanner) error {
+	f.count = 1
+	return nil
+}
+
+func (f *CountFunction) ProcessNext(s query.Scanner) error {
+	f.count++
+	return nil
+}
+
+func (f *CountFunction) FieldName() string {
+	if f.fieldName == "" {
+		return "count"
+	}
+	return fmt.Sprintf("count_of_%s", f.fieldName)
+}
+
+func (f *CountFunction) Value() query.Constant {
+	return query.NewConstant(f.count)
+}
+
+func (f *CountFunction) Clone() AggregationFunction {
+	return NewCountFunction(f.fieldName)
+}
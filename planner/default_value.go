@@ -0,0 +1,43 @@
+package planner
+
+import (
+	"github.com/ksrnnb/go-rdb/parser"
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/record"
+)
+
+// setDefaultValues は INSERT で値が指定されなかったフィールドに、デフォルト値の式を評価して設定する
+func setDefaultValues(us query.UpdateScanner, schema *record.Schema, data *parser.InsertData) error {
+	specified := make(map[string]bool)
+	for _, fn := range data.Fields() {
+		specified[fn] = true
+	}
+	for _, fn := range schema.Fields() {
+		if specified[fn] {
+			continue
+		}
+		dv, err := schema.DefaultValue(fn)
+		if err != nil {
+			return err
+		}
+		if dv == "" {
+			continue
+		}
+		p, err := parser.NewParser(dv)
+		if err != nil {
+			return err
+		}
+		expr, err := p.Expression()
+		if err != nil {
+			return err
+		}
+		val, err := expr.Evaluate(us)
+		if err != nil {
+			return err
+		}
+		if err := us.SetVal(fn, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package planner
+
+import (
+	"github.com/ksrnnb/go-rdb/metadata"
+	"github.com/ksrnnb/go-rdb/parser"
+	"github.com/ksrnnb/go-rdb/tx"
+)
+
+// DefaultDPTableLimit は DPQueryPlanner が動的計画法で結合順序を決めるテーブル数の上限
+// 部分集合の数はテーブル数に対して指数的に増えるので、これより多い場合は HeuristicQueryPlanner を使う
+const DefaultDPTableLimit = 8
+
+// DPQueryPlanner は Selinger 方式の query planner
+// テーブルの部分集合ごとに BlocksAccessed が最小になる left-deep の結合 plan を求めて、
+// 小さい部分集合の結果から大きい部分集合の plan を組み立てる
+type DPQueryPlanner struct {
+	mdm        *metadata.MetadataManager
+	generator  *NextTableNameGenerator
+	tableLimit int
+}
+
+func NewDPQueryPlanner(mdm *metadata.MetadataManager, generator *NextTableNameGenerator, tableLimit int) *DPQueryPlanner {
+	return &DPQueryPlanner{mdm: mdm, generator: generator, tableLimit: tableLimit}
+}
+
+func (dp *DPQueryPlanner) CreatePlan(data *parser.QueryData, tx *tx.Transaction) (Planner, error) {
+	tables := data.Tables()
+	if len(tables) > dp.tableLimit {
+		return NewHeuristicQueryPlanner(dp.mdm, dp.generator).CreatePlan(data, tx)
+	}
+
+	tps := make([]*TablePlanner, 0, len(tables))
+	for _, tn := range tables {
+		tp, err := NewTablePlanner(tn, data.Predicate(), tx, dp.mdm, dp.generator)
+		if err != nil {
+			return nil, err
+		}
+		tps = append(tps, tp)
+	}
+	p, err := dp.bestJoinPlan(tps, data)
+	if err != nil {
+		return nil, err
+	}
+	p, err = groupPlan(tx, p, data, dp.generator)
+	if err != nil {
+		return nil, err
+	}
+	p, err = extendPlan(p, data.Expressions())
+	if err != nil {
+		return nil, err
+	}
+	p, err = orderPlan(tx, p, data.OrderBy(), dp.generator)
+	if err != nil {
+		return nil, err
+	}
+	return NewProjectPlan(p, data.Fields())
+}
+
+// bestJoinPlan は全てのテーブルを結合する plan のうち、最もコストが小さいものを返す
+// best[set] は set のビットが立っているテーブルを結合する最適な plan
+// set の真部分集合は set より小さい値になるので、値の小さい順に求めればよい
+// テーブルが1つの場合は、インデックスを ORDER BY の順に読む plan とインデックスだけを読む plan も候補にする
+func (dp *DPQueryPlanner) bestJoinPlan(tps []*TablePlanner, data *parser.QueryData) (Planner, error) {
+	best := make([]Planner, 1<<len(tps))
+	for i, tp := range tps {
+		var p Planner
+		var err error
+		if len(tps) == 1 {
+			p, err = tp.MakeSingleTablePlan(referencedFields(data), scanOrder(data))
+		} else {
+			p, err = tp.MakeSelectPlan()
+		}
+		if err != nil {
+			return nil, err
+		}
+		best[1<<i] = p
+	}
+	for set := 1; set < len(best); set++ {
+		if best[set] != nil {
+			continue
+		}
+		p, err := dp.bestPlanFor(set, tps, best)
+		if err != nil {
+			return nil, err
+		}
+		best[set] = p
+	}
+	return best[len(best)-1], nil
+}
+
+// bestPlanFor は set のうち1つのテーブルを、残りのテーブルの最適な plan に結合する plan の中から最もコストが小さいものを返す
+// 結合条件でつながるテーブルがない場合だけ直積を考える
+func (dp *DPQueryPlanner) bestPlanFor(set int, tps []*TablePlanner, best []Planner) (Planner, error) {
+	var bestPlan Planner
+	for i, tp := range tps {
+		if set&(1<<i) == 0 {
+			continue
+		}
+		plans, err := tp.JoinPlans(best[set&^(1<<i)])
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range plans {
+			bestPlan = cheaperPlan(bestPlan, p)
+		}
+	}
+	if bestPlan != nil {
+		return bestPlan, nil
+	}
+
+	for i, tp := range tps {
+		if set&(1<<i) == 0 {
+			continue
+		}
+		p, err := tp.MakeProductPlan(best[set&^(1<<i)])
+		if err != nil {
+			return nil, err
+		}
+		bestPlan = cheaperPlan(bestPlan, p)
+	}
+	return bestPlan, nil
+}
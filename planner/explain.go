@@ -0,0 +1,82 @@
+package planner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PlanNode は EXPLAIN で表示する plan の木の1ノード
+// 見積もりの値は plan の BlocksAccessed, RecordsOutput, DistinctValues をそのまま使う
+type PlanNode struct {
+	Type           string            `json:"type"`
+	Properties     map[string]string `json:"properties,omitempty"`
+	BlocksAccessed int               `json:"blocks_accessed"`
+	RecordsOutput  int               `json:"records_output"`
+	DistinctValues map[string]int    `json:"distinct_values"`
+	Children       []*PlanNode       `json:"children,omitempty"`
+	// Actual は EXPLAIN ANALYZE で実行したときの実績値で、実行していない場合は nil
+	Actual *ActualStats `json:"actual,omitempty"`
+}
+
+// newPlanNode は p の見積もりを計算して、子の plan を再帰的にたどったノードを生成する
+func newPlanNode(p Planner, ptype string, props map[string]string, children ...Planner) *PlanNode {
+	node := &PlanNode{
+		Type:           ptype,
+		Properties:     props,
+		BlocksAccessed: p.BlocksAccessed(),
+		RecordsOutput:  p.RecordsOutput(),
+		DistinctValues: make(map[string]int),
+	}
+	for _, fn := range p.Schema().Fields() {
+		node.DistinctValues[fn] = p.DistinctValues(fn)
+	}
+	for _, c := range children {
+		node.Children = append(node.Children, c.Explain())
+	}
+	return node
+}
+
+// String は子のノードをインデントした複数行のテキストで plan の木を表す
+func (n *PlanNode) String() string {
+	var sb strings.Builder
+	n.writeTo(&sb, 0)
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func (n *PlanNode) writeTo(sb *strings.Builder, depth int) {
+	if depth > 0 {
+		sb.WriteString(strings.Repeat("  ", depth-1))
+		sb.WriteString("-> ")
+	}
+	sb.WriteString(n.Type)
+	for _, k := range sortedKeys(n.Properties) {
+		fmt.Fprintf(sb, " %s=%s", k, n.Properties[k])
+	}
+	fmt.Fprintf(sb, " (blocks=%d records=%d", n.BlocksAccessed, n.RecordsOutput)
+	if len(n.DistinctValues) > 0 {
+		dvs := make([]string, 0, len(n.DistinctValues))
+		for _, fn := range sortedKeys(n.DistinctValues) {
+			dvs = append(dvs, fmt.Sprintf("%s:%d", fn, n.DistinctValues[fn]))
+		}
+		fmt.Fprintf(sb, " distinct=[%s]", strings.Join(dvs, " "))
+	}
+	sb.WriteString(")")
+	if a := n.Actual; a != nil {
+		fmt.Fprintf(sb, " (actual loops=%d rows=%d time=%s pins=%d reads=%d writes=%d)",
+			a.Loops, a.Rows, a.Time, a.BufferPins, a.BlocksRead, a.BlocksWritten)
+	}
+	sb.WriteString("\n")
+	for _, c := range n.Children {
+		c.writeTo(sb, depth+1)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,76 @@
+package planner
+
+import (
+	"strings"
+
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/record"
+)
+
+// ExtendPlan は select 句の式 (payload->>'name' など) を評価したフィールドを追加する
+type ExtendPlan struct {
+	p      Planner
+	exprs  []query.Expression
+	schema *record.Schema
+}
+
+func NewExtendPlan(p Planner, exprs []query.Expression) (*ExtendPlan, error) {
+	ep := &ExtendPlan{p: p, schema: record.NewSchema()}
+	if err := ep.schema.AddAll(p.Schema()); err != nil {
+		return nil, err
+	}
+	for _, e := range exprs {
+		if e.IsFieldName() || ep.schema.HasField(e.String()) {
+			continue
+		}
+		ft, err := e.ResultType(p.Schema())
+		if err != nil {
+			return nil, err
+		}
+		ep.schema.AddField(e.String(), ft, query.ExpressionStringLength)
+		ep.exprs = append(ep.exprs, e)
+	}
+	return ep, nil
+}
+
+// extendPlan は式を含む場合のみ ExtendPlan を追加する
+func extendPlan(p Planner, exprs []query.Expression) (Planner, error) {
+	for _, e := range exprs {
+		if !e.IsFieldName() {
+			return NewExtendPlan(p, exprs)
+		}
+	}
+	return p, nil
+}
+
+func (ep *ExtendPlan) Open() (query.Scanner, error) {
+	s, err := ep.p.Open()
+	if err != nil {
+		return nil, err
+	}
+	return query.NewExtendScan(s, ep.exprs), nil
+}
+
+func (ep *ExtendPlan) BlocksAccessed() int {
+	return ep.p.BlocksAccessed()
+}
+
+func (ep *ExtendPlan) RecordsOutput() int {
+	return ep.p.RecordsOutput()
+}
+
+func (ep *ExtendPlan) DistinctValues(fieldName string) int {
+	return ep.p.DistinctValues(fieldName)
+}
+
+func (ep *ExtendPlan) Schema() *record.Schema {
+	return ep.schema
+}
+
+func (ep *ExtendPlan) Explain() *PlanNode {
+	exprs := make([]string, len(ep.exprs))
+	for i, e := range ep.exprs {
+		exprs[i] = e.String()
+	}
+	return newPlanNode(ep, "Extend", map[string]string{"expressions": strings.Join(exprs, ", ")}, ep.p)
+}
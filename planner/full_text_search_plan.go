@@ -0,0 +1,64 @@
+package planner
+
+import (
+	"errors"
+
+	"github.com/ksrnnb/go-rdb/metadata"
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/record"
+)
+
+// FullTextSearchPlan は全文検索のインデックスで terms の全ての語を含むレコードを探して、関連度の高い順に返す plan
+type FullTextSearchPlan struct {
+	p     Planner
+	ii    *metadata.IndexInfo
+	terms query.Constant
+}
+
+func NewFullTextSearchPlan(p Planner, ii *metadata.IndexInfo, terms query.Constant) *FullTextSearchPlan {
+	return &FullTextSearchPlan{p, ii, terms}
+}
+
+func (fsp *FullTextSearchPlan) Open() (query.Scanner, error) {
+	s, err := fsp.p.Open()
+	if err != nil {
+		return nil, err
+	}
+	ts, ok := s.(*query.TableScan)
+	if !ok {
+		return nil, errors.New("scanner must be TableScan")
+	}
+	idx, err := fsp.ii.Open()
+	if err != nil {
+		return nil, err
+	}
+	return NewFullTextSearchScan(ts, idx, fsp.terms.String(), fsp.p.RecordsOutput())
+}
+
+// BlocksAccessed は語ごとに posting list を読むブロック数に、データレコードごとに1ブロック読む数を足して見積もる
+func (fsp *FullTextSearchPlan) BlocksAccessed() int {
+	words := len(query.Tokenize(fsp.terms.String()))
+	return fsp.ii.BlocksAccessed()*words + fsp.RecordsOutput()
+}
+
+// RecordsOutput は MATCH の条件の絞り込みと同じく、 1/3 のレコードが一致するとみなす
+func (fsp *FullTextSearchPlan) RecordsOutput() int {
+	return fsp.p.RecordsOutput() / 3
+}
+
+func (fsp *FullTextSearchPlan) DistinctValues(fieldName string) int {
+	v := fsp.p.DistinctValues(fieldName)
+	if n := fsp.RecordsOutput(); n < v {
+		return n
+	}
+	return v
+}
+
+func (fsp *FullTextSearchPlan) Schema() *record.Schema {
+	return fsp.p.Schema()
+}
+
+func (fsp *FullTextSearchPlan) Explain() *PlanNode {
+	props := map[string]string{"index": fsp.ii.IndexName(), "key": fsp.ii.Key(), "terms": fsp.terms.String()}
+	return newPlanNode(fsp, "FullTextSearch", props, fsp.p)
+}
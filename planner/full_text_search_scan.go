@@ -0,0 +1,69 @@
+package planner
+
+import (
+	"fmt"
+
+	"github.com/ksrnnb/go-rdb/index"
+	"github.com/ksrnnb/go-rdb/query"
+)
+
+// FullTextSearchScan は全文検索に一致したレコードを、関連度の高い順に返す scan
+// 開いたときに一致したレコードの rid を全て集めてから、順にデータレコードに移動する
+type FullTextSearchScan struct {
+	ts      *query.TableScan
+	matches []index.TextMatch
+	pos     int
+}
+
+// NewFullTextSearchScan は idx で terms を検索して、一致したレコードを返す FullTextSearchScan を返す
+// numRecords はテーブルのレコード数で、関連度の計算に使う
+func NewFullTextSearchScan(ts *query.TableScan, idx index.Index, terms string, numRecords int) (*FullTextSearchScan, error) {
+	tsr, ok := idx.(index.TextSearcher)
+	if !ok {
+		return nil, fmt.Errorf("index %T does not support text search", idx)
+	}
+	matches, err := tsr.Search(terms, numRecords)
+	if err != nil {
+		return nil, err
+	}
+	if err := idx.Close(); err != nil {
+		return nil, err
+	}
+	return &FullTextSearchScan{ts: ts, matches: matches, pos: -1}, nil
+}
+
+func (fss *FullTextSearchScan) BeforeFirst() error {
+	fss.pos = -1
+	return nil
+}
+
+func (fss *FullTextSearchScan) Next() (bool, error) {
+	if fss.pos+1 >= len(fss.matches) {
+		return false, nil
+	}
+	fss.pos++
+	if err := fss.ts.MoveToRid(fss.matches[fss.pos].Rid()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (fss *FullTextSearchScan) GetInt(fieldName string) (int, error) {
+	return fss.ts.GetInt(fieldName)
+}
+
+func (fss *FullTextSearchScan) GetString(fieldName string) (string, error) {
+	return fss.ts.GetString(fieldName)
+}
+
+func (fss *FullTextSearchScan) GetVal(fieldName string) (query.Constant, error) {
+	return fss.ts.GetVal(fieldName)
+}
+
+func (fss *FullTextSearchScan) HasField(fieldName string) bool {
+	return fss.ts.HasField(fieldName)
+}
+
+func (fss *FullTextSearchScan) Close() error {
+	return fss.ts.Close()
+}
@@ -1,8 +1,12 @@
 package planner
 
 import (
+	"strings"
+
+	"github.com/ksrnnb/go-rdb/parser"
 	"github.com/ksrnnb/go-rdb/query"
 	"github.com/ksrnnb/go-rdb/record"
+	"github.com/ksrnnb/go-rdb/sqlstate"
 	"github.com/ksrnnb/go-rdb/tx"
 )
 
@@ -14,16 +18,54 @@ type GroupPlan struct {
 }
 
 func NewGroupPlan(tx *tx.Transaction, p Planner, groupFields []string, aggFns []AggregationFunction, generator *NextTableNameGenerator) (*GroupPlan, error) {
-	schema := record.NewSchema()
-	for _, fn := range groupFields {
-		if err := schema.Add(fn, p.Schema()); err != nil {
-			return nil, err
+	schema, err := newGroupSchema(p, groupFields, aggFns)
+	if err != nil {
+		return nil, err
+	}
+	return &GroupPlan{p, groupFields, aggFns, schema}, nil
+}
+
+// MakeGroupPlan は p をソートしてから集計する GroupPlan と、ソートしない HashGroupPlan のうち、コストが小さい方を返す
+// グループ数は DistinctValues から見積もるので、グループが少なくてバッファに収まる場合は HashGroupPlan になる
+// 同じ場合は、分割を繰り返さない GroupPlan を選ぶ
+func MakeGroupPlan(tx *tx.Transaction, p Planner, groupFields []string, aggFns []AggregationFunction, generator *NextTableNameGenerator) (Planner, error) {
+	sp := NewSortPlan(tx, groupFields, p, generator)
+	gp, err := NewGroupPlan(tx, sp, groupFields, aggFns, generator)
+	if err != nil {
+		return nil, err
+	}
+	hp, err := NewHashGroupPlan(tx, p, groupFields, aggFns, generator)
+	if err != nil {
+		return nil, err
+	}
+	if hp.BlocksAccessed() < sp.preprocessingBlocks()+gp.BlocksAccessed() {
+		return hp, nil
+	}
+	return gp, nil
+}
+
+// groupPlan はクエリに GROUP BY か集計関数がある場合に、 p をグループごとに集計する plan を返す
+func groupPlan(tx *tx.Transaction, p Planner, data *parser.QueryData, generator *NextTableNameGenerator) (Planner, error) {
+	if !data.IsGrouped() {
+		return p, nil
+	}
+	for _, fn := range data.GroupBy() {
+		if !p.Schema().HasField(fn) {
+			return nil, sqlstate.Errorf(sqlstate.UndefinedColumn, "group by field not found: %s", fn)
 		}
 	}
-	for _, aggFn := range aggFns {
-		schema.AddIntField(aggFn.FieldName())
+	aggFns := make([]AggregationFunction, len(data.Aggregates()))
+	for i, af := range data.Aggregates() {
+		if af.FieldName() != "" && !p.Schema().HasField(af.FieldName()) {
+			return nil, sqlstate.Errorf(sqlstate.UndefinedColumn, "field not found: %s", af.FieldName())
+		}
+		aggFn, err := newAggregationFunction(af)
+		if err != nil {
+			return nil, err
+		}
+		aggFns[i] = aggFn
 	}
-	return &GroupPlan{p, groupFields, aggFns, schema}, nil
+	return MakeGroupPlan(tx, p, data.GroupBy(), aggFns, generator)
 }
 
 func (gp *GroupPlan) Open() (query.Scanner, error) {
@@ -56,3 +98,42 @@ func (gp *GroupPlan) DistinctValues(fieldName string) int {
 func (gp *GroupPlan) Schema() *record.Schema {
 	return gp.schema
 }
+
+func (gp *GroupPlan) Explain() *PlanNode {
+	return newPlanNode(gp, "Group", groupProperties(gp.groupFields, gp.aggFns), gp.p)
+}
+
+// newGroupSchema はグループ化するフィールドと集計した値のフィールドからなるスキーマを返す
+func newGroupSchema(p Planner, groupFields []string, aggFns []AggregationFunction) (*record.Schema, error) {
+	schema := record.NewSchema()
+	for _, fn := range groupFields {
+		if err := schema.Add(fn, p.Schema()); err != nil {
+			return nil, err
+		}
+	}
+	for _, aggFn := range aggFns {
+		// MAX は集計するフィールドと同じ型の値を返す
+		if mf, ok := aggFn.(*MaxFunction); ok {
+			ft, err := p.Schema().FieldType(mf.fieldName)
+			if err != nil {
+				return nil, err
+			}
+			length, err := p.Schema().Length(mf.fieldName)
+			if err != nil {
+				return nil, err
+			}
+			schema.AddField(mf.FieldName(), ft, length)
+			continue
+		}
+		schema.AddIntField(aggFn.FieldName())
+	}
+	return schema, nil
+}
+
+func groupProperties(groupFields []string, aggFns []AggregationFunction) map[string]string {
+	aggs := make([]string, len(aggFns))
+	for i, fn := range aggFns {
+		aggs[i] = fn.FieldName()
+	}
+	return map[string]string{"group_fields": strings.Join(groupFields, ", "), "aggregates": strings.Join(aggs, ", ")}
+}
@@ -12,6 +12,10 @@ type GroupByScan struct {
 	aggFns      []AggregationFunction
 	groupVal    GroupValue
 	moreGroups  bool
+	// グループ化するフィールドがなく入力が空の場合も、集計関数は1行を返す
+	// emptyPending はまだその行を返していないこと、 empty はその行を返していることを表す
+	emptyPending bool
+	empty        bool
 }
 
 func NewGroupByScan(scan query.Scanner, groupFields []string, aggFns []AggregationFunction) (*GroupByScan, error) {
@@ -31,10 +35,18 @@ func (gs *GroupByScan) BeforeFirst() error {
 		return err
 	}
 	gs.moreGroups = moreGroups
+	gs.emptyPending = !moreGroups && len(gs.groupFields) == 0
+	gs.empty = false
 	return nil
 }
 
 func (gs *GroupByScan) Next() (bool, error) {
+	if gs.emptyPending {
+		gs.emptyPending = false
+		gs.empty = true
+		return true, nil
+	}
+	gs.empty = false
 	if !gs.moreGroups {
 		return false, nil
 	}
@@ -47,12 +59,16 @@ func (gs *GroupByScan) Next() (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	hasMoreGroup, err := gs.scan.Next()
-	if err != nil {
-		return false, err
-	}
-	gs.moreGroups = hasMoreGroup
-	for gs.moreGroups {
+	gs.groupVal = groupVal
+	for {
+		hasMoreGroup, err := gs.scan.Next()
+		if err != nil {
+			return false, err
+		}
+		gs.moreGroups = hasMoreGroup
+		if !gs.moreGroups {
+			break
+		}
 		gv, err := NewGroupValue(gs.scan, gs.groupFields)
 		if err != nil {
 			return false, err
@@ -95,6 +111,9 @@ func (gs *GroupByScan) GetVal(fieldName string) (query.Constant, error) {
 	}
 	for _, aggFn := range gs.aggFns {
 		if aggFn.FieldName() == fieldName {
+			if gs.empty {
+				return aggFn.Clone().Value(), nil
+			}
 			return aggFn.Value(), nil
 		}
 	}
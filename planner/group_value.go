@@ -11,7 +11,7 @@ func NewGroupValue(s query.Scanner, fields []string) (GroupValue, error) {
 	for _, fn := range fields {
 		v, err := s.GetVal(fn)
 		if err != nil {
-			return GroupValue{}, nil
+			return GroupValue{}, err
 		}
 		values[fn] = v
 	}
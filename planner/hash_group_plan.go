@@ -0,0 +1,91 @@
+package planner
+
+import (
+	"math"
+
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/record"
+	"github.com/ksrnnb/go-rdb/tx"
+)
+
+// HashGroupPlan は入力をソートせずに、グループごとの集計値をハッシュ表に持って集計する plan
+// グループが使えるバッファに収まらない場合は、収まらないグループのレコードを一時テーブルに分割して後から集計する
+type HashGroupPlan struct {
+	tx          *tx.Transaction
+	p           Planner
+	groupFields []string
+	aggFns      []AggregationFunction
+	schema      *record.Schema
+	generator   *NextTableNameGenerator
+}
+
+func NewHashGroupPlan(tx *tx.Transaction, p Planner, groupFields []string, aggFns []AggregationFunction, generator *NextTableNameGenerator) (*HashGroupPlan, error) {
+	schema, err := newGroupSchema(p, groupFields, aggFns)
+	if err != nil {
+		return nil, err
+	}
+	return &HashGroupPlan{
+		tx:          tx,
+		p:           p,
+		groupFields: groupFields,
+		aggFns:      aggFns,
+		schema:      schema,
+		generator:   generator,
+	}, nil
+}
+
+func (hp *HashGroupPlan) Open() (query.Scanner, error) {
+	s, err := hp.p.Open()
+	if err != nil {
+		return nil, err
+	}
+	return NewHashGroupScan(hp.tx, s, hp.p.Schema(), hp.groupFields, hp.aggFns, hp.schema, hp.generator)
+}
+
+// BlocksAccessed は入力を1回読むブロック数
+// グループがバッファに収まらない場合は、収まるまで分割する回数だけ入力を書き込んで読み直す分を足す
+func (hp *HashGroupPlan) BlocksAccessed() int {
+	return hp.p.BlocksAccessed() + 2*hp.partitionPasses()*NewMaterializePlan(hp.tx, hp.p, hp.generator).BlocksAccessed()
+}
+
+func (hp *HashGroupPlan) RecordsOutput() int {
+	numGroups := 1
+	for _, fn := range hp.groupFields {
+		numGroups *= hp.p.DistinctValues(fn)
+	}
+	return numGroups
+}
+
+func (hp *HashGroupPlan) DistinctValues(fieldName string) int {
+	if hp.p.Schema().HasField(fieldName) {
+		return hp.p.DistinctValues(fieldName)
+	}
+	return hp.RecordsOutput()
+}
+
+func (hp *HashGroupPlan) Schema() *record.Schema {
+	return hp.schema
+}
+
+func (hp *HashGroupPlan) Explain() *PlanNode {
+	props := groupProperties(hp.groupFields, hp.aggFns)
+	if hp.partitionPasses() > 0 {
+		props["partitioned"] = "true"
+	}
+	return newPlanNode(hp, "HashGroup", props, hp.p)
+}
+
+// partitionPasses は見積もったグループ数がバッファに収まるまでに、入力を分割する回数を返す
+// Open したときには入力の scan がバッファを1つ使うので、その分を除いて比べる
+func (hp *HashGroupPlan) partitionPasses() int {
+	available := hp.tx.AvailableBuffers() - 1
+	rpb := float64(hp.tx.BlockSize()) / float64(record.NewLayout(hp.schema).SlotSize())
+	size := math.Ceil(float64(hp.RecordsOutput()) / rpb)
+	fanout := math.Max(float64(available-1), 2)
+	passes := 0
+	for size > float64(available) && passes < hashGroupMaxDepth {
+		size = math.Ceil(size / fanout)
+		passes++
+	}
+	return passes
+}
@@ -0,0 +1,300 @@
+package planner
+
+import (
+	"fmt"
+
+	"github.com/ksrnnb/go-rdb/hashes"
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/record"
+	"github.com/ksrnnb/go-rdb/tx"
+)
+
+// hashGroupMaxDepth はパーティションを分割し直す深さの上限
+// 上限に達したら、バッファに収まらなくても全てのグループをメモリに載せる
+const hashGroupMaxDepth = 3
+
+// hashGroup は1つのグループの値と、そのグループの集計値
+type hashGroup struct {
+	values []query.Constant
+	aggFns []AggregationFunction
+}
+
+// groupPartition はハッシュ値で分割した入力のレコードを保存する一時テーブル
+type groupPartition struct {
+	table *TemporaryTable
+	depth int
+}
+
+// HashGroupScan はグループごとの集計値をハッシュ表に持って集計する scan
+// ハッシュ表に入っていないグループのレコードは、グループの数が上限に達したらパーティションに書き込み、
+// メモリ上のグループを全て返した後にパーティションごとに集計する
+type HashGroupScan struct {
+	tx          *tx.Transaction
+	inputSchema *record.Schema
+	groupFields []string
+	aggFns      []AggregationFunction
+	generator   *NextTableNameGenerator
+	maxGroups   int
+	fanout      int
+
+	// 最初に入力を読み込んだときに集計したグループと、書き込んだパーティション
+	first      []*hashGroup
+	partitions []groupPartition
+
+	// queue はまだ集計していないパーティション
+	queue   []groupPartition
+	results []*hashGroup
+	pos     int
+	current *hashGroup
+}
+
+// NewHashGroupScan は入力を全て読み込んで集計する
+// メモリに載せられるグループの数は、 scan を開いた時点で使えるバッファの分だけ
+func NewHashGroupScan(
+	tx *tx.Transaction,
+	s query.Scanner,
+	inputSchema *record.Schema,
+	groupFields []string,
+	aggFns []AggregationFunction,
+	schema *record.Schema,
+	generator *NextTableNameGenerator,
+) (*HashGroupScan, error) {
+	available := tx.AvailableBuffers()
+	maxGroups := available * tx.BlockSize() / record.NewLayout(schema).SlotSize()
+	if maxGroups < 1 {
+		maxGroups = 1
+	}
+	// 書き込み中のパーティションごとにバッファを1つ使う
+	fanout := available - 1
+	if fanout < 2 {
+		fanout = 2
+	}
+	gs := &HashGroupScan{
+		tx:          tx,
+		inputSchema: inputSchema,
+		groupFields: groupFields,
+		aggFns:      aggFns,
+		generator:   generator,
+		maxGroups:   maxGroups,
+		fanout:      fanout,
+	}
+	groups, partitions, err := gs.aggregate(s, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Close(); err != nil {
+		return nil, err
+	}
+	// グループ化するフィールドがなく入力が空の場合も、集計関数は1行を返す
+	if len(groupFields) == 0 && len(groups) == 0 {
+		g := &hashGroup{aggFns: make([]AggregationFunction, len(aggFns))}
+		for i, aggFn := range aggFns {
+			g.aggFns[i] = aggFn.Clone()
+		}
+		groups = append(groups, g)
+	}
+	gs.first = groups
+	gs.partitions = partitions
+	if err := gs.BeforeFirst(); err != nil {
+		return nil, err
+	}
+	return gs, nil
+}
+
+// BeforeFirst は最初に集計したグループから返し直す
+// パーティションは Next で順に集計し直す
+func (gs *HashGroupScan) BeforeFirst() error {
+	gs.results = gs.first
+	gs.pos = 0
+	gs.current = nil
+	gs.queue = append([]groupPartition(nil), gs.partitions...)
+	return nil
+}
+
+// Next はメモリ上のグループを順に返し、無くなったら次のパーティションを集計する
+// パーティションから分割し直したパーティションは、残りのパーティションより先に集計する
+func (gs *HashGroupScan) Next() (bool, error) {
+	for {
+		if gs.pos < len(gs.results) {
+			gs.current = gs.results[gs.pos]
+			gs.pos++
+			return true, nil
+		}
+		if len(gs.queue) == 0 {
+			return false, nil
+		}
+		p := gs.queue[0]
+		gs.queue = gs.queue[1:]
+		s, err := p.table.Open()
+		if err != nil {
+			return false, err
+		}
+		groups, subs, err := gs.aggregate(s, p.depth)
+		if err != nil {
+			return false, err
+		}
+		if err := s.Close(); err != nil {
+			return false, err
+		}
+		gs.queue = append(subs, gs.queue...)
+		gs.results = groups
+		gs.pos = 0
+	}
+}
+
+func (gs *HashGroupScan) GetInt(fieldName string) (int, error) {
+	v, err := gs.GetVal(fieldName)
+	if err != nil {
+		return 0, err
+	}
+	return v.AsInt(), nil
+}
+
+func (gs *HashGroupScan) GetString(fieldName string) (string, error) {
+	v, err := gs.GetVal(fieldName)
+	if err != nil {
+		return "", err
+	}
+	return v.AsString(), nil
+}
+
+func (gs *HashGroupScan) GetVal(fieldName string) (query.Constant, error) {
+	for i, fn := range gs.groupFields {
+		if fn == fieldName {
+			return gs.current.values[i], nil
+		}
+	}
+	for i, aggFn := range gs.aggFns {
+		if aggFn.FieldName() == fieldName {
+			return gs.current.aggFns[i].Value(), nil
+		}
+	}
+	return query.Constant{}, fmt.Errorf("no field %s", fieldName)
+}
+
+func (gs *HashGroupScan) HasField(fieldName string) bool {
+	if contains(gs.groupFields, fieldName) {
+		return true
+	}
+	for _, aggFn := range gs.aggFns {
+		if aggFn.FieldName() == fieldName {
+			return true
+		}
+	}
+	return false
+}
+
+// Close は入力を開いたときに閉じているので、集計したグループを捨てるだけ
+func (gs *HashGroupScan) Close() error {
+	gs.first = nil
+	gs.results = nil
+	gs.queue = nil
+	return nil
+}
+
+// aggregate は s のレコードをグループごとに集計する
+// ハッシュ表に無いグループのレコードは、グループの数が上限に達していたらパーティションに書き込む
+func (gs *HashGroupScan) aggregate(s query.Scanner, depth int) ([]*hashGroup, []groupPartition, error) {
+	table := make(map[uint32][]*hashGroup)
+	groups := make([]*hashGroup, 0)
+	var partitions []groupPartition
+	var dests []query.UpdateScanner
+	values := make([]query.Constant, len(gs.groupFields))
+	for {
+		ok, err := s.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			break
+		}
+		var h uint32
+		for i, fn := range gs.groupFields {
+			v, err := s.GetVal(fn)
+			if err != nil {
+				return nil, nil, err
+			}
+			values[i] = v
+			h = 31*h + v.HashCode()
+		}
+
+		if g := findGroup(table[h], values); g != nil {
+			for _, aggFn := range g.aggFns {
+				if err := aggFn.ProcessNext(s); err != nil {
+					return nil, nil, err
+				}
+			}
+			continue
+		}
+
+		if len(groups) < gs.maxGroups || depth >= hashGroupMaxDepth {
+			g := &hashGroup{
+				values: append([]query.Constant(nil), values...),
+				aggFns: make([]AggregationFunction, len(gs.aggFns)),
+			}
+			for i, aggFn := range gs.aggFns {
+				g.aggFns[i] = aggFn.Clone()
+				if err := g.aggFns[i].ProcessFirst(s); err != nil {
+					return nil, nil, err
+				}
+			}
+			table[h] = append(table[h], g)
+			groups = append(groups, g)
+			continue
+		}
+
+		if dests == nil {
+			partitions = make([]groupPartition, gs.fanout)
+			for i := range partitions {
+				partitions[i] = groupPartition{
+					table: NewTemporaryTable(gs.tx, gs.inputSchema, gs.generator),
+					depth: depth + 1,
+				}
+			}
+			dests, err = openGroupPartitions(partitions)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		row, err := readRow(s, gs.inputSchema)
+		if err != nil {
+			return nil, nil, err
+		}
+		dest := dests[hashes.Mix(h, uint32(depth))%uint32(gs.fanout)]
+		if err := writeRow(dest, gs.inputSchema, row); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := closeScans(dests); err != nil {
+		return nil, nil, err
+	}
+	return groups, partitions, nil
+}
+
+func findGroup(bucket []*hashGroup, values []query.Constant) *hashGroup {
+	for _, g := range bucket {
+		equal := true
+		for i, v := range g.values {
+			if !v.Equals(values[i]) {
+				equal = false
+				break
+			}
+		}
+		if equal {
+			return g
+		}
+	}
+	return nil
+}
+
+func openGroupPartitions(partitions []groupPartition) ([]query.UpdateScanner, error) {
+	scans := make([]query.UpdateScanner, len(partitions))
+	for i, p := range partitions {
+		s, err := p.table.Open()
+		if err != nil {
+			return nil, err
+		}
+		scans[i] = s
+	}
+	return scans, nil
+}
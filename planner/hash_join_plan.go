@@ -0,0 +1,128 @@
+package planner
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/record"
+	"github.com/ksrnnb/go-rdb/tx"
+)
+
+// HashJoinPlan は fieldName1 = fieldName2 の等結合をハッシュ表で行う plan
+// 一時テーブルにコピーしたときのブロック数が小さい方の入力からハッシュ表を作り、もう一方の入力で探索する
+type HashJoinPlan struct {
+	tx         *tx.Transaction
+	p1         Planner
+	p2         Planner
+	fieldName1 string
+	fieldName2 string
+	schema     *record.Schema
+	generator  *NextTableNameGenerator
+}
+
+func NewHashJoinPlan(tx *tx.Transaction, p1 Planner, p2 Planner, fieldName1 string, fieldName2 string, generator *NextTableNameGenerator) (*HashJoinPlan, error) {
+	schema := record.NewSchema()
+	if err := schema.AddAll(p1.Schema()); err != nil {
+		return nil, err
+	}
+	if err := schema.AddAll(p2.Schema()); err != nil {
+		return nil, err
+	}
+	return &HashJoinPlan{
+		tx:         tx,
+		p1:         p1,
+		p2:         p2,
+		fieldName1: fieldName1,
+		fieldName2: fieldName2,
+		schema:     schema,
+		generator:  generator,
+	}, nil
+}
+
+func (hp *HashJoinPlan) Open() (query.Scanner, error) {
+	build, probe, buildField, probeField := hp.sides()
+	bs, err := build.Open()
+	if err != nil {
+		return nil, err
+	}
+	ps, err := probe.Open()
+	if err != nil {
+		return nil, err
+	}
+	return NewHashJoinScan(hp.tx, bs, build.Schema(), buildField, ps, probe.Schema(), probeField, hp.generator)
+}
+
+// BlocksAccessed は両方の入力を1回ずつ読むブロック数
+// ハッシュ表が使えるバッファに収まらない場合は、両方の入力をパーティションに書き込んで読み直す分を足す
+// 分割できないほどバッファが少ない場合は、 build 側をバッファに収まる分ずつ読み込むたびに probe 側を読み直す分を足す
+func (hp *HashJoinPlan) BlocksAccessed() int {
+	build, probe, _, _ := hp.sides()
+	blocks := build.BlocksAccessed() + probe.BlocksAccessed()
+	if !hp.overflows(build) {
+		return blocks
+	}
+	if hp.partitioned(build) {
+		return blocks + 2*(hp.materializedSize(build)+hp.materializedSize(probe))
+	}
+	chunks := int(math.Ceil(float64(hp.materializedSize(build)) / math.Max(float64(hp.available()), 1)))
+	return blocks + (chunks-1)*probe.BlocksAccessed()
+}
+
+func (hp *HashJoinPlan) RecordsOutput() int {
+	maxVal := math.Max(float64(hp.p1.DistinctValues(hp.fieldName1)), float64(hp.p2.DistinctValues(hp.fieldName2)))
+	maxVal = math.Max(maxVal, 1)
+	return int(float64(hp.p1.RecordsOutput()*hp.p2.RecordsOutput()) / maxVal)
+}
+
+func (hp *HashJoinPlan) DistinctValues(fieldName string) int {
+	if hp.p1.Schema().HasField(fieldName) {
+		return hp.p1.DistinctValues(fieldName)
+	}
+	return hp.p2.DistinctValues(fieldName)
+}
+
+func (hp *HashJoinPlan) Schema() *record.Schema {
+	return hp.schema
+}
+
+func (hp *HashJoinPlan) Explain() *PlanNode {
+	build, _, buildField, _ := hp.sides()
+	props := map[string]string{
+		"condition": fmt.Sprintf("%s=%s", hp.fieldName1, hp.fieldName2),
+		"build":     buildField,
+	}
+	if hp.partitioned(build) {
+		props["partitioned"] = "true"
+	}
+	return newPlanNode(hp, "HashJoin", props, hp.p1, hp.p2)
+}
+
+// sides はハッシュ表を作る入力と探索する入力を、それぞれの結合フィールドと合わせて返す
+func (hp *HashJoinPlan) sides() (build Planner, probe Planner, buildField string, probeField string) {
+	if hp.materializedSize(hp.p2) < hp.materializedSize(hp.p1) {
+		return hp.p2, hp.p1, hp.fieldName2, hp.fieldName1
+	}
+	return hp.p1, hp.p2, hp.fieldName1, hp.fieldName2
+}
+
+// overflows は build 側がハッシュ表に収まらないかどうかを返す
+func (hp *HashJoinPlan) overflows(build Planner) bool {
+	return hp.materializedSize(build) > hp.available()
+}
+
+// partitioned は build 側がハッシュ表に収まらずにパーティションに分割するかどうかを返す
+// 書き込み中のパーティションごとにバッファを1つ使うので、2つ以上に分割できるバッファがない場合は分割しない
+func (hp *HashJoinPlan) partitioned(build Planner) bool {
+	return hp.overflows(build) && hp.available() >= 2
+}
+
+// available は Open したときに使えるバッファの数を返す
+// Open したときには両方の入力の scan がバッファを1つずつ使うので、その分を除く
+func (hp *HashJoinPlan) available() int {
+	return hp.tx.AvailableBuffers() - 2
+}
+
+func (hp *HashJoinPlan) materializedSize(p Planner) int {
+	return NewMaterializePlan(hp.tx, p, hp.generator).BlocksAccessed()
+}
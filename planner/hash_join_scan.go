@@ -0,0 +1,477 @@
+package planner
+
+import (
+	"github.com/ksrnnb/go-rdb/hashes"
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/record"
+	"github.com/ksrnnb/go-rdb/tx"
+)
+
+// hashJoinMaxDepth はパーティションを分割し直す深さの上限
+// 同じ結合値のレコードが多いと分割しても小さくならないので、上限に達したら build 側を maxRows 件ずつ読み込んで結合する
+const hashJoinMaxDepth = 3
+
+// hashPartition はハッシュ値で分割した build 側と probe 側の一時テーブルの組
+type hashPartition struct {
+	build *TemporaryTable
+	probe *TemporaryTable
+	depth int
+}
+
+// HashJoinScan は build 側のレコードからハッシュ表を作り、 probe 側のレコードで探索する scan
+// build 側が使えるバッファに収まらない場合は、両方の入力をハッシュ値で一時テーブルに分割して、パーティションごとに結合する
+// 分割できない場合は nested loop join のように、 build 側を maxRows 件ずつハッシュ表に読み込むたびに probe 側を先頭から読み直す
+type HashJoinScan struct {
+	tx          *tx.Transaction
+	buildSchema *record.Schema
+	buildField  string
+	probeSchema *record.Schema
+	probeField  string
+	generator   *NextTableNameGenerator
+	maxRows     int
+	fanout      int
+
+	fieldIndexes map[string]int
+	table        map[uint32][][]query.Constant
+
+	partitioned bool
+	partitions  []hashPartition
+	partIdx     int
+
+	// chunkBuild は maxRows 件ずつハッシュ表に読み込んでいる build 側の scan で、そうでない場合は nil
+	// chunkPending は chunkBuild にまだハッシュ表に読み込んでいないレコードがあるかどうか
+	chunkBuild   query.Scanner
+	chunkPending bool
+
+	probe   query.Scanner
+	matches [][]query.Constant
+	// matches に使うスライスを probe 側のレコードごとに作らないように使い回す
+	matchBuf [][]query.Constant
+	current  []query.Constant
+}
+
+// NewHashJoinScan は build 側を全て読み込んでハッシュ表を作る
+// ハッシュ表に載せられるレコード数は、 scan を開いた時点で使えるバッファの分だけ
+func NewHashJoinScan(
+	tx *tx.Transaction,
+	build query.Scanner,
+	buildSchema *record.Schema,
+	buildField string,
+	probe query.Scanner,
+	probeSchema *record.Schema,
+	probeField string,
+	generator *NextTableNameGenerator,
+) (*HashJoinScan, error) {
+	available := tx.AvailableBuffers()
+	maxRows := available * tx.BlockSize() / record.NewLayout(buildSchema).SlotSize()
+	if maxRows < 1 {
+		maxRows = 1
+	}
+	// 書き込み中のパーティションごとにバッファを1つ使う
+	// 分割するときに開いている build 側と probe 側の scan のバッファは、 available に含まれていない
+	// 2つ以上に分割できない場合は分割しない
+	fanout := available
+	fieldIndexes := make(map[string]int)
+	for i, fn := range buildSchema.Fields() {
+		fieldIndexes[fn] = i
+	}
+	hs := &HashJoinScan{
+		tx:           tx,
+		buildSchema:  buildSchema,
+		buildField:   buildField,
+		probeSchema:  probeSchema,
+		probeField:   probeField,
+		generator:    generator,
+		maxRows:      maxRows,
+		fanout:       fanout,
+		fieldIndexes: fieldIndexes,
+	}
+
+	overflow, err := hs.buildTable(build, false)
+	if err != nil {
+		return nil, err
+	}
+	if !overflow {
+		if err := build.Close(); err != nil {
+			return nil, err
+		}
+		hs.probe = probe
+		return hs, nil
+	}
+	if !hs.canPartition(0) {
+		hs.chunkBuild = build
+		hs.chunkPending = true
+		hs.probe = probe
+		return hs, nil
+	}
+
+	partitions, err := hs.partition(build, probe, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := build.Close(); err != nil {
+		return nil, err
+	}
+	if err := probe.Close(); err != nil {
+		return nil, err
+	}
+	hs.partitioned = true
+	hs.partitions = partitions
+	if _, err := hs.openPartition(); err != nil {
+		return nil, err
+	}
+	return hs, nil
+}
+
+// BeforeFirst は probe 側を先頭に戻す
+// build 側を maxRows 件ずつ読み込んでいる場合は、最初の maxRows 件からハッシュ表を作り直す
+// パーティションに分割している場合は、最初のパーティションからハッシュ表を作り直す
+func (hs *HashJoinScan) BeforeFirst() error {
+	hs.matches = nil
+	hs.current = nil
+	if !hs.partitioned {
+		if hs.chunkBuild != nil {
+			if err := hs.chunkBuild.BeforeFirst(); err != nil {
+				return err
+			}
+			overflow, err := hs.buildTable(hs.chunkBuild, false)
+			if err != nil {
+				return err
+			}
+			hs.chunkPending = overflow
+		}
+		return hs.probe.BeforeFirst()
+	}
+	if err := hs.closeInputs(); err != nil {
+		return err
+	}
+	hs.partIdx = 0
+	_, err := hs.openPartition()
+	return err
+}
+
+// Next は probe 側の現在のレコードと結合値が等しい build 側のレコードを順に返す
+// 無くなったら probe 側を進め、 probe 側が無くなったら build 側の次の maxRows 件か、次のパーティションに進む
+func (hs *HashJoinScan) Next() (bool, error) {
+	for {
+		if len(hs.matches) > 0 {
+			hs.current = hs.matches[0]
+			hs.matches = hs.matches[1:]
+			return true, nil
+		}
+		if hs.probe == nil {
+			return false, nil
+		}
+		ok, err := hs.probe.Next()
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			if hs.chunkPending {
+				if err := hs.nextChunk(); err != nil {
+					return false, err
+				}
+				continue
+			}
+			if !hs.partitioned {
+				return false, nil
+			}
+			if err := hs.closeInputs(); err != nil {
+				return false, err
+			}
+			hs.partIdx++
+			found, err := hs.openPartition()
+			if err != nil {
+				return false, err
+			}
+			if !found {
+				return false, nil
+			}
+			continue
+		}
+		key, err := hs.probe.GetVal(hs.probeField)
+		if err != nil {
+			return false, err
+		}
+		hs.matches = hs.lookup(key)
+	}
+}
+
+func (hs *HashJoinScan) GetInt(fieldName string) (int, error) {
+	v, err := hs.GetVal(fieldName)
+	if err != nil {
+		return 0, err
+	}
+	return v.AsInt(), nil
+}
+
+func (hs *HashJoinScan) GetString(fieldName string) (string, error) {
+	v, err := hs.GetVal(fieldName)
+	if err != nil {
+		return "", err
+	}
+	return v.AsString(), nil
+}
+
+func (hs *HashJoinScan) GetVal(fieldName string) (query.Constant, error) {
+	if i, ok := hs.fieldIndexes[fieldName]; ok {
+		return hs.current[i], nil
+	}
+	return hs.probe.GetVal(fieldName)
+}
+
+func (hs *HashJoinScan) HasField(fieldName string) bool {
+	return hs.buildSchema.HasField(fieldName) || hs.probeSchema.HasField(fieldName)
+}
+
+func (hs *HashJoinScan) Close() error {
+	hs.table = nil
+	return hs.closeInputs()
+}
+
+// closeInputs は開いている probe 側の scan と、 maxRows 件ずつ読み込んでいる build 側の scan を閉じる
+func (hs *HashJoinScan) closeInputs() error {
+	if hs.chunkBuild != nil {
+		if err := hs.chunkBuild.Close(); err != nil {
+			return err
+		}
+		hs.chunkBuild = nil
+		hs.chunkPending = false
+	}
+	if hs.probe == nil {
+		return nil
+	}
+	err := hs.probe.Close()
+	hs.probe = nil
+	return err
+}
+
+// nextChunk は build 側の次の maxRows 件をハッシュ表に読み込んで、 probe 側を先頭に戻す
+func (hs *HashJoinScan) nextChunk() error {
+	overflow, err := hs.buildTable(hs.chunkBuild, true)
+	if err != nil {
+		return err
+	}
+	hs.chunkPending = overflow
+	return hs.probe.BeforeFirst()
+}
+
+// canPartition は depth のパーティションをさらに分割できるかどうかを返す
+func (hs *HashJoinScan) canPartition(depth int) bool {
+	return depth < hashJoinMaxDepth && hs.fanout >= 2
+}
+
+// lookup は key と等しい結合値を持つ build 側のレコードを返す
+// ハッシュ値が衝突したレコードを除くために、値を比較する
+func (hs *HashJoinScan) lookup(key query.Constant) [][]query.Constant {
+	idx := hs.fieldIndexes[hs.buildField]
+	hs.matchBuf = hs.matchBuf[:0]
+	for _, row := range hs.table[key.HashCode()] {
+		if row[idx].Equals(key) {
+			hs.matchBuf = append(hs.matchBuf, row)
+		}
+	}
+	return hs.matchBuf
+}
+
+// buildTable は s のレコードを maxRows 件までハッシュ表に読み込む
+// pending が true の場合は、 s が指しているまだ読み込んでいないレコードから読み込む
+// maxRows を超える場合は true を返す。このとき s は読み込んでいないレコードを指している
+func (hs *HashJoinScan) buildTable(s query.Scanner, pending bool) (bool, error) {
+	hs.table = make(map[uint32][][]query.Constant)
+	n := 0
+	for {
+		if !pending {
+			ok, err := s.Next()
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				return false, nil
+			}
+		}
+		pending = false
+		if n >= hs.maxRows {
+			return true, nil
+		}
+		row, err := readRow(s, hs.buildSchema)
+		if err != nil {
+			return false, err
+		}
+		h := row[hs.fieldIndexes[hs.buildField]].HashCode()
+		hs.table[h] = append(hs.table[h], row)
+		n++
+	}
+}
+
+// partition はハッシュ表のレコードと build の残りのレコード、 probe の全てのレコードをパーティションに分割する
+// 同じ結合値のレコードが同じパーティションに入るように、深さごとに異なるシードでハッシュ値を混ぜる
+func (hs *HashJoinScan) partition(build query.Scanner, probe query.Scanner, depth int) ([]hashPartition, error) {
+	partitions := make([]hashPartition, hs.fanout)
+	for i := range partitions {
+		partitions[i] = hashPartition{
+			build: NewTemporaryTable(hs.tx, hs.buildSchema, hs.generator),
+			probe: NewTemporaryTable(hs.tx, hs.probeSchema, hs.generator),
+			depth: depth + 1,
+		}
+	}
+	seed := uint32(depth)
+
+	dests, err := openPartitionTables(partitions, func(p hashPartition) *TemporaryTable { return p.build })
+	if err != nil {
+		return nil, err
+	}
+	buildIdx := hs.fieldIndexes[hs.buildField]
+	for _, bucket := range hs.table {
+		for _, row := range bucket {
+			if err := writeRow(dests[hs.partitionOf(row[buildIdx], seed)], hs.buildSchema, row); err != nil {
+				return nil, err
+			}
+		}
+	}
+	hs.table = nil
+	// build はまだ読み込んでいないレコードを指している
+	for ok := true; ok; {
+		row, err := readRow(build, hs.buildSchema)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeRow(dests[hs.partitionOf(row[buildIdx], seed)], hs.buildSchema, row); err != nil {
+			return nil, err
+		}
+		ok, err = build.Next()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := closeScans(dests); err != nil {
+		return nil, err
+	}
+
+	dests, err = openPartitionTables(partitions, func(p hashPartition) *TemporaryTable { return p.probe })
+	if err != nil {
+		return nil, err
+	}
+	for {
+		ok, err := probe.Next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		row, err := readRow(probe, hs.probeSchema)
+		if err != nil {
+			return nil, err
+		}
+		key, err := probe.GetVal(hs.probeField)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeRow(dests[hs.partitionOf(key, seed)], hs.probeSchema, row); err != nil {
+			return nil, err
+		}
+	}
+	if err := closeScans(dests); err != nil {
+		return nil, err
+	}
+	return partitions, nil
+}
+
+func (hs *HashJoinScan) partitionOf(key query.Constant, seed uint32) int {
+	return int(hashes.Mix(key.HashCode(), seed) % uint32(hs.fanout))
+}
+
+// openPartition は partIdx 以降のパーティションからハッシュ表を作り、 probe 側のテーブルを開く
+// ハッシュ表に収まらないパーティションは、さらに分割したパーティションに置き換える
+// 深さの上限に達したパーティションは分割せずに、 build 側を maxRows 件ずつ読み込む
+func (hs *HashJoinScan) openPartition() (bool, error) {
+	for hs.partIdx < len(hs.partitions) {
+		p := hs.partitions[hs.partIdx]
+		bs, err := p.build.Open()
+		if err != nil {
+			return false, err
+		}
+		overflow, err := hs.buildTable(bs, false)
+		if err != nil {
+			return false, err
+		}
+		ps, err := p.probe.Open()
+		if err != nil {
+			return false, err
+		}
+		if !overflow {
+			if err := bs.Close(); err != nil {
+				return false, err
+			}
+			hs.probe = ps
+			return true, nil
+		}
+		if !hs.canPartition(p.depth) {
+			hs.chunkBuild = bs
+			hs.chunkPending = true
+			hs.probe = ps
+			return true, nil
+		}
+
+		subs, err := hs.partition(bs, ps, p.depth)
+		if err != nil {
+			return false, err
+		}
+		if err := bs.Close(); err != nil {
+			return false, err
+		}
+		if err := ps.Close(); err != nil {
+			return false, err
+		}
+		rest := append(subs, hs.partitions[hs.partIdx+1:]...)
+		hs.partitions = append(hs.partitions[:hs.partIdx], rest...)
+	}
+	return false, nil
+}
+
+func openPartitionTables(partitions []hashPartition, table func(hashPartition) *TemporaryTable) ([]query.UpdateScanner, error) {
+	scans := make([]query.UpdateScanner, len(partitions))
+	for i, p := range partitions {
+		s, err := table(p).Open()
+		if err != nil {
+			return nil, err
+		}
+		scans[i] = s
+	}
+	return scans, nil
+}
+
+func closeScans(scans []query.UpdateScanner) error {
+	for _, s := range scans {
+		if err := s.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readRow(s query.Scanner, schema *record.Schema) ([]query.Constant, error) {
+	fields := schema.Fields()
+	row := make([]query.Constant, len(fields))
+	for i, fn := range fields {
+		v, err := s.GetVal(fn)
+		if err != nil {
+			return nil, err
+		}
+		row[i] = v
+	}
+	return row, nil
+}
+
+func writeRow(dest query.UpdateScanner, schema *record.Schema, row []query.Constant) error {
+	if err := dest.Insert(); err != nil {
+		return err
+	}
+	for i, fn := range schema.Fields() {
+		if err := dest.SetVal(fn, row[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
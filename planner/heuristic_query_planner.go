@@ -27,7 +27,7 @@ func (hp *HeuristicQueryPlanner) CreatePlan(data *parser.QueryData, tx *tx.Trans
 	}
 
 	// step2: Choose the lowest-size plan to begin the join order
-	currentPlan, err := hp.getLowestSelectPlan()
+	currentPlan, err := hp.getLowestSelectPlan(data)
 	if err != nil {
 		return nil, err
 	}
@@ -46,14 +46,34 @@ func (hp *HeuristicQueryPlanner) CreatePlan(data *parser.QueryData, tx *tx.Trans
 			currentPlan = newP
 		}
 	}
+	currentPlan, err = groupPlan(tx, currentPlan, data, hp.generator)
+	if err != nil {
+		return nil, err
+	}
+	currentPlan, err = extendPlan(currentPlan, data.Expressions())
+	if err != nil {
+		return nil, err
+	}
+	currentPlan, err = orderPlan(tx, currentPlan, data.OrderBy(), hp.generator)
+	if err != nil {
+		return nil, err
+	}
 	return NewProjectPlan(currentPlan, data.Fields())
 }
 
-func (hp *HeuristicQueryPlanner) getLowestSelectPlan() (Planner, error) {
+// getLowestSelectPlan は RecordsOutput が最小の select plan を返す
+// テーブルが1つの場合は、インデックスを ORDER BY の順に読む plan とインデックスだけを読む plan も候補にする
+func (hp *HeuristicQueryPlanner) getLowestSelectPlan(data *parser.QueryData) (Planner, error) {
 	var bestTPIndex int
 	var bestPlan Planner
 	for i, tp := range hp.tps {
-		plan, err := tp.MakeSelectPlan()
+		var plan Planner
+		var err error
+		if len(hp.tps) == 1 {
+			plan, err = tp.MakeSingleTablePlan(referencedFields(data), scanOrder(data))
+		} else {
+			plan, err = tp.MakeSelectPlan()
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -75,6 +95,10 @@ func (hp *HeuristicQueryPlanner) getLowestJoinPlan(p Planner) (Planner, error) {
 		if err != nil {
 			return nil, err
 		}
+		// 結合条件がないテーブルは直積の候補にする
+		if plan == nil {
+			continue
+		}
 		if bestPlan == nil || plan.RecordsOutput() < bestPlan.RecordsOutput() {
 			bestTPIndex = i
 			bestPlan = plan
@@ -0,0 +1,163 @@
+package planner
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ksrnnb/go-rdb/index"
+	"github.com/ksrnnb/go-rdb/metadata"
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/sqlstate"
+	"github.com/ksrnnb/go-rdb/tx"
+)
+
+// CheckIndexes はテーブルの全てのインデックスについて、インデックスレコードがテーブルのレコードと一致するかを確認する
+// キーの値が存在するレコードのキーごとに、同じキーと rid のインデックスレコードがちょうど1つあり、それ以外のインデックスレコードがなければ一致する
+// 一致しない場合は、最初に見つかった食い違いを IndexCorrupted のエラーで返す
+func CheckIndexes(mdm *metadata.MetadataManager, tableName string, tx *tx.Transaction) error {
+	p, err := NewTablePlan(tx, tableName, mdm)
+	if err != nil {
+		return err
+	}
+	indexes, err := mdm.GetIndexInfo(tableName, tx)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(indexes))
+	for name := range indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := checkIndex(indexes[name], p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkIndex はテーブルの全てのレコードのキーを集めてから、インデックスの全てのレコードと突き合わせる
+func checkIndex(ii *metadata.IndexInfo, p *TablePlan) error {
+	rids, keys, err := tableKeys(ii, p)
+	if err != nil {
+		return err
+	}
+	idx, err := ii.Open()
+	if err != nil {
+		return err
+	}
+	fs, ok := idx.(index.FullScanIndex)
+	if !ok {
+		if err := idx.Close(); err != nil {
+			return err
+		}
+		return fmt.Errorf("index %s cannot be scanned", ii.IndexName())
+	}
+	if err := fs.BeforeFirstAll(); err != nil {
+		return err
+	}
+	hasNext, err := fs.Next()
+	if err != nil {
+		return err
+	}
+	for hasNext {
+		rid, err := fs.GetDataRid()
+		if err != nil {
+			return err
+		}
+		key, err := fs.GetDataVal()
+		if err != nil {
+			return err
+		}
+		want, ok := keys[rid.String()]
+		if !ok {
+			if err := fs.Close(); err != nil {
+				return err
+			}
+			return sqlstate.Errorf(sqlstate.IndexCorrupted, "index %s has an entry %s for record %s that is not in table %s", ii.IndexName(), key, rid, p.tableName)
+		}
+		i := indexOfKey(want, key)
+		if i < 0 {
+			if err := fs.Close(); err != nil {
+				return err
+			}
+			return sqlstate.Errorf(sqlstate.IndexCorrupted, "index %s has key %s for record %s, but the record has %s", ii.IndexName(), key, rid, want[0])
+		}
+		// 同じレコードを指す余分なインデックスレコードは、テーブルにないレコードとして見つかる
+		want = append(want[:i], want[i+1:]...)
+		if len(want) == 0 {
+			delete(keys, rid.String())
+		} else {
+			keys[rid.String()] = want
+		}
+		hasNext, err = fs.Next()
+		if err != nil {
+			return err
+		}
+	}
+	if err := fs.Close(); err != nil {
+		return err
+	}
+	for _, rid := range rids {
+		if want, ok := keys[rid]; ok {
+			return sqlstate.Errorf(sqlstate.IndexCorrupted, "index %s has no entry %s for record %s", ii.IndexName(), want[0], rid)
+		}
+	}
+	return nil
+}
+
+// indexOfKey は keys の中で key と等しいキーの位置を返し、ない場合は -1 を返す
+func indexOfKey(keys []query.Constant, key query.Constant) int {
+	for i, k := range keys {
+		if k.Equals(key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// tableKeys はテーブルのレコードの rid を走査した順に返し、 rid ごとにインデックスに保存されるキーを返す
+// 全文検索のインデックスは1つのレコードに語の数だけキーがある
+// キーの値が存在しないレコードはインデックスに登録しないので含めない
+func tableKeys(ii *metadata.IndexInfo, p *TablePlan) ([]string, map[string][]query.Constant, error) {
+	s, err := p.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	ts, ok := s.(query.UpdateScanner)
+	if !ok {
+		return nil, nil, errors.New("invalid Scanner")
+	}
+	rids := make([]string, 0)
+	keys := make(map[string][]query.Constant)
+	hasNext, err := ts.Next()
+	if err != nil {
+		return nil, nil, err
+	}
+	for hasNext {
+		vals, err := ii.IndexKeys(ts)
+		if err != nil {
+			return nil, nil, err
+		}
+		want := make([]query.Constant, 0, len(vals))
+		for _, v := range vals {
+			if !v.IsUnknown() {
+				want = append(want, index.NormalizeKey(ii.Layout(), v))
+			}
+		}
+		if len(want) > 0 {
+			rid, err := ts.GetRid()
+			if err != nil {
+				return nil, nil, err
+			}
+			rids = append(rids, rid.String())
+			keys[rid.String()] = want
+		}
+		hasNext, err = ts.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return rids, keys, ts.Close()
+}
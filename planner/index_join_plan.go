@@ -21,7 +21,7 @@ func NewIndexJoinPlan(p1 Planner, p2 Planner, ii *metadata.IndexInfo, joinField
 	if err := schema.AddAll(p1.Schema()); err != nil {
 		return nil, err
 	}
-	if err := schema.AddAll(p1.Schema()); err != nil {
+	if err := schema.AddAll(p2.Schema()); err != nil {
 		return nil, err
 	}
 	return &IndexJoinPlan{p1, p2, ii, joinField, schema}, nil
@@ -65,3 +65,8 @@ func (ijp *IndexJoinPlan) DistinctValues(fieldName string) int {
 func (ijp *IndexJoinPlan) Schema() *record.Schema {
 	return ijp.schema
 }
+
+func (ijp *IndexJoinPlan) Explain() *PlanNode {
+	props := map[string]string{"index": ijp.ii.IndexName(), "key": ijp.ii.Key(), "join_field": ijp.joinField}
+	return newPlanNode(ijp, "IndexJoin", props, ijp.p1, ijp.p2)
+}
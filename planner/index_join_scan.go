@@ -10,10 +10,12 @@ type IndexJoinScan struct {
 	rhs       *query.TableScan
 	idx       index.Index
 	joinField string
+	// lhs のレコードを全て読み終えたかどうか
+	done bool
 }
 
 func NewIndexJoinScan(lhs query.Scanner, idx index.Index, joinField string, rhs *query.TableScan) (*IndexJoinScan, error) {
-	ijs := &IndexJoinScan{lhs, rhs, idx, joinField}
+	ijs := &IndexJoinScan{lhs: lhs, rhs: rhs, idx: idx, joinField: joinField}
 	if err := ijs.BeforeFirst(); err != nil {
 		return nil, err
 	}
@@ -24,13 +26,21 @@ func (ijs *IndexJoinScan) BeforeFirst() error {
 	if err := ijs.lhs.BeforeFirst(); err != nil {
 		return err
 	}
-	if _, err := ijs.lhs.Next(); err != nil {
+	hasNext, err := ijs.lhs.Next()
+	if err != nil {
 		return err
 	}
+	ijs.done = !hasNext
+	if ijs.done {
+		return nil
+	}
 	return ijs.resetIndex()
 }
 
 func (ijs *IndexJoinScan) Next() (bool, error) {
+	if ijs.done {
+		return false, nil
+	}
 	for {
 		hasNext, err := ijs.idx.Next()
 		if err != nil {
@@ -52,6 +62,7 @@ func (ijs *IndexJoinScan) Next() (bool, error) {
 			return false, err
 		}
 		if !hasNext {
+			ijs.done = true
 			return false, nil
 		}
 		err = ijs.resetIndex()
@@ -0,0 +1,79 @@
+package planner
+
+import (
+	"github.com/ksrnnb/go-rdb/metadata"
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/record"
+)
+
+// IndexOnlyPlan はデータレコードを読まずに、インデックスのキーが r の範囲に含まれるキーだけを返す plan
+// クエリが参照するフィールドがインデックスのキーだけの場合に使う
+type IndexOnlyPlan struct {
+	p          Planner
+	ii         *metadata.IndexInfo
+	r          query.Range
+	descending bool
+	schema     *record.Schema
+}
+
+// NewIndexOnlyPlan はインデックスのキーのフィールドだけをもつ IndexOnlyPlan を返す
+// p はキーのフィールドの統計情報を得るためのテーブルの plan で、 Open はしない
+func NewIndexOnlyPlan(p Planner, ii *metadata.IndexInfo, r query.Range, descending bool) (*IndexOnlyPlan, error) {
+	schema := record.NewSchema()
+	if err := schema.Add(ii.Key(), p.Schema()); err != nil {
+		return nil, err
+	}
+	return &IndexOnlyPlan{p, ii, r, descending, schema}, nil
+}
+
+func (iop *IndexOnlyPlan) Open() (query.Scanner, error) {
+	idx, err := iop.ii.Open()
+	if err != nil {
+		return nil, err
+	}
+	return NewIndexOnlyScan(idx, iop.ii.Key(), iop.r, iop.descending)
+}
+
+func (iop *IndexOnlyPlan) BlocksAccessed() int {
+	return iop.ii.IndexOnlyBlocksAccessed(iop.r)
+}
+
+func (iop *IndexOnlyPlan) RecordsOutput() int {
+	return iop.ii.RangeRecordsOutput(iop.r)
+}
+
+func (iop *IndexOnlyPlan) DistinctValues(fieldName string) int {
+	if iop.r.IsPoint() {
+		return 1
+	}
+	v := iop.p.DistinctValues(fieldName)
+	if n := iop.RecordsOutput(); n < v {
+		return n
+	}
+	return v
+}
+
+func (iop *IndexOnlyPlan) Schema() *record.Schema {
+	return iop.schema
+}
+
+// IsOrderedBy は fieldName の順にキーを返すかどうかを返す
+func (iop *IndexOnlyPlan) IsOrderedBy(fieldName string, descending bool) bool {
+	if iop.ii.Key() != fieldName {
+		return false
+	}
+	return iop.r.IsPoint() || iop.descending == descending
+}
+
+func (iop *IndexOnlyPlan) Explain() *PlanNode {
+	props := map[string]string{"index": iop.ii.IndexName(), "key": iop.ii.Key()}
+	if iop.r.IsPoint() {
+		props["value"] = iop.r.Low().Value().String()
+	} else {
+		props["range"] = iop.r.String()
+	}
+	if iop.descending {
+		props["direction"] = "desc"
+	}
+	return newPlanNode(iop, "IndexOnly", props)
+}
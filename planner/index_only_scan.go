@@ -0,0 +1,76 @@
+package planner
+
+import (
+	"fmt"
+
+	"github.com/ksrnnb/go-rdb/index"
+	"github.com/ksrnnb/go-rdb/query"
+)
+
+// IndexOnlyScan はインデックスレコードの data_value を fieldName の値として返す scan
+type IndexOnlyScan struct {
+	idx        index.CoveringIndex
+	fieldName  string
+	r          query.Range
+	descending bool
+}
+
+// NewIndexOnlyScan は idx のキーが r の範囲に含まれるインデックスレコードを走査する
+// 1つの値の範囲以外はインデックスが index.RangeIndex である必要がある
+func NewIndexOnlyScan(idx index.Index, fieldName string, r query.Range, descending bool) (*IndexOnlyScan, error) {
+	ci, ok := idx.(index.CoveringIndex)
+	if !ok {
+		return nil, fmt.Errorf("index %T cannot return keys", idx)
+	}
+	ios := &IndexOnlyScan{ci, fieldName, r, descending}
+	if err := ios.BeforeFirst(); err != nil {
+		return nil, err
+	}
+	return ios, nil
+}
+
+func (ios *IndexOnlyScan) BeforeFirst() error {
+	if ios.r.IsPoint() {
+		return ios.idx.BeforeFirst(ios.r.Low().Value())
+	}
+	ri, ok := ios.idx.(index.RangeIndex)
+	if !ok {
+		return fmt.Errorf("index %T does not support range scans", ios.idx)
+	}
+	return ri.BeforeFirstRange(ios.r, ios.descending)
+}
+
+func (ios *IndexOnlyScan) Next() (bool, error) {
+	return ios.idx.Next()
+}
+
+func (ios *IndexOnlyScan) GetInt(fieldName string) (int, error) {
+	v, err := ios.GetVal(fieldName)
+	if err != nil {
+		return 0, err
+	}
+	return v.AsInt(), nil
+}
+
+func (ios *IndexOnlyScan) GetString(fieldName string) (string, error) {
+	v, err := ios.GetVal(fieldName)
+	if err != nil {
+		return "", err
+	}
+	return v.AsString(), nil
+}
+
+func (ios *IndexOnlyScan) GetVal(fieldName string) (query.Constant, error) {
+	if fieldName != ios.fieldName {
+		return query.Constant{}, fmt.Errorf("no field %s", fieldName)
+	}
+	return ios.idx.GetDataVal()
+}
+
+func (ios *IndexOnlyScan) HasField(fieldName string) bool {
+	return fieldName == ios.fieldName
+}
+
+func (ios *IndexOnlyScan) Close() error {
+	return ios.idx.Close()
+}
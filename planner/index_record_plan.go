@@ -0,0 +1,49 @@
+package planner
+
+import (
+	"github.com/ksrnnb/go-rdb/metadata"
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/record"
+)
+
+// IndexRecordPlan はテーブルの各レコードから、インデックスに登録するインデックスレコードを作る
+// CREATE INDEX で既存のレコードをインデックスに登録するときに、キーの順にソートする入力として使う
+type IndexRecordPlan struct {
+	p  Planner
+	ii *metadata.IndexInfo
+}
+
+// NewIndexRecordPlan は p のレコードから ii のインデックスレコードを作る plan を返す
+// p はデータレコードの ID を返せるように、テーブルの plan である必要がある
+func NewIndexRecordPlan(p Planner, ii *metadata.IndexInfo) *IndexRecordPlan {
+	return &IndexRecordPlan{p, ii}
+}
+
+func (irp *IndexRecordPlan) Open() (query.Scanner, error) {
+	s, err := irp.p.Open()
+	if err != nil {
+		return nil, err
+	}
+	return NewIndexRecordScan(s, irp.ii)
+}
+
+func (irp *IndexRecordPlan) BlocksAccessed() int {
+	return irp.p.BlocksAccessed()
+}
+
+func (irp *IndexRecordPlan) RecordsOutput() int {
+	return irp.p.RecordsOutput()
+}
+
+func (irp *IndexRecordPlan) DistinctValues(fieldName string) int {
+	return irp.ii.DistinctValues(fieldName)
+}
+
+// Schema はインデックスレコードのスキーマを返す
+func (irp *IndexRecordPlan) Schema() *record.Schema {
+	return irp.ii.Layout().Schema()
+}
+
+func (irp *IndexRecordPlan) Explain() *PlanNode {
+	return newPlanNode(irp, "IndexRecord", map[string]string{"index": irp.ii.IndexName(), "key": irp.ii.Key()}, irp.p)
+}
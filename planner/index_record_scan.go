@@ -0,0 +1,109 @@
+package planner
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ksrnnb/go-rdb/index"
+	"github.com/ksrnnb/go-rdb/metadata"
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/record"
+)
+
+// IndexRecordScan はテーブルの現在のレコードを、インデックスレコードのフィールドとして返す scan
+// キーの値が存在しないレコードはインデックスに登録しないので読み飛ばす
+type IndexRecordScan struct {
+	s   query.UpdateScanner
+	ii  *metadata.IndexInfo
+	rid *record.RecordID
+	// vals は現在のレコードのキーを、キーを保存するフィールドごとに分けた値
+	vals map[string]query.Constant
+}
+
+func NewIndexRecordScan(s query.Scanner, ii *metadata.IndexInfo) (*IndexRecordScan, error) {
+	us, ok := s.(query.UpdateScanner)
+	if !ok {
+		return nil, errors.New("invalid Scanner")
+	}
+	return &IndexRecordScan{s: us, ii: ii}, nil
+}
+
+func (irs *IndexRecordScan) BeforeFirst() error {
+	return irs.s.BeforeFirst()
+}
+
+func (irs *IndexRecordScan) Next() (bool, error) {
+	for {
+		hasNext, err := irs.s.Next()
+		if err != nil || !hasNext {
+			return false, err
+		}
+		key, err := irs.ii.KeyValue(irs.s)
+		if err != nil {
+			return false, err
+		}
+		if key.IsUnknown() {
+			continue
+		}
+		rid, err := irs.s.GetRid()
+		if err != nil {
+			return false, err
+		}
+		irs.rid = rid
+		irs.vals = keyValues(irs.ii.Layout(), key)
+		return true, nil
+	}
+}
+
+// keyValues はキーを Insert と同じように正規化して、キーを保存するフィールドごとの値に分ける
+func keyValues(layout *record.Layout, key query.Constant) map[string]query.Constant {
+	key = index.NormalizeKey(layout, key)
+	keyFields := index.DataValueFields(layout.Schema())
+	vals := make(map[string]query.Constant, len(keyFields))
+	if len(keyFields) == 1 {
+		vals[keyFields[0]] = key
+		return vals
+	}
+	for i, v := range key.AsTuple() {
+		vals[keyFields[i]] = v
+	}
+	return vals
+}
+
+func (irs *IndexRecordScan) GetInt(fieldName string) (int, error) {
+	v, err := irs.GetVal(fieldName)
+	if err != nil {
+		return 0, err
+	}
+	return v.AsInt(), nil
+}
+
+func (irs *IndexRecordScan) GetString(fieldName string) (string, error) {
+	v, err := irs.GetVal(fieldName)
+	if err != nil {
+		return "", err
+	}
+	return v.AsString(), nil
+}
+
+func (irs *IndexRecordScan) GetVal(fieldName string) (query.Constant, error) {
+	switch fieldName {
+	case index.IndexIdField:
+		return query.NewConstant(irs.rid.Slot()), nil
+	case index.IndexBlockNumberField:
+		return query.NewConstant(irs.rid.BlockNumber()), nil
+	}
+	v, ok := irs.vals[fieldName]
+	if !ok {
+		return query.Constant{}, fmt.Errorf("no field %s", fieldName)
+	}
+	return v, nil
+}
+
+func (irs *IndexRecordScan) HasField(fieldName string) bool {
+	return irs.ii.Layout().Schema().HasField(fieldName)
+}
+
+func (irs *IndexRecordScan) Close() error {
+	return irs.s.Close()
+}
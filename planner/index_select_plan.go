@@ -8,14 +8,22 @@ import (
 	"github.com/ksrnnb/go-rdb/record"
 )
 
+// IndexSelectPlan はインデックスのキーが r の範囲に含まれるレコードを、キーの順に返す plan
+// descending が true の場合は大きいキーから順に返す
 type IndexSelectPlan struct {
-	p   Planner
-	ii  *metadata.IndexInfo
-	val query.Constant
+	p          Planner
+	ii         *metadata.IndexInfo
+	r          query.Range
+	descending bool
 }
 
 func NewIndexSelectPlan(p Planner, ii *metadata.IndexInfo, val query.Constant) *IndexSelectPlan {
-	return &IndexSelectPlan{p, ii, val}
+	return &IndexSelectPlan{p, ii, query.NewPointRange(val), false}
+}
+
+// NewIndexRangeSelectPlan はキーが r の範囲に含まれるレコードを返す IndexSelectPlan を返す
+func NewIndexRangeSelectPlan(p Planner, ii *metadata.IndexInfo, r query.Range, descending bool) *IndexSelectPlan {
+	return &IndexSelectPlan{p, ii, r, descending}
 }
 
 func (isp *IndexSelectPlan) Open() (query.Scanner, error) {
@@ -31,21 +39,51 @@ func (isp *IndexSelectPlan) Open() (query.Scanner, error) {
 	if err != nil {
 		return nil, err
 	}
-	return NewIndexSelectScan(ts, idx, isp.val)
+	return NewIndexRangeSelectScan(ts, idx, isp.r, isp.descending)
 }
 
+// BlocksAccessed は範囲のインデックスレコードを読むブロック数に、データレコードごとに1ブロック読む数を足して見積もる
 func (isp *IndexSelectPlan) BlocksAccessed() int {
-	return isp.ii.BlocksAccessed() + isp.RecordsOutput()
+	return isp.ii.RangeBlocksAccessed(isp.r) + isp.RecordsOutput()
 }
 
 func (isp *IndexSelectPlan) RecordsOutput() int {
-	return isp.ii.RecordsOutput()
+	return isp.ii.RangeRecordsOutput(isp.r)
 }
 
 func (isp *IndexSelectPlan) DistinctValues(fieldName string) int {
-	return isp.ii.DistinctValues(fieldName)
+	if isp.r.IsPoint() {
+		return isp.ii.DistinctValues(fieldName)
+	}
+	v := isp.p.DistinctValues(fieldName)
+	if n := isp.RecordsOutput(); n < v {
+		return n
+	}
+	return v
 }
 
 func (isp *IndexSelectPlan) Schema() *record.Schema {
 	return isp.p.Schema()
 }
+
+// IsOrderedBy は fieldName の順にレコードを返すかどうかを返す
+// 1つの値の範囲は全て同じ値なので、どちらの向きでも順に並んでいる
+func (isp *IndexSelectPlan) IsOrderedBy(fieldName string, descending bool) bool {
+	if isp.ii.Key() != fieldName || !isp.ii.IsOrdered() {
+		return false
+	}
+	return isp.r.IsPoint() || isp.descending == descending
+}
+
+func (isp *IndexSelectPlan) Explain() *PlanNode {
+	props := map[string]string{"index": isp.ii.IndexName(), "key": isp.ii.Key()}
+	if isp.r.IsPoint() {
+		props["value"] = isp.r.Low().Value().String()
+	} else {
+		props["range"] = isp.r.String()
+	}
+	if isp.descending {
+		props["direction"] = "desc"
+	}
+	return newPlanNode(isp, "IndexSelect", props, isp.p)
+}
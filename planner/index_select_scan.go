@@ -1,18 +1,27 @@
 package planner
 
 import (
+	"fmt"
+
 	"github.com/ksrnnb/go-rdb/index"
 	"github.com/ksrnnb/go-rdb/query"
 )
 
 type IndexSelectScan struct {
-	ts  *query.TableScan
-	idx index.Index
-	val query.Constant
+	ts         *query.TableScan
+	idx        index.Index
+	r          query.Range
+	descending bool
 }
 
 func NewIndexSelectScan(ts *query.TableScan, idx index.Index, val query.Constant) (*IndexSelectScan, error) {
-	iss := &IndexSelectScan{ts, idx, val}
+	return NewIndexRangeSelectScan(ts, idx, query.NewPointRange(val), false)
+}
+
+// NewIndexRangeSelectScan はキーが r の範囲に含まれるレコードを返す IndexSelectScan を返す
+// 1つの値の範囲以外はインデックスが index.RangeIndex である必要がある
+func NewIndexRangeSelectScan(ts *query.TableScan, idx index.Index, r query.Range, descending bool) (*IndexSelectScan, error) {
+	iss := &IndexSelectScan{ts, idx, r, descending}
 	if err := iss.BeforeFirst(); err != nil {
 		return nil, err
 	}
@@ -20,9 +29,15 @@ func NewIndexSelectScan(ts *query.TableScan, idx index.Index, val query.Constant
 }
 
 func (iss *IndexSelectScan) BeforeFirst() error {
-	return iss.idx.BeforeFirst(iss.val)
+	if iss.r.IsPoint() {
+		return iss.idx.BeforeFirst(iss.r.Low().Value())
+	}
+	ri, ok := iss.idx.(index.RangeIndex)
+	if !ok {
+		return fmt.Errorf("index %T does not support range scans", iss.idx)
+	}
+	return ri.BeforeFirstRange(iss.r, iss.descending)
 }
-
 func (iss *IndexSelectScan) Next() (bool, error) {
 	hasNext, err := iss.idx.Next()
 	if err != nil {
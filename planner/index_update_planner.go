@@ -2,20 +2,24 @@ package planner
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/ksrnnb/go-rdb/index"
 	"github.com/ksrnnb/go-rdb/metadata"
 	"github.com/ksrnnb/go-rdb/parser"
 	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/record"
+	"github.com/ksrnnb/go-rdb/sqlstate"
 	"github.com/ksrnnb/go-rdb/tx"
 )
 
 type IndexUpdatePlanner struct {
-	mdm *metadata.MetadataManager
+	mdm       *metadata.MetadataManager
+	generator *NextTableNameGenerator
 }
 
 func NewIndexUpdatePlanner(mdm *metadata.MetadataManager) *IndexUpdatePlanner {
-	return &IndexUpdatePlanner{mdm}
+	return &IndexUpdatePlanner{mdm, NewNextTableNameGenerator()}
 }
 
 func (iup *IndexUpdatePlanner) ExecuteInsert(data *parser.InsertData, tx *tx.Transaction) (int, error) {
@@ -38,30 +42,29 @@ func (iup *IndexUpdatePlanner) ExecuteInsert(data *parser.InsertData, tx *tx.Tra
 		return 0, err
 	}
 
-	indexes, err := iup.mdm.GetIndexInfo(tn, tx)
-	if err != nil {
-		return 0, err
-	}
 	values := data.Values()
 	for i, fn := range data.Fields() {
-		val := values[i]
-
-		if err := us.SetVal(fn, val); err != nil {
-			return 0, err
-		}
-		ii := indexes[fn]
-		if ii == nil {
-			continue
+		if err := us.SetVal(fn, values[i]); err != nil {
+			return 0, abortInsert(us, err)
 		}
+	}
+	if err := setDefaultValues(us, p.Schema(), data); err != nil {
+		return 0, abortInsert(us, err)
+	}
 
-		idx, err := ii.Open()
-		if err != nil {
-			return 0, err
-		}
-		if err := idx.Insert(val, rid); err != nil {
-			return 0, err
+	// 式のインデックスは複数のフィールドを参照できるので、全ての値を設定してから評価する
+	indexes, err := iup.mdm.GetIndexInfo(tn, tx)
+	if err != nil {
+		return 0, err
+	}
+	// インデックスレコードを追加する前に全てのユニークインデックスを確認して、違反する場合はレコードを残さない
+	for _, ii := range indexes {
+		if err := checkUnique(ii, p, us, rid); err != nil {
+			return 0, abortInsert(us, err)
 		}
-		if err := idx.Close(); err != nil {
+	}
+	for _, ii := range indexes {
+		if err := insertIndexRecord(ii, us, rid); err != nil {
 			return 0, err
 		}
 	}
@@ -100,19 +103,12 @@ func (iup *IndexUpdatePlanner) ExecuteDelete(data *parser.DeleteData, tx *tx.Tra
 		if err != nil {
 			return 0, err
 		}
-		for fn, ii := range indexes {
-			val, err := us.GetVal(fn)
+		for _, ii := range indexes {
+			val, err := ii.KeyValue(us)
 			if err != nil {
 				return 0, err
 			}
-			idx, err := ii.Open()
-			if err != nil {
-				return 0, err
-			}
-			if err = idx.Delete(val, rid); err != nil {
-				return 0, err
-			}
-			if err = idx.Close(); err != nil {
+			if err := deleteIndexRecord(ii, val, rid); err != nil {
 				return 0, err
 			}
 		}
@@ -153,12 +149,11 @@ func (iup *IndexUpdatePlanner) ExecuteModify(data *parser.ModifyData, tx *tx.Tra
 	if err != nil {
 		return 0, err
 	}
-	ii := indexes[fn]
-	var idx index.Index
-	if ii != nil {
-		idx, err = ii.Open()
-		if err != nil {
-			return 0, err
+	// 更新するフィールドを参照しているインデックスのみ更新する
+	targets := make([]*metadata.IndexInfo, 0)
+	for _, ii := range indexes {
+		if ii.RefersTo(fn) {
+			targets = append(targets, ii)
 		}
 	}
 	hasNext, err := us.Next()
@@ -167,6 +162,22 @@ func (iup *IndexUpdatePlanner) ExecuteModify(data *parser.ModifyData, tx *tx.Tra
 	}
 	count := 0
 	for hasNext {
+		rid, err := us.GetRid()
+		if err != nil {
+			return 0, err
+		}
+		oldVals := make([]query.Constant, len(targets))
+		for i, ii := range targets {
+			oldVals[i], err = ii.KeyValue(us)
+			if err != nil {
+				return 0, err
+			}
+		}
+		oldFieldVal, err := us.GetVal(fn)
+		if err != nil {
+			return 0, err
+		}
+
 		newVal, err := data.NewValue().Evaluate(us)
 		if err != nil {
 			return 0, err
@@ -174,21 +185,22 @@ func (iup *IndexUpdatePlanner) ExecuteModify(data *parser.ModifyData, tx *tx.Tra
 		if err := us.SetVal(fn, newVal); err != nil {
 			return 0, err
 		}
-
-		// then update the appropriate index, if it exists
-		if idx != nil {
-			oldVal, err := us.GetVal(fn)
-			if err != nil {
-				return 0, err
-			}
-			rid, err := us.GetRid()
-			if err != nil {
+		// 新しい値がユニークインデックスに違反する場合は、インデックスと食い違わないように元の値に戻す
+		for _, ii := range targets {
+			if err := checkUnique(ii, p, us, rid); err != nil {
+				if rerr := us.SetVal(fn, oldFieldVal); rerr != nil {
+					return 0, rerr
+				}
 				return 0, err
 			}
-			if err := idx.Delete(oldVal, rid); err != nil {
+		}
+
+		// then update the appropriate index, if it exists
+		for i, ii := range targets {
+			if err := deleteIndexRecord(ii, oldVals[i], rid); err != nil {
 				return 0, err
 			}
-			if err := idx.Insert(newVal, rid); err != nil {
+			if err := insertIndexRecord(ii, us, rid); err != nil {
 				return 0, err
 			}
 		}
@@ -199,11 +211,6 @@ func (iup *IndexUpdatePlanner) ExecuteModify(data *parser.ModifyData, tx *tx.Tra
 		}
 		hasNext = newHasNext
 	}
-	if idx != nil {
-		if err := idx.Close(); err != nil {
-			return 0, err
-		}
-	}
 	if err := us.Close(); err != nil {
 		return 0, err
 	}
@@ -218,6 +225,211 @@ func (iup *IndexUpdatePlanner) ExecuteCreateView(data *parser.CreateViewData, tx
 	return 0, iup.mdm.CreateView(data.ViewName(), data.ViewDefinition(), tx)
 }
 
+// ExecuteCreateIndex はインデックスをカタログに登録して、テーブルにある全てのレコードをインデックスに登録する
+// まとめて構築できるインデックスはキーの順にソートしてから構築して、それ以外は1件ずつ追加する
 func (iup *IndexUpdatePlanner) ExecuteCreateIndex(data *parser.CreateIndexData, tx *tx.Transaction) (int, error) {
-	return 0, iup.mdm.CreateIndex(data.IndexName(), data.TableName(), data.FieldName(), tx)
+	if err := iup.mdm.CreateIndex(data.IndexName(), data.TableName(), data.FieldName(), data.Method(), data.PredicateString(), data.IsUnique(), tx); err != nil {
+		return 0, err
+	}
+	indexes, err := iup.mdm.GetIndexInfo(data.TableName(), tx)
+	if err != nil {
+		return 0, err
+	}
+	var ii *metadata.IndexInfo
+	for _, i := range indexes {
+		if i.IndexName() == data.IndexName() {
+			ii = i
+		}
+	}
+	if ii == nil {
+		return 0, fmt.Errorf("index %s is not found", data.IndexName())
+	}
+	p, err := NewTablePlan(tx, data.TableName(), iup.mdm)
+	if err != nil {
+		return 0, err
+	}
+
+	idx, err := ii.Open()
+	if err != nil {
+		return 0, err
+	}
+	// キーを切り詰めるユニークインデックスは、キーが同じレコードの値を比べながら1件ずつ追加する
+	bl, ok := idx.(index.BulkLoader)
+	if !ok || (ii.IsUnique() && ii.TruncatesKey()) {
+		if err := idx.Close(); err != nil {
+			return 0, err
+		}
+		return 0, insertIndexRecords(ii, p)
+	}
+	rp := NewIndexRecordPlan(p, ii)
+	sp := NewSortPlan(tx, index.DataValueFields(rp.Schema()), rp, iup.generator)
+	s, err := sp.Open()
+	if err != nil {
+		return 0, err
+	}
+	if err := bl.BulkLoad(s, ii.IsUnique()); err != nil {
+		return 0, err
+	}
+	if err := s.Close(); err != nil {
+		return 0, err
+	}
+	return 0, bl.Close()
+}
+
+// insertIndexRecords は p の全てのレコードを1件ずつインデックスに追加する
+// p はテーブルの plan
+func insertIndexRecords(ii *metadata.IndexInfo, p Planner) error {
+	s, err := p.Open()
+	if err != nil {
+		return err
+	}
+	us, ok := s.(query.UpdateScanner)
+	if !ok {
+		return errors.New("invalid Scanner")
+	}
+	hasNext, err := us.Next()
+	if err != nil {
+		return err
+	}
+	for hasNext {
+		rid, err := us.GetRid()
+		if err != nil {
+			return err
+		}
+		if err := checkUnique(ii, p, us, rid); err != nil {
+			return err
+		}
+		if err := insertIndexRecord(ii, us, rid); err != nil {
+			return err
+		}
+		hasNext, err = us.Next()
+		if err != nil {
+			return err
+		}
+	}
+	return us.Close()
+}
+
+// insertIndexRecord は現在のレコードでインデックスの式を評価して、インデックスレコードを追加する
+// 式の値が存在しない (JSON のパスが存在しないなど) 場合は追加しない
+// 複合インデックスは値が存在しない列が1つでもあれば追加しない
+func insertIndexRecord(ii *metadata.IndexInfo, s query.Scanner, rid *record.RecordID) error {
+	val, err := ii.KeyValue(s)
+	if err != nil {
+		return err
+	}
+	if val.IsUnknown() {
+		return nil
+	}
+	idx, err := ii.Open()
+	if err != nil {
+		return err
+	}
+	if err := idx.Insert(val, rid); err != nil {
+		return err
+	}
+	return idx.Close()
+}
+
+// checkUnique は ii がユニークインデックスの場合に、現在のレコードのキーが rid 以外のレコードで登録されていないことを確認する
+// B-tree は検索したキーの範囲を受け持つ leaf の共有ロックをコミットまで保持するので、他のトランザクションは同じキーを追加できない
+// 他のトランザクションが追加したコミット前のキーは、追加したトランザクションが leaf の排他ロックを外すまで待ってから確認する
+// キーを切り詰めるインデックスは、キーが同じでも値が違うことがあるので、テーブルの plan の p からデータレコードを読んで値を比べる
+func checkUnique(ii *metadata.IndexInfo, p Planner, s query.Scanner, rid *record.RecordID) error {
+	if !ii.IsUnique() {
+		return nil
+	}
+	val, err := ii.KeyValue(s)
+	if err != nil {
+		return err
+	}
+	// 値が存在しないキーはインデックスに登録しないので、重複しても構わない
+	if val.IsUnknown() {
+		return nil
+	}
+	idx, err := ii.Open()
+	if err != nil {
+		return err
+	}
+	if err := idx.BeforeFirst(val); err != nil {
+		return err
+	}
+	hasNext, err := idx.Next()
+	if err != nil {
+		return err
+	}
+	for hasNext {
+		dataRid, err := idx.GetDataRid()
+		if err != nil {
+			return err
+		}
+		duplicated := !dataRid.Equals(rid)
+		if duplicated && ii.TruncatesKey() {
+			duplicated, err = hasKey(ii, p, dataRid, val)
+			if err != nil {
+				return err
+			}
+		}
+		if duplicated {
+			if err := idx.Close(); err != nil {
+				return err
+			}
+			return sqlstate.Errorf(sqlstate.UniqueViolation, "duplicate key %s violates unique index %s", val, ii.IndexName())
+		}
+		hasNext, err = idx.Next()
+		if err != nil {
+			return err
+		}
+	}
+	return idx.Close()
+}
+
+// hasKey はテーブルの plan の p から dataRid のデータレコードを読んで、そのキーが val と等しいかどうかを返す
+func hasKey(ii *metadata.IndexInfo, p Planner, dataRid *record.RecordID, val query.Constant) (bool, error) {
+	s, err := p.Open()
+	if err != nil {
+		return false, err
+	}
+	ts, ok := s.(*query.TableScan)
+	if !ok {
+		return false, errors.New("scanner must be table scan")
+	}
+	if err := ts.MoveToRid(dataRid); err != nil {
+		return false, err
+	}
+	key, err := ii.KeyValue(ts)
+	if err != nil {
+		return false, err
+	}
+	if err := ts.Close(); err != nil {
+		return false, err
+	}
+	return !key.IsUnknown() && key.Equals(val), nil
+}
+
+// deleteIndexRecord はインデックスレコードを削除する
+func deleteIndexRecord(ii *metadata.IndexInfo, val query.Constant, rid *record.RecordID) error {
+	if val.IsUnknown() {
+		return nil
+	}
+	idx, err := ii.Open()
+	if err != nil {
+		return err
+	}
+	if err := idx.Delete(val, rid); err != nil {
+		return err
+	}
+	return idx.Close()
+}
+
+// abortInsert は値を設定できなかったレコードを削除して、元のエラーを返す
+// (不正な JSON などで途中までしか値が入っていないレコードを残さないため)
+func abortInsert(us query.UpdateScanner, cause error) error {
+	if err := us.Delete(); err != nil {
+		return err
+	}
+	if err := us.Close(); err != nil {
+		return err
+	}
+	return cause
 }
@@ -47,11 +47,10 @@ func (mp *MaterializePlan) Open() (query.Scanner, error) {
 			if err := destScanner.SetVal(fn, v); err != nil {
 				return nil, err
 			}
-			newHasNext, err := srcScanner.Next()
-			if err != nil {
-				return nil, err
-			}
-			hasNext = newHasNext
+		}
+		hasNext, err = srcScanner.Next()
+		if err != nil {
+			return nil, err
 		}
 	}
 	if err := srcScanner.Close(); err != nil {
@@ -80,3 +79,7 @@ func (mp *MaterializePlan) DistinctValues(fieldName string) int {
 func (mp *MaterializePlan) Schema() *record.Schema {
 	return mp.srcPlan.Schema()
 }
+
+func (mp *MaterializePlan) Explain() *PlanNode {
+	return newPlanNode(mp, "Materialize", nil, mp.srcPlan)
+}
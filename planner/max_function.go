@@ -42,3 +42,7 @@ func (f *MaxFunction) FieldName() string {
 func (f *MaxFunction) Value() query.Constant {
 	return f.val
 }
+
+func (f *MaxFunction) Clone() AggregationFunction {
+	return NewMaxFunction(f.fieldName)
+}
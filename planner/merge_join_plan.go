@@ -2,6 +2,7 @@ package planner
 
 import (
 	"errors"
+	"fmt"
 	"math"
 
 	"github.com/ksrnnb/go-rdb/query"
@@ -52,12 +53,21 @@ func (mp *MergeJoinPlan) Open() (query.Scanner, error) {
 	return NewMergeJoinScan(s1, ss2, mp.fieldName1, mp.fieldName2)
 }
 
+// BlocksAccessed はソート済みの両方の入力を読むブロック数に、ソートの前処理のブロック数を足す
 func (mp *MergeJoinPlan) BlocksAccessed() int {
-	return mp.p1.BlocksAccessed() + mp.p2.BlocksAccessed()
+	blocks := mp.p1.BlocksAccessed() + mp.p2.BlocksAccessed()
+	for _, p := range []Planner{mp.p1, mp.p2} {
+		if sp, ok := p.(*SortPlan); ok {
+			blocks += sp.preprocessingBlocks()
+		}
+	}
+	return blocks
 }
 
 func (mp *MergeJoinPlan) RecordsOutput() int {
 	maxVal := math.Max(float64(mp.p1.DistinctValues(mp.fieldName1)), float64(mp.p2.DistinctValues(mp.fieldName2)))
+	// 空のテーブルでは distinct value が 0 になる
+	maxVal = math.Max(maxVal, 1)
 	return int(float64(mp.p1.RecordsOutput()*mp.p2.RecordsOutput()) / maxVal)
 }
 
@@ -71,3 +81,8 @@ func (mp *MergeJoinPlan) DistinctValues(fieldName string) int {
 func (mp *MergeJoinPlan) Schema() *record.Schema {
 	return mp.schema
 }
+
+func (mp *MergeJoinPlan) Explain() *PlanNode {
+	props := map[string]string{"condition": fmt.Sprintf("%s=%s", mp.fieldName1, mp.fieldName2)}
+	return newPlanNode(mp, "MergeJoin", props, mp.p1, mp.p2)
+}
@@ -31,35 +31,46 @@ func (ms *MergeJoinScan) Close() error {
 }
 
 func (ms *MergeJoinScan) BeforeFirst() error {
+	ms.joinVal = query.Constant{}
 	if err := ms.s1.BeforeFirst(); err != nil {
 		return err
 	}
 	return ms.s2.BeforeFirst()
 }
 
+// Next は s2 の次のレコードが同じ結合値ならそのまま返す
+// s1 の次のレコードが同じ結合値なら、 s2 を同じ結合値の先頭に戻して返す
+// どちらでもなければ、両方を進めて次に等しくなる結合値を探す
 func (ms *MergeJoinScan) Next() (bool, error) {
 	hasMore2, err := ms.s2.Next()
 	if err != nil {
 		return false, err
 	}
-	v2, err := ms.s2.GetVal(ms.fieldName2)
-	if err != nil {
-		return false, err
-	}
-	if hasMore2 && v2.Equals(ms.joinVal) {
-		return true, nil
+	if hasMore2 {
+		v2, err := ms.s2.GetVal(ms.fieldName2)
+		if err != nil {
+			return false, err
+		}
+		if v2.Equals(ms.joinVal) {
+			return true, nil
+		}
 	}
 
 	hasMore1, err := ms.s1.Next()
 	if err != nil {
 		return false, err
 	}
-	v1, err := ms.s1.GetVal(ms.fieldName1)
-	if err != nil {
-		return false, err
-	}
-	if hasMore1 && v1.Equals(ms.joinVal) {
-		return true, nil
+	if hasMore1 {
+		v1, err := ms.s1.GetVal(ms.fieldName1)
+		if err != nil {
+			return false, err
+		}
+		if v1.Equals(ms.joinVal) {
+			if err := ms.s2.RestorePosition(); err != nil {
+				return false, err
+			}
+			return true, nil
+		}
 	}
 
 	for hasMore1 && hasMore2 {
@@ -72,26 +83,20 @@ func (ms *MergeJoinScan) Next() (bool, error) {
 			return false, err
 		}
 		if v1.IsLessThan(v2) {
-			newHasMore1, err := ms.s1.Next()
+			hasMore1, err = ms.s1.Next()
 			if err != nil {
 				return false, err
 			}
-			hasMore1 = newHasMore1
 		} else if v1.IsGreaterThan(v2) {
-			newHasMore2, err := ms.s2.Next()
+			hasMore2, err = ms.s2.Next()
 			if err != nil {
 				return false, err
 			}
-			hasMore2 = newHasMore2
 		} else {
 			if err := ms.s2.SavePosition(); err != nil {
 				return false, err
 			}
-			newJoinVal, err := ms.s2.GetVal(ms.fieldName2)
-			if err != nil {
-				return false, err
-			}
-			ms.joinVal = newJoinVal
+			ms.joinVal = v2
 			return true, nil
 		}
 	}
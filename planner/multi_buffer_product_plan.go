@@ -39,11 +39,20 @@ func (mp *MultiBufferProductPlan) Open() (query.Scanner, error) {
 	return NewMultiBufferProductScan(mp.tx, leftScan, fmt.Sprintf("%s.tbl", tt.TableName()), tt.Layout())
 }
 
+// BlocksAccessed は右側を一時テーブルにコピーして読むブロック数と、チャンクごとに左側を走査するブロック数の合計
+// チャンクの大きさは MultiBufferProductScan と同じように、現在使えるバッファ数から決める
 func (mp *MultiBufferProductPlan) BlocksAccessed() int {
-	available := mp.tx.AvailableBuffers()
 	size := NewMaterializePlan(mp.tx, mp.rhs, mp.generator).BlocksAccessed()
-	numChunks := size / available
-	return mp.rhs.BlocksAccessed() + mp.lhs.BlocksAccessed()*numChunks
+	return mp.rhs.BlocksAccessed() + 2*size + mp.lhs.BlocksAccessed()*mp.numChunks(size)
+}
+
+// numChunks は size ブロックの一時テーブルを分割するチャンクの数を返す
+func (mp *MultiBufferProductPlan) numChunks(size int) int {
+	if size < 1 {
+		return 1
+	}
+	chunkSize := BestFactor(mp.tx.AvailableBuffers(), size)
+	return (size + chunkSize - 1) / chunkSize
 }
 
 func (mp *MultiBufferProductPlan) RecordsOutput() int {
@@ -103,3 +112,7 @@ func (mp *MultiBufferProductPlan) copyRecordsFrom(p Planner) (*TemporaryTable, e
 	}
 	return tt, nil
 }
+
+func (mp *MultiBufferProductPlan) Explain() *PlanNode {
+	return newPlanNode(mp, "MultiBufferProduct", nil, mp.lhs, mp.rhs)
+}
@@ -1,7 +1,6 @@
 package planner
 
 import (
-	"errors"
 	"math"
 
 	"github.com/ksrnnb/go-rdb/query"
@@ -44,16 +43,20 @@ func (ms *MultiBufferProductScan) BeforeFirst() error {
 	return err
 }
 
+// Next は現在のチャンクとの直積を読み終えたら、次のチャンクに移って左側を先頭から走査し直す
 func (ms *MultiBufferProductScan) Next() (bool, error) {
+	// 全てのチャンクを読み終えたか、右側が空の場合
 	if ms.prodScan == nil {
-		return false, errors.New("Next() failed: MultiBufferProductScan.prodScan is nil")
-	}
-
-	hasNext, err := ms.prodScan.Next()
-	if err != nil {
-		return false, err
+		return false, nil
 	}
-	for !hasNext {
+	for {
+		hasNext, err := ms.prodScan.Next()
+		if err != nil {
+			return false, err
+		}
+		if hasNext {
+			return true, nil
+		}
 		nextChunk, err := ms.useNextChunk()
 		if err != nil {
 			return false, err
@@ -62,11 +65,16 @@ func (ms *MultiBufferProductScan) Next() (bool, error) {
 			return false, nil
 		}
 	}
-	return true, nil
 }
 
 func (ms *MultiBufferProductScan) Close() error {
-	return ms.prodScan.Close()
+	if err := ms.lhs.Close(); err != nil {
+		return err
+	}
+	if ms.rhs == nil {
+		return nil
+	}
+	return ms.rhs.Close()
 }
 
 func (ms *MultiBufferProductScan) GetInt(fieldName string) (int, error) {
@@ -82,7 +90,7 @@ func (ms *MultiBufferProductScan) GetVal(fieldName string) (query.Constant, erro
 }
 
 func (ms *MultiBufferProductScan) HasField(fieldName string) bool {
-	return ms.prodScan.HasField(fieldName)
+	return ms.lhs.HasField(fieldName) || ms.layout.Schema().HasField(fieldName)
 }
 
 func (ms *MultiBufferProductScan) useNextChunk() (bool, error) {
@@ -90,7 +98,9 @@ func (ms *MultiBufferProductScan) useNextChunk() (bool, error) {
 		if err := ms.rhs.Close(); err != nil {
 			return false, err
 		}
+		ms.rhs = nil
 	}
+	ms.prodScan = nil
 	if ms.nextBlkNum >= ms.fileSize {
 		return false, nil
 	}
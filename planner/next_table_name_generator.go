@@ -3,21 +3,26 @@ package planner
 import (
 	"fmt"
 	"sync"
+
+	"github.com/ksrnnb/go-rdb/file"
 )
 
-type NextTableNameGenerator struct {
+// 一時テーブルのファイルは全て同じディレクトリに作成されるので、番号は全ての generator で共有する
+var (
 	nextTableNum int
-	mux          sync.Mutex
-}
+	nextTableMux sync.Mutex
+)
+
+type NextTableNameGenerator struct{}
 
 func NewNextTableNameGenerator() *NextTableNameGenerator {
-	return &NextTableNameGenerator{nextTableNum: 0, mux: sync.Mutex{}}
+	return &NextTableNameGenerator{}
 }
 
 func (g *NextTableNameGenerator) NextTableName() string {
-	g.mux.Lock()
-	defer g.mux.Unlock()
+	nextTableMux.Lock()
+	defer nextTableMux.Unlock()
 
-	g.nextTableNum++
-	return fmt.Sprintf("temporary_table_%d", g.nextTableNum)
+	nextTableNum++
+	return fmt.Sprintf("%s%d", file.TemporaryFilePrefix, nextTableNum)
 }
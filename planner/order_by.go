@@ -0,0 +1,50 @@
+package planner
+
+import (
+	"github.com/ksrnnb/go-rdb/parser"
+	"github.com/ksrnnb/go-rdb/sqlstate"
+	"github.com/ksrnnb/go-rdb/tx"
+)
+
+// orderPlan は orderBy の順にレコードを返す plan を返す
+// p が既にその順に並んでいる場合はソートせずにそのまま返す
+func orderPlan(tx *tx.Transaction, p Planner, orderBy []parser.OrderField, generator *NextTableNameGenerator) (Planner, error) {
+	if len(orderBy) == 0 {
+		return p, nil
+	}
+	fields := make([]string, len(orderBy))
+	descending := make([]bool, len(orderBy))
+	for i, of := range orderBy {
+		if !p.Schema().HasField(of.FieldName()) {
+			return nil, sqlstate.Errorf(sqlstate.UndefinedColumn, "order by field not found: %s", of.FieldName())
+		}
+		fields[i] = of.FieldName()
+		descending[i] = of.IsDescending()
+	}
+	if isOrderedBy(p, orderBy) {
+		return p, nil
+	}
+	return NewSortPlanWithDirections(tx, fields, descending, p, generator), nil
+}
+
+// isOrderedBy は p が orderBy の順にレコードを返すかどうかを返す
+// レコードの順を変えない Select と Extend の下の IndexSelect か IndexOnly が、1つのフィールドの順に並べる場合だけ true
+func isOrderedBy(p Planner, orderBy []parser.OrderField) bool {
+	if len(orderBy) != 1 {
+		return false
+	}
+	for {
+		switch v := p.(type) {
+		case *SelectPlan:
+			p = v.p
+		case *ExtendPlan:
+			p = v.p
+		case *IndexSelectPlan:
+			return v.IsOrderedBy(orderBy[0].FieldName(), orderBy[0].IsDescending())
+		case *IndexOnlyPlan:
+			return v.IsOrderedBy(orderBy[0].FieldName(), orderBy[0].IsDescending())
+		default:
+			return false
+		}
+	}
+}
@@ -1,9 +1,6 @@
 package planner
 
 import (
-	"errors"
-
-	"github.com/ksrnnb/go-rdb/parser"
 	"github.com/ksrnnb/go-rdb/tx"
 )
 
@@ -16,40 +13,33 @@ func NewPlanExecuter(qp QueryPlanner, up UpdatePlanner) *PlanExecuter {
 	return &PlanExecuter{qp, up}
 }
 
+// Prepare はクエリをパースしてプリペアドステートメントを返す
+// クエリには ? か $1, $2, ... のプレースホルダを含めることができる
+func (pe *PlanExecuter) Prepare(query string) (*PreparedStatement, error) {
+	return newPreparedStatement(pe, query)
+}
+
 func (pe *PlanExecuter) CreateQueryPlan(query string, tx *tx.Transaction) (Planner, error) {
-	parser, err := parser.NewParser(query)
-	if err != nil {
-		return nil, err
-	}
-	qd, err := parser.Query()
+	ps, err := pe.Prepare(query)
 	if err != nil {
 		return nil, err
 	}
-	return pe.qp.CreatePlan(qd, tx)
+	return ps.CreateQueryPlan(nil, tx)
 }
 
 func (pe *PlanExecuter) ExecuteUpdate(query string, tx *tx.Transaction) (int, error) {
-	p, err := parser.NewParser(query)
+	ps, err := pe.Prepare(query)
 	if err != nil {
 		return 0, err
 	}
-	cmd, err := p.UpdateCommand()
+	return ps.ExecuteUpdate(nil, tx)
+}
+
+// Explain は EXPLAIN <query> の plan の木を返す
+func (pe *PlanExecuter) Explain(query string, tx *tx.Transaction) (*PlanNode, error) {
+	ps, err := pe.Prepare(query)
 	if err != nil {
-		return 0, err
-	}
-	switch v := cmd.(type) {
-	case *parser.InsertData:
-		return pe.up.ExecuteInsert(v, tx)
-	case *parser.DeleteData:
-		return pe.up.ExecuteDelete(v, tx)
-	case *parser.ModifyData:
-		return pe.up.ExecuteModify(v, tx)
-	case *parser.CreateTableData:
-		return pe.up.ExecuteCreateTable(v, tx)
-	case *parser.CreateViewData:
-		return pe.up.ExecuteCreateView(v, tx)
-	case *parser.CreateIndexData:
-		return pe.up.ExecuteCreateIndex(v, tx)
+		return nil, err
 	}
-	return 0, errors.New("invalid update command")
+	return ps.Explain(nil, tx)
 }
@@ -1,11 +1,25 @@
 package planner_test
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ksrnnb/go-rdb/file"
+	"github.com/ksrnnb/go-rdb/index"
+	"github.com/ksrnnb/go-rdb/parser"
+	"github.com/ksrnnb/go-rdb/planner"
+	"github.com/ksrnnb/go-rdb/query"
 	"github.com/ksrnnb/go-rdb/server"
+	"github.com/ksrnnb/go-rdb/sqlstate"
+	"github.com/ksrnnb/go-rdb/tx"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -15,6 +29,15 @@ func initializeFiles(t *testing.T) {
 	require.NoError(t, err)
 }
 
+// addPictures は pictures に user_id が 100 以上で重ならない n 件のレコードを追加する
+func addPictures(t *testing.T, pe *planner.PlanExecuter, tx *tx.Transaction, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		_, err := pe.ExecuteUpdate(fmt.Sprintf("insert into pictures (pid, user_id, title) values (%d, %d, 'extra%d')", 1000+i, 100+i, i), tx)
+		require.NoError(t, err)
+	}
+}
+
 func TestPlanExecuter(t *testing.T) {
 	initializeFiles(t)
 
@@ -137,3 +160,2348 @@ func TestPlanExecuter(t *testing.T) {
 	err = s.Close()
 	require.NoError(t, err)
 }
+
+func TestPlanExecuter_JSON(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	mdm := db.MetadataManager()
+	pe := planner.NewPlanExecuter(
+		planner.NewHeuristicQueryPlanner(mdm, planner.NewNextTableNameGenerator()),
+		planner.NewIndexUpdatePlanner(mdm),
+	)
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+
+	_, err = pe.ExecuteUpdate("create table events (id int, payload json)", tx)
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("create index events_name_idx on events (payload->>'name')", tx)
+	require.NoError(t, err)
+
+	// 1 ブロックに収まらない JSON も保存できる
+	memo := strings.Repeat("x", 2000)
+	for i := 0; i < 10; i++ {
+		iq := fmt.Sprintf(`insert into events (id, payload) values (%d, '{"name": "user%d", "tags": ["t%d"], "age": %d, "memo": "%s"}')`, i, i, i, i*10, memo)
+		_, err = pe.ExecuteUpdate(iq, tx)
+		require.NoError(t, err)
+	}
+
+	_, err = pe.ExecuteUpdate(`insert into events (id, payload) values (100, '{"name": ')`, tx)
+	assert.Error(t, err)
+
+	sq := "select id, payload->'tags'->0, payload->>'memo' from events where payload->>'name'='user3'"
+	p, err := pe.CreateQueryPlan(sq, tx)
+	require.NoError(t, err)
+	s, err := p.Open()
+	require.NoError(t, err)
+	count := 0
+	hasNext, err := s.Next()
+	require.NoError(t, err)
+	for hasNext {
+		id, err := s.GetInt("id")
+		require.NoError(t, err)
+		assert.Equal(t, 3, id)
+		tag, err := s.GetVal("payload->'tags'->0")
+		require.NoError(t, err)
+		assert.Equal(t, `"t3"`, tag.String())
+		m, err := s.GetString("payload->>'memo'")
+		require.NoError(t, err)
+		assert.Equal(t, memo, m)
+		count++
+		hasNext, err = s.Next()
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.Close())
+	assert.Equal(t, 1, count)
+
+	// ->> は文字列を返すので、整数のリテラルは文字列に変換して比べる
+	ids := func(q string) []int {
+		t.Helper()
+		p, err := pe.CreateQueryPlan(q, tx)
+		require.NoError(t, err)
+		s, err := p.Open()
+		require.NoError(t, err)
+		result := make([]int, 0)
+		for {
+			ok, err := s.Next()
+			require.NoError(t, err)
+			if !ok {
+				break
+			}
+			id, err := s.GetInt("id")
+			require.NoError(t, err)
+			result = append(result, id)
+		}
+		require.NoError(t, s.Close())
+		return result
+	}
+	assert.Equal(t, []int{3}, ids("select id from events where payload->>'age' = 30"))
+	assert.Equal(t, []int{3}, ids("select id from events where 30 = payload->>'age'"))
+	assert.ElementsMatch(t, []int{2, 4}, ids("select id from events where payload->>'age' in (20, 40)"))
+	assert.Empty(t, ids("select id from events where payload->>'age' = 35"))
+
+	// インデックスの式と一致する条件の場合は IndexSelectPlan が使われる
+	indexes, err := mdm.GetIndexInfo("events", tx)
+	require.NoError(t, err)
+	ii, ok := indexes["events_name_idx"]
+	require.True(t, ok)
+	idx, err := ii.Open()
+	require.NoError(t, err)
+	require.NoError(t, idx.BeforeFirst(query.NewConstant("user5")))
+	hasNext, err = idx.Next()
+	require.NoError(t, err)
+	assert.True(t, hasNext)
+	require.NoError(t, idx.Close())
+
+	_, err = pe.ExecuteUpdate(`update events set payload='{"name": "renamed"}' where id=5`, tx)
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("delete from events where payload->>'name'='user7'", tx)
+	require.NoError(t, err)
+
+	names := make([]string, 0)
+	p, err = pe.CreateQueryPlan("select id, payload->>'name' from events", tx)
+	require.NoError(t, err)
+	s, err = p.Open()
+	require.NoError(t, err)
+	hasNext, err = s.Next()
+	require.NoError(t, err)
+	for hasNext {
+		name, err := s.GetString("payload->>'name'")
+		require.NoError(t, err)
+		names = append(names, name)
+		hasNext, err = s.Next()
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.Close())
+	assert.Len(t, names, 9)
+	assert.Contains(t, names, "renamed")
+	assert.NotContains(t, names, "user5")
+	assert.NotContains(t, names, "user7")
+
+	require.NoError(t, tx.Commit())
+}
+
+func TestPlanExecuter_TextAndBlob(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	pe := db.PlanExecuter()
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+
+	_, err = pe.ExecuteUpdate("create table docs (id int, body text, data blob)", tx)
+	require.NoError(t, err)
+
+	body := strings.Repeat("0123456789", 300)
+	for i := 0; i < 5; i++ {
+		iq := fmt.Sprintf("insert into docs (id, body, data) values (%d, '%s%d', x'00ff%02x')", i, body, i, i)
+		_, err = pe.ExecuteUpdate(iq, tx)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tx.Commit())
+
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate(fmt.Sprintf("update docs set body='%s' where id=2", strings.Repeat("z", 5000)), tx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Rollback())
+
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	p, err := pe.CreateQueryPlan("select id, body, data from docs where id=2", tx)
+	require.NoError(t, err)
+	s, err := p.Open()
+	require.NoError(t, err)
+	count := 0
+	hasNext, err := s.Next()
+	require.NoError(t, err)
+	for hasNext {
+		// 大きな値は Scanner から io.Reader で読み込める
+		r, err := query.GetReader(s, "body")
+		require.NoError(t, err)
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, body+"2", string(b))
+
+		data, err := s.GetVal("data")
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0x00, 0xff, 0x02}, data.AsBytes())
+		count++
+		hasNext, err = s.Next()
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.Close())
+	assert.Equal(t, 1, count)
+	require.NoError(t, tx.Commit())
+}
+
+func TestPlanExecuter_UUID(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	mdm := db.MetadataManager()
+	pe := planner.NewPlanExecuter(
+		planner.NewHeuristicQueryPlanner(mdm, planner.NewNextTableNameGenerator()),
+		planner.NewIndexUpdatePlanner(mdm),
+	)
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+
+	_, err = pe.ExecuteUpdate("create table sessions (id uuid default gen_random_uuid(), user_id int)", tx)
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("create index sessions_id_idx on sessions (id)", tx)
+	require.NoError(t, err)
+
+	_, err = pe.ExecuteUpdate("insert into sessions (id, user_id) values ('6ba7b810-9dad-11d1-80b4-00c04fd430c8', 1)", tx)
+	require.NoError(t, err)
+	// id を指定しない場合はデフォルト値の gen_random_uuid() が評価される
+	for i := 2; i <= 5; i++ {
+		_, err = pe.ExecuteUpdate(fmt.Sprintf("insert into sessions (user_id) values (%d)", i), tx)
+		require.NoError(t, err)
+	}
+	_, err = pe.ExecuteUpdate("insert into sessions (id, user_id) values ('not-a-uuid', 6)", tx)
+	assert.Error(t, err)
+
+	ids := make(map[string]int)
+	p, err := pe.CreateQueryPlan("select id, user_id from sessions", tx)
+	require.NoError(t, err)
+	s, err := p.Open()
+	require.NoError(t, err)
+	hasNext, err := s.Next()
+	require.NoError(t, err)
+	for hasNext {
+		id, err := s.GetVal("id")
+		require.NoError(t, err)
+		assert.Equal(t, query.UUIDConstant, int(id.ConstantType()))
+		userID, err := s.GetInt("user_id")
+		require.NoError(t, err)
+		ids[id.String()] = userID
+		hasNext, err = s.Next()
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.Close())
+	assert.Len(t, ids, 5)
+	assert.Equal(t, 1, ids["6ba7b810-9dad-11d1-80b4-00c04fd430c8"])
+
+	// 文字列のリテラルで UUID のインデックスを検索できる
+	for id, userID := range ids {
+		p, err := pe.CreateQueryPlan(fmt.Sprintf("select user_id from sessions where id='%s'", id), tx)
+		require.NoError(t, err)
+		s, err := p.Open()
+		require.NoError(t, err)
+		hasNext, err := s.Next()
+		require.NoError(t, err)
+		require.True(t, hasNext, id)
+		got, err := s.GetInt("user_id")
+		require.NoError(t, err)
+		assert.Equal(t, userID, got)
+		require.NoError(t, s.Close())
+	}
+
+	// インデックスを使わない条件でも、文字列のリテラルは UUID に変換して比べる
+	p, err = pe.CreateQueryPlan("select user_id from sessions where user_id < 3 and id <> '6BA7B810-9DAD-11D1-80B4-00C04FD430C8'", tx)
+	require.NoError(t, err)
+	s, err = p.Open()
+	require.NoError(t, err)
+	userIDs := make([]int, 0)
+	for {
+		ok, err := s.Next()
+		require.NoError(t, err)
+		if !ok {
+			break
+		}
+		got, err := s.GetInt("user_id")
+		require.NoError(t, err)
+		userIDs = append(userIDs, got)
+	}
+	require.NoError(t, s.Close())
+	assert.Equal(t, []int{2}, userIDs)
+	require.NoError(t, tx.Commit())
+}
+
+func TestPlanExecuter_Prepare(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	mdm := db.MetadataManager()
+	pe := planner.NewPlanExecuter(
+		planner.NewHeuristicQueryPlanner(mdm, planner.NewNextTableNameGenerator()),
+		planner.NewIndexUpdatePlanner(mdm),
+	)
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+
+	_, err = pe.ExecuteUpdate("create table users (uid int, uname varchar(16))", tx)
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("create index users_uid_idx on users (uid)", tx)
+	require.NoError(t, err)
+
+	insert, err := pe.Prepare("insert into users (uid, uname) values (?, ?)")
+	require.NoError(t, err)
+	assert.False(t, insert.IsQuery())
+	assert.Equal(t, 2, insert.NumParameters())
+	for i := 1; i <= 5; i++ {
+		// 文字列の値はクォートされずにそのまま保存される
+		n, err := insert.ExecuteUpdate([]query.Constant{query.NewConstant(i), query.NewConstant(fmt.Sprintf("user'%d", i))}, tx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+	}
+	_, err = insert.ExecuteUpdate([]query.Constant{query.NewConstant(6)}, tx)
+	assert.Error(t, err)
+
+	sel, err := pe.Prepare("select uname from users where uid=$1")
+	require.NoError(t, err)
+	assert.True(t, sel.IsQuery())
+	assert.Equal(t, []string{"uname"}, sel.Fields())
+	for i := 1; i <= 5; i++ {
+		p, err := sel.CreateQueryPlan([]query.Constant{query.NewConstant(i)}, tx)
+		require.NoError(t, err)
+		s, err := p.Open()
+		require.NoError(t, err)
+		hasNext, err := s.Next()
+		require.NoError(t, err)
+		require.True(t, hasNext)
+		got, err := s.GetString("uname")
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("user'%d", i), got)
+		hasNext, err = s.Next()
+		require.NoError(t, err)
+		assert.False(t, hasNext)
+		require.NoError(t, s.Close())
+	}
+	_, err = sel.ExecuteUpdate([]query.Constant{query.NewConstant(1)}, tx)
+	assert.ErrorIs(t, err, planner.ErrNotUpdateCommand)
+
+	modify, err := pe.Prepare("update users set uname=$2 where uid=$1")
+	require.NoError(t, err)
+	n, err := modify.ExecuteUpdate([]query.Constant{query.NewConstant(3), query.NewConstant("carol")}, tx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	del, err := pe.Prepare("delete from users where uname=?")
+	require.NoError(t, err)
+	n, err = del.ExecuteUpdate([]query.Constant{query.NewConstant("carol")}, tx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// プレースホルダを含むクエリは値を割り当てずに実行できない
+	_, err = pe.CreateQueryPlan("select uname from users where uid=?", tx)
+	assert.Error(t, err)
+	_, err = pe.Prepare("create table t (a int default ?)")
+	assert.Error(t, err)
+	require.NoError(t, tx.Commit())
+}
+
+func TestPlanExecuter_ExecuteScript(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	pe := db.PlanExecuter()
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+
+	script := `
+-- ユーザーのテーブルを作成する
+create table "users" (uid int, uname varchar(16));
+insert into users (uid, uname) values (1, 'o''brien'); /* ; は区切りにならない */
+insert into users (uid, uname) values (2, 'a;b');
+select uid, uname from users where uid=1;
+update users set uname='bob' where uid=2;
+`
+	results, err := pe.ExecuteScript(script, tx)
+	require.NoError(t, err)
+	require.Len(t, results, 5)
+	assert.Equal(t, 1, results[1].RowsAffected)
+	assert.Equal(t, 1, results[2].RowsAffected)
+	assert.False(t, results[2].IsQuery())
+
+	sel := results[3]
+	require.True(t, sel.IsQuery())
+	assert.Equal(t, "select uid, uname from users where uid=1", sel.Statement)
+	assert.Equal(t, []string{"uid", "uname"}, sel.Fields)
+	require.Len(t, sel.Records, 1)
+	assert.Equal(t, 1, sel.Records[0][0].AsInt())
+	assert.Equal(t, "o'brien", sel.Records[0][1].AsString())
+	assert.Equal(t, 1, results[4].RowsAffected)
+	require.NoError(t, tx.Commit())
+
+	// 実行時のエラーはそれまでの結果とエラーを返す
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	results, err = pe.ExecuteScript("insert into users (uid, uname) values (3, 'c'); select a from missing", tx)
+	assert.Error(t, err)
+	assert.Len(t, results, 1)
+	require.NoError(t, tx.Rollback())
+
+	// 構文エラーがある場合はどの文も実行しない
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	results, err = pe.ExecuteScript("insert into users (uid, uname) values (4, 'd'); select from", tx)
+	assert.Error(t, err)
+	assert.Empty(t, results)
+
+	results, err = pe.ExecuteScript("select uid from users", tx)
+	require.NoError(t, err)
+	assert.Len(t, results[0].Records, 2)
+	require.NoError(t, tx.Commit())
+}
+
+func TestPlanExecuter_ErrorCode(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	pe := db.PlanExecuter()
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+
+	_, err = pe.ExecuteUpdate("create table users (uid int, id uuid)", tx)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		query string
+		want  sqlstate.Code
+	}{
+		{"syntax error", "select uid frm users", sqlstate.SyntaxError},
+		{"undefined table", "select uid from missing", sqlstate.UndefinedTable},
+		{"undefined column", "insert into users (missing) values (1)", sqlstate.UndefinedColumn},
+		{"undefined function", "select nope(uid) from users", sqlstate.UndefinedFunction},
+		{"invalid uuid", "insert into users (uid, id) values (1, 'not-a-uuid')", sqlstate.InvalidTextRepresentation},
+		{"missing parameter", "select uid from users where uid=?", sqlstate.InvalidParameterValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, err := pe.Prepare(tt.query)
+			if err == nil {
+				if ps.IsQuery() {
+					var p planner.Planner
+					p, err = pe.CreateQueryPlan(tt.query, tx)
+					if err == nil {
+						_, err = p.Open()
+					}
+				} else {
+					_, err = ps.ExecuteUpdate(nil, tx)
+				}
+			}
+			require.Error(t, err)
+			assert.Equal(t, tt.want, sqlstate.CodeOf(err), err.Error())
+		})
+	}
+	require.NoError(t, tx.Commit())
+}
+
+func TestPlanExecuter_Explain(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	mdm := db.MetadataManager()
+	pe := planner.NewPlanExecuter(
+		planner.NewHeuristicQueryPlanner(mdm, planner.NewNextTableNameGenerator()),
+		planner.NewIndexUpdatePlanner(mdm),
+	)
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+
+	script := `
+create table users (uid int, uname varchar(16));
+create table pictures (pid int, user_id int, title varchar(16));
+create index pictures_user_id_idx on pictures (user_id);
+`
+	_, err = pe.ExecuteScript(script, tx)
+	require.NoError(t, err)
+	for i := 1; i <= 20; i++ {
+		_, err = pe.ExecuteUpdate(fmt.Sprintf("insert into users (uid, uname) values (%d, 'user%d')", i, i), tx)
+		require.NoError(t, err)
+		_, err = pe.ExecuteUpdate(fmt.Sprintf("insert into pictures (pid, user_id, title) values (%d, %d, 'title%d')", i, i%5, i), tx)
+		require.NoError(t, err)
+	}
+	// テーブルを順に読むより安くなるように、条件に合わないレコードも入れて統計情報を更新する
+	addPictures(t, pe, tx, 40)
+	require.NoError(t, tx.Commit())
+	db = server.NewSimpleDBWithMetadata("data")
+	mdm = db.MetadataManager()
+	pe = planner.NewPlanExecuter(
+		planner.NewHeuristicQueryPlanner(mdm, planner.NewNextTableNameGenerator()),
+		planner.NewIndexUpdatePlanner(mdm),
+	)
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+
+	plan, err := pe.Explain("explain select title from pictures where user_id=3", tx)
+	require.NoError(t, err)
+	assert.Equal(t, "Project", plan.Type)
+	assert.Equal(t, "title", plan.Properties["fields"])
+	require.Len(t, plan.Children, 1)
+	sel := plan.Children[0]
+	assert.Equal(t, "Select", sel.Type)
+	require.Len(t, sel.Children, 1)
+	idx := sel.Children[0]
+	assert.Equal(t, "IndexSelect", idx.Type)
+	assert.Equal(t, "pictures_user_id_idx", idx.Properties["index"])
+	assert.Equal(t, "3", idx.Properties["value"])
+	require.Len(t, idx.Children, 1)
+	assert.Equal(t, "Table", idx.Children[0].Type)
+	assert.Equal(t, "pictures", idx.Children[0].Properties["table"])
+	assert.Contains(t, idx.Children[0].DistinctValues, "user_id")
+
+	lines := strings.Split(plan.String(), "\n")
+	require.Len(t, lines, 4)
+	assert.True(t, strings.HasPrefix(lines[0], "Project fields=title (blocks="), lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "-> Select predicate=user_id=3 "), lines[1])
+	assert.True(t, strings.HasPrefix(lines[2], "  -> IndexSelect index=pictures_user_id_idx key=user_id value=3 "), lines[2])
+	assert.True(t, strings.HasPrefix(lines[3], "    -> Table table=pictures "), lines[3])
+
+	// 子のノードは plan の入力の順に並ぶ
+	plan, err = pe.Explain("explain select uname, title from users, pictures where uid=user_id", tx)
+	require.NoError(t, err)
+	tables := make([]string, 0)
+	var walk func(n *planner.PlanNode)
+	walk = func(n *planner.PlanNode) {
+		if n.Type == "Table" {
+			tables = append(tables, n.Properties["table"])
+		}
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	walk(plan)
+	assert.ElementsMatch(t, []string{"users", "pictures"}, tables)
+	assert.Equal(t, plan.RecordsOutput, plan.Children[0].RecordsOutput)
+
+	// EXPLAIN はクエリとして実行できない
+	_, err = pe.CreateQueryPlan("explain select uname from users", tx)
+	assert.ErrorIs(t, err, planner.ErrNotQuery)
+	_, err = pe.Explain("select uname from users", tx)
+	assert.ErrorIs(t, err, planner.ErrNotExplain)
+	require.NoError(t, tx.Commit())
+}
+
+func TestPlanExecuter_ExplainAnalyze(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	mdm := db.MetadataManager()
+	pe := planner.NewPlanExecuter(
+		planner.NewHeuristicQueryPlanner(mdm, planner.NewNextTableNameGenerator()),
+		planner.NewIndexUpdatePlanner(mdm),
+	)
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+
+	script := `
+create table users (uid int, uname varchar(16));
+create table pictures (pid int, user_id int, title varchar(16));
+create index pictures_user_id_idx on pictures (user_id);
+`
+	_, err = pe.ExecuteScript(script, tx)
+	require.NoError(t, err)
+	for i := 1; i <= 20; i++ {
+		_, err = pe.ExecuteUpdate(fmt.Sprintf("insert into users (uid, uname) values (%d, 'user%d')", i, i), tx)
+		require.NoError(t, err)
+		_, err = pe.ExecuteUpdate(fmt.Sprintf("insert into pictures (pid, user_id, title) values (%d, %d, 'title%d')", i, i%5, i), tx)
+		require.NoError(t, err)
+	}
+
+	// 見積もりは統計情報のキャッシュで 0 のままだが、実績値は実行した結果になる
+	plan, err := pe.Explain("explain analyze select uname, title from users, pictures where uid=pid", tx)
+	require.NoError(t, err)
+	assert.Equal(t, 20, plan.Actual.Rows)
+	var product *planner.PlanNode
+	var walk func(n *planner.PlanNode)
+	walk = func(n *planner.PlanNode) {
+		if n.Type == "MultiBufferProduct" {
+			product = n
+		}
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	walk(plan)
+	require.NotNil(t, product)
+	assert.Equal(t, 400, product.Actual.Rows)
+	// 右側は一時テーブルにコピーするので、1回だけ走査する
+	for _, c := range product.Children {
+		assert.Equal(t, 1, c.Actual.Loops)
+		assert.Equal(t, 20, c.Actual.Rows)
+		assert.GreaterOrEqual(t, product.Actual.BufferPins, c.Actual.BufferPins)
+	}
+	assert.Greater(t, product.Actual.BlocksWritten, 0)
+
+	b, err := json.Marshal(plan)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"actual":{"loops":1,"rows":20,`)
+	require.NoError(t, tx.Commit())
+
+	// テーブルを順に読むより安くなるように、条件に合わないレコードも入れて統計情報を更新する
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	addPictures(t, pe, tx, 40)
+	require.NoError(t, tx.Commit())
+	db = server.NewSimpleDBWithMetadata("data")
+	mdm = db.MetadataManager()
+	pe = planner.NewPlanExecuter(
+		planner.NewHeuristicQueryPlanner(mdm, planner.NewNextTableNameGenerator()),
+		planner.NewIndexUpdatePlanner(mdm),
+	)
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+
+	// EXPLAIN だけでは実行しない
+	plan, err = pe.Explain("explain select title from pictures where user_id=3", tx)
+	require.NoError(t, err)
+	assert.Nil(t, plan.Actual)
+
+	plan, err = pe.Explain("explain analyze select title from pictures where user_id=3", tx)
+	require.NoError(t, err)
+	require.NotNil(t, plan.Actual)
+	assert.Equal(t, 1, plan.Actual.Loops)
+	assert.Equal(t, 4, plan.Actual.Rows)
+	assert.Greater(t, plan.Actual.BufferPins, 0)
+	assert.Greater(t, plan.Actual.Time, time.Duration(0))
+	idx := plan.Children[0].Children[0]
+	assert.Equal(t, "IndexSelect", idx.Type)
+	assert.Equal(t, 4, idx.Actual.Rows)
+	// IndexSelect の子の TableScan は計測しない
+	assert.Nil(t, idx.Children[0].Actual)
+
+	lines := strings.Split(plan.String(), "\n")
+	require.Len(t, lines, 4)
+	assert.Contains(t, lines[0], ") (actual loops=1 rows=4 time=")
+	require.NoError(t, tx.Commit())
+}
+
+func TestDPQueryPlanner(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	mdm := db.MetadataManager()
+	pe := planner.NewPlanExecuter(planner.NewBasicQueryPlanner(mdm), planner.NewIndexUpdatePlanner(mdm))
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+
+	script := `
+create table regions (rid int, rname varchar(16));
+create table customers (cid int, region_id int);
+create table orders (oid int, customer_id int);
+create table items (iid int, order_id int);
+create index items_order_id_idx on items (order_id);
+`
+	_, err = pe.ExecuteScript(script, tx)
+	require.NoError(t, err)
+	inserts := []struct {
+		query string
+		num   int
+		mod   int
+	}{
+		{"insert into regions (rid, rname) values (?, ?)", 4, 0},
+		{"insert into customers (cid, region_id) values (?, ?)", 40, 4},
+		{"insert into orders (oid, customer_id) values (?, ?)", 80, 40},
+		{"insert into items (iid, order_id) values (?, ?)", 160, 80},
+	}
+	for _, ins := range inserts {
+		ps, err := pe.Prepare(ins.query)
+		require.NoError(t, err)
+		for i := 0; i < ins.num; i++ {
+			second := query.NewConstant(fmt.Sprintf("region%d", i))
+			if ins.mod > 0 {
+				second = query.NewConstant(i % ins.mod)
+			}
+			_, err = ps.ExecuteUpdate([]query.Constant{query.NewConstant(i), second}, tx)
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tx.Commit())
+
+	// 統計情報を計算し直すために開き直す
+	db = server.NewSimpleDBWithMetadata("data", server.WithQueryPlanner(server.DPPlanner))
+	mdm = db.MetadataManager()
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+
+	q := "select rname, iid from regions, customers, orders, items where rid=region_id and cid=customer_id and oid=order_id and rname='region1'"
+	results, err := pe.ExecuteScript(q, tx)
+	require.NoError(t, err)
+	want := results[0].Records
+	require.Len(t, want, 40)
+
+	results, err = db.PlanExecuter().ExecuteScript(q, tx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, want, results[0].Records)
+
+	qd := func() *parser.QueryData {
+		p, err := parser.NewParser(q)
+		require.NoError(t, err)
+		qd, err := p.Query()
+		require.NoError(t, err)
+		return qd
+	}
+	dpPlan, err := planner.NewDPQueryPlanner(mdm, planner.NewNextTableNameGenerator(), planner.DefaultDPTableLimit).CreatePlan(qd(), tx)
+	require.NoError(t, err)
+	hpPlan, err := planner.NewHeuristicQueryPlanner(mdm, planner.NewNextTableNameGenerator()).CreatePlan(qd(), tx)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, dpPlan.BlocksAccessed(), hpPlan.BlocksAccessed())
+
+	// テーブル数が上限を超える場合は heuristic と同じ plan になる
+	fallback, err := planner.NewDPQueryPlanner(mdm, planner.NewNextTableNameGenerator(), 3).CreatePlan(qd(), tx)
+	require.NoError(t, err)
+	assert.Equal(t, hpPlan.Explain().String(), fallback.Explain().String())
+
+	// 結合値が重複していても merge join は全ての組み合わせを返す
+	orders, err := planner.NewTablePlan(tx, "orders", mdm)
+	require.NoError(t, err)
+	customers, err := planner.NewTablePlan(tx, "customers", mdm)
+	require.NoError(t, err)
+	mj, err := planner.NewMergeJoinPlan(tx, customers, orders, "cid", "customer_id", planner.NewNextTableNameGenerator())
+	require.NoError(t, err)
+	s, err := mj.Open()
+	require.NoError(t, err)
+	count := 0
+	for {
+		ok, err := s.Next()
+		require.NoError(t, err)
+		if !ok {
+			break
+		}
+		cid, err := s.GetInt("cid")
+		require.NoError(t, err)
+		customerID, err := s.GetInt("customer_id")
+		require.NoError(t, err)
+		assert.Equal(t, cid, customerID)
+		count++
+	}
+	require.NoError(t, s.Close())
+	assert.Equal(t, 80, count)
+	require.NoError(t, tx.Commit())
+}
+
+func TestTablePlanner_MakeJoinPlan(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	mdm := db.MetadataManager()
+	pe := planner.NewPlanExecuter(planner.NewBasicQueryPlanner(mdm), planner.NewIndexUpdatePlanner(mdm))
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+
+	_, err = pe.ExecuteScript("create table a (aid int, aval int); create table b (bid int, baid int);", tx)
+	require.NoError(t, err)
+	for _, q := range []string{"insert into a (aid, aval) values (?, ?)", "insert into b (bid, baid) values (?, ?)"} {
+		ps, err := pe.Prepare(q)
+		require.NoError(t, err)
+		for i := 0; i < 200; i++ {
+			_, err = ps.ExecuteUpdate([]query.Constant{query.NewConstant(i), query.NewConstant(i % 50)}, tx)
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tx.Commit())
+
+	// 統計情報を計算し直すために開き直す
+	db = server.NewSimpleDBWithMetadata("data")
+	mdm = db.MetadataManager()
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+
+	p, err := parser.NewParser("select aid, bid from a, b where aval=baid")
+	require.NoError(t, err)
+	qd, err := p.Query()
+	require.NoError(t, err)
+	generator := planner.NewNextTableNameGenerator()
+	tpA, err := planner.NewTablePlanner("a", qd.Predicate(), tx, mdm, generator)
+	require.NoError(t, err)
+	tpB, err := planner.NewTablePlanner("b", qd.Predicate(), tx, mdm, generator)
+	require.NoError(t, err)
+	current, err := tpA.MakeSelectPlan()
+	require.NoError(t, err)
+
+	// インデックスがないので、直積と hash join と merge join の候補の中から最もコストが小さいものを選ぶ
+	candidates, err := tpB.JoinPlans(current)
+	require.NoError(t, err)
+	types := make([]string, 0)
+	minBlocks := math.MaxInt
+	for _, c := range candidates {
+		types = append(types, c.Explain().Type)
+		if c.BlocksAccessed() < minBlocks {
+			minBlocks = c.BlocksAccessed()
+		}
+	}
+	assert.Len(t, types, 3)
+	joinPlan, err := tpB.MakeJoinPlan(current)
+	require.NoError(t, err)
+	assert.Equal(t, minBlocks, joinPlan.BlocksAccessed())
+
+	results, err := planner.NewPlanExecuter(planner.NewHeuristicQueryPlanner(mdm, generator), planner.NewIndexUpdatePlanner(mdm)).
+		ExecuteScript("select aid, bid from a, b where aval=baid", tx)
+	require.NoError(t, err)
+	assert.Len(t, results[0].Records, 800)
+
+	// 左側のレコードが多い場合は、チャンクごとに左側を走査する multibuffer product の方が安い
+	tpA, err = planner.NewTablePlanner("a", query.NewPredicate(), tx, mdm, generator)
+	require.NoError(t, err)
+	tpB, err = planner.NewTablePlanner("b", query.NewPredicate(), tx, mdm, generator)
+	require.NoError(t, err)
+	current, err = tpA.MakeSelectPlan()
+	require.NoError(t, err)
+	product, err := tpB.MakeProductPlan(current)
+	require.NoError(t, err)
+	assert.Equal(t, "MultiBufferProduct", product.Explain().Type)
+	bPlan, err := planner.NewTablePlan(tx, "b", mdm)
+	require.NoError(t, err)
+	mbp, err := planner.NewMultiBufferProductPlan(tx, current, bPlan, generator)
+	require.NoError(t, err)
+	ampleBlocks := mbp.BlocksAccessed()
+
+	// 使えるバッファが少ないとチャンクが小さくなり、左側を走査する回数が増える
+	pinned := make([]file.BlockID, 0)
+	for i := 0; tx.AvailableBuffers() > 2; i++ {
+		blk := file.NewBlockID("b.tbl", i)
+		require.NoError(t, tx.Pin(blk))
+		pinned = append(pinned, blk)
+	}
+	assert.Greater(t, mbp.BlocksAccessed(), ampleBlocks)
+	pp, err := planner.NewProductPlan(current, bPlan)
+	require.NoError(t, err)
+	product, err = tpB.MakeProductPlan(current)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, product.BlocksAccessed(), mbp.BlocksAccessed())
+	assert.LessOrEqual(t, product.BlocksAccessed(), pp.BlocksAccessed())
+	for _, blk := range pinned {
+		require.NoError(t, tx.Unpin(blk))
+	}
+
+	// 左側のレコードが少ない場合は、一時テーブルにコピーしない単純な直積の方が安い
+	p, err = parser.NewParser("select aid, bid from a, b where aid=3")
+	require.NoError(t, err)
+	qd, err = p.Query()
+	require.NoError(t, err)
+	tpA, err = planner.NewTablePlanner("a", qd.Predicate(), tx, mdm, generator)
+	require.NoError(t, err)
+	current, err = tpA.MakeSelectPlan()
+	require.NoError(t, err)
+	product, err = tpB.MakeProductPlan(current)
+	require.NoError(t, err)
+	assert.Equal(t, "Product", product.Explain().Type)
+	require.NoError(t, tx.Commit())
+}
+
+func TestHashJoinPlan(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	mdm := db.MetadataManager()
+	pe := planner.NewPlanExecuter(planner.NewBasicQueryPlanner(mdm), planner.NewIndexUpdatePlanner(mdm))
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+
+	_, err = pe.ExecuteScript("create table x (xid int, xk int); create table y (yid int, yk int); create table z (zid int, zk int);", tx)
+	require.NoError(t, err)
+	for _, q := range []string{"insert into x (xid, xk) values (?, ?)", "insert into y (yid, yk) values (?, ?)"} {
+		ps, err := pe.Prepare(q)
+		require.NoError(t, err)
+		for i := 0; i < 300; i++ {
+			_, err = ps.ExecuteUpdate([]query.Constant{query.NewConstant(i), query.NewConstant(i % 60)}, tx)
+			require.NoError(t, err)
+		}
+	}
+	// z は全てのレコードが同じ結合値なので、分割しても小さくならない
+	ps, err := pe.Prepare("insert into z (zid, zk) values (?, 0)")
+	require.NoError(t, err)
+	for i := 0; i < 300; i++ {
+		_, err = ps.ExecuteUpdate([]query.Constant{query.NewConstant(i)}, tx)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tx.Commit())
+
+	db = server.NewSimpleDBWithMetadata("data")
+	mdm = db.MetadataManager()
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	generator := planner.NewNextTableNameGenerator()
+
+	// x と y は結合値ごとに両方に 5 件ずつあるので、 60 * 5 * 5 件になる
+	count := func(p planner.Planner, fieldName1 string, fieldName2 string) int {
+		t.Helper()
+		s, err := p.Open()
+		require.NoError(t, err)
+		n := 0
+		for pass := 0; pass < 2; pass++ {
+			require.NoError(t, s.BeforeFirst())
+			n = 0
+			for {
+				ok, err := s.Next()
+				require.NoError(t, err)
+				if !ok {
+					break
+				}
+				v1, err := s.GetInt(fieldName1)
+				require.NoError(t, err)
+				v2, err := s.GetInt(fieldName2)
+				require.NoError(t, err)
+				assert.Equal(t, v1, v2)
+				n++
+			}
+		}
+		require.NoError(t, s.Close())
+		return n
+	}
+
+	newTablesPlan := func(table1 string, table2 string, fieldName1 string, fieldName2 string) *planner.HashJoinPlan {
+		t.Helper()
+		p1, err := planner.NewTablePlan(tx, table1, mdm)
+		require.NoError(t, err)
+		p2, err := planner.NewTablePlan(tx, table2, mdm)
+		require.NoError(t, err)
+		hp, err := planner.NewHashJoinPlan(tx, p1, p2, fieldName1, fieldName2, generator)
+		require.NoError(t, err)
+		return hp
+	}
+	newPlan := func() *planner.HashJoinPlan {
+		t.Helper()
+		return newTablesPlan("x", "y", "xk", "yk")
+	}
+
+	// バッファに収まる場合はメモリ上のハッシュ表だけで結合する
+	hp := newPlan()
+	node := hp.Explain()
+	assert.Equal(t, "HashJoin", node.Type)
+	assert.Equal(t, "xk=yk", node.Properties["condition"])
+	assert.Empty(t, node.Properties["partitioned"])
+	before := tx.IOStats()
+	assert.Equal(t, 1500, count(hp, "xk", "yk"))
+	assert.Equal(t, 0, tx.IOStats().Sub(before).BlocksWritten)
+
+	// 使えるバッファが少ない場合はパーティションに分割して結合する
+	// パーティションもハッシュ表に収まらないので、さらに分割する
+	pinned := make([]file.BlockID, 0)
+	// 先頭のブロックは Open したときに scan が使うので、それ以外を pin する
+	for i := 1; tx.AvailableBuffers() > 4; i++ {
+		blk := file.NewBlockID("x.tbl", i)
+		require.NoError(t, tx.Pin(blk))
+		pinned = append(pinned, blk)
+	}
+	hp = newPlan()
+	assert.Equal(t, "true", hp.Explain().Properties["partitioned"])
+	children := hp.Explain().Children
+	assert.Greater(t, hp.BlocksAccessed(), children[0].BlocksAccessed+children[1].BlocksAccessed)
+	before = tx.IOStats()
+	assert.Equal(t, 1500, count(hp, "xk", "yk"))
+	assert.Greater(t, tx.IOStats().Sub(before).BlocksWritten, 0)
+
+	// 同じ結合値のレコードは分割し直しても同じパーティションに入るので、深さの上限に達したら
+	// build 側をハッシュ表に収まる分ずつ読み込んで、そのたびに probe 側を読み直す
+	// z は x と同じ大きさなので、先に渡した z が build 側になる
+	hp = newTablesPlan("z", "x", "zk", "xk")
+	assert.Equal(t, "zk", hp.Explain().Properties["build"])
+	assert.Equal(t, 1500, count(hp, "zk", "xk"))
+
+	// 2つ以上に分割できるバッファがない場合は分割しない
+	for i := len(pinned) + 1; tx.AvailableBuffers() > 3; i++ {
+		blk := file.NewBlockID("x.tbl", i)
+		require.NoError(t, tx.Pin(blk))
+		pinned = append(pinned, blk)
+	}
+	hp = newPlan()
+	assert.Empty(t, hp.Explain().Properties["partitioned"])
+	children = hp.Explain().Children
+	assert.Greater(t, hp.BlocksAccessed(), children[0].BlocksAccessed+children[1].BlocksAccessed)
+	before = tx.IOStats()
+	assert.Equal(t, 1500, count(hp, "xk", "yk"))
+	// 走査するたびに build 側を読み込む回数だけ probe 側を読み直す
+	assert.Greater(t, tx.IOStats().Sub(before).Pins, 2*(children[0].BlocksAccessed+children[1].BlocksAccessed))
+	for _, blk := range pinned {
+		require.NoError(t, tx.Unpin(blk))
+	}
+
+	// 等結合で同じ型のフィールドがあれば、 TablePlanner の候補にも hash join が入る
+	results, err := planner.NewPlanExecuter(planner.NewHeuristicQueryPlanner(mdm, generator), planner.NewIndexUpdatePlanner(mdm)).
+		ExecuteScript("select xid, yid from x, y where xk=yk", tx)
+	require.NoError(t, err)
+	assert.Len(t, results[0].Records, 1500)
+	require.NoError(t, tx.Commit())
+}
+
+func TestMakeGroupPlan(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	mdm := db.MetadataManager()
+	pe := planner.NewPlanExecuter(planner.NewBasicQueryPlanner(mdm), planner.NewIndexUpdatePlanner(mdm))
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+
+	_, err = pe.ExecuteUpdate("create table g (gid int, gk int, gv int)", tx)
+	require.NoError(t, err)
+	ps, err := pe.Prepare("insert into g (gid, gk, gv) values (?, ?, ?)")
+	require.NoError(t, err)
+	for i := 0; i < 600; i++ {
+		_, err = ps.ExecuteUpdate([]query.Constant{query.NewConstant(i), query.NewConstant(i % 5), query.NewConstant(i)}, tx)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tx.Commit())
+
+	db = server.NewSimpleDBWithMetadata("data")
+	mdm = db.MetadataManager()
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	generator := planner.NewNextTableNameGenerator()
+
+	// 2回走査して、どちらもグループ化したフィールドと最大値の組を返すことを確かめる
+	collect := func(p planner.Planner, groupField string) map[int]int {
+		t.Helper()
+		s, err := p.Open()
+		require.NoError(t, err)
+		var maxes map[int]int
+		for pass := 0; pass < 2; pass++ {
+			require.NoError(t, s.BeforeFirst())
+			maxes = make(map[int]int)
+			for {
+				ok, err := s.Next()
+				require.NoError(t, err)
+				if !ok {
+					break
+				}
+				k, err := s.GetInt(groupField)
+				require.NoError(t, err)
+				v, err := s.GetInt("max_of_gv")
+				require.NoError(t, err)
+				_, dup := maxes[k]
+				require.False(t, dup)
+				maxes[k] = v
+			}
+		}
+		require.NoError(t, s.Close())
+		return maxes
+	}
+	newPlan := func(groupField string) planner.Planner {
+		t.Helper()
+		tp, err := planner.NewTablePlan(tx, "g", mdm)
+		require.NoError(t, err)
+		p, err := planner.MakeGroupPlan(tx, tp, []string{groupField}, []planner.AggregationFunction{planner.NewMaxFunction("gv")}, generator)
+		require.NoError(t, err)
+		return p
+	}
+
+	// グループが少ない場合はソートせずに集計する
+	p := newPlan("gk")
+	assert.Equal(t, "HashGroup", p.Explain().Type)
+	assert.Equal(t, map[int]int{0: 595, 1: 596, 2: 597, 3: 598, 4: 599}, collect(p, "gk"))
+	tp, err := planner.NewTablePlan(tx, "g", mdm)
+	require.NoError(t, err)
+	sortGroup, err := planner.NewGroupPlan(tx, planner.NewSortPlan(tx, []string{"gk"}, tp, generator), []string{"gk"}, []planner.AggregationFunction{planner.NewMaxFunction("gv")}, generator)
+	require.NoError(t, err)
+	assert.Equal(t, collect(sortGroup, "gk"), collect(p, "gk"))
+
+	// 使えるバッファが少なくてグループが収まらない場合は、ソートしてから集計する
+	pinned := make([]file.BlockID, 0)
+	for i := 1; tx.AvailableBuffers() > 3; i++ {
+		blk := file.NewBlockID("g.tbl", i)
+		require.NoError(t, tx.Pin(blk))
+		pinned = append(pinned, blk)
+	}
+	assert.Equal(t, "Group", newPlan("gid").Explain().Type)
+
+	// HashGroupPlan は収まらないグループのレコードをパーティションに分割して集計する
+	tp, err = planner.NewTablePlan(tx, "g", mdm)
+	require.NoError(t, err)
+	hp, err := planner.NewHashGroupPlan(tx, tp, []string{"gid"}, []planner.AggregationFunction{planner.NewMaxFunction("gv")}, generator)
+	require.NoError(t, err)
+	assert.Equal(t, "true", hp.Explain().Properties["partitioned"])
+	before := tx.IOStats()
+	maxes := collect(hp, "gid")
+	assert.Greater(t, tx.IOStats().Sub(before).BlocksWritten, 0)
+	assert.Len(t, maxes, 600)
+	for k, v := range maxes {
+		assert.Equal(t, k, v)
+	}
+	for _, blk := range pinned {
+		require.NoError(t, tx.Unpin(blk))
+	}
+	require.NoError(t, tx.Commit())
+}
+
+func TestPlanExecuter_GroupBy(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	mdm := db.MetadataManager()
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+	pe := db.PlanExecuter()
+	_, err = pe.ExecuteUpdate("create table g (gid int, gk int, gv int, name varchar(8))", tx)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		_, err = pe.ExecuteUpdate(fmt.Sprintf("insert into g (gid, gk, gv, name) values (%d, %d, %d, 'n%d')", i, i%3, i, i%4), tx)
+		require.NoError(t, err)
+	}
+
+	generator := planner.NewNextTableNameGenerator()
+	planners := map[string]planner.QueryPlanner{
+		"basic":     planner.NewBasicQueryPlanner(mdm),
+		"heuristic": planner.NewHeuristicQueryPlanner(mdm, generator),
+		"dp":        planner.NewDPQueryPlanner(mdm, generator, planner.DefaultDPTableLimit),
+	}
+	for name, qp := range planners {
+		t.Run(name, func(t *testing.T) {
+			pe := planner.NewPlanExecuter(qp, planner.NewIndexUpdatePlanner(mdm))
+			rows := func(q string, fields ...string) [][]interface{} {
+				t.Helper()
+				p, err := pe.CreateQueryPlan(q, tx)
+				require.NoError(t, err)
+				s, err := p.Open()
+				require.NoError(t, err)
+				rows := make([][]interface{}, 0)
+				for {
+					ok, err := s.Next()
+					require.NoError(t, err)
+					if !ok {
+						break
+					}
+					row := make([]interface{}, len(fields))
+					for i, fn := range fields {
+						v, err := s.GetVal(fn)
+						require.NoError(t, err)
+						switch v.ConstantType() {
+						case query.IntConstant:
+							row[i] = v.AsInt()
+						case query.StringConstant:
+							row[i] = v.AsString()
+						}
+					}
+					rows = append(rows, row)
+				}
+				require.NoError(t, s.Close())
+				return rows
+			}
+
+			assert.Equal(t,
+				[][]interface{}{{0, 7, 18}, {1, 7, 19}, {2, 6, 17}},
+				rows("select gk, count(*), max(gv) from g group by gk order by gk", "gk", "count", "max_of_gv"),
+			)
+			assert.Equal(t,
+				[][]interface{}{{1, 19}, {0, 18}, {2, 17}},
+				rows("select gk, max(gv) from g where gid < 100 group by gk order by max_of_gv desc", "gk", "max_of_gv"),
+			)
+			// GROUP BY がない場合は全てのレコードを1つのグループとして集計する
+			assert.Equal(t, [][]interface{}{{5, "n3"}}, rows("select count(gv), max(name) from g where gv < 5", "count_of_gv", "max_of_name"))
+			// 条件に合うレコードがなくても1行を返して、 MAX は値がない
+			assert.Equal(t, [][]interface{}{{0, nil}}, rows("select count(*), max(gv) from g where gv > 100", "count", "max_of_gv"))
+			// GROUP BY だけの場合はグループの値を重複なく返す
+			assert.Equal(t, [][]interface{}{{"n0"}, {"n1"}, {"n2"}, {"n3"}}, rows("select name from g group by name order by name", "name"))
+		})
+	}
+
+	// 集計しないフィールドは GROUP BY に書く
+	_, err = pe.CreateQueryPlan("select gv, count(*) from g group by gk", tx)
+	assert.Equal(t, sqlstate.GroupingError, sqlstate.CodeOf(err))
+	_, err = pe.CreateQueryPlan("select missing, count(*) from g group by missing", tx)
+	assert.Equal(t, sqlstate.UndefinedColumn, sqlstate.CodeOf(err))
+	require.NoError(t, tx.Commit())
+}
+
+func TestIndexRangeSelect(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	mdm := db.MetadataManager()
+	pe := planner.NewPlanExecuter(planner.NewBasicQueryPlanner(mdm), planner.NewIndexUpdatePlanner(mdm))
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+
+	// 範囲の条件は 1/3 に絞り込むと見積もるので、1ブロックに入るレコードが少ない幅の広いテーブルにする
+	script := `
+create table r (rid int, rk int, rname varchar(16), memo varchar(100));
+create index r_rk_idx on r (rk);
+create index r_rname_idx on r (rname);
+`
+	_, err = pe.ExecuteScript(script, tx)
+	require.NoError(t, err)
+	ps, err := pe.Prepare("insert into r (rid, rk, rname) values (?, ?, ?)")
+	require.NoError(t, err)
+	for i := 0; i < 600; i++ {
+		_, err = ps.ExecuteUpdate([]query.Constant{query.NewConstant(i), query.NewConstant(599 - i), query.NewConstant(fmt.Sprintf("name%03d", i))}, tx)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tx.Commit())
+
+	// 統計情報を計算し直すために開き直す
+	db = server.NewSimpleDBWithMetadata("data")
+	mdm = db.MetadataManager()
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	basic := planner.NewPlanExecuter(planner.NewBasicQueryPlanner(mdm), planner.NewIndexUpdatePlanner(mdm))
+	pe = planner.NewPlanExecuter(
+		planner.NewHeuristicQueryPlanner(mdm, planner.NewNextTableNameGenerator()),
+		planner.NewIndexUpdatePlanner(mdm),
+	)
+
+	rids := func(pe *planner.PlanExecuter, q string) []int {
+		t.Helper()
+		p, err := pe.CreateQueryPlan(q, tx)
+		require.NoError(t, err)
+		s, err := p.Open()
+		require.NoError(t, err)
+		result := make([]int, 0)
+		for {
+			ok, err := s.Next()
+			require.NoError(t, err)
+			if !ok {
+				break
+			}
+			v, err := s.GetInt("rid")
+			require.NoError(t, err)
+			result = append(result, v)
+		}
+		require.NoError(t, s.Close())
+		return result
+	}
+	// nodeTypes は plan に含まれるノードの種類と、 IndexSelect のプロパティを返す
+	nodeTypes := func(q string) ([]string, map[string]string) {
+		t.Helper()
+		plan, err := pe.Explain("explain "+q, tx)
+		require.NoError(t, err)
+		types := make([]string, 0)
+		var props map[string]string
+		for n := plan; ; n = n.Children[0] {
+			types = append(types, n.Type)
+			if n.Type == "IndexSelect" {
+				props = n.Properties
+			}
+			if len(n.Children) == 0 {
+				return types, props
+			}
+		}
+	}
+	between := func(from, to int) []int {
+		result := make([]int, 0)
+		for i := from; i <= to; i++ {
+			result = append(result, i)
+		}
+		return result
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		index string
+		rng   string
+		want  []int
+	}{
+		{"less than", "select rid from r where rk < 10", "r_rk_idx", "(-inf, 10)", between(590, 599)},
+		{"greater than or equal", "select rid from r where 595 <= rk", "r_rk_idx", "[595, +inf)", between(0, 4)},
+		{"between", "select rid from r where rk between 100 and 110", "r_rk_idx", "[100, 110]", between(489, 499)},
+		{"like prefix", "select rid from r where rname like 'name01%'", "r_rname_idx", "['name01', 'name02')", between(10, 19)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			types, props := nodeTypes(tt.query)
+			assert.Contains(t, types, "IndexSelect")
+			assert.Equal(t, tt.index, props["index"])
+			assert.Equal(t, tt.rng, props["range"])
+			assert.ElementsMatch(t, tt.want, rids(pe, tt.query))
+			assert.ElementsMatch(t, tt.want, rids(basic, tt.query))
+		})
+	}
+
+	// インデックスをキーの順に読めば、ソートせずに ORDER BY の順に返せる
+	orderTests := []struct {
+		name      string
+		query     string
+		want      []int
+		direction string
+	}{
+		{"asc", "select rid from r where rk < 10 order by rk", []int{599, 598, 597, 596, 595, 594, 593, 592, 591, 590}, ""},
+		{"desc", "select rid from r where rk < 10 order by rk desc", between(590, 599), "desc"},
+		{"like desc", "select rid from r where rname like 'name01%' order by rname desc", []int{19, 18, 17, 16, 15, 14, 13, 12, 11, 10}, "desc"},
+	}
+	for _, tt := range orderTests {
+		t.Run(tt.name, func(t *testing.T) {
+			types, props := nodeTypes(tt.query)
+			assert.NotContains(t, types, "Sort")
+			assert.Equal(t, tt.direction, props["direction"])
+			assert.Equal(t, tt.want, rids(pe, tt.query))
+			assert.Equal(t, tt.want, rids(basic, tt.query))
+		})
+	}
+
+	// インデックスがないフィールドはソートする
+	q := "select rid from r where rk < 5 order by rid desc"
+	types, _ := nodeTypes(q)
+	assert.Contains(t, types, "Sort")
+	assert.Equal(t, []int{599, 598, 597, 596, 595}, rids(pe, q))
+
+	_, err = pe.CreateQueryPlan("select rid from r order by missing", tx)
+	assert.Equal(t, sqlstate.UndefinedColumn, sqlstate.CodeOf(err))
+	require.NoError(t, tx.Commit())
+}
+
+func TestIndexOnlyPlan(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	mdm := db.MetadataManager()
+	pe := planner.NewPlanExecuter(planner.NewBasicQueryPlanner(mdm), planner.NewIndexUpdatePlanner(mdm))
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+
+	_, err = pe.ExecuteScript("create table pictures (pid int, user_id int, title varchar(16)); create index pictures_user_id_idx on pictures (user_id); create index pictures_title_idx on pictures (title);", tx)
+	require.NoError(t, err)
+	ps, err := pe.Prepare("insert into pictures (pid, user_id, title) values (?, ?, ?)")
+	require.NoError(t, err)
+	for i := 0; i < 300; i++ {
+		_, err = ps.ExecuteUpdate([]query.Constant{query.NewConstant(i), query.NewConstant(i % 30), query.NewConstant(fmt.Sprintf("title%d", i))}, tx)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tx.Commit())
+
+	// 統計情報を計算し直すために開き直す
+	db = server.NewSimpleDBWithMetadata("data")
+	mdm = db.MetadataManager()
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	pe = planner.NewPlanExecuter(
+		planner.NewHeuristicQueryPlanner(mdm, planner.NewNextTableNameGenerator()),
+		planner.NewIndexUpdatePlanner(mdm),
+	)
+
+	values := func(q string, fieldName string) []int {
+		t.Helper()
+		p, err := pe.CreateQueryPlan(q, tx)
+		require.NoError(t, err)
+		s, err := p.Open()
+		require.NoError(t, err)
+		result := make([]int, 0)
+		for {
+			ok, err := s.Next()
+			require.NoError(t, err)
+			if !ok {
+				break
+			}
+			v, err := s.GetInt(fieldName)
+			require.NoError(t, err)
+			result = append(result, v)
+		}
+		require.NoError(t, s.Close())
+		return result
+	}
+	leaf := func(q string) *planner.PlanNode {
+		t.Helper()
+		plan, err := pe.Explain("explain "+q, tx)
+		require.NoError(t, err)
+		for len(plan.Children) > 0 {
+			assert.NotEqual(t, "Sort", plan.Type)
+			plan = plan.Children[0]
+		}
+		return plan
+	}
+	leafType := func(q string) string {
+		t.Helper()
+		return leaf(q).Type
+	}
+	repeat := func(v int, n int) []int {
+		result := make([]int, n)
+		for i := range result {
+			result[i] = v
+		}
+		return result
+	}
+
+	// 参照するフィールドがインデックスのキーだけの場合は、データレコードを読まない
+	q := "select user_id from pictures where user_id=5"
+	assert.Equal(t, "IndexOnly", leafType(q))
+	assert.Equal(t, repeat(5, 10), values(q, "user_id"))
+
+	q = "select user_id from pictures where user_id < 3 order by user_id desc"
+	assert.Equal(t, "IndexOnly", leafType(q))
+	want := append(append(repeat(2, 10), repeat(1, 10)...), repeat(0, 10)...)
+	assert.Equal(t, want, values(q, "user_id"))
+
+	q = "select user_id from pictures"
+	assert.Equal(t, "IndexOnly", leafType(q))
+	assert.Len(t, values(q, "user_id"), 300)
+
+	// インデックスにないフィールドを参照する場合はデータレコードを読む
+	q = "select pid from pictures where user_id=5"
+	assert.Equal(t, "Table", leafType(q))
+	assert.ElementsMatch(t, []int{5, 35, 65, 95, 125, 155, 185, 215, 245, 275}, values(q, "pid"))
+
+	// COUNT(*) はレコードの数だけを使うので、どのインデックスでも読めて、最も小さいインデックスを読む
+	q = "select count(*) from pictures"
+	node := leaf(q)
+	assert.Equal(t, "IndexOnly", node.Type)
+	assert.Equal(t, "pictures_user_id_idx", node.Properties["index"])
+	assert.Equal(t, []int{300}, values(q, "count"))
+
+	q = "select count(*) from pictures where title < 'title2'"
+	node = leaf(q)
+	assert.Equal(t, "IndexOnly", node.Type)
+	assert.Equal(t, "pictures_title_idx", node.Properties["index"])
+	assert.Equal(t, []int{112}, values(q, "count"))
+	require.NoError(t, tx.Commit())
+}
+
+func TestIndexJoinPlan_CheapestIndex(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	pe := db.PlanExecuter()
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+	script := `
+create table pictures (pid int, user_id int, title varchar(16));
+create index pictures_user_id_idx on pictures using hash (user_id);
+create index pictures_pid_idx on pictures (pid);
+create table comments (cpid int, cuser int, body varchar(16));
+`
+	_, err = pe.ExecuteScript(script, tx)
+	require.NoError(t, err)
+	for i := 0; i < 300; i++ {
+		_, err = pe.ExecuteUpdate(fmt.Sprintf("insert into pictures (pid, user_id, title) values (%d, %d, 'title%d')", i, i%3, i), tx)
+		require.NoError(t, err)
+	}
+	for i := 0; i < 10; i++ {
+		_, err = pe.ExecuteUpdate(fmt.Sprintf("insert into comments (cpid, cuser, body) values (%d, %d, 'body%d')", i*7, i*7%3, i), tx)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tx.Commit())
+
+	// 統計情報を計算し直すために開き直す
+	db = server.NewSimpleDBWithMetadata("data")
+	mdm := db.MetadataManager()
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+
+	p, err := parser.NewParser("select title, body from comments, pictures where cpid = pid and cuser = user_id")
+	require.NoError(t, err)
+	qd, err := p.Query()
+	require.NoError(t, err)
+	generator := planner.NewNextTableNameGenerator()
+	tpComments, err := planner.NewTablePlanner("comments", qd.Predicate(), tx, mdm, generator)
+	require.NoError(t, err)
+	tpPictures, err := planner.NewTablePlanner("pictures", qd.Predicate(), tx, mdm, generator)
+	require.NoError(t, err)
+	current, err := tpComments.MakeSelectPlan()
+	require.NoError(t, err)
+
+	// どちらのインデックスでも結合できるので、ディレクトリとバケットを読むハッシュより安い B-tree のインデックスで結合する
+	candidates, err := tpPictures.JoinPlans(current)
+	require.NoError(t, err)
+	var indexJoin *planner.PlanNode
+	for _, c := range candidates {
+		node := c.Explain()
+		for node.Type == "Select" {
+			node = node.Children[0]
+		}
+		if node.Type == "IndexJoin" {
+			indexJoin = node
+		}
+	}
+	require.NotNil(t, indexJoin)
+	assert.Equal(t, "pictures_pid_idx", indexJoin.Properties["index"])
+	require.NoError(t, tx.Commit())
+}
+
+func TestIndexJoinPlan_TieBreaksByIndexName(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	pe := db.PlanExecuter()
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+	script := `
+create table pictures (pid int, user_id int, title varchar(16));
+create index pictures_pid_idx on pictures (pid);
+create index pictures_a_user_id_idx on pictures (user_id);
+create table comments (cpid int, cuser int, body varchar(16));
+`
+	_, err = pe.ExecuteScript(script, tx)
+	require.NoError(t, err)
+	for i := 0; i < 300; i++ {
+		_, err = pe.ExecuteUpdate(fmt.Sprintf("insert into pictures (pid, user_id, title) values (%d, %d, 'title%d')", i, i, i), tx)
+		require.NoError(t, err)
+	}
+	for i := 0; i < 10; i++ {
+		_, err = pe.ExecuteUpdate(fmt.Sprintf("insert into comments (cpid, cuser, body) values (%d, %d, 'body%d')", i*7, i*7, i), tx)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tx.Commit())
+
+	// 統計情報を計算し直すために開き直す
+	db = server.NewSimpleDBWithMetadata("data")
+	mdm := db.MetadataManager()
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+
+	p, err := parser.NewParser("select title, body from comments, pictures where cpid = pid and cuser = user_id")
+	require.NoError(t, err)
+	qd, err := p.Query()
+	require.NoError(t, err)
+
+	// どちらのインデックスもコストが同じなので、何度計画してもインデックス名が小さい方で結合する
+	for i := 0; i < 20; i++ {
+		generator := planner.NewNextTableNameGenerator()
+		tpComments, err := planner.NewTablePlanner("comments", qd.Predicate(), tx, mdm, generator)
+		require.NoError(t, err)
+		tpPictures, err := planner.NewTablePlanner("pictures", qd.Predicate(), tx, mdm, generator)
+		require.NoError(t, err)
+		current, err := tpComments.MakeSelectPlan()
+		require.NoError(t, err)
+		candidates, err := tpPictures.JoinPlans(current)
+		require.NoError(t, err)
+		var indexJoin *planner.PlanNode
+		for _, c := range candidates {
+			node := c.Explain()
+			for node.Type == "Select" {
+				node = node.Children[0]
+			}
+			if node.Type == "IndexJoin" {
+				indexJoin = node
+			}
+		}
+		require.NotNil(t, indexJoin)
+		assert.Equal(t, "pictures_a_user_id_idx", indexJoin.Properties["index"])
+	}
+	require.NoError(t, tx.Commit())
+}
+
+func TestCompositeIndex(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	mdm := db.MetadataManager()
+	pe := planner.NewPlanExecuter(planner.NewBasicQueryPlanner(mdm), planner.NewIndexUpdatePlanner(mdm))
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+
+	script := `
+create table c (cid int, a int, b varchar(16), memo varchar(100));
+create index c_ab_idx on c (a, b);
+`
+	_, err = pe.ExecuteScript(script, tx)
+	require.NoError(t, err)
+	ps, err := pe.Prepare("insert into c (cid, a, b) values (?, ?, ?)")
+	require.NoError(t, err)
+	for i := 0; i < 600; i++ {
+		_, err = ps.ExecuteUpdate([]query.Constant{query.NewConstant(i), query.NewConstant(i % 20), query.NewConstant(fmt.Sprintf("b%03d", i/20))}, tx)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tx.Commit())
+
+	// 統計情報を計算し直すために開き直す
+	db = server.NewSimpleDBWithMetadata("data")
+	mdm = db.MetadataManager()
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	pe = planner.NewPlanExecuter(
+		planner.NewHeuristicQueryPlanner(mdm, planner.NewNextTableNameGenerator()),
+		planner.NewIndexUpdatePlanner(mdm),
+	)
+
+	cids := func(q string) []int {
+		t.Helper()
+		p, err := pe.CreateQueryPlan(q, tx)
+		require.NoError(t, err)
+		s, err := p.Open()
+		require.NoError(t, err)
+		result := make([]int, 0)
+		for {
+			ok, err := s.Next()
+			require.NoError(t, err)
+			if !ok {
+				break
+			}
+			v, err := s.GetInt("cid")
+			require.NoError(t, err)
+			result = append(result, v)
+		}
+		require.NoError(t, s.Close())
+		return result
+	}
+	indexSelect := func(q string) map[string]string {
+		t.Helper()
+		plan, err := pe.Explain("explain "+q, tx)
+		require.NoError(t, err)
+		for n := plan; ; n = n.Children[0] {
+			if n.Type == "IndexSelect" {
+				return n.Properties
+			}
+			if len(n.Children) == 0 {
+				return nil
+			}
+		}
+	}
+	withA := func(a int) []int {
+		result := make([]int, 0)
+		for i := a; i < 600; i += 20 {
+			result = append(result, i)
+		}
+		return result
+	}
+
+	// 全ての列が等しい場合は値の組で検索する
+	q := "select cid from c where b = 'b005' and a = 3"
+	props := indexSelect(q)
+	require.NotNil(t, props)
+	assert.Equal(t, "c_ab_idx", props["index"])
+	assert.Equal(t, "(3, 'b005')", props["value"])
+	assert.Equal(t, []int{103}, cids(q))
+
+	// 先頭の列だけが等しい場合は、その値から始まるキーの範囲を読む
+	q = "select cid from c where a = 3"
+	props = indexSelect(q)
+	require.NotNil(t, props)
+	assert.Equal(t, "c_ab_idx", props["index"])
+	assert.Equal(t, "[(3), (3, +inf)]", props["range"])
+	assert.ElementsMatch(t, withA(3), cids(q))
+
+	// 先頭の列の条件がなければインデックスは使えない
+	q = "select cid from c where b = 'b005'"
+	assert.Nil(t, indexSelect(q))
+	assert.ElementsMatch(t, []int{100, 101, 102, 103, 104, 105, 106, 107, 108, 109, 110, 111, 112, 113, 114, 115, 116, 117, 118, 119}, cids(q))
+
+	// 更新、削除したレコードのインデックスレコードも更新される
+	_, err = pe.ExecuteUpdate("update c set b = 'zzz' where cid = 104", tx)
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("delete from c where cid = 103", tx)
+	require.NoError(t, err)
+	assert.Empty(t, cids("select cid from c where a = 3 and b = 'b005'"))
+	assert.Empty(t, cids("select cid from c where a = 4 and b = 'b005'"))
+	assert.Equal(t, []int{104}, cids("select cid from c where a = 4 and b = 'zzz'"))
+	assert.ElementsMatch(t, withA(4), cids("select cid from c where a = 4"))
+	require.NoError(t, tx.Commit())
+}
+
+func TestUniqueIndex(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	mdm := db.MetadataManager()
+	pe := planner.NewPlanExecuter(planner.NewBasicQueryPlanner(mdm), planner.NewIndexUpdatePlanner(mdm))
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+
+	script := `
+create table u (uid int, email varchar(20), a int, b int);
+create unique index u_email_idx on u (email);
+create unique index u_ab_idx on u (a, b);
+insert into u (uid, email, a, b) values (1, 'x@example.com', 1, 1);
+insert into u (uid, email, a, b) values (2, 'y@example.com', 1, 2);
+`
+	_, err = pe.ExecuteScript(script, tx)
+	require.NoError(t, err)
+
+	uids := func(q string) []int {
+		t.Helper()
+		p, err := pe.CreateQueryPlan(q, tx)
+		require.NoError(t, err)
+		s, err := p.Open()
+		require.NoError(t, err)
+		result := make([]int, 0)
+		for {
+			ok, err := s.Next()
+			require.NoError(t, err)
+			if !ok {
+				break
+			}
+			v, err := s.GetInt("uid")
+			require.NoError(t, err)
+			result = append(result, v)
+		}
+		require.NoError(t, s.Close())
+		return result
+	}
+
+	// 同じキーのレコードは追加できず、途中まで追加したレコードも残らない
+	_, err = pe.ExecuteUpdate("insert into u (uid, email, a, b) values (3, 'x@example.com', 2, 1)", tx)
+	assert.Equal(t, sqlstate.UniqueViolation, sqlstate.CodeOf(err))
+	_, err = pe.ExecuteUpdate("insert into u (uid, email, a, b) values (3, 'z@example.com', 1, 2)", tx)
+	assert.Equal(t, sqlstate.UniqueViolation, sqlstate.CodeOf(err))
+	assert.ElementsMatch(t, []int{1, 2}, uids("select uid from u"))
+
+	// 他のレコードと同じキーには更新できず、元の値のまま残る
+	_, err = pe.ExecuteUpdate("update u set email = 'x@example.com' where uid = 2", tx)
+	assert.Equal(t, sqlstate.UniqueViolation, sqlstate.CodeOf(err))
+	assert.Equal(t, []int{2}, uids("select uid from u where email = 'y@example.com'"))
+	_, err = pe.ExecuteUpdate("update u set b = 1 where uid = 2", tx)
+	assert.Equal(t, sqlstate.UniqueViolation, sqlstate.CodeOf(err))
+
+	// 自分自身と同じキーへの更新や、重複しないキーへの更新はできる
+	_, err = pe.ExecuteUpdate("update u set email = 'y@example.com' where uid = 2", tx)
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("update u set b = 3 where uid = 2", tx)
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("insert into u (uid, email, a, b) values (3, 'z@example.com', 1, 2)", tx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	// ロールバックしたトランザクションが追加したキーは登録できる
+	tx1, err := db.NewTransaction()
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("insert into u (uid, email, a, b) values (4, 'w@example.com', 4, 4)", tx1)
+	require.NoError(t, err)
+	require.NoError(t, tx1.Rollback())
+
+	tx2, err := db.NewTransaction()
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("insert into u (uid, email, a, b) values (5, 'w@example.com', 5, 5)", tx2)
+	require.NoError(t, err)
+
+	// コミット前のキーを検索したトランザクションは、キーを追加したトランザクションがコミットするまで leaf のロックを待つ
+	// その後に同じキーを追加しようとすると、コミットされたキーと重複していることを確認する
+	found := make(chan bool, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		tx3, err := db.NewTransaction()
+		if err != nil {
+			found <- false
+			errCh <- err
+			return
+		}
+		ok, err := func() (bool, error) {
+			indexes, err := mdm.GetIndexInfo("u", tx3)
+			if err != nil {
+				return false, err
+			}
+			idx, err := indexes["u_email_idx"].Open()
+			if err != nil {
+				return false, err
+			}
+			if err := idx.BeforeFirst(query.NewConstant("w@example.com")); err != nil {
+				return false, err
+			}
+			ok, err := idx.Next()
+			if err != nil {
+				return false, err
+			}
+			return ok, idx.Close()
+		}()
+		found <- ok
+		if err == nil {
+			_, err = pe.ExecuteUpdate("insert into u (uid, email, a, b) values (6, 'w@example.com', 6, 6)", tx3)
+		}
+		if rerr := tx3.Rollback(); rerr != nil {
+			errCh <- rerr
+			return
+		}
+		errCh <- err
+	}()
+	select {
+	case <-found:
+		t.Fatal("search must wait for the transaction that inserted the uncommitted key")
+	case <-time.After(200 * time.Millisecond):
+	}
+	require.NoError(t, tx2.Commit())
+	assert.True(t, <-found)
+	assert.Equal(t, sqlstate.UniqueViolation, sqlstate.CodeOf(<-errCh))
+
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	assert.Equal(t, []int{5}, uids("select uid from u where email = 'w@example.com'"))
+	require.NoError(t, tx.Commit())
+}
+
+func TestUniqueIndex_TruncatedKey(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	pe := db.PlanExecuter()
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+
+	// 先頭の 32 文字が同じで、インデックスのキーが同じになる値
+	prefix := strings.Repeat("a", 32)
+	script := fmt.Sprintf(`
+create table u (uid int, email varchar(64), bio text);
+insert into u (uid, email, bio) values (1, '%[1]s1@example.com', '%[1]s1');
+insert into u (uid, email, bio) values (2, '%[1]s2@example.com', '%[1]s2');
+create unique index u_lower_email_idx on u (lower(email));
+create unique index u_bio_idx on u (bio);
+`, prefix)
+	_, err = pe.ExecuteScript(script, tx)
+	require.NoError(t, err)
+
+	// キーが同じでも値が違うレコードは追加できて、値が同じレコードは追加できない
+	_, err = pe.ExecuteUpdate(fmt.Sprintf("insert into u (uid, email, bio) values (3, '%[1]s3@example.com', '%[1]s3')", prefix), tx)
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate(fmt.Sprintf("insert into u (uid, email, bio) values (4, '%s1@EXAMPLE.com', 'x')", strings.ToUpper(prefix)), tx)
+	assert.Equal(t, sqlstate.UniqueViolation, sqlstate.CodeOf(err))
+	_, err = pe.ExecuteUpdate(fmt.Sprintf("insert into u (uid, email, bio) values (4, 'x@example.com', '%s2')", prefix), tx)
+	assert.Equal(t, sqlstate.UniqueViolation, sqlstate.CodeOf(err))
+	_, err = pe.ExecuteUpdate(fmt.Sprintf("update u set bio = '%s1' where uid = 3", prefix), tx)
+	assert.Equal(t, sqlstate.UniqueViolation, sqlstate.CodeOf(err))
+	_, err = pe.ExecuteUpdate(fmt.Sprintf("update u set bio = '%s4' where uid = 3", prefix), tx)
+	require.NoError(t, err)
+
+	// キーが同じでも値が違うレコードがあるテーブルにも、ユニークインデックスを作れる
+	_, err = pe.ExecuteUpdate(fmt.Sprintf("insert into u (uid, email, bio) values (5, 'y@example.com', '%s5')", prefix), tx)
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("create unique index u_upper_email_idx on u (upper(email))", tx)
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("insert into u (uid, email, bio) values (6, 'Y@example.com', 'z')", tx)
+	assert.Equal(t, sqlstate.UniqueViolation, sqlstate.CodeOf(err))
+	require.NoError(t, db.CheckIndexes("u", tx))
+	require.NoError(t, tx.Commit())
+}
+
+func TestCreateIndexOnPopulatedTable(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	mdm := db.MetadataManager()
+	pe := planner.NewPlanExecuter(
+		planner.NewHeuristicQueryPlanner(mdm, planner.NewNextTableNameGenerator()),
+		planner.NewIndexUpdatePlanner(mdm),
+	)
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+
+	// name のキーは長いので leaf とディレクトリのページに少ししか入らず、ディレクトリが複数の階層になる
+	// grp = 7 のレコードは1つの leaf に収まらないので overflow block に入る
+	_, err = pe.ExecuteUpdate("create table b (bid int, grp int, name varchar(20))", tx)
+	require.NoError(t, err)
+	ps, err := pe.Prepare("insert into b (bid, grp, name) values (?, ?, ?)")
+	require.NoError(t, err)
+	name := func(i int) string {
+		return fmt.Sprintf("name%04d", (i*7)%600)
+	}
+	grp := func(i int) int {
+		if i%3 == 0 {
+			return 7
+		}
+		return 1000 + i
+	}
+	for i := 0; i < 600; i++ {
+		_, err = ps.ExecuteUpdate([]query.Constant{query.NewConstant(i), query.NewConstant(grp(i)), query.NewConstant(name(i))}, tx)
+		require.NoError(t, err)
+	}
+
+	script := `
+create index b_grp_idx on b (grp);
+create unique index b_name_idx on b (name);
+`
+	_, err = pe.ExecuteScript(script, tx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	// 重複したキーがある場合はユニークインデックスを作成できない
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("create unique index b_grp_uidx on b (grp)", tx)
+	assert.Equal(t, sqlstate.UniqueViolation, sqlstate.CodeOf(err))
+	require.NoError(t, tx.Rollback())
+
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	bids := func(q string) []int {
+		t.Helper()
+		p, err := pe.CreateQueryPlan(q, tx)
+		require.NoError(t, err)
+		s, err := p.Open()
+		require.NoError(t, err)
+		result := make([]int, 0)
+		for {
+			ok, err := s.Next()
+			require.NoError(t, err)
+			if !ok {
+				break
+			}
+			v, err := s.GetInt("bid")
+			require.NoError(t, err)
+			result = append(result, v)
+		}
+		require.NoError(t, s.Close())
+		return result
+	}
+	indexes, err := mdm.GetIndexInfo("b", tx)
+	require.NoError(t, err)
+	nameIdx, err := indexes["b_name_idx"].Open()
+	require.NoError(t, err)
+	layout, err := mdm.Layout("b", tx)
+	require.NoError(t, err)
+
+	// 全てのキーをインデックスで検索できる
+	for i := 0; i < 600; i++ {
+		require.NoError(t, nameIdx.BeforeFirst(query.NewConstant(name(i))))
+		ok, err := nameIdx.Next()
+		require.NoError(t, err)
+		require.True(t, ok, name(i))
+		rid, err := nameIdx.GetDataRid()
+		require.NoError(t, err)
+		ok, err = nameIdx.Next()
+		require.NoError(t, err)
+		require.False(t, ok, name(i))
+
+		ts, err := query.NewTableScan(tx, "b", layout)
+		require.NoError(t, err)
+		require.NoError(t, ts.MoveToRid(rid))
+		bid, err := ts.GetInt("bid")
+		require.NoError(t, err)
+		require.Equal(t, i, bid)
+		require.NoError(t, ts.Close())
+	}
+
+	// leaf をたどるとキーの順に並んでいる
+	ri, ok := nameIdx.(index.RangeIndex)
+	require.True(t, ok)
+	require.NoError(t, ri.BeforeFirstRange(query.NewRange(query.Bound{}, query.Bound{}), false))
+	keys := make([]string, 0)
+	for {
+		ok, err := ri.Next()
+		require.NoError(t, err)
+		if !ok {
+			break
+		}
+		v, err := nameIdx.(index.CoveringIndex).GetDataVal()
+		require.NoError(t, err)
+		keys = append(keys, v.AsString())
+	}
+	require.NoError(t, nameIdx.Close())
+	require.Len(t, keys, 600)
+	assert.True(t, sort.StringsAreSorted(keys))
+
+	withGrp7 := make([]int, 0)
+	for i := 0; i < 600; i += 3 {
+		withGrp7 = append(withGrp7, i)
+	}
+	assert.ElementsMatch(t, withGrp7, bids("select bid from b where grp = 7"))
+	assert.Equal(t, []int{10}, bids("select bid from b where grp = 1010"))
+
+	// まとめて構築したインデックスにも1件ずつ追加、削除できる
+	for i := 600; i < 660; i++ {
+		_, err = ps.ExecuteUpdate([]query.Constant{query.NewConstant(i), query.NewConstant(grp(i)), query.NewConstant(fmt.Sprintf("name%04d", i))}, tx)
+		require.NoError(t, err)
+	}
+	_, err = pe.ExecuteUpdate("delete from b where bid = 3", tx)
+	require.NoError(t, err)
+	withGrp7 = append(withGrp7[:1], withGrp7[2:]...)
+	for i := 600; i < 660; i += 3 {
+		withGrp7 = append(withGrp7, i)
+	}
+	assert.ElementsMatch(t, withGrp7, bids("select bid from b where grp = 7"))
+	assert.Equal(t, []int{659}, bids("select bid from b where name = 'name0659'"))
+	assert.Empty(t, bids("select bid from b where name = 'name0021'"))
+	assert.Equal(t, []int{4}, bids("select bid from b where name = 'name0028'"))
+	require.NoError(t, tx.Commit())
+}
+
+func TestIndexSelectCost(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	mdm := db.MetadataManager()
+	pe := planner.NewPlanExecuter(planner.NewBasicQueryPlanner(mdm), planner.NewIndexUpdatePlanner(mdm))
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+	script := `
+create table c (cid int, tag varchar(16), code int);
+create index c_tag_idx on c using hash (tag);
+create index c_code_idx on c using btree (code);
+`
+	_, err = pe.ExecuteScript(script, tx)
+	require.NoError(t, err)
+	ps, err := pe.Prepare("insert into c (cid, tag, code) values (?, ?, ?)")
+	require.NoError(t, err)
+	insert := func(from, to int) {
+		tx, err := db.NewTransaction()
+		require.NoError(t, err)
+		for i := from; i < to; i++ {
+			_, err := ps.ExecuteUpdate([]query.Constant{query.NewConstant(i), query.NewConstant(fmt.Sprintf("tag%d", i%50)), query.NewConstant(i)}, tx)
+			require.NoError(t, err)
+		}
+		require.NoError(t, tx.Commit())
+	}
+	// 統計情報を読み直して、 where の plan の種類と結果のレコード数を返す
+	explain := func(q string) (string, int) {
+		db = server.NewSimpleDBWithMetadata("data")
+		mdm = db.MetadataManager()
+		pe = planner.NewPlanExecuter(planner.NewHeuristicQueryPlanner(mdm, planner.NewNextTableNameGenerator()), planner.NewIndexUpdatePlanner(mdm))
+		tx, err := db.NewTransaction()
+		require.NoError(t, err)
+		plan, err := pe.Explain("explain analyze "+q, tx)
+		require.NoError(t, err)
+		require.NoError(t, tx.Commit())
+		return plan.Children[0].Children[0].Type, plan.Actual.Rows
+	}
+	require.NoError(t, tx.Commit())
+
+	// 1ブロックのテーブルは、インデックスを読むより順に読む方が安い
+	insert(0, 5)
+	typ, rows := explain("select cid from c where tag = 'tag3'")
+	assert.Equal(t, "Table", typ)
+	assert.Equal(t, 1, rows)
+	typ, rows = explain("select cid from c where code = 3")
+	assert.Equal(t, "Table", typ)
+	assert.Equal(t, 1, rows)
+
+	// テーブルが大きくなると、キーが等しいレコードだけを読む方が安くなる
+	insert(5, 500)
+	typ, rows = explain("select cid from c where tag = 'tag3'")
+	assert.Equal(t, "IndexSelect", typ)
+	assert.Equal(t, 10, rows)
+	typ, rows = explain("select cid from c where code = 3")
+	assert.Equal(t, "IndexSelect", typ)
+	assert.Equal(t, 1, rows)
+
+	// ハッシュインデックスは範囲を読めないので、範囲の条件ではテーブルを読む
+	typ, rows = explain("select cid from c where tag > 'tag48'")
+	assert.Equal(t, "Table", typ)
+	assert.Equal(t, 60, rows)
+}
+
+func TestCheckIndexes(t *testing.T) {
+	initializeFiles(t)
+
+	// 既定の update planner は insert, update, delete でインデックスも更新する
+	db := server.NewSimpleDBWithMetadata("data")
+	pe := db.PlanExecuter()
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+	script := `
+create table t (id int, name varchar(16), code int);
+create index t_name_idx on t using hash (name);
+create index t_code_idx on t (code);
+create index t_name_code_idx on t (name, code);
+`
+	_, err = pe.ExecuteScript(script, tx)
+	require.NoError(t, err)
+	for i := 0; i < 200; i++ {
+		_, err := pe.ExecuteUpdate(fmt.Sprintf("insert into t (id, name, code) values (%d, 'name%d', %d)", i, i%30, i), tx)
+		require.NoError(t, err)
+	}
+	_, err = pe.ExecuteUpdate("update t set code = 1000 where name = 'name3'", tx)
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("delete from t where code < 50", tx)
+	require.NoError(t, err)
+	require.NoError(t, db.CheckIndexes("t", tx))
+	require.NoError(t, tx.Commit())
+
+	// インデックスを更新しない update planner で変更すると、食い違いが見つかる
+	db = server.NewSimpleDBWithMetadata("data", server.WithUpdatePlanner(server.BasicUpdater))
+	pe = db.PlanExecuter()
+	tests := []struct {
+		query string
+		msg   string
+	}{
+		{"insert into t (id, name, code) values (500, 'name1', 500)", "has no entry"},
+		{"delete from t where id = 100", "that is not in table t"},
+		{"update t set code = 2000 where id = 150", "has key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			tx, err := db.NewTransaction()
+			require.NoError(t, err)
+			_, err = pe.ExecuteUpdate(tt.query, tx)
+			require.NoError(t, err)
+			err = db.CheckIndexes("t", tx)
+			require.Error(t, err)
+			assert.Equal(t, sqlstate.IndexCorrupted, sqlstate.CodeOf(err))
+			assert.Contains(t, err.Error(), tt.msg)
+			require.NoError(t, tx.Rollback())
+		})
+	}
+
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	require.NoError(t, db.CheckIndexes("t", tx))
+	require.NoError(t, tx.Commit())
+}
+
+func TestPartialAndExpressionIndex(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	pe := db.PlanExecuter()
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+	script := `
+create table users (id int, email varchar(32), active int);
+create index users_lower_email_idx on users (lower(email));
+create unique index users_active_email_idx on users (email) where active = 1;
+`
+	_, err = pe.ExecuteScript(script, tx)
+	require.NoError(t, err)
+	for i := 0; i < 300; i++ {
+		_, err := pe.ExecuteUpdate(fmt.Sprintf("insert into users (id, email, active) values (%d, 'User%d@Example.com', %d)", i, i, i%2), tx)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tx.Commit())
+
+	// ユニークインデックスの条件を満たさないレコードは、同じキーでも登録できる
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("insert into users (id, email, active) values (1000, 'User1@Example.com', 0)", tx)
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("insert into users (id, email, active) values (1001, 'User1@Example.com', 1)", tx)
+	assert.Equal(t, sqlstate.UniqueViolation, sqlstate.CodeOf(err))
+	_, err = pe.ExecuteUpdate("update users set active = 1 where id = 1000", tx)
+	assert.Equal(t, sqlstate.UniqueViolation, sqlstate.CodeOf(err))
+	// 条件のフィールドを更新すると、部分インデックスに登録されたり削除されたりする
+	_, err = pe.ExecuteUpdate("update users set active = 1 where id = 2", tx)
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("update users set active = 0 where id = 3", tx)
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("delete from users where id = 5", tx)
+	require.NoError(t, err)
+	require.NoError(t, db.CheckIndexes("users", tx))
+	require.NoError(t, tx.Commit())
+
+	// 統計情報を読み直して、 where の plan の種類と結果のレコード数を返す
+	explain := func(q string) (string, int) {
+		db = server.NewSimpleDBWithMetadata("data")
+		tx, err := db.NewTransaction()
+		require.NoError(t, err)
+		plan, err := db.PlanExecuter().Explain("explain analyze "+q, tx)
+		require.NoError(t, err)
+		require.NoError(t, tx.Commit())
+		node := plan.Children[0]
+		for node.Type == "Select" {
+			node = node.Children[0]
+		}
+		return node.Type, plan.Actual.Rows
+	}
+	tests := []struct {
+		query string
+		typ   string
+		rows  int
+	}{
+		// 式のインデックスは、インデックスと同じ式の条件で使える
+		{"select id from users where lower(email) = 'user7@example.com'", "IndexSelect", 1},
+		{"select id from users where email = 'user7@example.com'", "Table", 0},
+		// 部分インデックスは、クエリの条件が部分インデックスの条件を満たす場合だけ使える
+		{"select id from users where email = 'User2@Example.com' and active = 1", "IndexSelect", 1},
+		{"select id from users where email = 'User3@Example.com' and active = 1", "IndexSelect", 0},
+		{"select id from users where email = 'User3@Example.com'", "Table", 1},
+		{"select id from users where email = 'User1@Example.com' and active = 0", "Table", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			typ, rows := explain(tt.query)
+			assert.Equal(t, tt.typ, typ)
+			assert.Equal(t, tt.rows, rows)
+		})
+	}
+}
+
+func TestFullTextIndex(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	pe := db.PlanExecuter()
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+	script := `
+create table pictures (pid int, title varchar(48));
+create index pictures_title_idx on pictures using fulltext (title);
+insert into pictures (pid, title) values (1, 'Sunset over the Sea');
+insert into pictures (pid, title) values (2, 'sea, sea and sea birds');
+insert into pictures (pid, title) values (3, 'Mountain sunset');
+insert into pictures (pid, title) values (4, 'City lights');
+`
+	_, err = pe.ExecuteScript(script, tx)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		_, err := pe.ExecuteUpdate(fmt.Sprintf("insert into pictures (pid, title) values (%d, 'picture %d')", 100+i, i), tx)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tx.Commit())
+
+	// 統計情報を読み直して、 MATCH の条件で一致したレコードを返した順に返す
+	search := func(terms string) []int {
+		db = server.NewSimpleDBWithMetadata("data")
+		tx, err := db.NewTransaction()
+		require.NoError(t, err)
+		p, err := db.PlanExecuter().CreateQueryPlan(fmt.Sprintf("select pid from pictures where match(title, '%s')", terms), tx)
+		require.NoError(t, err)
+		s, err := p.Open()
+		require.NoError(t, err)
+		pids := make([]int, 0)
+		hasNext, err := s.Next()
+		require.NoError(t, err)
+		for hasNext {
+			pid, err := s.GetInt("pid")
+			require.NoError(t, err)
+			pids = append(pids, pid)
+			hasNext, err = s.Next()
+			require.NoError(t, err)
+		}
+		require.NoError(t, s.Close())
+		require.NoError(t, tx.Commit())
+		return pids
+	}
+	// 語を多く含むレコードほど先に返して、関連度が同じ場合は追加した順に返す
+	assert.Equal(t, []int{2, 1}, search("sea"))
+	assert.Equal(t, []int{1, 3}, search("SUNSET"))
+	assert.Equal(t, []int{1}, search("sunset sea"))
+	assert.Equal(t, []int{}, search("ocean"))
+
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	plan, err := db.PlanExecuter().Explain("explain select pid from pictures where match(title, 'sea')", tx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+	node := plan.Children[0]
+	for node.Type == "Select" {
+		node = node.Children[0]
+	}
+	assert.Equal(t, "FullTextSearch", node.Type)
+	assert.Equal(t, "sea", node.Properties["terms"])
+
+	// 更新と削除で語の posting list も更新する
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("update pictures set title = 'Sunset in the city' where pid = 4", tx)
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("delete from pictures where pid = 3", tx)
+	require.NoError(t, err)
+	require.NoError(t, db.CheckIndexes("pictures", tx))
+	require.NoError(t, tx.Commit())
+	assert.Equal(t, []int{1, 4}, search("sunset"))
+	assert.Equal(t, []int{}, search("lights"))
+
+	// 全文検索のインデックスは文字列の1つの列だけに作れる
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("create index pictures_pid_idx on pictures using fulltext (pid)", tx)
+	assert.Equal(t, sqlstate.DatatypeMismatch, sqlstate.CodeOf(err))
+	_, err = pe.ExecuteUpdate("create unique index pictures_title_uidx on pictures using fulltext (title)", tx)
+	assert.Equal(t, sqlstate.FeatureNotSupported, sqlstate.CodeOf(err))
+	require.NoError(t, tx.Rollback())
+}
+
+func TestBitmapIndex(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	pe := db.PlanExecuter()
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+	script := `
+create table orders (oid int, status varchar(8), region int);
+create index orders_status_idx on orders using bitmap (status);
+create index orders_region_idx on orders using bitmap (region);
+`
+	_, err = pe.ExecuteScript(script, tx)
+	require.NoError(t, err)
+	statuses := []string{"new", "paid", "sent", "hold"}
+	for i := 0; i < 400; i++ {
+		_, err := pe.ExecuteUpdate(fmt.Sprintf("insert into orders (oid, status, region) values (%d, '%s', %d)", i, statuses[i%4], i%5), tx)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tx.Commit())
+
+	// 統計情報を読み直して、条件で一致したレコードの oid を昇順に返す
+	query := func(pred string) []int {
+		db = server.NewSimpleDBWithMetadata("data")
+		tx, err := db.NewTransaction()
+		require.NoError(t, err)
+		p, err := db.PlanExecuter().CreateQueryPlan("select oid from orders where "+pred, tx)
+		require.NoError(t, err)
+		s, err := p.Open()
+		require.NoError(t, err)
+		oids := make([]int, 0)
+		hasNext, err := s.Next()
+		require.NoError(t, err)
+		for hasNext {
+			oid, err := s.GetInt("oid")
+			require.NoError(t, err)
+			oids = append(oids, oid)
+			hasNext, err = s.Next()
+			require.NoError(t, err)
+		}
+		require.NoError(t, s.Close())
+		require.NoError(t, tx.Commit())
+		sort.Ints(oids)
+		return oids
+	}
+	want := func(f func(i int) bool) []int {
+		oids := make([]int, 0)
+		for i := 0; i < 400; i++ {
+			if f(i) {
+				oids = append(oids, i)
+			}
+		}
+		return oids
+	}
+
+	// IN の値のビットマップは OR で、複数の列の条件は AND で組み合わせる
+	pred := "status in ('new', 'hold') and region in (1, 2)"
+	assert.Equal(t, want(func(i int) bool { return (i%4 == 0 || i%4 == 3) && (i%5 == 1 || i%5 == 2) }), query(pred))
+	assert.Equal(t, want(func(i int) bool { return (i%4 == 0 || i%4 == 3) && i%5 == 2 }), query("status in ('new', 'hold') and region = 2"))
+	assert.Equal(t, want(func(i int) bool { return i%4 == 1 && i%5 == 4 }), query("status = 'paid' and region = 4"))
+	assert.Equal(t, []int{}, query("status in ('lost') and region = 1"))
+
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	plan, err := db.PlanExecuter().Explain("explain select oid from orders where "+pred, tx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+	node := plan.Children[0]
+	for node.Type == "Select" {
+		node = node.Children[0]
+	}
+	assert.Equal(t, "BitmapSelect", node.Type)
+	assert.Equal(t, "orders_region_idx, orders_status_idx", node.Properties["index"])
+
+	// 更新と削除で値ごとのビットマップも更新する
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("update orders set status = 'lost' where oid = 7", tx)
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("delete from orders where region = 3", tx)
+	require.NoError(t, err)
+	require.NoError(t, db.CheckIndexes("orders", tx))
+	require.NoError(t, tx.Commit())
+	assert.Equal(t, []int{7}, query("status in ('lost', 'gone') and region = 2"))
+	assert.Equal(t, want(func(i int) bool { return (i%4 == 0 || i%4 == 3) && (i%5 == 1 || i%5 == 2) && i != 7 }), query(pred))
+	assert.Equal(t, []int{}, query("status = 'new' and region = 3"))
+
+	// ビットマップのインデックスは一意にできない
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	_, err = pe.ExecuteUpdate("create unique index orders_oid_idx on orders using bitmap (oid)", tx)
+	assert.Equal(t, sqlstate.FeatureNotSupported, sqlstate.CodeOf(err))
+	require.NoError(t, tx.Rollback())
+}
+
+func TestIndexSelect_TieBreaksByIndexName(t *testing.T) {
+	initializeFiles(t)
+
+	db := server.NewSimpleDBWithMetadata("data")
+	pe := db.PlanExecuter()
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+	script := `
+create table orders (oid int, status int);
+create index orders_a_status_idx on orders (status);
+create index orders_status_idx on orders (status);
+create index orders_status_hash_idx on orders using hash (status);
+`
+	_, err = pe.ExecuteScript(script, tx)
+	require.NoError(t, err)
+	for i := 0; i < 400; i++ {
+		_, err := pe.ExecuteUpdate(fmt.Sprintf("insert into orders (oid, status) values (%d, %d)", i, i%40), tx)
+		require.NoError(t, err)
+	}
+	// 同じ列のインデックスも全て取得して更新する
+	indexes, err := db.MetadataManager().GetIndexInfo("orders", tx)
+	require.NoError(t, err)
+	assert.Len(t, indexes, 3)
+	require.NoError(t, db.CheckIndexes("orders", tx))
+	require.NoError(t, tx.Commit())
+
+	// 統計情報を計算し直すために開き直す
+	db = server.NewSimpleDBWithMetadata("data")
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+
+	// 同じ列のインデックスのうちコストが同じものは、何度計画してもインデックス名が小さい方を選ぶ
+	for i := 0; i < 20; i++ {
+		plan, err := db.PlanExecuter().Explain("explain select oid from orders where status = 7", tx)
+		require.NoError(t, err)
+		node := plan.Children[0]
+		for node.Type == "Select" {
+			node = node.Children[0]
+		}
+		assert.Equal(t, "IndexSelect", node.Type)
+		assert.Equal(t, "orders_a_status_idx", node.Properties["index"])
+	}
+	require.NoError(t, tx.Commit())
+}
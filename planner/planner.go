@@ -23,6 +23,9 @@ type Planner interface {
 	// Schema は出力テーブルの schema を返す
 	// query planner はこのスキーマを型の検証や最適な plan 選択に使用する
 	Schema() *record.Schema
+
+	// Explain は EXPLAIN で表示するために、この plan を根とする木を返す
+	Explain() *PlanNode
 }
 
 type QueryPlanner interface {
@@ -0,0 +1,163 @@
+package planner
+
+import (
+	"errors"
+
+	"github.com/ksrnnb/go-rdb/parser"
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/sqlstate"
+	"github.com/ksrnnb/go-rdb/tx"
+)
+
+var ErrNotQuery = sqlstate.New(sqlstate.WrongObjectType, "prepared statement is not a query")
+var ErrNotUpdateCommand = sqlstate.New(sqlstate.WrongObjectType, "prepared statement is not an update command")
+var ErrNotExplain = sqlstate.New(sqlstate.WrongObjectType, "prepared statement is not an explain statement")
+
+// PreparedStatement はパース済みのクエリを保持して、実行ごとにプレースホルダへ値を割り当てる
+// plan はトランザクションに紐づくので、実行するたびに割り当て後のパース結果から作成する
+type PreparedStatement struct {
+	pe        *PlanExecuter
+	query     string
+	numParams int
+	qd        *parser.QueryData
+	explain   bool
+	analyze   bool
+	cmd       interface{}
+}
+
+func newPreparedStatement(pe *PlanExecuter, query string) (*PreparedStatement, error) {
+	p, err := parser.NewParser(query)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := p.Statement()
+	if err != nil {
+		return nil, err
+	}
+	ps := &PreparedStatement{pe: pe, query: query, numParams: p.NumParameters()}
+	switch v := stmt.(type) {
+	case *parser.QueryData:
+		ps.qd = v
+	case *parser.ExplainData:
+		ps.qd = v.Query()
+		ps.explain = true
+		ps.analyze = v.Analyze()
+	case *parser.CreateTableData, *parser.CreateViewData, *parser.CreateIndexData:
+		// テーブルやビューの定義はプレースホルダの値によって変えられない
+		if ps.numParams > 0 {
+			return nil, sqlstate.New(sqlstate.FeatureNotSupported, "create statement cannot contain placeholders")
+		}
+		ps.cmd = v
+	default:
+		ps.cmd = v
+	}
+	return ps, nil
+}
+
+// Query は元のクエリの文字列を返す
+func (ps *PreparedStatement) Query() string {
+	return ps.query
+}
+
+// NumParameters は実行時に割り当てる値の数を返す
+func (ps *PreparedStatement) NumParameters() int {
+	return ps.numParams
+}
+
+// IsQuery は select 文かどうかを返す
+func (ps *PreparedStatement) IsQuery() bool {
+	return ps.qd != nil && !ps.explain
+}
+
+// IsExplain は EXPLAIN 文かどうかを返す
+func (ps *PreparedStatement) IsExplain() bool {
+	return ps.explain
+}
+
+// Fields は select 文の出力フィールドを返す
+func (ps *PreparedStatement) Fields() []string {
+	if !ps.IsQuery() {
+		return nil
+	}
+	return ps.qd.Fields()
+}
+
+// CreateQueryPlan は params をプレースホルダに割り当てて、select 文の plan を作成する
+func (ps *PreparedStatement) CreateQueryPlan(params []query.Constant, tx *tx.Transaction) (Planner, error) {
+	if !ps.IsQuery() {
+		return nil, ErrNotQuery
+	}
+	return ps.createPlan(params, tx)
+}
+
+// Explain は params をプレースホルダに割り当てて、 EXPLAIN の対象のクエリの plan の木を返す
+// EXPLAIN ANALYZE の場合はクエリを実行して、各ノードに実績値をつける
+func (ps *PreparedStatement) Explain(params []query.Constant, tx *tx.Transaction) (*PlanNode, error) {
+	if !ps.IsExplain() {
+		return nil, ErrNotExplain
+	}
+	p, err := ps.createPlan(params, tx)
+	if err != nil {
+		return nil, err
+	}
+	if ps.analyze {
+		return analyze(p, tx)
+	}
+	return p.Explain(), nil
+}
+
+func (ps *PreparedStatement) createPlan(params []query.Constant, tx *tx.Transaction) (Planner, error) {
+	if err := ps.checkParameters(params); err != nil {
+		return nil, err
+	}
+	qd, err := ps.qd.Bind(params)
+	if err != nil {
+		return nil, err
+	}
+	return ps.pe.qp.CreatePlan(qd, tx)
+}
+
+// ExecuteUpdate は params をプレースホルダに割り当てて、更新系のコマンドを実行する
+func (ps *PreparedStatement) ExecuteUpdate(params []query.Constant, tx *tx.Transaction) (int, error) {
+	if ps.qd != nil {
+		return 0, ErrNotUpdateCommand
+	}
+	if err := ps.checkParameters(params); err != nil {
+		return 0, err
+	}
+	up := ps.pe.up
+	switch v := ps.cmd.(type) {
+	case *parser.InsertData:
+		id, err := v.Bind(params)
+		if err != nil {
+			return 0, err
+		}
+		return up.ExecuteInsert(id, tx)
+	case *parser.DeleteData:
+		dd, err := v.Bind(params)
+		if err != nil {
+			return 0, err
+		}
+		return up.ExecuteDelete(dd, tx)
+	case *parser.ModifyData:
+		md, err := v.Bind(params)
+		if err != nil {
+			return 0, err
+		}
+		return up.ExecuteModify(md, tx)
+	case *parser.CreateTableData:
+		return up.ExecuteCreateTable(v, tx)
+	case *parser.CreateViewData:
+		return up.ExecuteCreateView(v, tx)
+	case *parser.CreateIndexData:
+		return up.ExecuteCreateIndex(v, tx)
+	}
+	return 0, errors.New("invalid update command")
+}
+
+func (ps *PreparedStatement) checkParameters(params []query.Constant) error {
+	if len(params) != ps.numParams {
+		return sqlstate.Errorf(sqlstate.InvalidParameterValue, "query requires %d parameters, but got %d", ps.numParams, len(params))
+	}
+	return nil
+}
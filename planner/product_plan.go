@@ -56,3 +56,7 @@ func (ps *ProductPlan) DistinctValues(fieldName string) int {
 func (ps *ProductPlan) Schema() *record.Schema {
 	return ps.schema
 }
+
+func (ps *ProductPlan) Explain() *PlanNode {
+	return newPlanNode(ps, "Product", nil, ps.p1, ps.p2)
+}
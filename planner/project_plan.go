@@ -1,6 +1,8 @@
 package planner
 
 import (
+	"strings"
+
 	"github.com/ksrnnb/go-rdb/query"
 	"github.com/ksrnnb/go-rdb/record"
 )
@@ -45,3 +47,7 @@ func (ps *ProjectPlan) DistinctValues(fieldName string) int {
 func (ps *ProjectPlan) Schema() *record.Schema {
 	return ps.schema
 }
+
+func (ps *ProjectPlan) Explain() *PlanNode {
+	return newPlanNode(ps, "Project", map[string]string{"fields": strings.Join(ps.schema.Fields(), ", ")}, ps.p)
+}
@@ -3,15 +3,21 @@ package planner
 import "github.com/ksrnnb/go-rdb/query"
 
 type RecordComparator struct {
-	fields []string
+	fields     []string
+	descending []bool
 }
 
 func NewRecordComparator(fields []string) RecordComparator {
-	return RecordComparator{fields}
+	return RecordComparator{fields, make([]bool, len(fields))}
+}
+
+// NewRecordComparatorWithDirections は descending[i] が true のフィールドを大きい順に比べる comparator を返す
+func NewRecordComparatorWithDirections(fields []string, descending []bool) RecordComparator {
+	return RecordComparator{fields, descending}
 }
 
 func (rc RecordComparator) Compare(s1 query.Scanner, s2 query.Scanner) (int, error) {
-	for _, fn := range rc.fields {
+	for i, fn := range rc.fields {
 		val1, err := s1.GetVal(fn)
 		if err != nil {
 			return 0, err
@@ -22,8 +28,26 @@ func (rc RecordComparator) Compare(s1 query.Scanner, s2 query.Scanner) (int, err
 		}
 		result := val1.CompareTo(val2)
 		if result != 0 {
+			if rc.descending[i] {
+				return -result, nil
+			}
 			return result, nil
 		}
 	}
 	return 0, nil
 }
+
+// String は desc のフィールドに desc をつけて、ソートする順にフィールドを並べる
+func (rc RecordComparator) String() string {
+	s := ""
+	for i, fn := range rc.fields {
+		if i > 0 {
+			s += ", "
+		}
+		s += fn
+		if rc.descending[i] {
+			s += " desc"
+		}
+	}
+	return s
+}
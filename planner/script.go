@@ -0,0 +1,110 @@
+package planner
+
+import (
+	"fmt"
+
+	"github.com/ksrnnb/go-rdb/lexer"
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/ksrnnb/go-rdb/tx"
+)
+
+// StatementResult はスクリプトの1文を実行した結果
+// select 文の場合は Fields と Records に出力を、 EXPLAIN の場合は Plan に plan の木を保持して、
+// それ以外は RowsAffected に変更したレコード数を保持する
+type StatementResult struct {
+	Statement    string
+	Fields       []string
+	Records      [][]query.Constant
+	Plan         *PlanNode
+	RowsAffected int
+}
+
+// IsQuery は select 文の結果かどうかを返す
+func (sr StatementResult) IsQuery() bool {
+	return sr.Fields != nil
+}
+
+// ExecuteScript は ; で区切った複数の文を、同じトランザクションで順に実行する
+// 構文エラーがある場合はどの文も実行しない
+// 実行中にエラーが発生した場合は、それまでの結果とエラーを返すので、呼び出し側でロールバックする
+func (pe *PlanExecuter) ExecuteScript(script string, tx *tx.Transaction) ([]StatementResult, error) {
+	stmts, err := lexer.SplitStatements(script)
+	if err != nil {
+		return nil, err
+	}
+	prepared := make([]*PreparedStatement, len(stmts))
+	for i, stmt := range stmts {
+		ps, err := pe.Prepare(stmt)
+		if err != nil {
+			return nil, fmt.Errorf("statement %d: %w", i+1, err)
+		}
+		prepared[i] = ps
+	}
+
+	results := make([]StatementResult, 0, len(prepared))
+	for i, ps := range prepared {
+		res, err := executeStatement(ps, tx)
+		if err != nil {
+			return results, fmt.Errorf("statement %d: %w", i+1, err)
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// executeStatement は1文を実行する
+// 後続の文で値が変わらないように、 select 文の出力はこの時点で読み込む
+func executeStatement(ps *PreparedStatement, tx *tx.Transaction) (StatementResult, error) {
+	res := StatementResult{Statement: ps.Query()}
+	if ps.IsExplain() {
+		plan, err := ps.Explain(nil, tx)
+		if err != nil {
+			return StatementResult{}, err
+		}
+		res.Plan = plan
+		return res, nil
+	}
+	if !ps.IsQuery() {
+		n, err := ps.ExecuteUpdate(nil, tx)
+		if err != nil {
+			return StatementResult{}, err
+		}
+		res.RowsAffected = n
+		return res, nil
+	}
+
+	p, err := ps.CreateQueryPlan(nil, tx)
+	if err != nil {
+		return StatementResult{}, err
+	}
+	res.Fields = p.Schema().Fields()
+	res.Records = make([][]query.Constant, 0)
+	s, err := p.Open()
+	if err != nil {
+		return StatementResult{}, err
+	}
+	for {
+		hasNext, err := s.Next()
+		if err != nil {
+			s.Close()
+			return StatementResult{}, err
+		}
+		if !hasNext {
+			break
+		}
+		rec := make([]query.Constant, len(res.Fields))
+		for i, fn := range res.Fields {
+			v, err := s.GetVal(fn)
+			if err != nil {
+				s.Close()
+				return StatementResult{}, err
+			}
+			rec[i] = v
+		}
+		res.Records = append(res.Records, rec)
+	}
+	if err := s.Close(); err != nil {
+		return StatementResult{}, err
+	}
+	return res, nil
+}
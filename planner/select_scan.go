@@ -52,3 +52,7 @@ func (sp *SelectPlan) DistinctValues(fieldName string) int {
 func (sp *SelectPlan) Schema() *record.Schema {
 	return sp.p.Schema()
 }
+
+func (sp *SelectPlan) Explain() *PlanNode {
+	return newPlanNode(sp, "Select", map[string]string{"predicate": sp.pred.String()}, sp.p)
+}
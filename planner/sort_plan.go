@@ -15,11 +15,21 @@ type SortPlan struct {
 }
 
 func NewSortPlan(tx *tx.Transaction, sortFields []string, p Planner, generator *NextTableNameGenerator) *SortPlan {
+	return newSortPlan(tx, NewRecordComparator(sortFields), p, generator)
+}
+
+// NewSortPlanWithDirections は descending[i] が true のフィールドを大きい順に並べる SortPlan を返す
+func NewSortPlanWithDirections(tx *tx.Transaction, sortFields []string, descending []bool, p Planner, generator *NextTableNameGenerator) *SortPlan {
+	return newSortPlan(tx, NewRecordComparatorWithDirections(sortFields, descending), p, generator)
+}
+
+func newSortPlan(tx *tx.Transaction, comparator RecordComparator, p Planner, generator *NextTableNameGenerator) *SortPlan {
 	return &SortPlan{
 		tx:         tx,
 		p:          p,
 		schema:     p.Schema(),
-		comparator: NewRecordComparator(sortFields),
+		comparator: comparator,
+		generator:  generator,
 	}
 }
 
@@ -36,6 +46,10 @@ func (sp *SortPlan) Open() (query.Scanner, error) {
 	if err := src.Close(); err != nil {
 		return nil, err
 	}
+	// 入力が空の場合も SortScan が走査できるように、空の run を1つ作る
+	if len(runs) == 0 {
+		runs = append(runs, NewTemporaryTable(sp.tx, sp.schema, sp.generator))
+	}
 	for len(runs) > 2 {
 		newRuns, err := sp.doAMergeIteration(runs)
 		if err != nil {
@@ -51,6 +65,13 @@ func (sp *SortPlan) BlocksAccessed() int {
 	return mp.BlocksAccessed()
 }
 
+// preprocessingBlocks はソートの前処理で入力を読み込んで run を書き込むブロック数
+// BlocksAccessed はソート済みの結果を読むブロック数だけなので、結合のコストを比べるときに足す
+// merge phase の回数は考慮しない
+func (sp *SortPlan) preprocessingBlocks() int {
+	return sp.p.BlocksAccessed() + sp.BlocksAccessed()
+}
+
 func (sp *SortPlan) RecordsOutput() int {
 	return sp.p.RecordsOutput()
 }
@@ -221,3 +242,7 @@ func (sp *SortPlan) copy(src query.Scanner, dest query.UpdateScanner) (bool, err
 	}
 	return src.Next()
 }
+
+func (sp *SortPlan) Explain() *PlanNode {
+	return newPlanNode(sp, "Sort", map[string]string{"fields": sp.comparator.String()}, sp.p)
+}
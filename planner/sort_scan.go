@@ -12,7 +12,15 @@ type SortScan struct {
 	comparator    RecordComparator
 	hasMore1      bool
 	hasMore2      bool
-	savedPosition []*record.RecordID
+	savedPosition *sortPosition
+}
+
+// sortPosition は SavePosition で保存した SortScan の位置
+// 読み終えた run の RecordID は nil になる
+type sortPosition struct {
+	rid1, rid2         *record.RecordID
+	currentScan        query.UpdateScanner
+	hasMore1, hasMore2 bool
 }
 
 func NewSortScan(runs []*TemporaryTable, comparator RecordComparator) (*SortScan, error) {
@@ -42,6 +50,7 @@ func NewSortScan(runs []*TemporaryTable, comparator RecordComparator) (*SortScan
 }
 
 func (ss *SortScan) BeforeFirst() error {
+	ss.currentScan = nil
 	if err := ss.s1.BeforeFirst(); err != nil {
 		return err
 	}
@@ -73,7 +82,7 @@ func (ss *SortScan) Next() (bool, error) {
 			return false, err
 		}
 		ss.hasMore1 = hasMore1
-	} else if ss.currentScan == ss.s2 {
+	} else if ss.s2 != nil && ss.currentScan == ss.s2 {
 		hasMore2, err := ss.s2.Next()
 		if err != nil {
 			return false, err
@@ -123,29 +132,46 @@ func (ss *SortScan) GetVal(fieldName string) (query.Constant, error) {
 	return ss.currentScan.GetVal(fieldName)
 }
 
+// HasField は全ての run が同じスキーマなので、1つ目の run で判定する
 func (ss *SortScan) HasField(fieldName string) bool {
-	return ss.currentScan.HasField(fieldName)
+	return ss.s1.HasField(fieldName)
 }
 
+// SavePosition は現在の位置を保存して、 RestorePosition で戻れるようにする
 func (ss *SortScan) SavePosition() error {
-	rid1, err := ss.s1.GetRid()
-	if err != nil {
-		return err
+	pos := &sortPosition{currentScan: ss.currentScan, hasMore1: ss.hasMore1, hasMore2: ss.hasMore2}
+	if ss.hasMore1 {
+		rid, err := ss.s1.GetRid()
+		if err != nil {
+			return err
+		}
+		pos.rid1 = rid
 	}
-	rid2, err := ss.s2.GetRid()
-	if err != nil {
-		return err
+	if ss.s2 != nil && ss.hasMore2 {
+		rid, err := ss.s2.GetRid()
+		if err != nil {
+			return err
+		}
+		pos.rid2 = rid
 	}
-	ss.savedPosition = []*record.RecordID{rid1, rid2}
+	ss.savedPosition = pos
 	return nil
 }
 
 func (ss *SortScan) RestorePosition() error {
-	rid1 := ss.savedPosition[0]
-	rid2 := ss.savedPosition[1]
-
-	if err := ss.s1.MoveToRid(rid1); err != nil {
-		return err
+	pos := ss.savedPosition
+	if pos.rid1 != nil {
+		if err := ss.s1.MoveToRid(pos.rid1); err != nil {
+			return err
+		}
+	}
+	if pos.rid2 != nil {
+		if err := ss.s2.MoveToRid(pos.rid2); err != nil {
+			return err
+		}
 	}
-	return ss.s2.MoveToRid(rid2)
+	ss.currentScan = pos.currentScan
+	ss.hasMore1 = pos.hasMore1
+	ss.hasMore2 = pos.hasMore2
+	return nil
 }
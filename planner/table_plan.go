@@ -45,3 +45,7 @@ func (tp *TablePlan) DistinctValues(fieldName string) int {
 func (tp *TablePlan) Schema() *record.Schema {
 	return tp.layout.Schema()
 }
+
+func (tp *TablePlan) Explain() *PlanNode {
+	return newPlanNode(tp, "Table", map[string]string{"table": tp.tableName})
+}
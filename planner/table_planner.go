@@ -2,20 +2,24 @@ package planner
 
 import (
 	"errors"
+	"sort"
 
 	"github.com/ksrnnb/go-rdb/metadata"
+	"github.com/ksrnnb/go-rdb/parser"
 	"github.com/ksrnnb/go-rdb/query"
 	"github.com/ksrnnb/go-rdb/record"
 	"github.com/ksrnnb/go-rdb/tx"
 )
 
 type TablePlanner struct {
-	plan      *TablePlan
-	pred      *query.Predicate
-	schema    *record.Schema
-	indexes   map[string]*metadata.IndexInfo
-	tx        *tx.Transaction
-	generator *NextTableNameGenerator
+	plan    *TablePlan
+	pred    *query.Predicate
+	schema  *record.Schema
+	indexes map[string]*metadata.IndexInfo
+	// textIndexes は全文検索のインデックスで、キーが値ではなく語なので indexes とは分けて MATCH の条件にだけ使う
+	textIndexes []*metadata.IndexInfo
+	tx          *tx.Transaction
+	generator   *NextTableNameGenerator
 }
 
 func NewTablePlanner(tableName string, pred *query.Predicate, tx *tx.Transaction, mdm *metadata.MetadataManager, generator *NextTableNameGenerator) (*TablePlanner, error) {
@@ -28,18 +32,117 @@ func NewTablePlanner(tableName string, pred *query.Predicate, tx *tx.Transaction
 	if err != nil {
 		return nil, err
 	}
-	return &TablePlanner{plan, pred, schema, indexes, tx, generator}, nil
+	usable, textIndexes := usableIndexes(indexes, pred)
+	return &TablePlanner{plan, pred, schema, usable, textIndexes, tx, generator}, nil
 }
 
+// usableIndexes は pred のクエリで使えるインデックスを、全文検索のインデックスとそれ以外に分けて返す
+// 部分インデックスは条件を満たすレコードしか登録していないので、 pred が部分インデックスの条件を満たす場合だけ使える
+// 全文検索のインデックスはインデックス名の順に返す
+func usableIndexes(indexes map[string]*metadata.IndexInfo, pred *query.Predicate) (map[string]*metadata.IndexInfo, []*metadata.IndexInfo) {
+	usable := make(map[string]*metadata.IndexInfo)
+	textIndexes := make([]*metadata.IndexInfo, 0)
+	for key, ii := range indexes {
+		if ii.IsPartial() && (pred == nil || !pred.Implies(ii.Predicate())) {
+			continue
+		}
+		if ii.IsFullText() {
+			textIndexes = append(textIndexes, ii)
+			continue
+		}
+		usable[key] = ii
+	}
+	sort.Slice(textIndexes, func(i, j int) bool {
+		return textIndexes[i].IndexName() < textIndexes[j].IndexName()
+	})
+	return usable, textIndexes
+}
+
+// MakeSelectPlan はこのテーブルの条件に合うレコードを返す plan を返す
+// MATCH の条件に使える全文検索のインデックスがある場合は、関連度の高い順に返すように全文検索の plan を優先する
 func (tp *TablePlanner) MakeSelectPlan() (Planner, error) {
-	p := tp.makeIndexSelect()
+	p := tp.makeFullTextSelect()
+	if p == nil {
+		p = tp.makeIndexSelect()
+	}
 	if p == nil {
 		p = tp.plan
 	}
 	return tp.addSelectPredicate(p)
 }
 
+// makeFullTextSelect は MATCH の条件のフィールドに全文検索のインデックスがある場合に、それを使う plan を返す
+// 長い語はインデックスで切り詰めているので、 MATCH の条件は Select でもう一度確認する
+func (tp *TablePlanner) makeFullTextSelect() Planner {
+	for _, ii := range tp.textIndexes {
+		terms := tp.pred.MatchTerms(ii.Key())
+		if !terms.IsUnknown() {
+			return NewFullTextSearchPlan(tp.plan, ii, terms)
+		}
+	}
+	return nil
+}
+
+// MakeOrderedSelectPlan は orderBy の順にレコードを返せる場合に、 select plan とソートのコストと比べて
+// インデックスをキーの順に読む plan の方が安ければそちらを返す
+// それ以外の場合は MakeSelectPlan と同じ plan を返すので、呼び出し側でソートする必要がある
+func (tp *TablePlanner) MakeOrderedSelectPlan(orderBy []parser.OrderField) (Planner, error) {
+	p, err := tp.MakeSelectPlan()
+	if err != nil {
+		return nil, err
+	}
+	if len(orderBy) != 1 || isOrderedBy(p, orderBy) {
+		return p, nil
+	}
+	of := orderBy[0]
+	ii := tp.orderedIndex(of.FieldName())
+	if ii == nil {
+		return p, nil
+	}
+	r, ok := tp.pred.RangeWithConstant(of.FieldName())
+	if !ok || !ii.AcceptsRange(r) {
+		r = query.NewRange(query.Bound{}, query.Bound{})
+	}
+	op, err := tp.addSelectPredicate(NewIndexRangeSelectPlan(tp.plan, ii, r, of.IsDescending()))
+	if err != nil {
+		return nil, err
+	}
+	sp := NewSortPlan(tp.tx, []string{of.FieldName()}, p, tp.generator)
+	if op.BlocksAccessed() < sp.preprocessingBlocks() {
+		return op, nil
+	}
+	return p, nil
+}
+
+// orderedIndex は fieldName をキーにした、キーの順に読めるインデックスを返す
+// そのようなインデックスがない場合は nil を返す
+func (tp *TablePlanner) orderedIndex(fieldName string) *metadata.IndexInfo {
+	for _, ii := range tp.sortedIndexes() {
+		if ii.Key() == fieldName && ii.IsOrdered() {
+			return ii
+		}
+	}
+	return nil
+}
+
+// MakeJoinPlan は currentPlan とこのテーブルを結合する plan の候補のうち、 BlocksAccessed が最小のものを返す
+// 結合条件がない場合は nil を返す
 func (tp *TablePlanner) MakeJoinPlan(currentPlan Planner) (Planner, error) {
+	plans, err := tp.JoinPlans(currentPlan)
+	if err != nil {
+		return nil, err
+	}
+	var best Planner
+	for _, p := range plans {
+		best = cheaperPlan(best, p)
+	}
+	return best, nil
+}
+
+// JoinPlans は currentPlan とこのテーブルを結合する plan の候補を全て返す
+// コストが同じ場合に先の候補が選ばれるように、ソートが必要な merge join を最後にする
+// 結合条件がない場合は nil を返す
+func (tp *TablePlanner) JoinPlans(currentPlan Planner) ([]Planner, error) {
 	currentSchema := currentPlan.Schema()
 	joinPred, err := tp.pred.JoinSubPredicate(tp.schema, currentSchema)
 	if err != nil {
@@ -50,55 +153,327 @@ func (tp *TablePlanner) MakeJoinPlan(currentPlan Planner) (Planner, error) {
 	if joinPred == nil {
 		return nil, nil
 	}
-	p, err := tp.makeIndexJoin(currentPlan, currentSchema)
-	if err != nil {
-		return nil, err
+	makers := []func(Planner, *record.Schema) (Planner, error){
+		tp.makeIndexJoin,
+		tp.makeProductJoin,
+		tp.makeHashJoin,
+		tp.makeMergeJoin,
 	}
-	if p == nil {
-		p, err = tp.makeProductJoin(currentPlan, currentSchema)
+	plans := make([]Planner, 0, len(makers))
+	for _, makePlan := range makers {
+		p, err := makePlan(currentPlan, currentSchema)
 		if err != nil {
 			return nil, err
 		}
+		if p != nil {
+			plans = append(plans, p)
+		}
 	}
-	return p, nil
+	return plans, nil
 }
 
+// MakeProductPlan は currentPlan とこのテーブルの直積のうち、 BlocksAccessed が小さい方を返す
+// 使えるバッファが少なくてチャンクが小さい場合は、一時テーブルにコピーしない ProductPlan の方が安くなる
 func (tp *TablePlanner) MakeProductPlan(currentPlan Planner) (Planner, error) {
 	p, err := tp.addSelectPredicate(tp.plan)
 	if err != nil {
 		return nil, err
 	}
-	return NewMultiBufferProductPlan(tp.tx, currentPlan, p, tp.generator)
+	mbp, err := NewMultiBufferProductPlan(tp.tx, currentPlan, p, tp.generator)
+	if err != nil {
+		return nil, err
+	}
+	pp, err := NewProductPlan(currentPlan, p)
+	if err != nil {
+		return nil, err
+	}
+	return cheaperPlan(mbp, pp), nil
+}
+
+// MakeSingleTablePlan はこのテーブルだけを参照するクエリの plan を返す
+// MakeOrderedSelectPlan の plan と MakeIndexOnlyPlan の plan のうち、 orderBy の順に並べるソートも含めたコストが小さい方を選ぶ
+// ORDER BY がなく全文検索の plan を使える場合は、関連度の順を保つようにその plan を返す
+// fieldNames はクエリが参照する全てのフィールド名
+func (tp *TablePlanner) MakeSingleTablePlan(fieldNames []string, orderBy []parser.OrderField) (Planner, error) {
+	p, err := tp.MakeOrderedSelectPlan(orderBy)
+	if err != nil {
+		return nil, err
+	}
+	if len(orderBy) == 0 && tp.makeFullTextSelect() != nil {
+		return p, nil
+	}
+	ip, err := tp.MakeIndexOnlyPlan(fieldNames, orderBy)
+	if err != nil {
+		return nil, err
+	}
+	if ip == nil {
+		return p, nil
+	}
+	if tp.orderedCost(ip, orderBy) < tp.orderedCost(p, orderBy) {
+		return ip, nil
+	}
+	return p, nil
+}
+
+// MakeIndexOnlyPlan は fieldNames が全てインデックスのキーの場合に、データレコードを読まずにキーだけを返す plan を返す
+// 範囲にできる条件はインデックスを読む範囲にして、それ以外の条件は Select で確認する
+// orderBy がキーだけの場合はその向きに読む
+// fieldNames が空の場合は COUNT(*) のようにレコードの数だけを使うので、どのインデックスでも読める
+// 使えるインデックスが複数ある場合は、 orderBy の順に並べるソートも含めたコストが最小のものを選ぶ
+// コストもレコード数も同じ場合は、インデックス名が小さいものを選ぶ
+// そのようなインデックスがない場合は nil を返す
+func (tp *TablePlanner) MakeIndexOnlyPlan(fieldNames []string, orderBy []parser.OrderField) (Planner, error) {
+	var best Planner
+	bestCost := 0
+	for _, ii := range tp.sortedIndexes() {
+		if !coversAll(ii, fieldNames) {
+			continue
+		}
+		fn := ii.Key()
+		r, ok := tp.pred.RangeWithConstant(fn)
+		if !ok || !ii.AcceptsRange(r) {
+			r = query.NewRange(query.Bound{}, query.Bound{})
+		}
+		descending := len(orderBy) == 1 && orderBy[0].FieldName() == fn && orderBy[0].IsDescending()
+		ip, err := NewIndexOnlyPlan(tp.plan, ii, r, descending)
+		if err != nil {
+			return nil, err
+		}
+		p, err := tp.addSelectPredicate(ip)
+		if err != nil {
+			return nil, err
+		}
+		cost := tp.orderedCost(p, orderBy)
+		if best == nil || cost < bestCost || (cost == bestCost && p.RecordsOutput() < best.RecordsOutput()) {
+			best, bestCost = p, cost
+		}
+	}
+	return best, nil
+}
+
+// orderedCost は p のレコードを orderBy の順に並べるまでに読むブロック数を返す
+// p がその順に返す場合はソートしないので、 p の BlocksAccessed になる
+func (tp *TablePlanner) orderedCost(p Planner, orderBy []parser.OrderField) int {
+	if len(orderBy) == 0 || isOrderedBy(p, orderBy) {
+		return p.BlocksAccessed()
+	}
+	sortFields := make([]string, len(orderBy))
+	for i, of := range orderBy {
+		sortFields[i] = of.FieldName()
+	}
+	return NewSortPlan(tp.tx, sortFields, p, tp.generator).preprocessingBlocks()
 }
 
+// referencedFields はクエリの select 句、条件、 GROUP BY、 ORDER BY が参照する全てのフィールド名を返す
+// 集計する場合の ORDER BY は集計した結果を並べるので含めない
+func referencedFields(data *parser.QueryData) []string {
+	fns := make([]string, 0)
+	for _, e := range data.Expressions() {
+		fns = append(fns, e.FieldNames()...)
+	}
+	for _, af := range data.Aggregates() {
+		if af.FieldName() != "" {
+			fns = append(fns, af.FieldName())
+		}
+	}
+	fns = append(fns, data.Predicate().FieldNames()...)
+	fns = append(fns, data.GroupBy()...)
+	for _, of := range scanOrder(data) {
+		fns = append(fns, of.FieldName())
+	}
+	return fns
+}
+
+// scanOrder はテーブルを読む plan に求める順で、集計する場合は集計した後に並べるので空を返す
+func scanOrder(data *parser.QueryData) []parser.OrderField {
+	if data.IsGrouped() {
+		return nil
+	}
+	return data.OrderBy()
+}
+
+// coversAll は ii のキーだけで fieldNames の値を全て読めるかどうかを返す
+// fieldNames が空の場合は、キーの順に全てのレコードを読めるインデックスなら true
+func coversAll(ii *metadata.IndexInfo, fieldNames []string) bool {
+	if len(fieldNames) == 0 {
+		return ii.IsOrdered()
+	}
+	for _, fn := range fieldNames {
+		if !ii.Covers(fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// makeIndexSelect は定数と等しい条件や範囲の条件があるインデックス、先頭の列が定数と等しい複合インデックス、
+// 定数と等しい条件か IN の条件があるビットマップインデックスを使う plan のうち
+// 最も安く、テーブルを全て読むより安いものを返す
+// 同じ列に B-tree、ハッシュ、ビットマップのインデックスがあってコストが同じ場合は、インデックス名が小さいものを選び、
+// ビットマップのインデックスを組み合わせる plan はそれらより安い場合だけ選ぶ
+// 条件に合うレコードが多い場合はデータレコードを1件ずつ読むより、テーブルを順に読む方が安いので nil を返す
 func (tp *TablePlanner) makeIndexSelect() Planner {
-	for fn, ii := range tp.indexes {
+	var best Planner
+	for _, ii := range tp.sortedIndexes() {
+		fn := ii.Key()
 		val := tp.pred.EquatesWithConstant(fn)
+		if ii.IsComposite() {
+			val = tp.equatesWithKey(ii, len(ii.Expressions()))
+		}
 		if !val.IsUnknown() {
-			return NewIndexSelectPlan(tp.plan, ii, val)
+			best = cheaperPlan(best, NewIndexSelectPlan(tp.plan, ii, val))
+			continue
 		}
+		if ii.IsComposite() {
+			if p := tp.makePrefixIndexSelect(ii); p != nil {
+				best = cheaperPlan(best, p)
+			}
+			continue
+		}
+		r, ok := tp.pred.RangeWithConstant(fn)
+		if !ok || !ii.AcceptsRange(r) {
+			continue
+		}
+		best = cheaperPlan(best, NewIndexRangeSelectPlan(tp.plan, ii, r, false))
+	}
+	if p := tp.makeBitmapSelect(); p != nil {
+		best = cheaperPlan(best, p)
+	}
+	if best == nil || best.BlocksAccessed() >= tp.plan.BlocksAccessed() {
+		return nil
+	}
+	return best
+}
+
+// makeBitmapSelect は定数と等しい条件か IN の条件があるビットマップインデックスを全て組み合わせる plan を返す
+// そのようなインデックスがない場合は nil を返す
+func (tp *TablePlanner) makeBitmapSelect() Planner {
+	conds := make([]BitmapCondition, 0)
+	for _, ii := range tp.sortedIndexes() {
+		if !ii.IsBitmap() {
+			continue
+		}
+		vals, ok := tp.pred.ValuesWithConstant(ii.Key())
+		if !ok {
+			continue
+		}
+		conds = append(conds, NewBitmapCondition(ii, vals))
+	}
+	if len(conds) == 0 {
+		return nil
+	}
+	return NewBitmapSelectPlan(tp.plan, conds)
+}
+
+// equatesWithKey は複合インデックスの先頭の n 列が全て定数と等しい場合に、その値の組を返す
+func (tp *TablePlanner) equatesWithKey(ii *metadata.IndexInfo, n int) query.Constant {
+	vals := make([]query.Constant, n)
+	for i, e := range ii.Expressions()[:n] {
+		vals[i] = tp.pred.EquatesWithConstant(e.String())
+		if vals[i].IsUnknown() {
+			return query.Constant{}
+		}
+	}
+	return query.NewTupleConstant(vals)
+}
+
+// makePrefixIndexSelect は複合インデックスの先頭から続けて定数と等しい列がある場合に、
+// その値の組から始まるキーの範囲を読む plan を返す
+func (tp *TablePlanner) makePrefixIndexSelect(ii *metadata.IndexInfo) Planner {
+	for n := len(ii.Expressions()) - 1; n > 0; n-- {
+		key := tp.equatesWithKey(ii, n)
+		if key.IsUnknown() {
+			continue
+		}
+		r := query.NewRange(
+			query.NewBound(key, true),
+			query.NewBound(query.NewTupleUpperBound(key.AsTuple()), true),
+		)
+		if !ii.AcceptsRange(r) {
+			return nil
+		}
+		return NewIndexRangeSelectPlan(tp.plan, ii, r, false)
 	}
 	return nil
 }
 
+// makeIndexJoin は currentPlan のフィールドと等しいフィールドをキーにしたインデックスを使って結合する
+// 使えるインデックスが複数ある場合は、 BlocksAccessed が最小のものを選び、同じ場合はインデックス名が小さいものを選ぶ
 func (tp *TablePlanner) makeIndexJoin(currentPlan Planner, currentSchema *record.Schema) (Planner, error) {
-	for fn, ii := range tp.indexes {
+	var best Planner
+	for _, ii := range tp.sortedIndexes() {
+		outerField := tp.pred.EquatesWithField(ii.Key())
+		if outerField == "" || !currentSchema.HasField(outerField) {
+			continue
+		}
+		ip, err := NewIndexJoinPlan(currentPlan, tp.plan, ii, outerField)
+		if err != nil {
+			return nil, err
+		}
+		p, err := tp.addSelectPredicate(ip)
+		if err != nil {
+			return nil, err
+		}
+		p, err = tp.addJoinPredicate(p, currentSchema)
+		if err != nil {
+			return nil, err
+		}
+		best = cheaperPlan(best, p)
+	}
+	return best, nil
+}
+
+// makeMergeJoin は currentPlan のフィールドと等しいフィールドがある場合に、両方をソートして結合する
+func (tp *TablePlanner) makeMergeJoin(currentPlan Planner, currentSchema *record.Schema) (Planner, error) {
+	for _, fn := range tp.schema.Fields() {
 		outerField := tp.pred.EquatesWithField(fn)
 		if outerField != "" && currentSchema.HasField(outerField) {
-			ip, err := NewIndexJoinPlan(currentPlan, tp.plan, ii, outerField)
+			p, err := tp.addSelectPredicate(tp.plan)
 			if err != nil {
 				return nil, err
 			}
-			p, err := tp.addSelectPredicate(ip)
+			mp, err := NewMergeJoinPlan(tp.tx, currentPlan, p, outerField, fn, tp.generator)
 			if err != nil {
 				return nil, err
 			}
-			return tp.addJoinPredicate(p, currentSchema)
+			return tp.addJoinPredicate(mp, currentSchema)
 		}
 	}
 	return nil, nil
 }
 
+// makeHashJoin は currentPlan のフィールドと等しい同じ型のフィールドがある場合に、ハッシュ表を使って結合する
+// 型が違うと等しい値でもハッシュ値が異なることがあるので、候補にしない
+func (tp *TablePlanner) makeHashJoin(currentPlan Planner, currentSchema *record.Schema) (Planner, error) {
+	for _, fn := range tp.schema.Fields() {
+		outerField := tp.pred.EquatesWithField(fn)
+		if outerField == "" || !currentSchema.HasField(outerField) {
+			continue
+		}
+		innerType, err := tp.schema.FieldType(fn)
+		if err != nil {
+			return nil, err
+		}
+		outerType, err := currentSchema.FieldType(outerField)
+		if err != nil {
+			return nil, err
+		}
+		if innerType != outerType {
+			continue
+		}
+		p, err := tp.addSelectPredicate(tp.plan)
+		if err != nil {
+			return nil, err
+		}
+		hp, err := NewHashJoinPlan(tp.tx, currentPlan, p, outerField, fn, tp.generator)
+		if err != nil {
+			return nil, err
+		}
+		return tp.addJoinPredicate(hp, currentSchema)
+	}
+	return nil, nil
+}
+
 func (tp *TablePlanner) makeProductJoin(currentPlan Planner, currentSchema *record.Schema) (Planner, error) {
 	p, err := tp.MakeProductPlan(currentPlan)
 	if err != nil {
@@ -132,3 +507,29 @@ func (tp *TablePlanner) addJoinPredicate(p Planner, currentSchema *record.Schema
 	}
 	return p, nil
 }
+
+// sortedIndexes は使えるインデックスをインデックス名の順に返す
+// map の順に調べると、コストが同じ候補のどれを選ぶかが実行ごとに変わるので、候補はこの順に調べる
+func (tp *TablePlanner) sortedIndexes() []*metadata.IndexInfo {
+	indexes := make([]*metadata.IndexInfo, 0, len(tp.indexes))
+	for _, ii := range tp.indexes {
+		indexes = append(indexes, ii)
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		return indexes[i].IndexName() < indexes[j].IndexName()
+	})
+	return indexes
+}
+
+// cheaperPlan は BlocksAccessed が小さい方の plan を返す
+// 同じ場合は RecordsOutput が小さい方を、それも同じ場合は先に見つかった current を返す
+func cheaperPlan(current Planner, p Planner) Planner {
+	if current == nil {
+		return p
+	}
+	cb, pb := current.BlocksAccessed(), p.BlocksAccessed()
+	if pb < cb || (pb == cb && p.RecordsOutput() < current.RecordsOutput()) {
+		return p
+	}
+	return current
+}
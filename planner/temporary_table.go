@@ -7,7 +7,7 @@ import (
 )
 
 // TemporaryTable は TableManager の CreateTable メソッドでは生成されない
-// ファイル自体は生成される。適当なタイミングで削除される。
+// ファイル自体は生成される。次に起動したときに FileManager が削除する。
 type TemporaryTable struct {
 	tx        *tx.Transaction
 	tableName string
@@ -1,9 +1,16 @@
 package query
 
 import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"strconv"
+	"strings"
 
+	"github.com/google/uuid"
 	"github.com/ksrnnb/go-rdb/hashes"
+	"github.com/ksrnnb/go-rdb/sqlstate"
 )
 
 type ConstantType uint8
@@ -12,12 +19,21 @@ const (
 	UnknownConstant = iota
 	IntConstant
 	StringConstant
+	JSONConstant
+	BlobConstant
+	UUIDConstant
+	ParameterConstant
+	TupleConstant
 )
 
 type Constant struct {
 	intVal    int
 	stringVal string
 	ctype     ConstantType
+	// tuple は複合インデックスのキーの値
+	// upper が true の場合は、 tuple から始まる全ての組より大きい値として比べる
+	tuple []Constant
+	upper bool
 }
 
 func NewConstant(val interface{}) Constant {
@@ -26,15 +42,91 @@ func NewConstant(val interface{}) Constant {
 		return Constant{intVal: v, ctype: IntConstant}
 	case string:
 		return Constant{stringVal: v, ctype: StringConstant}
+	case []byte:
+		return Constant{stringVal: string(v), ctype: BlobConstant}
 	default:
 		return Constant{ctype: UnknownConstant}
 	}
 }
 
+// NewJSONConstant は JSON 文字列を検証して、空白を取り除いた Constant を返す
+func NewJSONConstant(text string) (Constant, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, []byte(text)); err != nil {
+		return Constant{}, sqlstate.Errorf(sqlstate.InvalidTextRepresentation, "invalid json value %q: %w", text, err)
+	}
+	return Constant{stringVal: buf.String(), ctype: JSONConstant}, nil
+}
+
+// NewUUIDConstant は UUID の定数を返す
+// 16 バイトのバイナリをそのまま保持するので、比較はバイト列の比較になる
+func NewUUIDConstant(u uuid.UUID) Constant {
+	return Constant{stringVal: string(u[:]), ctype: UUIDConstant}
+}
+
+// ParseUUID は UUID の文字列表現を検証して、UUID の定数を返す
+func ParseUUID(text string) (Constant, error) {
+	u, err := uuid.Parse(text)
+	if err != nil {
+		return Constant{}, sqlstate.Errorf(sqlstate.InvalidTextRepresentation, "invalid uuid %q: %w", text, err)
+	}
+	return NewUUIDConstant(u), nil
+}
+
+// NewParameterConstant はプリペアドステートメントのプレースホルダを表す定数を返す
+// n は 1 から始まるプレースホルダの番号で、実行時に Bind で値に置き換える
+func NewParameterConstant(n int) Constant {
+	return Constant{intVal: n, ctype: ParameterConstant}
+}
+
+// NewTupleConstant は複合インデックスのキーを表す組の定数を返す
+// 組は先頭の値から順に比べて、短い組は同じ値から始まる長い組より小さい
+func NewTupleConstant(vals []Constant) Constant {
+	return Constant{tuple: vals, ctype: TupleConstant}
+}
+
+// NewTupleUpperBound は vals から始まる全ての組より大きい組の定数を返す
+// 複合インデックスの先頭の列だけを指定した範囲の上端に使う
+func NewTupleUpperBound(vals []Constant) Constant {
+	return Constant{tuple: vals, ctype: TupleConstant, upper: true}
+}
+
 func (c Constant) IsUnknown() bool {
 	return c.ctype == UnknownConstant
 }
 
+func (c Constant) IsParameter() bool {
+	return c.ctype == ParameterConstant
+}
+
+// Bind はプレースホルダの場合に params から番号に対応する値を返す
+// IN の値の組の場合は、組に含まれるプレースホルダを置き換えた組を返す
+// プレースホルダでない場合はそのまま返す
+func (c Constant) Bind(params []Constant) (Constant, error) {
+	if c.ctype == TupleConstant {
+		vals := make([]Constant, len(c.tuple))
+		for i, v := range c.tuple {
+			bv, err := v.Bind(params)
+			if err != nil {
+				return Constant{}, err
+			}
+			vals[i] = bv
+		}
+		return Constant{tuple: vals, ctype: TupleConstant, upper: c.upper}, nil
+	}
+	if !c.IsParameter() {
+		return c, nil
+	}
+	if c.intVal < 1 || c.intVal > len(params) {
+		return Constant{}, sqlstate.Errorf(sqlstate.InvalidParameterValue, "parameter %s is not bound", c.String())
+	}
+	p := params[c.intVal-1]
+	if p.IsParameter() || p.IsUnknown() {
+		return Constant{}, sqlstate.Errorf(sqlstate.InvalidParameterValue, "invalid value for parameter %s", c.String())
+	}
+	return p, nil
+}
+
 func (c Constant) ConstantType() ConstantType {
 	return c.ctype
 }
@@ -47,14 +139,37 @@ func (c Constant) AsString() string {
 	return c.stringVal
 }
 
+func (c Constant) AsBytes() []byte {
+	return []byte(c.stringVal)
+}
+
+func (c Constant) AsTuple() []Constant {
+	return c.tuple
+}
+
+// IsTupleUpperBound は NewTupleUpperBound で作った組かどうかを返す
+func (c Constant) IsTupleUpperBound() bool {
+	return c.upper
+}
+
+func (c Constant) AsUUID() uuid.UUID {
+	var u uuid.UUID
+	copy(u[:], c.stringVal)
+	return u
+}
+
+// Equals は同じ型で同じ値の場合に true を返す
+// 型が異なる定数は等しくならないので、文字列のリテラルと UUID や JSON を比べる場合は ConvertTo で型を揃えておく
 func (c Constant) Equals(cc Constant) bool {
 	if c.ctype != cc.ctype {
 		return false
 	}
 	switch c.ctype {
-	case IntConstant:
+	case TupleConstant:
+		return c.compareToTuple(cc) == 0
+	case IntConstant, ParameterConstant:
 		return c.intVal == cc.intVal
-	case StringConstant:
+	case StringConstant, JSONConstant, BlobConstant, UUIDConstant:
 		return c.stringVal == cc.stringVal
 	default:
 		return false
@@ -62,7 +177,10 @@ func (c Constant) Equals(cc Constant) bool {
 }
 
 func (c Constant) CompareTo(cc Constant) int {
-	if c.ctype != IntConstant {
+	if c.ctype == TupleConstant && cc.ctype == TupleConstant {
+		return c.compareToTuple(cc)
+	}
+	if c.ctype == IntConstant {
 		return c.compareToInt(cc)
 	}
 	return c.compareToString(cc)
@@ -77,14 +195,77 @@ func (c Constant) IsLessThan(cc Constant) bool {
 }
 
 func (c Constant) String() string {
-	if c.ctype == IntConstant {
+	switch c.ctype {
+	case IntConstant:
 		return strconv.Itoa(c.intVal)
+	case BlobConstant:
+		// パースし直せるように 16 進数のリテラルで表す
+		return fmt.Sprintf("x'%s'", hex.EncodeToString(c.AsBytes()))
+	case UUIDConstant:
+		return c.AsUUID().String()
+	case ParameterConstant:
+		return fmt.Sprintf("$%d", c.intVal)
+	case TupleConstant:
+		vals := make([]string, len(c.tuple))
+		for i, v := range c.tuple {
+			vals[i] = NewExpressionFromConstant(v).argString()
+		}
+		if c.upper {
+			vals = append(vals, "+inf")
+		}
+		return fmt.Sprintf("(%s)", strings.Join(vals, ", "))
 	}
 	return c.stringVal
 }
 
+// HashCode は Equals が true になる定数どうしで同じ値を返す
+// ハッシュインデックスのバケットの決定に使うので、プロセスをまたいでも同じ値になる
 func (c Constant) HashCode() uint32 {
-	return hashes.HashCode(c)
+	switch c.ctype {
+	case IntConstant, ParameterConstant:
+		return hashes.Int(c.intVal)
+	case StringConstant, JSONConstant, BlobConstant, UUIDConstant:
+		// UUID は 16 バイトのバイナリをそのままハッシュする
+		return hashes.String(c.stringVal)
+	case TupleConstant:
+		var h uint32
+		for _, v := range c.tuple {
+			h = 31*h + v.HashCode()
+		}
+		return h
+	}
+	return 0
+}
+
+// ConvertTo は ctype の型の値と比べられるように、リテラルの定数を ctype の型に変換する
+// UUID には UUID の文字列表現を、 JSON には JSON として正しい文字列と整数を変換して、変換できない場合はそのまま返す
+// 文字列には整数を10進数の文字列に変換して、 payload->>'k' = 30 のように ->> で取り出した文字列と整数を比べられるようにする
+func (c Constant) ConvertTo(ctype ConstantType) Constant {
+	if c.ctype == ctype {
+		return c
+	}
+	switch ctype {
+	case StringConstant:
+		if c.ctype == IntConstant {
+			return NewConstant(strconv.Itoa(c.intVal))
+		}
+	case UUIDConstant:
+		if c.ctype == StringConstant {
+			if u, err := ParseUUID(c.stringVal); err == nil {
+				return u
+			}
+		}
+	case JSONConstant:
+		switch c.ctype {
+		case IntConstant:
+			return Constant{stringVal: strconv.Itoa(c.intVal), ctype: JSONConstant}
+		case StringConstant:
+			if j, err := NewJSONConstant(c.stringVal); err == nil {
+				return j
+			}
+		}
+	}
+	return c
 }
 
 func (c Constant) compareToInt(cc Constant) int {
@@ -96,6 +277,29 @@ func (c Constant) compareToInt(cc Constant) int {
 	return 1
 }
 
+// compareToTuple は組を先頭の値から順に比べる
+// 一方が他方の先頭部分と等しい場合は、上端の組を大きく、それ以外は短い組を小さいとみなす
+func (c Constant) compareToTuple(cc Constant) int {
+	n := len(c.tuple)
+	if len(cc.tuple) < n {
+		n = len(cc.tuple)
+	}
+	for i := 0; i < n; i++ {
+		if result := c.tuple[i].CompareTo(cc.tuple[i]); result != 0 {
+			return result
+		}
+	}
+	switch {
+	case c.upper && cc.upper:
+		return len(cc.tuple) - len(c.tuple)
+	case c.upper:
+		return 1
+	case cc.upper:
+		return -1
+	}
+	return len(c.tuple) - len(cc.tuple)
+}
+
 func (c Constant) compareToString(cc Constant) int {
 	if c.stringVal < cc.stringVal {
 		return -1
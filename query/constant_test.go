@@ -0,0 +1,107 @@
+package query_test
+
+import (
+	"testing"
+
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConstant_CompareTo(t *testing.T) {
+	// 整数は数値の大小で、文字列は辞書順で比べる
+	assert.True(t, query.NewConstant(2).IsLessThan(query.NewConstant(10)))
+	assert.True(t, query.NewConstant(10).IsGreaterThan(query.NewConstant(2)))
+	assert.True(t, query.NewConstant(-1).IsLessThan(query.NewConstant(0)))
+	assert.Equal(t, 0, query.NewConstant(7).CompareTo(query.NewConstant(7)))
+	assert.True(t, query.NewConstant("10").IsLessThan(query.NewConstant("2")))
+	assert.Equal(t, 0, query.NewConstant("a").CompareTo(query.NewConstant("a")))
+}
+
+func TestConstant_UUID(t *testing.T) {
+	u1, err := query.ParseUUID("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	require.NoError(t, err)
+	u2, err := query.ParseUUID("6BA7B811-9DAD-11D1-80B4-00C04FD430C8")
+	require.NoError(t, err)
+
+	_, err = query.ParseUUID("6ba7b810-9dad-11d1-80b4")
+	assert.Error(t, err, "too short")
+	_, err = query.ParseUUID("6ba7b810-9dad-11d1-80b4-00c04fd430zz")
+	assert.Error(t, err, "invalid hex")
+
+	assert.Equal(t, "6ba7b811-9dad-11d1-80b4-00c04fd430c8", u2.String())
+	// 文字列のリテラルは UUID に変換してから比べる
+	literal := query.NewConstant("6BA7B810-9DAD-11D1-80B4-00C04FD430C8")
+	assert.False(t, u1.Equals(literal), "different types are not equal")
+	assert.True(t, u1.Equals(literal.ConvertTo(query.UUIDConstant)), "equals converted string literal")
+	assert.Equal(t, u1.HashCode(), literal.ConvertTo(query.UUIDConstant).HashCode())
+	notUUID := query.NewConstant("not a uuid")
+	assert.Equal(t, notUUID, notUUID.ConvertTo(query.UUIDConstant))
+	assert.False(t, u1.Equals(u2))
+	assert.True(t, u1.IsLessThan(u2))
+	assert.True(t, u2.IsGreaterThan(literal.ConvertTo(query.UUIDConstant)))
+
+	same, err := query.ParseUUID(u1.String())
+	require.NoError(t, err)
+	assert.Equal(t, u1.HashCode(), same.HashCode())
+	assert.NotEqual(t, u1.HashCode(), u2.HashCode())
+}
+
+func TestConstant_JSON(t *testing.T) {
+	doc, err := query.NewJSONConstant(`{"a": [1, 2]}`)
+	require.NoError(t, err)
+	one, err := query.NewJSONConstant("1")
+	require.NoError(t, err)
+
+	// JSON は他の型の値と等しくならず、リテラルを JSON に変換してから比べる
+	assert.False(t, one.Equals(query.NewConstant(1)))
+	assert.True(t, one.Equals(query.NewConstant(1).ConvertTo(query.JSONConstant)))
+	assert.Equal(t, one.HashCode(), query.NewConstant(1).ConvertTo(query.JSONConstant).HashCode())
+	literal := query.NewConstant(`{"a":[1,2]}`)
+	assert.False(t, doc.Equals(literal))
+	assert.True(t, doc.Equals(literal.ConvertTo(query.JSONConstant)))
+	assert.Equal(t, doc.HashCode(), literal.ConvertTo(query.JSONConstant).HashCode())
+	invalid := query.NewConstant("{")
+	assert.Equal(t, invalid, invalid.ConvertTo(query.JSONConstant))
+
+	// ->> で取り出した文字列と比べられるように、整数のリテラルは文字列に変換する
+	assert.False(t, query.NewConstant("30").Equals(query.NewConstant(30)))
+	assert.True(t, query.NewConstant("30").Equals(query.NewConstant(30).ConvertTo(query.StringConstant)))
+	assert.Equal(t, query.NewConstant("-5"), query.NewConstant(-5).ConvertTo(query.StringConstant))
+}
+
+func TestConstant_HashCode(t *testing.T) {
+	// 同じ値なら何度呼び出しても同じハッシュ値になる
+	assert.Equal(t, query.NewConstant(42).HashCode(), query.NewConstant(42).HashCode())
+	assert.Equal(t, query.NewConstant(42).HashCode(), query.NewConstant(42).HashCode())
+	assert.NotEqual(t, query.NewConstant(42).HashCode(), query.NewConstant(43).HashCode())
+	assert.Equal(t, query.NewConstant("hoge").HashCode(), query.NewConstant("hoge").HashCode())
+	assert.NotEqual(t, query.NewConstant("hoge").HashCode(), query.NewConstant("fuga").HashCode())
+}
+
+func TestConstant_Tuple(t *testing.T) {
+	tuple := func(vals ...any) query.Constant {
+		cs := make([]query.Constant, len(vals))
+		for i, v := range vals {
+			cs[i] = query.NewConstant(v)
+		}
+		return query.NewTupleConstant(cs)
+	}
+
+	assert.True(t, tuple(1, "a").Equals(tuple(1, "a")))
+	assert.False(t, tuple(1, "a").Equals(tuple(1, "b")))
+	assert.Equal(t, tuple(1, "a").HashCode(), tuple(1, "a").HashCode())
+	assert.Equal(t, "(1, 'a')", tuple(1, "a").String())
+
+	// 先頭の列から順に比べる
+	assert.True(t, tuple(1, "b").IsLessThan(tuple(2, "a")))
+	assert.True(t, tuple(1, "a").IsLessThan(tuple(1, "b")))
+	assert.True(t, tuple(2, "a").IsGreaterThan(tuple(1, "z")))
+
+	// 先頭の一部の組は、その組から始まる組より小さく、上端の組はその組から始まる全ての組より大きい
+	upper := query.NewTupleUpperBound([]query.Constant{query.NewConstant(1)})
+	assert.True(t, tuple(1).IsLessThan(tuple(1, "a")))
+	assert.True(t, upper.IsGreaterThan(tuple(1, "zzz")))
+	assert.True(t, upper.IsLessThan(tuple(2, "a")))
+	assert.False(t, upper.Equals(tuple(1)))
+}
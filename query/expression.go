@@ -1,17 +1,26 @@
 package query
 
-import "github.com/ksrnnb/go-rdb/record"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ksrnnb/go-rdb/record"
+	"github.com/ksrnnb/go-rdb/sqlstate"
+)
 
 type ExpressionType uint8
 
 const (
 	FieldNameExpression = iota + 1
 	ConstantExpression
+	FunctionExpression
 )
 
 type Expression struct {
 	val       Constant
 	fieldName string
+	fn        Function
+	args      []Expression
 	etype     ExpressionType
 }
 
@@ -23,6 +32,19 @@ func NewExpressionFromFieldName(fieldName string) Expression {
 	return Expression{fieldName: fieldName, etype: FieldNameExpression}
 }
 
+// NewExpressionFromFunction は関数呼び出しの式を生成する
+// 演算子 (->, ->>) も関数として扱う
+func NewExpressionFromFunction(name string, args []Expression) (Expression, error) {
+	fn, err := LookupFunction(name)
+	if err != nil {
+		return Expression{}, err
+	}
+	if len(args) != fn.numArgs {
+		return Expression{}, sqlstate.Errorf(sqlstate.UndefinedFunction, "function %s requires %d arguments, but got %d", name, fn.numArgs, len(args))
+	}
+	return Expression{fn: fn, args: args, etype: FunctionExpression}, nil
+}
+
 func (e Expression) IsConstant() bool {
 	return e.etype == ConstantExpression
 }
@@ -31,6 +53,10 @@ func (e Expression) IsFieldName() bool {
 	return e.etype == FieldNameExpression
 }
 
+func (e Expression) IsFunction() bool {
+	return e.etype == FunctionExpression
+}
+
 func (e Expression) AsConstant() Constant {
 	return e.val
 }
@@ -39,11 +65,86 @@ func (e Expression) AsFieldName() string {
 	return e.fieldName
 }
 
+// HasParameters は式にプレースホルダが含まれるかどうかを返す
+func (e Expression) HasParameters() bool {
+	switch e.etype {
+	case ConstantExpression:
+		if e.val.ConstantType() == TupleConstant {
+			for _, v := range e.val.AsTuple() {
+				if v.IsParameter() {
+					return true
+				}
+			}
+		}
+		return e.val.IsParameter()
+	case FunctionExpression:
+		for _, arg := range e.args {
+			if arg.HasParameters() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Bind は式に含まれるプレースホルダを params の値に置き換えた式を返す
+func (e Expression) Bind(params []Constant) (Expression, error) {
+	switch e.etype {
+	case ConstantExpression:
+		c, err := e.val.Bind(params)
+		if err != nil {
+			return Expression{}, err
+		}
+		return NewExpressionFromConstant(c), nil
+	case FunctionExpression:
+		args := make([]Expression, len(e.args))
+		for i, arg := range e.args {
+			a, err := arg.Bind(params)
+			if err != nil {
+				return Expression{}, err
+			}
+			args[i] = a
+		}
+		return Expression{fn: e.fn, args: args, etype: FunctionExpression}, nil
+	}
+	return e, nil
+}
+
+// FieldNames は式が参照しているフィールド名を返す
+func (e Expression) FieldNames() []string {
+	switch e.etype {
+	case FieldNameExpression:
+		return []string{e.fieldName}
+	case FunctionExpression:
+		fns := make([]string, 0)
+		for _, arg := range e.args {
+			fns = append(fns, arg.FieldNames()...)
+		}
+		return fns
+	}
+	return nil
+}
+
 // Evaluate は式を評価して、定数の場合はそのまま定数を返して
 // フィールド名の場合は、 Scanner から値を取得する
+// 関数の場合は引数を評価してから関数を呼び出す
 func (e Expression) Evaluate(s Scanner) (Constant, error) {
-	if e.IsConstant() {
+	switch e.etype {
+	case ConstantExpression:
+		if e.val.IsParameter() {
+			return Constant{}, sqlstate.Errorf(sqlstate.InvalidParameterValue, "parameter %s is not bound", e.val.String())
+		}
 		return e.val, nil
+	case FunctionExpression:
+		args := make([]Constant, len(e.args))
+		for i, arg := range e.args {
+			v, err := arg.Evaluate(s)
+			if err != nil {
+				return Constant{}, err
+			}
+			args[i] = v
+		}
+		return e.fn.eval(args)
 	}
 	return s.GetVal(e.fieldName)
 }
@@ -51,15 +152,68 @@ func (e Expression) Evaluate(s Scanner) (Constant, error) {
 // AppliesTo は Expression の値が Schema に含まれるかどうかを返す
 // 定数の場合は無条件で true を返す
 func (e Expression) AppliesTo(schema *record.Schema) bool {
-	if e.IsConstant() {
+	switch e.etype {
+	case ConstantExpression:
+		return true
+	case FunctionExpression:
+		for _, arg := range e.args {
+			if !arg.AppliesTo(schema) {
+				return false
+			}
+		}
 		return true
 	}
 	return schema.HasField(e.fieldName)
 }
 
+// ResultType は式を評価した結果の型を返す
+func (e Expression) ResultType(schema *record.Schema) (record.FieldType, error) {
+	switch e.etype {
+	case ConstantExpression:
+		switch e.val.ctype {
+		case IntConstant:
+			return record.Integer, nil
+		case JSONConstant:
+			return record.JSON, nil
+		case BlobConstant:
+			return record.Blob, nil
+		case UUIDConstant:
+			return record.UUID, nil
+		case ParameterConstant:
+			return record.Unknown, sqlstate.Errorf(sqlstate.DatatypeMismatch, "type of parameter %s is unknown", e.val.String())
+		}
+		return record.String, nil
+	case FunctionExpression:
+		return e.fn.resultType, nil
+	}
+	return schema.FieldType(e.fieldName)
+}
+
 func (e Expression) String() string {
-	if e.IsConstant() {
+	switch e.etype {
+	case ConstantExpression:
 		return e.val.String()
+	case FunctionExpression:
+		args := make([]string, len(e.args))
+		for i, arg := range e.args {
+			args[i] = arg.argString()
+		}
+		if e.fn.infix {
+			return strings.Join(args, e.fn.name)
+		}
+		return fmt.Sprintf("%s(%s)", e.fn.name, strings.Join(args, ", "))
 	}
 	return e.fieldName
 }
+
+// argString は関数の引数や Term の辺として表示する文字列を返す
+// 再度パースできるように文字列の定数はクォートして、 ' は2つ続けてエスケープする
+func (e Expression) argString() string {
+	if e.IsConstant() && e.val.ctype == StringConstant {
+		return fmt.Sprintf("'%s'", strings.ReplaceAll(e.val.stringVal, "'", "''"))
+	}
+	if e.IsConstant() && e.val.ctype == UUIDConstant {
+		return fmt.Sprintf("'%s'", e.val.String())
+	}
+	return e.String()
+}
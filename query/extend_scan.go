@@ -0,0 +1,78 @@
+package query
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+// ExtendScan は下位の Scanner のフィールドに、式を評価したフィールドを追加する
+// 追加したフィールドの名前は式の文字列になる
+type ExtendScan struct {
+	scan  Scanner
+	exprs map[string]Expression
+}
+
+func NewExtendScan(scan Scanner, exprs []Expression) *ExtendScan {
+	m := make(map[string]Expression, len(exprs))
+	for _, e := range exprs {
+		m[e.String()] = e
+	}
+	return &ExtendScan{scan, m}
+}
+
+func (es *ExtendScan) BeforeFirst() error {
+	return es.scan.BeforeFirst()
+}
+
+func (es *ExtendScan) Next() (bool, error) {
+	return es.scan.Next()
+}
+
+func (es *ExtendScan) GetInt(fieldName string) (int, error) {
+	v, err := es.GetVal(fieldName)
+	if err != nil {
+		return 0, err
+	}
+	if v.ctype != IntConstant {
+		return 0, errors.New("field is not integer")
+	}
+	return v.AsInt(), nil
+}
+
+func (es *ExtendScan) GetString(fieldName string) (string, error) {
+	v, err := es.GetVal(fieldName)
+	if err != nil {
+		return "", err
+	}
+	return v.String(), nil
+}
+
+func (es *ExtendScan) GetReader(fieldName string) (io.Reader, error) {
+	if _, ok := es.exprs[fieldName]; ok {
+		v, err := es.GetVal(fieldName)
+		if err != nil {
+			return nil, err
+		}
+		return strings.NewReader(v.AsString()), nil
+	}
+	return GetReader(es.scan, fieldName)
+}
+
+func (es *ExtendScan) GetVal(fieldName string) (Constant, error) {
+	if e, ok := es.exprs[fieldName]; ok {
+		return e.Evaluate(es.scan)
+	}
+	return es.scan.GetVal(fieldName)
+}
+
+func (es *ExtendScan) HasField(fieldName string) bool {
+	if _, ok := es.exprs[fieldName]; ok {
+		return true
+	}
+	return es.scan.HasField(fieldName)
+}
+
+func (es *ExtendScan) Close() error {
+	return es.scan.Close()
+}
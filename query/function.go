@@ -0,0 +1,106 @@
+package query
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/ksrnnb/go-rdb/record"
+	"github.com/ksrnnb/go-rdb/sqlstate"
+)
+
+// ExpressionStringLength は文字列を返す式の結果を保存するときの最大文字数
+const ExpressionStringLength = 32
+
+// Function は式の中で使える組み込み関数
+type Function struct {
+	name       string
+	numArgs    int
+	infix      bool
+	resultType record.FieldType
+	eval       func(args []Constant) (Constant, error)
+}
+
+// 演算子も引数 2 つの関数として扱う
+var functions = map[string]Function{
+	"->": {
+		name:       "->",
+		numArgs:    2,
+		infix:      true,
+		resultType: record.JSON,
+		eval: func(args []Constant) (Constant, error) {
+			return ExtractJSON(args[0], args[1])
+		},
+	},
+	"->>": {
+		name:       "->>",
+		numArgs:    2,
+		infix:      true,
+		resultType: record.String,
+		eval: func(args []Constant) (Constant, error) {
+			return ExtractJSONText(args[0], args[1])
+		},
+	},
+	"json_extract": {
+		name:       "json_extract",
+		numArgs:    2,
+		resultType: record.JSON,
+		eval: func(args []Constant) (Constant, error) {
+			return ExtractJSON(args[0], args[1])
+		},
+	},
+	"lower": {
+		name:       "lower",
+		numArgs:    1,
+		resultType: record.String,
+		eval: func(args []Constant) (Constant, error) {
+			return mapString(args[0], strings.ToLower), nil
+		},
+	},
+	"upper": {
+		name:       "upper",
+		numArgs:    1,
+		resultType: record.String,
+		eval: func(args []Constant) (Constant, error) {
+			return mapString(args[0], strings.ToUpper), nil
+		},
+	},
+	"gen_random_uuid": {
+		name:       "gen_random_uuid",
+		numArgs:    0,
+		resultType: record.UUID,
+		eval: func(args []Constant) (Constant, error) {
+			u, err := uuid.NewRandom()
+			if err != nil {
+				return Constant{}, err
+			}
+			return NewUUIDConstant(u), nil
+		},
+	},
+}
+
+// mapString は文字列の定数を f で変換する
+// 値が存在しない場合は Unknown のまま返す
+func mapString(c Constant, f func(string) string) Constant {
+	if c.IsUnknown() {
+		return c
+	}
+	return NewConstant(f(c.String()))
+}
+
+// LookupFunction は関数名から組み込み関数を取得する
+func LookupFunction(name string) (Function, error) {
+	f, ok := functions[name]
+	if !ok {
+		return Function{}, sqlstate.Errorf(sqlstate.UndefinedFunction, "function %s is not found", name)
+	}
+	return f, nil
+}
+
+func (f Function) Name() string {
+	return f.name
+}
+
+// ResultType は関数の戻り値の型を返す
+func (f Function) ResultType() record.FieldType {
+	return f.resultType
+}
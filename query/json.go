@@ -0,0 +1,141 @@
+package query
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/ksrnnb/go-rdb/sqlstate"
+)
+
+// ExtractJSON は JSON から path で指定した値を JSON のまま取り出す (-> 演算子)
+// path が int の場合は配列の添字、文字列の場合はオブジェクトのキーとして扱う
+// 文字列が $ から始まる場合は $.a.b[0] のようなパスとして扱う
+// 値が存在しない場合は Unknown の Constant を返す
+func ExtractJSON(doc Constant, path Constant) (Constant, error) {
+	v, ok, err := extractJSONValue(doc, path)
+	if err != nil || !ok {
+		return Constant{}, err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return Constant{}, err
+	}
+	return Constant{stringVal: string(b), ctype: JSONConstant}, nil
+}
+
+// ExtractJSONText は JSON から path で指定した値をテキストとして取り出す (->> 演算子)
+// 文字列はクォートを外し、null や値が存在しない場合は Unknown の Constant を返す
+func ExtractJSONText(doc Constant, path Constant) (Constant, error) {
+	v, ok, err := extractJSONValue(doc, path)
+	if err != nil || !ok || v == nil {
+		return Constant{}, err
+	}
+	if s, ok := v.(string); ok {
+		return NewConstant(s), nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return Constant{}, err
+	}
+	return NewConstant(string(b)), nil
+}
+
+func extractJSONValue(doc Constant, path Constant) (interface{}, bool, error) {
+	if doc.IsUnknown() || path.IsUnknown() {
+		return nil, false, nil
+	}
+	if doc.ctype == IntConstant {
+		return nil, false, sqlstate.Errorf(sqlstate.DatatypeMismatch, "cannot extract path from non json value %s", doc.String())
+	}
+
+	dec := json.NewDecoder(strings.NewReader(doc.stringVal))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, false, sqlstate.Errorf(sqlstate.InvalidTextRepresentation, "invalid json value %q: %w", doc.stringVal, err)
+	}
+
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, step := range steps {
+		switch cur := v.(type) {
+		case map[string]interface{}:
+			next, ok := cur[step.key]
+			if step.isIndex || !ok {
+				return nil, false, nil
+			}
+			v = next
+		case []interface{}:
+			if !step.isIndex || step.index < 0 || step.index >= len(cur) {
+				return nil, false, nil
+			}
+			v = cur[step.index]
+		default:
+			return nil, false, nil
+		}
+	}
+	return v, true, nil
+}
+
+type jsonPathStep struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// parseJSONPath は path を step の配列に変換する
+// $ から始まらない文字列は 1 つのキーとして扱う
+func parseJSONPath(path Constant) ([]jsonPathStep, error) {
+	if path.ctype == IntConstant {
+		return []jsonPathStep{{index: path.intVal, isIndex: true}}, nil
+	}
+
+	p := path.stringVal
+	if !strings.HasPrefix(p, "$") {
+		return []jsonPathStep{{key: p}}, nil
+	}
+
+	steps := make([]jsonPathStep, 0)
+	rest := p[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, sqlstate.Errorf(sqlstate.InvalidParameterValue, "invalid json path %q", p)
+			}
+			steps = append(steps, jsonPathStep{key: rest[:end]})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, sqlstate.Errorf(sqlstate.InvalidParameterValue, "invalid json path %q", p)
+			}
+			inner := rest[1:end]
+			rest = rest[end+1:]
+			if strings.HasPrefix(inner, "\"") {
+				key, err := strconv.Unquote(inner)
+				if err != nil {
+					return nil, sqlstate.Errorf(sqlstate.InvalidParameterValue, "invalid json path %q", p)
+				}
+				steps = append(steps, jsonPathStep{key: key})
+				continue
+			}
+			i, err := strconv.Atoi(inner)
+			if err != nil {
+				return nil, sqlstate.Errorf(sqlstate.InvalidParameterValue, "invalid json path %q", p)
+			}
+			steps = append(steps, jsonPathStep{index: i, isIndex: true})
+		default:
+			return nil, sqlstate.Errorf(sqlstate.InvalidParameterValue, "invalid json path %q", p)
+		}
+	}
+	return steps, nil
+}
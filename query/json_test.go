@@ -0,0 +1,69 @@
+package query_test
+
+import (
+	"testing"
+
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractJSON(t *testing.T) {
+	doc, err := query.NewJSONConstant(`{"name": "alice", "age": 20, "tags": ["a", "b"], "address": {"city": "Tokyo"}, "memo": null}`)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		path     query.Constant
+		wantJSON string
+		wantText string
+		unknown  bool
+	}{
+		{name: "key", path: query.NewConstant("name"), wantJSON: `"alice"`, wantText: "alice"},
+		{name: "number", path: query.NewConstant("age"), wantJSON: "20", wantText: "20"},
+		{name: "object", path: query.NewConstant("address"), wantJSON: `{"city":"Tokyo"}`, wantText: `{"city":"Tokyo"}`},
+		{name: "path", path: query.NewConstant("$.address.city"), wantJSON: `"Tokyo"`, wantText: "Tokyo"},
+		{name: "path with array index", path: query.NewConstant("$.tags[1]"), wantJSON: `"b"`, wantText: "b"},
+		{name: "path with quoted key", path: query.NewConstant(`$["address"].city`), wantJSON: `"Tokyo"`, wantText: "Tokyo"},
+		{name: "missing key", path: query.NewConstant("email"), unknown: true},
+		{name: "array index on object", path: query.NewConstant(0), unknown: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j, err := query.ExtractJSON(doc, tt.path)
+			require.NoError(t, err)
+			text, err := query.ExtractJSONText(doc, tt.path)
+			require.NoError(t, err)
+
+			if tt.unknown {
+				assert.True(t, j.IsUnknown())
+				assert.True(t, text.IsUnknown())
+				return
+			}
+			assert.Equal(t, query.ConstantType(query.JSONConstant), j.ConstantType())
+			assert.Equal(t, tt.wantJSON, j.String())
+			assert.Equal(t, query.ConstantType(query.StringConstant), text.ConstantType())
+			assert.Equal(t, tt.wantText, text.String())
+		})
+	}
+
+	t.Run("null is extracted as unknown text", func(t *testing.T) {
+		j, err := query.ExtractJSON(doc, query.NewConstant("memo"))
+		require.NoError(t, err)
+		assert.Equal(t, "null", j.String())
+		text, err := query.ExtractJSONText(doc, query.NewConstant("memo"))
+		require.NoError(t, err)
+		assert.True(t, text.IsUnknown())
+	})
+
+	t.Run("invalid json", func(t *testing.T) {
+		_, err := query.NewJSONConstant(`{"name": `)
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid path", func(t *testing.T) {
+		_, err := query.ExtractJSON(doc, query.NewConstant("$.tags[x]"))
+		assert.Error(t, err)
+	})
+}
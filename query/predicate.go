@@ -38,6 +38,59 @@ func (p *Predicate) IsSatisfied(s Scanner) (bool, error) {
 	return true, nil
 }
 
+// FieldNames は全ての Term が参照しているフィールド名を返す
+func (p *Predicate) FieldNames() []string {
+	fns := make([]string, 0)
+	for _, t := range p.terms {
+		fns = append(fns, t.FieldNames()...)
+	}
+	return fns
+}
+
+// AppliesTo は全ての Term が schema のフィールドだけを参照しているかどうかを返す
+func (p *Predicate) AppliesTo(schema *record.Schema) bool {
+	for _, t := range p.terms {
+		if !t.AppliesTo(schema) {
+			return false
+		}
+	}
+	return true
+}
+
+// Implies は p を満たすレコードが必ず q も満たすかどうかを返す
+// q の各 Term について、 p に同じ Term があるか、 p から分かる値の範囲が Term の範囲に含まれる場合に満たすとみなす
+// 部分インデックスの条件をクエリの条件が満たすかどうかを確認するときに使う
+func (p *Predicate) Implies(q *Predicate) bool {
+	for _, t := range q.terms {
+		if !p.impliesTerm(t) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Predicate) impliesTerm(t Term) bool {
+	for _, pt := range p.terms {
+		if pt.String() == t.String() {
+			return true
+		}
+	}
+	// LIKE の範囲はパターンに一致しない値も含むので、範囲で比べられない
+	if t.op == Like {
+		return false
+	}
+	fn, ok := t.comparedExpression()
+	if !ok {
+		return false
+	}
+	tr, ok := t.RangeWithConstant(fn)
+	if !ok {
+		return false
+	}
+	pr, ok := p.RangeWithConstant(fn)
+	return ok && tr.Includes(pr)
+}
+
 func (p *Predicate) ReductionFactor(planner Planner) int {
 	factor := 1
 	for _, t := range p.terms {
@@ -92,6 +145,49 @@ func (p *Predicate) EquatesWithConstant(fieldName string) Constant {
 	return Constant{}
 }
 
+// ValuesWithConstant は fieldName が定数のいずれかと等しい条件 ("F=c" か "F IN (c1, c2, ...)") がある場合に、その値を返す
+// そのような Term が複数ある場合は最初の Term の値を返す
+func (p *Predicate) ValuesWithConstant(fieldName string) ([]Constant, bool) {
+	for _, t := range p.terms {
+		if vals, ok := t.ValuesWithConstant(fieldName); ok {
+			return vals, true
+		}
+	}
+	return nil, false
+}
+
+// MatchTerms は fieldName の全文検索の条件がある場合に、検索する語の文字列を返す
+// そのような Term がない場合は Unknown を返す
+func (p *Predicate) MatchTerms(fieldName string) Constant {
+	for _, t := range p.terms {
+		c := t.MatchTerms(fieldName)
+		if !c.IsUnknown() {
+			return c
+		}
+	}
+	return Constant{}
+}
+
+// RangeWithConstant は fieldName と定数を比べる Term を全て満たす値の範囲を返す
+// そのような Term がない場合は false を返す
+func (p *Predicate) RangeWithConstant(fieldName string) (Range, bool) {
+	var r Range
+	found := false
+	for _, t := range p.terms {
+		tr, ok := t.RangeWithConstant(fieldName)
+		if !ok {
+			continue
+		}
+		if !found {
+			r = tr
+			found = true
+			continue
+		}
+		r = r.Intersect(tr)
+	}
+	return r, found
+}
+
 func (p *Predicate) EquatesWithField(fieldName string) string {
 	for _, t := range p.terms {
 		s := t.EquatesWithFieldName(fieldName)
@@ -102,6 +198,20 @@ func (p *Predicate) EquatesWithField(fieldName string) string {
 	return ""
 }
 
+// Bind はプレースホルダを params の値に置き換えた Predicate を返す
+// プリペアドステートメントで使い回せるように、元の Predicate は変更しない
+func (p *Predicate) Bind(params []Constant) (*Predicate, error) {
+	newP := NewPredicate()
+	for _, t := range p.terms {
+		bt, err := t.Bind(params)
+		if err != nil {
+			return nil, err
+		}
+		newP.terms = append(newP.terms, bt)
+	}
+	return newP, nil
+}
+
 func (p *Predicate) String() string {
 	var s string
 	for i, t := range p.terms {
@@ -0,0 +1,45 @@
+package query_test
+
+import (
+	"testing"
+
+	"github.com/ksrnnb/go-rdb/parser"
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPredicate_Implies(t *testing.T) {
+	parse := func(s string) *query.Predicate {
+		t.Helper()
+		p, err := parser.NewParser(s)
+		require.NoError(t, err)
+		pred, err := p.Predicate()
+		require.NoError(t, err)
+		return pred
+	}
+	tests := []struct {
+		p    string
+		q    string
+		want bool
+	}{
+		{"active = 1", "active = 1", true},
+		{"name = 'a' and active = 1", "active = 1", true},
+		{"1 = active", "active = 1", true},
+		{"name = 'a'", "active = 1", false},
+		{"active = 2", "active = 1", false},
+		{"age > 30", "age >= 20", true},
+		{"age between 20 and 30", "age >= 20", true},
+		{"age >= 20", "age > 20", false},
+		{"age = 20", "age <= 20", true},
+		{"name like 'ab%'", "name >= 'ab'", true},
+		{"name = 'abc'", "name like 'ab%'", false},
+		{"name like 'ab%'", "name like 'ab%'", true},
+		{"active <> 0", "active <> 0", true},
+		{"active = 1", "active <> 0", false},
+		{"active = 1", "active = 1 and age > 20", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, parse(tt.p).Implies(parse(tt.q)), "%s implies %s", tt.p, tt.q)
+	}
+}
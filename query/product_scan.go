@@ -1,16 +1,20 @@
 package query
 
+import "io"
+
 type ProductScan struct {
 	scan1 Scanner
 	scan2 Scanner
+	// scan1 が現在のレコードを指しているかどうか
+	hasMore1 bool
 }
 
 func NewProductScan(scan1, scan2 Scanner) (*ProductScan, error) {
-	_, err := scan1.Next()
+	hasMore1, err := scan1.Next()
 	if err != nil {
 		return nil, err
 	}
-	return &ProductScan{scan1, scan2}, nil
+	return &ProductScan{scan1, scan2, hasMore1}, nil
 }
 
 func (ps *ProductScan) BeforeFirst() error {
@@ -18,7 +22,7 @@ func (ps *ProductScan) BeforeFirst() error {
 	if err != nil {
 		return err
 	}
-	_, err = ps.scan1.Next()
+	ps.hasMore1, err = ps.scan1.Next()
 	if err != nil {
 		return err
 	}
@@ -26,6 +30,9 @@ func (ps *ProductScan) BeforeFirst() error {
 }
 
 func (ps *ProductScan) Next() (bool, error) {
+	if !ps.hasMore1 {
+		return false, nil
+	}
 	hasNext, err := ps.scan2.Next()
 	if err != nil {
 		return false, err
@@ -48,11 +55,11 @@ func (ps *ProductScan) Next() (bool, error) {
 		return false, nil
 	}
 
-	hasNext, err = ps.scan1.Next()
+	ps.hasMore1, err = ps.scan1.Next()
 	if err != nil {
 		return false, err
 	}
-	return hasNext, nil
+	return ps.hasMore1, nil
 }
 
 func (ps *ProductScan) GetInt(fieldName string) (int, error) {
@@ -76,6 +83,13 @@ func (ps *ProductScan) GetVal(fieldName string) (Constant, error) {
 	return ps.scan2.GetVal(fieldName)
 }
 
+func (ps *ProductScan) GetReader(fieldName string) (io.Reader, error) {
+	if ps.scan1.HasField(fieldName) {
+		return GetReader(ps.scan1, fieldName)
+	}
+	return GetReader(ps.scan2, fieldName)
+}
+
 func (ps *ProductScan) HasField(fieldName string) bool {
 	return ps.scan1.HasField(fieldName) || ps.scan2.HasField(fieldName)
 }
@@ -1,6 +1,10 @@
 package query
 
-import "errors"
+import (
+	"io"
+
+	"github.com/ksrnnb/go-rdb/sqlstate"
+)
 
 type ProjectScan struct {
 	scan      Scanner
@@ -23,21 +27,28 @@ func (ps *ProjectScan) GetInt(fieldName string) (int, error) {
 	if ps.HasField(fieldName) {
 		return ps.scan.GetInt(fieldName)
 	}
-	return 0, errors.New("field not found")
+	return 0, sqlstate.Errorf(sqlstate.UndefinedColumn, "field not found: %s", fieldName)
 }
 
 func (ps *ProjectScan) GetString(fieldName string) (string, error) {
 	if ps.HasField(fieldName) {
 		return ps.scan.GetString(fieldName)
 	}
-	return "", errors.New("field not found")
+	return "", sqlstate.Errorf(sqlstate.UndefinedColumn, "field not found: %s", fieldName)
 }
 
 func (ps *ProjectScan) GetVal(fieldName string) (Constant, error) {
 	if ps.HasField(fieldName) {
 		return ps.scan.GetVal(fieldName)
 	}
-	return Constant{}, errors.New("field not found")
+	return Constant{}, sqlstate.Errorf(sqlstate.UndefinedColumn, "field not found: %s", fieldName)
+}
+
+func (ps *ProjectScan) GetReader(fieldName string) (io.Reader, error) {
+	if ps.HasField(fieldName) {
+		return GetReader(ps.scan, fieldName)
+	}
+	return nil, sqlstate.Errorf(sqlstate.UndefinedColumn, "field not found: %s", fieldName)
 }
 
 func (ps *ProjectScan) HasField(fieldName string) bool {
@@ -0,0 +1,208 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// rangeReductionFactor は範囲の条件でレコード数が何分の 1 になるかの見積もり
+const rangeReductionFactor = 3
+
+// Bound は範囲の端
+// 値が Unknown の場合は端がない
+type Bound struct {
+	val       Constant
+	inclusive bool
+}
+
+func NewBound(val Constant, inclusive bool) Bound {
+	return Bound{val, inclusive}
+}
+
+func (b Bound) Value() Constant {
+	return b.val
+}
+
+func (b Bound) IsInclusive() bool {
+	return b.inclusive
+}
+
+// IsOpen は端がないかどうかを返す
+func (b Bound) IsOpen() bool {
+	return b.val.IsUnknown()
+}
+
+// Range は low から high までの値の範囲
+type Range struct {
+	low  Bound
+	high Bound
+}
+
+func NewRange(low Bound, high Bound) Range {
+	return Range{low, high}
+}
+
+// NewPointRange は val だけを含む範囲を返す
+func NewPointRange(val Constant) Range {
+	return Range{NewBound(val, true), NewBound(val, true)}
+}
+
+func (r Range) Low() Bound {
+	return r.low
+}
+
+func (r Range) High() Bound {
+	return r.high
+}
+
+// IsPoint は1つの値だけを含む範囲かどうかを返す
+func (r Range) IsPoint() bool {
+	return !r.low.IsOpen() && !r.high.IsOpen() && r.low.inclusive && r.high.inclusive && r.low.val.Equals(r.high.val)
+}
+
+// IsUnbounded は両端がない範囲かどうかを返す
+func (r Range) IsUnbounded() bool {
+	return r.low.IsOpen() && r.high.IsOpen()
+}
+
+// Intersect は r と rr の両方に含まれる範囲を返す
+func (r Range) Intersect(rr Range) Range {
+	low := r.low
+	if !rr.low.IsOpen() {
+		c := 1
+		if !low.IsOpen() {
+			c = rr.low.val.CompareTo(low.val)
+		}
+		if c > 0 || (c == 0 && !rr.low.inclusive) {
+			low = rr.low
+		}
+	}
+	high := r.high
+	if !rr.high.IsOpen() {
+		c := -1
+		if !high.IsOpen() {
+			c = rr.high.val.CompareTo(high.val)
+		}
+		if c < 0 || (c == 0 && !rr.high.inclusive) {
+			high = rr.high
+		}
+	}
+	return Range{low, high}
+}
+
+// AboveLow は val が下端より大きいかどうかを返す
+func (r Range) AboveLow(val Constant) bool {
+	if r.low.IsOpen() {
+		return true
+	}
+	c := val.CompareTo(r.low.val)
+	return c > 0 || (c == 0 && r.low.inclusive)
+}
+
+// BelowHigh は val が上端より小さいかどうかを返す
+func (r Range) BelowHigh(val Constant) bool {
+	if r.high.IsOpen() {
+		return true
+	}
+	c := val.CompareTo(r.high.val)
+	return c < 0 || (c == 0 && r.high.inclusive)
+}
+
+// Contains は val が範囲に含まれるかどうかを返す
+func (r Range) Contains(val Constant) bool {
+	return r.AboveLow(val) && r.BelowHigh(val)
+}
+
+// Includes は rr の全ての値が r に含まれるかどうかを返す
+func (r Range) Includes(rr Range) bool {
+	if !r.low.IsOpen() {
+		if rr.low.IsOpen() {
+			return false
+		}
+		c := rr.low.val.CompareTo(r.low.val)
+		if c < 0 || (c == 0 && rr.low.inclusive && !r.low.inclusive) {
+			return false
+		}
+	}
+	if !r.high.IsOpen() {
+		if rr.high.IsOpen() {
+			return false
+		}
+		c := rr.high.val.CompareTo(r.high.val)
+		if c > 0 || (c == 0 && rr.high.inclusive && !r.high.inclusive) {
+			return false
+		}
+	}
+	return true
+}
+
+// String は [1, 10) のように、含む端を角括弧、含まない端を丸括弧で表す
+func (r Range) String() string {
+	low, high := "(", ")"
+	if r.low.inclusive {
+		low = "["
+	}
+	if r.high.inclusive {
+		high = "]"
+	}
+	lowVal, highVal := "-inf", "+inf"
+	if !r.low.IsOpen() {
+		lowVal = NewExpressionFromConstant(r.low.val).argString()
+	}
+	if !r.high.IsOpen() {
+		highVal = NewExpressionFromConstant(r.high.val).argString()
+	}
+	return fmt.Sprintf("%s%s, %s%s", low, lowVal, highVal, high)
+}
+
+// likeRange は LIKE のパターンに一致する文字列の範囲を返す
+// ワイルドカードより前の部分 p で始まる文字列は p 以上で、 p の最後の文字を1つ進めた文字列未満になる
+func likeRange(pattern string) (Range, bool) {
+	i := strings.IndexAny(pattern, "%_")
+	if i == 0 {
+		return Range{}, false
+	}
+	if i < 0 {
+		return NewPointRange(NewConstant(pattern)), true
+	}
+	prefix := pattern[:i]
+	low := NewBound(NewConstant(prefix), true)
+	rs := []rune(prefix)
+	last := rs[len(rs)-1]
+	if last == utf8.MaxRune {
+		return NewRange(low, Bound{}), true
+	}
+	rs[len(rs)-1] = last + 1
+	return NewRange(low, NewBound(NewConstant(string(rs)), false)), true
+}
+
+// MatchLike は s が LIKE のパターンに一致するかどうかを返す
+// % は 0 文字以上の任意の文字列、 _ は任意の 1 文字に一致する
+func MatchLike(s string, pattern string) bool {
+	sr, pr := []rune(s), []rune(pattern)
+	// 最後に % があった位置と、そのときの s の位置から後戻りして探す
+	si, pi := 0, 0
+	star, mark := -1, 0
+	for si < len(sr) {
+		switch {
+		case pi < len(pr) && (pr[pi] == '_' || pr[pi] == sr[si]):
+			si++
+			pi++
+		case pi < len(pr) && pr[pi] == '%':
+			star = pi
+			mark = si
+			pi++
+		case star >= 0:
+			pi = star + 1
+			mark++
+			si = mark
+		default:
+			return false
+		}
+	}
+	for pi < len(pr) && pr[pi] == '%' {
+		pi++
+	}
+	return pi == len(pr)
+}
@@ -0,0 +1,81 @@
+package query_test
+
+import (
+	"testing"
+
+	"github.com/ksrnnb/go-rdb/query"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchLike(t *testing.T) {
+	tests := []struct {
+		s       string
+		pattern string
+		want    bool
+	}{
+		{"hoge", "hoge", true},
+		{"hoge", "ho%", true},
+		{"hoge", "%ge", true},
+		{"hoge", "h%g%", true},
+		{"hoge", "h_ge", true},
+		{"hoge", "h_e", false},
+		{"hoge", "%x%", false},
+		{"", "%", true},
+		{"あいう", "あ_う", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, query.MatchLike(tt.s, tt.pattern), "%s like %s", tt.s, tt.pattern)
+	}
+}
+
+func TestMatchText(t *testing.T) {
+	assert.Equal(t, []string{"sea", "sea", "and", "birds", "2024"}, query.Tokenize("Sea, SEA and birds (2024)"))
+
+	tests := []struct {
+		text  string
+		terms string
+		want  bool
+	}{
+		{"Sunset over the Sea", "sea", true},
+		{"Sunset over the Sea", "SUNSET sea", true},
+		{"Sunset over the Sea", "sunset mountain", false},
+		{"Seaside", "sea", false},
+		{"Sunset over the Sea", "", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, query.MatchText(tt.text, tt.terms), "match(%s, %s)", tt.text, tt.terms)
+	}
+}
+
+func TestPredicate_RangeWithConstant(t *testing.T) {
+	field := query.NewExpressionFromFieldName("a")
+	term := func(op query.Operator, v interface{}) *query.Predicate {
+		return query.NewPredicateFromTerm(query.NewTermWithOperator(field, op, query.NewExpressionFromConstant(query.NewConstant(v))))
+	}
+
+	pred := term(query.GreaterThan, 1)
+	pred.ConJoinWith(term(query.LessOrEqual, 10))
+	pred.ConJoinWith(term(query.GreaterOrEqual, 3))
+	r, ok := pred.RangeWithConstant("a")
+	assert.True(t, ok)
+	assert.Equal(t, "[3, 10]", r.String())
+	assert.True(t, r.Contains(query.NewConstant(10)))
+	assert.False(t, r.Contains(query.NewConstant(2)))
+
+	// 定数が左辺の場合は演算子の向きを入れ替える
+	reversed := query.NewPredicateFromTerm(query.NewTermWithOperator(query.NewExpressionFromConstant(query.NewConstant(5)), query.LessThan, field))
+	r, ok = reversed.RangeWithConstant("a")
+	assert.True(t, ok)
+	assert.Equal(t, "(5, +inf)", r.String())
+
+	r, ok = term(query.Like, "ho%").RangeWithConstant("a")
+	assert.True(t, ok)
+	assert.Equal(t, "['ho', 'hp')", r.String())
+
+	_, ok = term(query.Like, "%ge").RangeWithConstant("a")
+	assert.False(t, ok)
+	_, ok = term(query.NotEqual, 1).RangeWithConstant("a")
+	assert.False(t, ok)
+	_, ok = term(query.LessThan, 1).RangeWithConstant("b")
+	assert.False(t, ok)
+}
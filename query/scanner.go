@@ -1,6 +1,11 @@
 package query
 
-import "github.com/ksrnnb/go-rdb/record"
+import (
+	"io"
+	"strings"
+
+	"github.com/ksrnnb/go-rdb/record"
+)
 
 type Scanner interface {
 	BeforeFirst() error
@@ -25,3 +30,23 @@ type UpdateScanner interface {
 	GetRid() (*record.RecordID, error)
 	MoveToRid(rid *record.RecordID) error
 }
+
+// StreamScanner は large value (TEXT, BLOB, JSON) を io.Reader で読み込める Scanner
+// overflow chain を 1 ブロックずつ読み込むので、値全体をメモリに載せずに済む
+type StreamScanner interface {
+	Scanner
+	GetReader(fieldName string) (io.Reader, error)
+}
+
+// GetReader は Scanner から指定したフィールドの値を読み込む io.Reader を返す
+// StreamScanner を実装していない Scanner の場合は、値を取得してから io.Reader に変換する
+func GetReader(s Scanner, fieldName string) (io.Reader, error) {
+	if ss, ok := s.(StreamScanner); ok {
+		return ss.GetReader(fieldName)
+	}
+	v, err := s.GetVal(fieldName)
+	if err != nil {
+		return nil, err
+	}
+	return strings.NewReader(v.AsString()), nil
+}
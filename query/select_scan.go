@@ -2,6 +2,7 @@ package query
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/ksrnnb/go-rdb/record"
 )
@@ -57,6 +58,10 @@ func (ss *SelectScan) GetVal(fieldName string) (Constant, error) {
 	return ss.scan.GetVal(fieldName)
 }
 
+func (ss *SelectScan) GetReader(fieldName string) (io.Reader, error) {
+	return GetReader(ss.scan, fieldName)
+}
+
 func (ss *SelectScan) HasField(fieldName string) bool {
 	return ss.scan.HasField(fieldName)
 }
@@ -2,9 +2,12 @@ package query
 
 import (
 	"fmt"
+	"io"
+	"strconv"
 
 	"github.com/ksrnnb/go-rdb/file"
 	"github.com/ksrnnb/go-rdb/record"
+	"github.com/ksrnnb/go-rdb/sqlstate"
 	"github.com/ksrnnb/go-rdb/tx"
 )
 
@@ -111,10 +114,43 @@ func (ts *TableScan) GetVal(fieldName string) (Constant, error) {
 			return Constant{}, err
 		}
 		return NewConstant(v), nil
+	} else if ft == record.Text {
+		v, err := ts.GetString(fieldName)
+		if err != nil {
+			return Constant{}, err
+		}
+		return NewConstant(v), nil
+	} else if ft == record.Blob {
+		v, err := ts.GetString(fieldName)
+		if err != nil {
+			return Constant{}, err
+		}
+		return NewConstant([]byte(v)), nil
+	} else if ft == record.UUID {
+		v, err := ts.rp.GetUUID(ts.currentSlot, fieldName)
+		if err != nil {
+			return Constant{}, err
+		}
+		return NewUUIDConstant(v), nil
+	} else if ft == record.JSON {
+		v, err := ts.GetString(fieldName)
+		if err != nil {
+			return Constant{}, err
+		}
+		// 値が設定されていない場合は空文字列になっている
+		if v == "" {
+			return Constant{}, nil
+		}
+		return Constant{stringVal: v, ctype: JSONConstant}, nil
 	}
 	return Constant{}, fmt.Errorf("invalid record type: %d", ft)
 }
 
+// GetReader は large value のフィールドを読み込む io.Reader を返す
+func (ts *TableScan) GetReader(fieldName string) (io.Reader, error) {
+	return ts.rp.GetReader(ts.currentSlot, fieldName)
+}
+
 func (ts *TableScan) HasField(fieldName string) bool {
 	return ts.layout.Schema().HasField(fieldName)
 }
@@ -138,12 +174,25 @@ func (ts *TableScan) SetVal(fieldName string, val Constant) error {
 			return err
 		}
 		return nil
-	} else if ft == record.String {
+	} else if ft == record.String || ft == record.Text || ft == record.Blob {
+		// BLOB の値もバイト列をそのまま文字列として保持している
 		err := ts.SetString(fieldName, val.stringVal)
 		if err != nil {
 			return err
 		}
 		return nil
+	} else if ft == record.UUID {
+		u := val.ConvertTo(UUIDConstant)
+		if u.ctype != UUIDConstant {
+			return sqlstate.Errorf(sqlstate.InvalidTextRepresentation, "invalid uuid %q", val.String())
+		}
+		return ts.rp.SetUUID(ts.currentSlot, fieldName, u.AsUUID())
+	} else if ft == record.JSON {
+		text, err := jsonText(val)
+		if err != nil {
+			return err
+		}
+		return ts.SetString(fieldName, text)
 	}
 	return fmt.Errorf("invalid record type: %d", ft)
 }
@@ -257,3 +306,21 @@ func (ts *TableScan) atLastBlock() (bool, error) {
 	}
 	return ts.rp.Block().Number() == size-1, nil
 }
+
+// jsonText は JSON フィールドに保存する文字列を返す
+// JSON 以外の定数の場合は JSON として正しいかどうかを検証する
+func jsonText(val Constant) (string, error) {
+	switch val.ctype {
+	case UnknownConstant:
+		return "", nil
+	case JSONConstant:
+		return val.stringVal, nil
+	case IntConstant:
+		return strconv.Itoa(val.intVal), nil
+	}
+	c, err := NewJSONConstant(val.stringVal)
+	if err != nil {
+		return "", err
+	}
+	return c.stringVal, nil
+}
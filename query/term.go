@@ -7,13 +7,41 @@ import (
 	"github.com/ksrnnb/go-rdb/record"
 )
 
+// Operator は Term の lhs と rhs を比べる演算子
+// Match は MATCH(F, 'terms') の全文検索の条件で、 F の値が terms の全ての語を含む場合に満たす
+// In は F IN (c1, c2, ...) の条件で、 rhs は値の組の定数になり、 F の値が組のどれかと等しい場合に満たす
+type Operator string
+
+const (
+	Equal          Operator = "="
+	NotEqual       Operator = "<>"
+	LessThan       Operator = "<"
+	LessOrEqual    Operator = "<="
+	GreaterThan    Operator = ">"
+	GreaterOrEqual Operator = ">="
+	Like           Operator = "like"
+	Match          Operator = "match"
+	In             Operator = "in"
+)
+
 type Term struct {
 	lhs Expression
+	op  Operator
 	rhs Expression
 }
 
+// NewTerm は lhs=rhs の Term を返す
 func NewTerm(lhs, rhs Expression) Term {
-	return Term{lhs, rhs}
+	return Term{lhs, Equal, rhs}
+}
+
+// NewTermWithOperator は lhs と rhs を op で比べる Term を返す
+func NewTermWithOperator(lhs Expression, op Operator, rhs Expression) Term {
+	return Term{lhs, op, rhs}
+}
+
+func (t Term) Operator() Operator {
+	return t.op
 }
 
 func (t Term) IsSatisfied(s Scanner) (bool, error) {
@@ -25,30 +53,99 @@ func (t Term) IsSatisfied(s Scanner) (bool, error) {
 	if err != nil {
 		return false, err
 	}
+	lhsVal, rhsVal = t.convertConstants(lhsVal, rhsVal)
+	switch t.op {
+	case NotEqual:
+		return !lhsVal.Equals(rhsVal), nil
+	case LessThan:
+		return lhsVal.CompareTo(rhsVal) < 0, nil
+	case LessOrEqual:
+		return lhsVal.CompareTo(rhsVal) <= 0, nil
+	case GreaterThan:
+		return lhsVal.CompareTo(rhsVal) > 0, nil
+	case GreaterOrEqual:
+		return lhsVal.CompareTo(rhsVal) >= 0, nil
+	case Like:
+		return MatchLike(lhsVal.String(), rhsVal.String()), nil
+	case Match:
+		return MatchText(lhsVal.String(), rhsVal.String()), nil
+	case In:
+		for _, v := range rhsVal.AsTuple() {
+			if lhsVal.Equals(v) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
 	return lhsVal.Equals(rhsVal), nil
 }
 
+// convertConstants は定数の辺の値を、もう一方の辺の値の型に変換する
+// UUID の列と文字列のリテラルや、 ->> で取り出した文字列と整数のリテラルなどを比べられるようにするためで、
+// 列どうしは型が異なれば等しくならない
+// 列どうしを変換しないので、ハッシュ結合やマージ結合でも同じ結果になる
+func (t Term) convertConstants(lhsVal, rhsVal Constant) (Constant, Constant) {
+	if t.lhs.IsConstant() && !t.rhs.IsConstant() {
+		return lhsVal.ConvertTo(rhsVal.ctype), rhsVal
+	}
+	if !t.rhs.IsConstant() || t.lhs.IsConstant() {
+		return lhsVal, rhsVal
+	}
+	if t.op != In {
+		return lhsVal, rhsVal.ConvertTo(lhsVal.ctype)
+	}
+	vals := make([]Constant, len(rhsVal.AsTuple()))
+	for i, v := range rhsVal.AsTuple() {
+		vals[i] = v.ConvertTo(lhsVal.ctype)
+	}
+	return lhsVal, NewTupleConstant(vals)
+}
+
 // AppliesTo は Term の lhs, rhs ともに Expression の条件を満たす場合に true を返す
 // Expression が Identifier の場合は、スキーマに定義されているかどうかを確認する
 func (t Term) AppliesTo(schema *record.Schema) bool {
 	return t.lhs.AppliesTo(schema) && t.rhs.AppliesTo(schema)
 }
 
+// FieldNames は Term の両辺が参照しているフィールド名を返す
+func (t Term) FieldNames() []string {
+	return append(t.lhs.FieldNames(), t.rhs.FieldNames()...)
+}
+
+// ReductionFactor は Term による絞り込みでレコード数が何分の 1 になるかを返す
+// 関数の式は式の文字列をフィールド名とみなして distinct value を計算する
+// 範囲の条件と LIKE, MATCH は 1/3、 <> はほとんど絞り込まないとみなす
+// IN は値の数だけ等しい条件を合わせたとみなす
 func (t Term) ReductionFactor(p Planner) int {
-	if t.lhs.IsFieldName() && t.rhs.IsFieldName() {
-		lhs := p.DistinctValues(t.lhs.AsFieldName())
-		rhs := p.DistinctValues(t.rhs.AsFieldName())
+	switch t.op {
+	case NotEqual:
+		return 1
+	case LessThan, LessOrEqual, GreaterThan, GreaterOrEqual, Like, Match:
+		return rangeReductionFactor
+	case In:
+		if t.lhs.IsConstant() || !t.rhs.IsConstant() {
+			return rangeReductionFactor
+		}
+		rf := p.DistinctValues(t.lhs.String()) / len(t.rhs.AsConstant().AsTuple())
+		if rf < 1 {
+			return 1
+		}
+		return rf
+	}
+	if !t.lhs.IsConstant() && !t.rhs.IsConstant() {
+		lhs := p.DistinctValues(t.lhs.String())
+		rhs := p.DistinctValues(t.rhs.String())
 		if lhs > rhs {
 			return lhs
 		}
 		return rhs
 	}
-	if t.lhs.IsFieldName() {
-		return p.DistinctValues(t.lhs.AsFieldName())
+	if !t.lhs.IsConstant() {
+		return p.DistinctValues(t.lhs.String())
 	}
 
-	if t.rhs.IsFieldName() {
-		return p.DistinctValues(t.rhs.AsFieldName())
+	if !t.rhs.IsConstant() {
+		return p.DistinctValues(t.rhs.String())
 	}
 
 	if t.lhs.AsConstant().Equals(t.rhs.AsConstant()) {
@@ -57,26 +154,148 @@ func (t Term) ReductionFactor(p Planner) int {
 	return math.MaxInt
 }
 
+// EquatesWithConstant は "F=c" の形の Term の場合に c を返す
+// F はフィールド名か、式の文字列 (payload->>'name' など)
 func (t Term) EquatesWithConstant(fieldName string) Constant {
-	if t.lhs.IsFieldName() && t.lhs.AsFieldName() == fieldName && !t.rhs.IsFieldName() {
+	if t.op != Equal {
+		return Constant{}
+	}
+	if !t.lhs.IsConstant() && t.lhs.String() == fieldName && t.rhs.IsConstant() {
 		return t.rhs.AsConstant()
 	}
-	if t.rhs.IsFieldName() && t.rhs.AsFieldName() == fieldName && !t.lhs.IsFieldName() {
+	if !t.rhs.IsConstant() && t.rhs.String() == fieldName && t.lhs.IsConstant() {
 		return t.lhs.AsConstant()
 	}
 	return Constant{}
 }
 
+// ValuesWithConstant は "F=c" の形の Term の場合に [c] を、 "F IN (c1, c2, ...)" の形の Term の場合に [c1, c2, ...] を返す
+// F がいずれかの値と等しいレコードだけが Term を満たす
+// そのような形でない場合と、プレースホルダを含む場合は false を返す
+func (t Term) ValuesWithConstant(fieldName string) ([]Constant, bool) {
+	if t.op == In {
+		if t.lhs.IsConstant() || t.lhs.String() != fieldName || !t.rhs.IsConstant() || t.rhs.HasParameters() {
+			return nil, false
+		}
+		return t.rhs.AsConstant().AsTuple(), true
+	}
+	c := t.EquatesWithConstant(fieldName)
+	if c.IsUnknown() || c.IsParameter() {
+		return nil, false
+	}
+	return []Constant{c}, true
+}
+
+// EquatesWithFieldName は "F1=F2" の形の Term の場合に、 fieldName と等しいもう一方のフィールド名を返す
 func (t Term) EquatesWithFieldName(fieldName string) string {
-	if t.lhs.IsFieldName() && t.lhs.AsFieldName() == fieldName && !t.rhs.IsFieldName() {
+	if t.op != Equal {
+		return ""
+	}
+	if t.lhs.IsFieldName() && t.lhs.AsFieldName() == fieldName && t.rhs.IsFieldName() {
 		return t.rhs.AsFieldName()
 	}
-	if t.rhs.IsFieldName() && t.rhs.AsFieldName() == fieldName && !t.lhs.IsFieldName() {
+	if t.rhs.IsFieldName() && t.rhs.AsFieldName() == fieldName && t.lhs.IsFieldName() {
 		return t.lhs.AsFieldName()
 	}
 	return ""
 }
 
+// Bind は lhs, rhs のプレースホルダを params の値に置き換えた Term を返す
+func (t Term) Bind(params []Constant) (Term, error) {
+	lhs, err := t.lhs.Bind(params)
+	if err != nil {
+		return Term{}, err
+	}
+	rhs, err := t.rhs.Bind(params)
+	if err != nil {
+		return Term{}, err
+	}
+	return NewTermWithOperator(lhs, t.op, rhs), nil
+}
+
+// MatchTerms は "MATCH(F, 'terms')" の形の Term の場合に 'terms' を返す
+func (t Term) MatchTerms(fieldName string) Constant {
+	if t.op != Match || t.lhs.IsConstant() || t.lhs.String() != fieldName || !t.rhs.IsConstant() {
+		return Constant{}
+	}
+	if t.rhs.AsConstant().IsParameter() {
+		return Constant{}
+	}
+	return t.rhs.AsConstant()
+}
+
+// comparedExpression は "F op c" か "c op F" の形の Term の場合に F の文字列を返す
+func (t Term) comparedExpression() (string, bool) {
+	switch {
+	case !t.lhs.IsConstant() && t.rhs.IsConstant():
+		return t.lhs.String(), true
+	case !t.rhs.IsConstant() && t.lhs.IsConstant():
+		return t.rhs.String(), true
+	}
+	return "", false
+}
+
+// RangeWithConstant は "F op c" か "c op F" の形の Term の場合に、 F の値の範囲を返す
+// LIKE はワイルドカードより前の部分で始まる文字列の範囲になる
+// 範囲を表せない Term の場合は false を返す
+func (t Term) RangeWithConstant(fieldName string) (Range, bool) {
+	op := t.op
+	var c Constant
+	switch {
+	case !t.lhs.IsConstant() && t.lhs.String() == fieldName && t.rhs.IsConstant():
+		c = t.rhs.AsConstant()
+	case !t.rhs.IsConstant() && t.rhs.String() == fieldName && t.lhs.IsConstant() && op != Like:
+		c = t.lhs.AsConstant()
+		op = op.reverse()
+	default:
+		return Range{}, false
+	}
+	if c.IsParameter() {
+		return Range{}, false
+	}
+
+	switch op {
+	case Equal:
+		return NewPointRange(c), true
+	case LessThan:
+		return NewRange(Bound{}, NewBound(c, false)), true
+	case LessOrEqual:
+		return NewRange(Bound{}, NewBound(c, true)), true
+	case GreaterThan:
+		return NewRange(NewBound(c, false), Bound{}), true
+	case GreaterOrEqual:
+		return NewRange(NewBound(c, true), Bound{}), true
+	case Like:
+		return likeRange(c.String())
+	}
+	return Range{}, false
+}
+
+// String は再度パースできるように、文字列の定数をクォートして Term を表す
 func (t Term) String() string {
-	return fmt.Sprintf("%s=%s", t.lhs.String(), t.rhs.String())
+	if t.op == Like {
+		return fmt.Sprintf("%s like %s", t.lhs.argString(), t.rhs.argString())
+	}
+	if t.op == Match {
+		return fmt.Sprintf("match(%s, %s)", t.lhs.argString(), t.rhs.argString())
+	}
+	if t.op == In {
+		return fmt.Sprintf("%s in %s", t.lhs.argString(), t.rhs.String())
+	}
+	return fmt.Sprintf("%s%s%s", t.lhs.argString(), t.op, t.rhs.argString())
+}
+
+// reverse は lhs と rhs を入れ替えたときの演算子を返す
+func (op Operator) reverse() Operator {
+	switch op {
+	case LessThan:
+		return GreaterThan
+	case LessOrEqual:
+		return GreaterOrEqual
+	case GreaterThan:
+		return LessThan
+	case GreaterOrEqual:
+		return LessOrEqual
+	}
+	return op
 }
@@ -0,0 +1,34 @@
+package query
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Tokenize は全文検索のために文字列を語に分ける
+// 文字か数字が続く部分を1つの語にして、大文字小文字を区別しないように小文字にそろえる
+// 同じ語が複数回現れる場合は、現れた回数だけ返す
+func Tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// MatchText は text が terms の全ての語を含むかどうかを返す
+// terms に語がない場合は false を返す
+func MatchText(text string, terms string) bool {
+	want := Tokenize(terms)
+	if len(want) == 0 {
+		return false
+	}
+	words := make(map[string]bool)
+	for _, w := range Tokenize(text) {
+		words[w] = true
+	}
+	for _, w := range want {
+		if !words[w] {
+			return false
+		}
+	}
+	return true
+}
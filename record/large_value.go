@@ -0,0 +1,353 @@
+package record
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ksrnnb/go-rdb/file"
+	"github.com/ksrnnb/go-rdb/tx"
+)
+
+// LargeValueInlineLength はスロット内に確保する文字数
+// file.MaxLength で換算したバイト数までは値をスロット内に直接格納する
+// これを超える値は overflow ファイルのブロックチェーンに格納する
+const LargeValueInlineLength = 32
+
+// NoOverflowBlock は値がスロット内に収まっていることを表す
+const NoOverflowBlock = -1
+
+// large value のスロット内の構造
+// --------------------------------------------------
+// | overflow head block (int32) | inline (string) |
+// --------------------------------------------------
+//
+// overflow block の構造
+// ------------------------------------------
+// | next block (int32) | chunk (string) | |
+// ------------------------------------------
+// チェーンは書き込みのたびに新しいブロックに作り直す（copy-on-write）
+// 新しく append したブロックはゼロ埋めされているので、チャンクを書き込むときのログには
+// 古い値として空文字列が記録される。値が何ブロックにまたがっていても各ログレコードは小さく、1 ブロックに収まる
+// ロールバック時はチャンクが空に戻り、スロット内の head も元に戻るので、古いチェーンが再び参照される
+//
+// 古いチェーンは同じトランザクションで解放して、解放したブロックのリストにつなぐ
+// リストは next でブロックをつなぎ、先頭のブロック番号に 1 を足した値を <overflow ファイル名>_free の先頭のブロックに保存する
+// (ゼロ埋めのブロックを空のリストとして読めるように 1 を足す)
+// 解放したブロックにはトランザクションの終わりまで排他ロックをかけるので、コミットするまで他のトランザクションは再利用しない
+// 解放したトランザクション自身も、ロールバックで古いチェーンに戻せるように再利用しない
+// 再利用するブロックは先に文字列の長さを 0 にしてから書き込むので、チャンクのログには古い値として空文字列が記録される
+func largeValueSlotSize() int {
+	return IntByteSize + file.MaxLength(LargeValueInlineLength)
+}
+
+// largeValueInlineBytes はスロット内に直接格納できるバイト数
+func largeValueInlineBytes() int {
+	return file.MaxLength(LargeValueInlineLength) - IntByteSize
+}
+
+// OverflowFileName はテーブルファイルに対応する overflow ファイル名を返す
+func OverflowFileName(fileName string) string {
+	return fmt.Sprintf("%s.ovf", strings.TrimSuffix(fileName, ".tbl"))
+}
+
+// IsLargeValue は overflow に対応したフィールド型かどうかを返す
+func (ft FieldType) IsLargeValue() bool {
+	switch ft {
+	case JSON, Text, Blob:
+		return true
+	}
+	return false
+}
+
+func (rp *RecordPage) isLargeValueField(fieldName string) bool {
+	ft, err := rp.layout.Schema().FieldType(fieldName)
+	if err != nil {
+		return false
+	}
+	return ft.IsLargeValue()
+}
+
+// GetReader は指定されたレコードの large value を読み込む io.Reader を返す
+// overflow chain は読み込むたびに 1 ブロックずつ pin するので、値全体をメモリに載せずに読める
+func (rp *RecordPage) GetReader(slot int, fieldName string) (io.Reader, error) {
+	if !rp.isLargeValueField(fieldName) {
+		return nil, fmt.Errorf("field %s is not a large value field", fieldName)
+	}
+	ofs, err := rp.layout.Offset(fieldName)
+	if err != nil {
+		return nil, err
+	}
+	fieldPos := rp.offset(slot) + ofs
+	head, err := rp.tx.GetInt(rp.blk, fieldPos)
+	if err != nil {
+		return nil, err
+	}
+	if head == NoOverflowBlock {
+		s, err := rp.tx.GetString(rp.blk, fieldPos+IntByteSize)
+		if err != nil {
+			return nil, err
+		}
+		return strings.NewReader(s), nil
+	}
+	return newOverflowReader(rp.tx, OverflowFileName(rp.blk.FileName()), head), nil
+}
+
+func (rp *RecordPage) getLargeString(fieldPos int) (string, error) {
+	head, err := rp.tx.GetInt(rp.blk, fieldPos)
+	if err != nil {
+		return "", err
+	}
+	if head == NoOverflowBlock {
+		return rp.tx.GetString(rp.blk, fieldPos+IntByteSize)
+	}
+	b, err := io.ReadAll(newOverflowReader(rp.tx, OverflowFileName(rp.blk.FileName()), head))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (rp *RecordPage) setLargeString(fieldPos int, val string, okToLog bool) error {
+	if len(val) <= largeValueInlineBytes() {
+		if err := rp.tx.SetInt(rp.blk, fieldPos, NoOverflowBlock, okToLog); err != nil {
+			return err
+		}
+		return rp.tx.SetString(rp.blk, fieldPos+IntByteSize, val, okToLog)
+	}
+
+	head, err := rp.writeOverflow(val, okToLog)
+	if err != nil {
+		return err
+	}
+	if err := rp.tx.SetInt(rp.blk, fieldPos, head, okToLog); err != nil {
+		return err
+	}
+	return rp.tx.SetString(rp.blk, fieldPos+IntByteSize, "", okToLog)
+}
+
+// writeOverflow は値をチャンクに分割して overflow ファイルに書き込み、先頭のブロック番号を返す
+// 後ろのチャンクから書き込むことで、各ブロックの next を書き込み時点で決定できる
+func (rp *RecordPage) writeOverflow(val string, okToLog bool) (int, error) {
+	chunks := splitIntoChunks(val, rp.overflowChunkSize())
+	fileName := OverflowFileName(rp.blk.FileName())
+	next := NoOverflowBlock
+	for i := len(chunks) - 1; i >= 0; i-- {
+		blk, reused, err := rp.allocateOverflow(fileName)
+		if err != nil {
+			return 0, err
+		}
+		if err := rp.tx.Pin(blk); err != nil {
+			return 0, err
+		}
+		if reused {
+			if err := rp.tx.SetInt(blk, IntByteSize, 0, okToLog); err != nil {
+				return 0, err
+			}
+		}
+		if err := rp.tx.SetInt(blk, 0, next, okToLog); err != nil {
+			return 0, err
+		}
+		if err := rp.tx.SetString(blk, IntByteSize, chunks[i], okToLog); err != nil {
+			return 0, err
+		}
+		if err := rp.tx.Unpin(blk); err != nil {
+			return 0, err
+		}
+		next = blk.Number()
+	}
+	return next, nil
+}
+
+// freeOverflow は head から始まるチェーンを解放したブロックのリストの先頭につなぐ
+// 呼び出し側でスロット内の head を書き換える
+func (rp *RecordPage) freeOverflow(head int) error {
+	if head == NoOverflowBlock {
+		return nil
+	}
+	fileName := OverflowFileName(rp.blk.FileName())
+	if err := rp.tx.XLock(overflowFreeListHeadBlock(fileName)); err != nil {
+		return err
+	}
+	tail := file.NewBlockID(fileName, head)
+	for {
+		if err := rp.tx.XLock(tail); err != nil {
+			return err
+		}
+		if err := rp.tx.Pin(tail); err != nil {
+			return err
+		}
+		next, err := rp.tx.GetInt(tail, 0)
+		if err != nil {
+			return err
+		}
+		if next == NoOverflowBlock {
+			break
+		}
+		if err := rp.tx.Unpin(tail); err != nil {
+			return err
+		}
+		tail = file.NewBlockID(fileName, next)
+	}
+
+	freeHead, err := overflowFreeListHead(rp.tx, fileName)
+	if err != nil {
+		return err
+	}
+	if err := rp.tx.SetInt(tail, 0, freeHead, true); err != nil {
+		return err
+	}
+	if err := rp.tx.Unpin(tail); err != nil {
+		return err
+	}
+	return setOverflowFreeListHead(rp.tx, fileName, head)
+}
+
+// allocateOverflow は解放したブロックがあれば再利用して、なければ overflow ファイルの末尾に追加する
+// 他のトランザクションがリストを使っている場合は待たずに末尾に追加する
+// 再利用したブロックの場合は true を返す
+func (rp *RecordPage) allocateOverflow(fileName string) (file.BlockID, bool, error) {
+	if !rp.tx.TryXLock(overflowFreeListHeadBlock(fileName)) {
+		blk, err := rp.tx.Append(fileName)
+		return blk, false, err
+	}
+	head, err := overflowFreeListHead(rp.tx, fileName)
+	if err != nil {
+		return file.BlockID{}, false, err
+	}
+	blk := file.NewBlockID(fileName, head)
+	if head == NoOverflowBlock || rp.tx.HasXLock(blk) || !rp.tx.TryXLock(blk) {
+		blk, err := rp.tx.Append(fileName)
+		return blk, false, err
+	}
+
+	if err := rp.tx.Pin(blk); err != nil {
+		return file.BlockID{}, false, err
+	}
+	next, err := rp.tx.GetInt(blk, 0)
+	if err != nil {
+		return file.BlockID{}, false, err
+	}
+	if err := rp.tx.Unpin(blk); err != nil {
+		return file.BlockID{}, false, err
+	}
+	if err := setOverflowFreeListHead(rp.tx, fileName, next); err != nil {
+		return file.BlockID{}, false, err
+	}
+	return blk, true, nil
+}
+
+func overflowFreeListFile(fileName string) string {
+	return fmt.Sprintf("%s_free", fileName)
+}
+
+func overflowFreeListHeadBlock(fileName string) file.BlockID {
+	return file.NewBlockID(overflowFreeListFile(fileName), 0)
+}
+
+// overflowFreeListHead は解放したブロックのリストの先頭のブロック番号を返す
+// 呼び出し側でリストの先頭のブロックに排他ロックをかけておく
+func overflowFreeListHead(tx *tx.Transaction, fileName string) (int, error) {
+	size, err := tx.Size(overflowFreeListFile(fileName))
+	if err != nil {
+		return 0, err
+	}
+	if size == 0 {
+		return NoOverflowBlock, nil
+	}
+	blk := overflowFreeListHeadBlock(fileName)
+	if err := tx.Pin(blk); err != nil {
+		return 0, err
+	}
+	head, err := tx.GetInt(blk, 0)
+	if err != nil {
+		return 0, err
+	}
+	return head - 1, tx.Unpin(blk)
+}
+
+func setOverflowFreeListHead(tx *tx.Transaction, fileName string, head int) error {
+	size, err := tx.Size(overflowFreeListFile(fileName))
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		if _, err := tx.Append(overflowFreeListFile(fileName)); err != nil {
+			return err
+		}
+	}
+	blk := overflowFreeListHeadBlock(fileName)
+	if err := tx.Pin(blk); err != nil {
+		return err
+	}
+	if err := tx.SetInt(blk, 0, head+1, true); err != nil {
+		return err
+	}
+	return tx.Unpin(blk)
+}
+
+// overflowChunkSize は 1 ブロックに格納できるチャンクのバイト数
+// next と文字列長の分を除く
+func (rp *RecordPage) overflowChunkSize() int {
+	return rp.tx.BlockSize() - 2*IntByteSize
+}
+
+// splitIntoChunks はバイト単位で文字列を分割する
+// 読み込み時に連結するのでマルチバイト文字の途中で分割しても問題ない
+func splitIntoChunks(val string, size int) []string {
+	chunks := make([]string, 0, len(val)/size+1)
+	for len(val) > size {
+		chunks = append(chunks, val[:size])
+		val = val[size:]
+	}
+	return append(chunks, val)
+}
+
+// overflowReader は overflow chain をたどりながら値を読み込む
+// 保持するのは読み込み中のチャンク 1 つ分だけ
+type overflowReader struct {
+	tx       *tx.Transaction
+	fileName string
+	next     int
+	chunk    []byte
+}
+
+func newOverflowReader(tx *tx.Transaction, fileName string, head int) *overflowReader {
+	return &overflowReader{tx: tx, fileName: fileName, next: head}
+}
+
+func (r *overflowReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.next == NoOverflowBlock {
+			return 0, io.EOF
+		}
+		if err := r.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+// readChunk は次のブロックを pin してチャンクを読み込み、すぐに unpin する
+func (r *overflowReader) readChunk() error {
+	blk := file.NewBlockID(r.fileName, r.next)
+	if err := r.tx.Pin(blk); err != nil {
+		return err
+	}
+	next, err := r.tx.GetInt(blk, 0)
+	if err != nil {
+		return err
+	}
+	chunk, err := r.tx.GetString(blk, IntByteSize)
+	if err != nil {
+		return err
+	}
+	if err := r.tx.Unpin(blk); err != nil {
+		return err
+	}
+	r.next = next
+	r.chunk = []byte(chunk)
+	return nil
+}
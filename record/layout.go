@@ -1,6 +1,6 @@
 package record
 
-import "fmt"
+import "github.com/ksrnnb/go-rdb/sqlstate"
 
 type Layout struct {
 	schema   *Schema
@@ -34,7 +34,7 @@ func (l *Layout) Offset(fieldName string) (int, error) {
 			return ofs, nil
 		}
 	}
-	return 0, fmt.Errorf("invalid field name [%s]", fieldName)
+	return 0, sqlstate.Errorf(sqlstate.UndefinedColumn, "invalid field name [%s]", fieldName)
 }
 
 func (l *Layout) SlotSize() int {
@@ -3,7 +3,9 @@ package record
 import (
 	"fmt"
 
+	"github.com/google/uuid"
 	"github.com/ksrnnb/go-rdb/file"
+	"github.com/ksrnnb/go-rdb/sqlstate"
 	"github.com/ksrnnb/go-rdb/tx"
 )
 
@@ -46,6 +48,17 @@ func (rp *RecordPage) GetString(slot int, fieldName string) (string, error) {
 	}
 
 	fieldPos := rp.offset(slot) + ofs
+	if rp.isLargeValueField(fieldName) {
+		return rp.getLargeString(fieldPos)
+	}
+	// UUID は 16 バイトで格納しているので、文字列表現に変換する
+	if rp.isUUIDField(fieldName) {
+		u, err := ReadUUID(rp.tx, rp.blk, fieldPos)
+		if err != nil {
+			return "", err
+		}
+		return u.String(), nil
+	}
 	return rp.tx.GetString(rp.blk, fieldPos)
 }
 
@@ -66,16 +79,56 @@ func (rp *RecordPage) SetString(slot int, fieldName string, val string) error {
 	}
 
 	fieldPos := rp.offset(slot) + ofs
+	if rp.isLargeValueField(fieldName) {
+		// 新しい値を先に書き込んで、リストの先頭にある他のトランザクションが解放したブロックを新しいチェーンで再利用する
+		head, err := rp.tx.GetInt(rp.blk, fieldPos)
+		if err != nil {
+			return err
+		}
+		if err := rp.setLargeString(fieldPos, val, true); err != nil {
+			return err
+		}
+		return rp.freeOverflow(head)
+	}
+	if rp.isUUIDField(fieldName) {
+		u, err := uuid.Parse(val)
+		if err != nil {
+			return sqlstate.Errorf(sqlstate.InvalidTextRepresentation, "invalid uuid %q: %w", val, err)
+		}
+		return WriteUUID(rp.tx, rp.blk, fieldPos, u, true)
+	}
 	return rp.tx.SetString(rp.blk, fieldPos, val, true)
 }
 
 // Delete はレコードのフラグを Empty にする
+// large value を overflow ファイルに格納している場合は、そのチェーンも解放する
 func (rp *RecordPage) Delete(slot int) error {
+	schema := rp.layout.Schema()
+	for _, fn := range schema.Fields() {
+		if !rp.isLargeValueField(fn) {
+			continue
+		}
+		ofs, err := rp.layout.Offset(fn)
+		if err != nil {
+			return err
+		}
+		fieldPos := rp.offset(slot) + ofs
+		head, err := rp.tx.GetInt(rp.blk, fieldPos)
+		if err != nil {
+			return err
+		}
+		if err := rp.tx.SetInt(rp.blk, fieldPos, NoOverflowBlock, true); err != nil {
+			return err
+		}
+		if err := rp.freeOverflow(head); err != nil {
+			return err
+		}
+	}
 	return rp.setFlag(slot, Empty)
 }
 
 // Format はページ内の全てのレコードスロットをデフォルト値にする
-// 全てのフラグを Empty にして、Integer は 0, String は "" にする
+// 全てのフラグを Empty にして、Integer は 0, String と large value (JSON, TEXT, BLOB) は ""、UUID は全て 0 にする
 func (rp *RecordPage) Format() error {
 	slot := 0
 	for rp.isValidSlot(slot) {
@@ -104,6 +157,16 @@ func (rp *RecordPage) Format() error {
 				if err != nil {
 					return err
 				}
+			case JSON, Text, Blob:
+				err := rp.setLargeString(fieldPos, "", false)
+				if err != nil {
+					return err
+				}
+			case UUID:
+				err := WriteUUID(rp.tx, rp.blk, fieldPos, uuid.Nil, false)
+				if err != nil {
+					return err
+				}
 			default:
 				return fmt.Errorf("invalid field type [%d]", fieldType)
 			}
@@ -1,8 +1,12 @@
 package record_test
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"math/rand"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/ksrnnb/go-rdb/record"
@@ -11,7 +15,14 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+func initializeFiles(t *testing.T) {
+	t.Helper()
+	err := os.RemoveAll("../data")
+	require.NoError(t, err)
+}
+
 func TestRecordPage(t *testing.T) {
+	initializeFiles(t)
 	db := server.NewSimpleDB("data", 400, 8)
 	tx, err := db.NewTransaction()
 	require.NoError(t, err)
@@ -94,3 +105,142 @@ func TestRecordPage(t *testing.T) {
 	require.NoError(t, tx.Unpin(blk))
 	require.NoError(t, tx.Commit())
 }
+
+func TestRecordPage_LargeValue(t *testing.T) {
+	initializeFiles(t)
+	db := server.NewSimpleDB("data", 400, 8)
+	schema := record.NewSchema()
+	schema.AddIntField("id")
+	schema.AddTextField("body")
+	schema.AddBlobField("data")
+	layout := record.NewLayout(schema)
+
+	// 1 ブロックに収まらない値は overflow ブロックのチェーンに格納される
+	body := strings.Repeat("あいうえお", 300)
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i % 256)
+	}
+
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+	blk, err := tx.Append("largefile")
+	require.NoError(t, err)
+	rp, err := record.NewRecordPage(tx, blk, layout)
+	require.NoError(t, err)
+	require.NoError(t, rp.Format())
+	slot, err := rp.InsertAfter(-1)
+	require.NoError(t, err)
+	require.NoError(t, rp.SetInt(slot, "id", 1))
+	require.NoError(t, rp.SetString(slot, "body", body))
+	require.NoError(t, rp.SetString(slot, "data", string(data)))
+	require.NoError(t, tx.Unpin(blk))
+	require.NoError(t, tx.Commit())
+
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	rp, err = record.NewRecordPage(tx, blk, layout)
+	require.NoError(t, err)
+	v, err := rp.GetString(slot, "body")
+	require.NoError(t, err)
+	assert.Equal(t, body, v)
+
+	r, err := rp.GetReader(slot, "data")
+	require.NoError(t, err)
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(data, b), "read blob by stream")
+
+	// 複数ブロックにまたがる値の更新もロールバックで元に戻る
+	require.NoError(t, rp.SetString(slot, "body", strings.Repeat("z", 2000)))
+	require.NoError(t, rp.SetString(slot, "data", "short"))
+	require.NoError(t, tx.Unpin(blk))
+	require.NoError(t, tx.Rollback())
+
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	rp, err = record.NewRecordPage(tx, blk, layout)
+	require.NoError(t, err)
+	v, err = rp.GetString(slot, "body")
+	require.NoError(t, err)
+	assert.Equal(t, body, v, "body after rollback")
+	v, err = rp.GetString(slot, "data")
+	require.NoError(t, err)
+	assert.Equal(t, string(data), v, "data after rollback")
+	require.NoError(t, tx.Unpin(blk))
+	require.NoError(t, tx.Commit())
+}
+
+func TestRecordPage_LargeValueReusesFreedBlocks(t *testing.T) {
+	initializeFiles(t)
+	db := server.NewSimpleDB("data", 400, 8)
+	schema := record.NewSchema()
+	schema.AddIntField("id")
+	schema.AddTextField("body")
+	layout := record.NewLayout(schema)
+	ovf := record.OverflowFileName("reusefile")
+
+	tx, err := db.NewTransaction()
+	require.NoError(t, err)
+	blk, err := tx.Append("reusefile")
+	require.NoError(t, err)
+	rp, err := record.NewRecordPage(tx, blk, layout)
+	require.NoError(t, err)
+	require.NoError(t, rp.Format())
+	slot, err := rp.InsertAfter(-1)
+	require.NoError(t, err)
+	require.NoError(t, rp.SetString(slot, "body", strings.Repeat("a", 2000)))
+	require.NoError(t, tx.Unpin(blk))
+	require.NoError(t, tx.Commit())
+
+	update := func(body string, commit bool) int {
+		tx, err := db.NewTransaction()
+		require.NoError(t, err)
+		rp, err := record.NewRecordPage(tx, blk, layout)
+		require.NoError(t, err)
+		require.NoError(t, rp.SetString(slot, "body", body))
+		size, err := tx.Size(ovf)
+		require.NoError(t, err)
+		require.NoError(t, tx.Unpin(blk))
+		if commit {
+			require.NoError(t, tx.Commit())
+		} else {
+			require.NoError(t, tx.Rollback())
+		}
+		return size
+	}
+
+	// 解放したチェーンはコミットした後の更新で再利用されるので、何度更新してもファイルは大きくならない
+	size := update(strings.Repeat("b", 2000), true)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, size, update(strings.Repeat(fmt.Sprint(i), 2000), true), "update %d", i)
+	}
+
+	// 再利用したブロックへの書き込みもロールバックで元に戻る
+	update(strings.Repeat("x", 2000), false)
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	rp, err = record.NewRecordPage(tx, blk, layout)
+	require.NoError(t, err)
+	v, err := rp.GetString(slot, "body")
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("9", 2000), v)
+
+	// 削除したレコードのチェーンも、コミットした後に再利用される
+	require.NoError(t, rp.Delete(slot))
+	require.NoError(t, tx.Unpin(blk))
+	require.NoError(t, tx.Commit())
+
+	tx, err = db.NewTransaction()
+	require.NoError(t, err)
+	rp, err = record.NewRecordPage(tx, blk, layout)
+	require.NoError(t, err)
+	slot, err = rp.InsertAfter(-1)
+	require.NoError(t, err)
+	require.NoError(t, rp.SetString(slot, "body", strings.Repeat("c", 2000)))
+	got, err := tx.Size(ovf)
+	require.NoError(t, err)
+	assert.Equal(t, size, got)
+	require.NoError(t, tx.Unpin(blk))
+	require.NoError(t, tx.Commit())
+}
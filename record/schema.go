@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/ksrnnb/go-rdb/file"
+	"github.com/ksrnnb/go-rdb/sqlstate"
 )
 
 const IntByteSize = 4
@@ -14,6 +15,10 @@ const (
 	Unknown FieldType = iota
 	Integer
 	String
+	JSON
+	Text
+	Blob
+	UUID
 )
 
 func (ft FieldType) AsInt() int {
@@ -21,13 +26,13 @@ func (ft FieldType) AsInt() int {
 }
 
 type FieldInfo struct {
-	fieldType FieldType
-	length    int
+	fieldType    FieldType
+	length       int
+	defaultValue string
 }
 
 func NewFieldInfo(fieldType FieldType, length int) *FieldInfo {
-	return &FieldInfo{fieldType,
-		length}
+	return &FieldInfo{fieldType: fieldType, length: length}
 }
 
 type Schema struct {
@@ -52,6 +57,32 @@ func (s *Schema) AddStringField(fieldName string, length int) {
 	s.AddField(fieldName, String, length)
 }
 
+func (s *Schema) AddJSONField(fieldName string) {
+	s.AddField(fieldName, JSON, 0)
+}
+
+func (s *Schema) AddTextField(fieldName string) {
+	s.AddField(fieldName, Text, 0)
+}
+
+func (s *Schema) AddBlobField(fieldName string) {
+	s.AddField(fieldName, Blob, 0)
+}
+
+func (s *Schema) AddUUIDField(fieldName string) {
+	s.AddField(fieldName, UUID, 0)
+}
+
+// SetDefaultValue は INSERT で値が指定されなかったときに評価する式の文字列を設定する
+func (s *Schema) SetDefaultValue(fieldName string, expr string) error {
+	fi, ok := s.fieldInfo[fieldName]
+	if !ok {
+		return sqlstate.Errorf(sqlstate.UndefinedColumn, "cannot get field info: field name [%s]", fieldName)
+	}
+	fi.defaultValue = expr
+	return nil
+}
+
 func (s *Schema) Add(fieldName string, ss *Schema) error {
 	ft, err := ss.FieldType(fieldName)
 	if err != nil {
@@ -63,8 +94,13 @@ func (s *Schema) Add(fieldName string, ss *Schema) error {
 		return err
 	}
 
+	dv, err := ss.DefaultValue(fieldName)
+	if err != nil {
+		return err
+	}
+
 	s.AddField(fieldName, ft, l)
-	return nil
+	return s.SetDefaultValue(fieldName, dv)
 }
 
 func (s *Schema) AddAll(schema *Schema) error {
@@ -93,7 +129,7 @@ func (s *Schema) Fields() []string {
 func (s *Schema) FieldType(fieldName string) (FieldType, error) {
 	fi, ok := s.fieldInfo[fieldName]
 	if !ok {
-		return Unknown, fmt.Errorf("cannot get field info: field name [%s]", fieldName)
+		return Unknown, sqlstate.Errorf(sqlstate.UndefinedColumn, "cannot get field info: field name [%s]", fieldName)
 	}
 	return fi.fieldType, nil
 }
@@ -101,15 +137,25 @@ func (s *Schema) FieldType(fieldName string) (FieldType, error) {
 func (s *Schema) Length(fieldName string) (int, error) {
 	fi, ok := s.fieldInfo[fieldName]
 	if !ok {
-		return 0, fmt.Errorf("cannot get field info: field name [%s]", fieldName)
+		return 0, sqlstate.Errorf(sqlstate.UndefinedColumn, "cannot get field info: field name [%s]", fieldName)
 	}
 	return fi.length, nil
 }
 
+// DefaultValue はデフォルト値の式の文字列を返す
+// デフォルト値が設定されていない場合は空文字列を返す
+func (s *Schema) DefaultValue(fieldName string) (string, error) {
+	fi, ok := s.fieldInfo[fieldName]
+	if !ok {
+		return "", sqlstate.Errorf(sqlstate.UndefinedColumn, "cannot get field info: field name [%s]", fieldName)
+	}
+	return fi.defaultValue, nil
+}
+
 func (s *Schema) lengthInBytes(fieldName string) (int, error) {
 	fi, ok := s.fieldInfo[fieldName]
 	if !ok {
-		return 0, fmt.Errorf("cannot get field info: field name [%s]", fieldName)
+		return 0, sqlstate.Errorf(sqlstate.UndefinedColumn, "cannot get field info: field name [%s]", fieldName)
 	}
 
 	switch fi.fieldType {
@@ -121,6 +167,10 @@ func (s *Schema) lengthInBytes(fieldName string) (int, error) {
 			return 0, err
 		}
 		return file.MaxLength(strlen), nil
+	case JSON, Text, Blob:
+		return largeValueSlotSize(), nil
+	case UUID:
+		return UUIDByteSize, nil
 	default:
 		return 0, fmt.Errorf("invalid field type [%d]", fi.fieldType)
 	}
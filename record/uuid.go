@@ -0,0 +1,65 @@
+package record
+
+import (
+	"encoding/binary"
+
+	"github.com/google/uuid"
+	"github.com/ksrnnb/go-rdb/file"
+	"github.com/ksrnnb/go-rdb/tx"
+)
+
+// UUIDByteSize は UUID をスロットに格納するときのバイト数
+// 文字列 (36 文字) ではなく、16 バイトのバイナリとして格納する
+const UUIDByteSize = 16
+
+// ReadUUID は指定した位置から 16 バイトの UUID を読み込む
+// トランザクションの値の単位は int32 なので、4 バイトずつ 4 回に分けて読み込む
+func ReadUUID(tx *tx.Transaction, blk file.BlockID, pos int) (uuid.UUID, error) {
+	var u uuid.UUID
+	for i := 0; i < UUIDByteSize; i += IntByteSize {
+		v, err := tx.GetInt(blk, pos+i)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		binary.BigEndian.PutUint32(u[i:], uint32(v))
+	}
+	return u, nil
+}
+
+// WriteUUID は指定した位置に 16 バイトの UUID を書き込む
+// 4 バイトずつ書き込むので、ログも 4 つの SetInt のレコードとして記録される
+func WriteUUID(tx *tx.Transaction, blk file.BlockID, pos int, u uuid.UUID, okToLog bool) error {
+	for i := 0; i < UUIDByteSize; i += IntByteSize {
+		v := int32(binary.BigEndian.Uint32(u[i:]))
+		if err := tx.SetInt(blk, pos+i, int(v), okToLog); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetUUID は指定されたレコードの UUID フィールドの値を取得する
+func (rp *RecordPage) GetUUID(slot int, fieldName string) (uuid.UUID, error) {
+	ofs, err := rp.layout.Offset(fieldName)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return ReadUUID(rp.tx, rp.blk, rp.offset(slot)+ofs)
+}
+
+// SetUUID は指定されたレコードの UUID フィールドに値を設定する
+func (rp *RecordPage) SetUUID(slot int, fieldName string, u uuid.UUID) error {
+	ofs, err := rp.layout.Offset(fieldName)
+	if err != nil {
+		return err
+	}
+	return WriteUUID(rp.tx, rp.blk, rp.offset(slot)+ofs, u, true)
+}
+
+func (rp *RecordPage) isUUIDField(fieldName string) bool {
+	ft, err := rp.layout.Schema().FieldType(fieldName)
+	if err != nil {
+		return false
+	}
+	return ft == UUID
+}
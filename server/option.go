@@ -0,0 +1,91 @@
+package server
+
+import (
+	"github.com/ksrnnb/go-rdb/metadata"
+	"github.com/ksrnnb/go-rdb/planner"
+)
+
+// QueryPlannerType は SimpleDB が select 文の plan を作成する query planner の種類
+type QueryPlannerType int
+
+const (
+	// HeuristicPlanner は出力レコード数の少ないテーブルから貪欲に結合順序を決める
+	HeuristicPlanner QueryPlannerType = iota
+	// DPPlanner は動的計画法で結合順序を決める
+	// テーブル数が上限を超える場合は HeuristicPlanner と同じ方法を使う
+	DPPlanner
+)
+
+// UpdatePlannerType は SimpleDB が insert, update, delete 文などを実行する update planner の種類
+type UpdatePlannerType int
+
+const (
+	// IndexUpdater はテーブルのレコードと一緒にインデックスも更新する
+	IndexUpdater UpdatePlannerType = iota
+	// BasicUpdater はテーブルのレコードだけを更新して、インデックスは更新しない
+	// インデックスがテーブルと食い違うので、インデックスを作成しない場合だけ使う
+	BasicUpdater
+)
+
+// Option は NewSimpleDBWithMetadata で生成する SimpleDB の設定を変更する
+type Option func(*options)
+
+type options struct {
+	queryPlanner  QueryPlannerType
+	updatePlanner UpdatePlannerType
+	dpTableLimit  int
+	bufferSize    int
+}
+
+func defaultOptions() *options {
+	return &options{
+		queryPlanner:  HeuristicPlanner,
+		updatePlanner: IndexUpdater,
+		dpTableLimit:  planner.DefaultDPTableLimit,
+		bufferSize:    defaultBufferSize,
+	}
+}
+
+// WithQueryPlanner は使用する query planner を指定する
+func WithQueryPlanner(qpt QueryPlannerType) Option {
+	return func(o *options) {
+		o.queryPlanner = qpt
+	}
+}
+
+// WithUpdatePlanner は使用する update planner を指定する
+func WithUpdatePlanner(upt UpdatePlannerType) Option {
+	return func(o *options) {
+		o.updatePlanner = upt
+	}
+}
+
+// WithDPTableLimit は DPPlanner が動的計画法を使うテーブル数の上限を指定する
+func WithDPTableLimit(limit int) Option {
+	return func(o *options) {
+		o.dpTableLimit = limit
+	}
+}
+
+// WithBufferSize はバッファプールのバッファの数を指定する
+// 同時に実行するトランザクションが多い場合は、ページを pin できずに待たないように増やす
+func WithBufferSize(n int) Option {
+	return func(o *options) {
+		o.bufferSize = n
+	}
+}
+
+func (o *options) newQueryPlanner(mm *metadata.MetadataManager) planner.QueryPlanner {
+	generator := planner.NewNextTableNameGenerator()
+	if o.queryPlanner == DPPlanner {
+		return planner.NewDPQueryPlanner(mm, generator, o.dpTableLimit)
+	}
+	return planner.NewHeuristicQueryPlanner(mm, generator)
+}
+
+func (o *options) newUpdatePlanner(mm *metadata.MetadataManager) planner.UpdatePlanner {
+	if o.updatePlanner == BasicUpdater {
+		return planner.NewBasicUpdatePlanner(mm)
+	}
+	return planner.NewIndexUpdatePlanner(mm)
+}
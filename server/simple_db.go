@@ -52,8 +52,14 @@ func NewSimpleDB(dirname string, blockSize, bufferSize int) *SimpleDB {
 	}
 }
 
-func NewSimpleDBWithMetadata(dirname string) *SimpleDB {
-	db := NewSimpleDB(dirname, defaultBlockSize, defaultBufferSize)
+// NewSimpleDBWithMetadata はメタデータを読み込んで、クエリを実行できる SimpleDB を返す
+// opts で query planner などを変更できる
+func NewSimpleDBWithMetadata(dirname string, opts ...Option) *SimpleDB {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+	db := NewSimpleDB(dirname, defaultBlockSize, o.bufferSize)
 	tx, err := db.NewTransaction()
 	if err != nil {
 		log.Fatalf("NewTransaction() failed, %v", err)
@@ -75,8 +81,8 @@ func NewSimpleDBWithMetadata(dirname string) *SimpleDB {
 		log.Fatalf("NewMetadataManager() failed, %v", err)
 	}
 
-	qp := planner.NewHeuristicQueryPlanner(mm, planner.NewNextTableNameGenerator())
-	up := planner.NewBasicUpdatePlanner(mm)
+	qp := o.newQueryPlanner(mm)
+	up := o.newUpdatePlanner(mm)
 	db.pe = planner.NewPlanExecuter(qp, up)
 
 	err = tx.Commit()
@@ -108,6 +114,11 @@ func (db *SimpleDB) PlanExecuter() *planner.PlanExecuter {
 	return db.pe
 }
 
+// CheckIndexes はテーブルの全てのインデックスの内容がテーブルのレコードと一致するかを確認する
+func (db *SimpleDB) CheckIndexes(tableName string, tx *tx.Transaction) error {
+	return planner.CheckIndexes(db.mm, tableName, tx)
+}
+
 func (db *SimpleDB) NewTransaction() (*tx.Transaction, error) {
 	return tx.NewTransaction(db.fm, db.lm, db.bm, db.lt, db.tng)
 }
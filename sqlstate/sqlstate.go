@@ -0,0 +1,83 @@
+package sqlstate
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Code は PostgreSQL の SQLSTATE にならった5文字のエラーコード
+// 先頭2文字がエラーのクラスを表す
+type Code string
+
+const (
+	ProtocolViolation         Code = "08P01"
+	FeatureNotSupported       Code = "0A000"
+	InvalidParameterValue     Code = "22023"
+	InvalidTextRepresentation Code = "22P02"
+	StringDataRightTruncation Code = "22001"
+	UniqueViolation           Code = "23505"
+	NoActiveTransaction       Code = "25P01"
+	InvalidStatementName      Code = "26000"
+	SyntaxError               Code = "42601"
+	WrongObjectType           Code = "42809"
+	UndefinedTable            Code = "42P01"
+	UndefinedColumn           Code = "42703"
+	UndefinedFunction         Code = "42883"
+	UndefinedObject           Code = "42704"
+	DatatypeMismatch          Code = "42804"
+	GroupingError             Code = "42803"
+	InvalidObjectDefinition   Code = "42P17"
+	LockNotAvailable          Code = "55P03"
+	InternalError             Code = "XX000"
+	DataCorrupted             Code = "XX001"
+	IndexCorrupted            Code = "XX002"
+)
+
+// Class はエラーのクラス (コードの先頭2文字) を返す
+func (c Code) Class() string {
+	return string(c[:2])
+}
+
+// Coder は SQLSTATE のコードを返せるエラー
+type Coder interface {
+	SQLState() Code
+}
+
+// Error は SQLSTATE のコードを持つエラー
+type Error struct {
+	code Code
+	err  error
+}
+
+// New はメッセージから SQLSTATE のコードを持つエラーを生成する
+func New(code Code, msg string) error {
+	return &Error{code, errors.New(msg)}
+}
+
+// Errorf はフォーマットしたメッセージから SQLSTATE のコードを持つエラーを生成する
+// %w で元のエラーをラップできる
+func Errorf(code Code, format string, args ...interface{}) error {
+	return &Error{code, fmt.Errorf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return e.err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+func (e *Error) SQLState() Code {
+	return e.code
+}
+
+// CodeOf はエラーのチェーンから最初に見つかった SQLSTATE のコードを返す
+// コードを持つエラーがない場合は InternalError を返す
+func CodeOf(err error) Code {
+	var c Coder
+	if errors.As(err, &c) {
+		return c.SQLState()
+	}
+	return InternalError
+}
@@ -0,0 +1,24 @@
+package sqlstate
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodeOf(t *testing.T) {
+	cause := errors.New("cause")
+	err := Errorf(UndefinedTable, "table is not found: %s, %w", "users", cause)
+	assert.Equal(t, UndefinedTable, CodeOf(err))
+	assert.ErrorIs(t, err, cause)
+	assert.Equal(t, "table is not found: users, cause", err.Error())
+
+	// ラップしたエラーからもコードを取得できる
+	wrapped := fmt.Errorf("statement 1: %w", err)
+	assert.Equal(t, UndefinedTable, CodeOf(wrapped))
+	assert.Equal(t, "42", CodeOf(wrapped).Class())
+
+	assert.Equal(t, InternalError, CodeOf(cause))
+}
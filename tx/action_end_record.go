@@ -0,0 +1,97 @@
+package tx
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/ksrnnb/go-rdb/file"
+	"github.com/ksrnnb/go-rdb/logs"
+)
+
+// ActionEndRecord は EndAction で終えた一連の変更の終わりを表す
+// 変更は物理的に戻さずに、 undoName で登録した関数に undoData を渡して論理的に取り消す
+type ActionEndRecord struct {
+	txnum    int
+	undoName string
+	undoData []byte
+}
+
+func NewActionEndRecord(p *file.Page) (*ActionEndRecord, error) {
+	aer := &ActionEndRecord{}
+
+	tpos := intByteSize
+	var err error
+	aer.txnum, err = p.GetInt(tpos)
+	if err != nil {
+		return nil, err
+	}
+
+	npos := tpos + intByteSize
+	aer.undoName, err = p.GetString(npos)
+	if err != nil {
+		return nil, err
+	}
+
+	dpos := npos + file.MaxLengthInString(aer.undoName)
+	aer.undoData, err = p.GetBytes(dpos)
+	if err != nil {
+		return nil, err
+	}
+
+	return aer, nil
+}
+
+// Op() returns the log record's type
+func (aer *ActionEndRecord) Op() int {
+	return ActionEnd
+}
+
+// TxNumber() returns the transaction id stored with the log record
+func (aer *ActionEndRecord) TxNumber() int {
+	return aer.txnum
+}
+
+// Undo() undoes the operation encoded by this log record
+// 取り消せなかった場合は他のトランザクションと共有するページが不整合になるので、エラーを返してロールバックやリカバリを止める
+func (aer *ActionEndRecord) Undo(tx *Transaction) error {
+	f, ok := undoFuncs[aer.undoName]
+	if !ok {
+		return fmt.Errorf("tx: undo function %q of transaction %d is not registered", aer.undoName, aer.txnum)
+	}
+	if err := f(tx, aer.undoData); err != nil {
+		return fmt.Errorf("tx: logical undo %q of transaction %d failed, %w", aer.undoName, aer.txnum, err)
+	}
+	return nil
+}
+
+func (aer *ActionEndRecord) String() string {
+	return "<ACTIONEND " + strconv.Itoa(aer.txnum) + " " + aer.undoName + ">"
+}
+
+func writeActionEndToLog(lm *logs.LogManager, txnum int, undoName string, undoData []byte) (latestLSN int, err error) {
+	tpos := intByteSize
+	npos := tpos + intByteSize
+	dpos := npos + file.MaxLengthInString(undoName)
+	recSize := dpos + intByteSize + len(undoData)
+
+	rec := make([]byte, recSize)
+	p := file.NewPageWithBuf(rec)
+
+	if err := p.SetInt(0, ActionEnd); err != nil {
+		return 0, err
+	}
+
+	if err := p.SetInt(tpos, txnum); err != nil {
+		return 0, err
+	}
+
+	if err := p.SetString(npos, undoName); err != nil {
+		return 0, err
+	}
+
+	if err := p.SetBytes(dpos, undoData); err != nil {
+		return 0, err
+	}
+
+	return lm.Append(rec)
+}
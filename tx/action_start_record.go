@@ -0,0 +1,56 @@
+package tx
+
+import (
+	"strconv"
+
+	"github.com/ksrnnb/go-rdb/file"
+	"github.com/ksrnnb/go-rdb/logs"
+)
+
+// ActionStartRecord は StartAction で始めた一連の変更の始まりを表す
+type ActionStartRecord struct {
+	txnum int
+}
+
+func NewActionStartRecord(p *file.Page) (*ActionStartRecord, error) {
+	tpos := intByteSize
+	txnum, err := p.GetInt(tpos)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ActionStartRecord{txnum: txnum}, nil
+}
+
+// Op() returns the log record's type
+func (asr *ActionStartRecord) Op() int {
+	return ActionStart
+}
+
+// TxNumber() returns the transaction id stored with the log record
+func (asr *ActionStartRecord) TxNumber() int {
+	return asr.txnum
+}
+
+// Undo() undoes the operation encoded by this log record
+// do nothing in ActionStart
+func (asr *ActionStartRecord) Undo(tx *Transaction) error { return nil }
+
+func (asr *ActionStartRecord) String() string {
+	return "<ACTIONSTART " + strconv.Itoa(asr.txnum) + ">"
+}
+
+func writeActionStartToLog(lm *logs.LogManager, txnum int) (latestLSN int, err error) {
+	rec := make([]byte, 2*intByteSize)
+	p := file.NewPageWithBuf(rec)
+
+	if err := p.SetInt(0, ActionStart); err != nil {
+		return 0, err
+	}
+
+	if err := p.SetInt(intByteSize, txnum); err != nil {
+		return 0, err
+	}
+
+	return lm.Append(rec)
+}
@@ -18,6 +18,8 @@ type BufferList struct {
 	txBuffers []*txBuffer
 	pins      []file.BlockID
 	bm        *buffer.BufferManager
+	// トランザクションが pin した回数と、 pin したときにディスクを読み書きしたブロック数
+	ios buffer.IOStats
 }
 
 func newTxBuffer(blk file.BlockID, buf *buffer.Buffer) *txBuffer {
@@ -39,13 +41,16 @@ func (bl *BufferList) getTxBuffer(blk file.BlockID) (*txBuffer, error) {
 }
 
 func (bl *BufferList) pin(blk file.BlockID) error {
-	buf, err := bl.bm.Pin(blk)
+	buf, err := bl.bm.PinCounting(blk, &bl.ios)
 
 	if err != nil {
 		return err
 	}
 
-	bl.txBuffers = append(bl.txBuffers, newTxBuffer(blk, buf))
+	// 同じブロックを複数回 pin した場合でも txBuffer は1つだけ保持する
+	if _, err := bl.getTxBuffer(blk); errors.Is(err, ErrBufferNotFound) {
+		bl.txBuffers = append(bl.txBuffers, newTxBuffer(blk, buf))
+	}
 	bl.pins = append(bl.pins, blk)
 	return nil
 }
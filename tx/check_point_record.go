@@ -24,7 +24,7 @@ func (cpr *CheckPointRecord) TxNumber() int {
 
 // Undo() undoes the operation encoded by this log record
 // do nothing in checkpoint
-func (cpr *CheckPointRecord) Undo(tx *Transaction) {}
+func (cpr *CheckPointRecord) Undo(tx *Transaction) error { return nil }
 
 func (cpr *CheckPointRecord) String() string {
 	return "<CHECKPOINT>"
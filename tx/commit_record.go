@@ -33,7 +33,7 @@ func (cr *CommitRecord) TxNumber() int {
 
 // Undo() undoes the operation encoded by this log record
 // do nothing in Commit
-func (cr *CommitRecord) Undo(tx *Transaction) {}
+func (cr *CommitRecord) Undo(tx *Transaction) error { return nil }
 
 func (cr *CommitRecord) String() string {
 	return "<COMMIT " + strconv.Itoa(cr.txnum) + ">"
@@ -1,9 +1,13 @@
 package concurrency
 
 import (
+	"errors"
+
 	"github.com/ksrnnb/go-rdb/file"
 )
 
+var ErrLatchUpgrade = errors.New("concurrency: cannot upgrade a shared latch")
+
 type LockType int
 
 const (
@@ -16,13 +20,20 @@ type ConcurrencyManagerLock struct {
 	blk file.BlockID
 }
 
+// heldLatch はトランザクションがかけている latch で、 count は同じブロックに重ねてかけた回数
+type heldLatch struct {
+	ty    LockType
+	count int
+}
+
 type ConcurrencyManager struct {
-	lt    *LockTable
-	locks []*ConcurrencyManagerLock
+	lt      *LockTable
+	locks   []*ConcurrencyManagerLock
+	latches map[file.BlockID]*heldLatch
 }
 
 func NewConcurrencyManager(lt *LockTable) *ConcurrencyManager {
-	return &ConcurrencyManager{lt: lt}
+	return &ConcurrencyManager{lt: lt, latches: make(map[file.BlockID]*heldLatch)}
 }
 
 func (cm *ConcurrencyManager) SLock(blk file.BlockID) error {
@@ -42,9 +53,13 @@ func (cm *ConcurrencyManager) SLock(blk file.BlockID) error {
 
 func (cm *ConcurrencyManager) XLock(blk file.BlockID) error {
 	// ConcurrencyManager が xLock を所持している場合は、同じトランザクションなのでそのまま書き込みできる
-	if cm.hasXLock(blk) {
+	if cm.HasXLock(blk) {
 		return nil
 	}
+	// 他のトランザクションの排他ロックと重ならないように、先に共有ロックをかけてから排他ロックに上げる
+	if err := cm.SLock(blk); err != nil {
+		return err
+	}
 
 	err := cm.lt.XLock(blk)
 	if err != nil {
@@ -55,15 +70,99 @@ func (cm *ConcurrencyManager) XLock(blk file.BlockID) error {
 	return nil
 }
 
+// TrySLock は待たずに blk に共有ロックをかけて、かけられなかった場合は false を返す
+// latch をかけたまま他のトランザクションを待たないように、 latch をかけている間はこちらを使う
+func (cm *ConcurrencyManager) TrySLock(blk file.BlockID) bool {
+	if cm.getConcurrencyManagerLock(blk) != nil {
+		return true
+	}
+	if !cm.lt.TrySLock(blk) {
+		return false
+	}
+	cm.setConcurrencyManagerLock(blk, SLockType)
+	return true
+}
+
+// TryXLock は待たずに blk に排他ロックをかけて、かけられなかった場合は false を返す
+// 共有ロックまでかけられた場合は、共有ロックをかけたままにする
+func (cm *ConcurrencyManager) TryXLock(blk file.BlockID) bool {
+	if cm.HasXLock(blk) {
+		return true
+	}
+	if !cm.TrySLock(blk) || !cm.lt.TryXLock(blk) {
+		return false
+	}
+	cm.setConcurrencyManagerLock(blk, XLockType)
+	return true
+}
+
+// Latched は blk に latch をかけているかどうかを返す
+// 書き込む場合 (write が true の場合) に共有の latch しかかけていなければエラーを返す
+func (cm *ConcurrencyManager) Latched(blk file.BlockID, write bool) (bool, error) {
+	l := cm.latches[blk]
+	if l == nil {
+		return false, nil
+	}
+	if write && l.ty != XLockType {
+		return false, ErrLatchUpgrade
+	}
+	return true, nil
+}
+
+// SLatch は blk に共有の latch をかける
+// 同じブロックに重ねてかけた場合は、同じ回数 Unlatch を呼ぶと外れる
+func (cm *ConcurrencyManager) SLatch(blk file.BlockID) error {
+	if l := cm.latches[blk]; l != nil {
+		l.count++
+		return nil
+	}
+	cm.lt.latches.SLatch(blk)
+	cm.latches[blk] = &heldLatch{ty: SLockType, count: 1}
+	return nil
+}
+
+// XLatch は blk に排他の latch をかける
+// 共有の latch をかけているブロックに排他の latch をかけようとすると、他のトランザクションと deadlock するおそれがあるのでエラーを返す
+func (cm *ConcurrencyManager) XLatch(blk file.BlockID) error {
+	if l := cm.latches[blk]; l != nil {
+		if l.ty != XLockType {
+			return ErrLatchUpgrade
+		}
+		l.count++
+		return nil
+	}
+	cm.lt.latches.XLatch(blk)
+	cm.latches[blk] = &heldLatch{ty: XLockType, count: 1}
+	return nil
+}
+
+func (cm *ConcurrencyManager) Unlatch(blk file.BlockID) {
+	l := cm.latches[blk]
+	if l == nil {
+		return
+	}
+	l.count--
+	if l.count == 0 {
+		cm.lt.latches.Unlatch(blk, l.ty)
+		delete(cm.latches, blk)
+	}
+}
+
+// Release はトランザクションのロックと、エラーなどで外していない latch を全て外す
 func (cm *ConcurrencyManager) Release() {
 	for _, lock := range cm.locks {
 		cm.lt.Unlock(lock.blk)
 	}
+	for blk, l := range cm.latches {
+		cm.lt.latches.Unlatch(blk, l.ty)
+	}
 
 	cm.locks = nil
+	cm.latches = make(map[file.BlockID]*heldLatch)
 }
 
-func (cm *ConcurrencyManager) hasXLock(blk file.BlockID) bool {
+// HasXLock はこのトランザクションが blk に排他ロックをかけているかどうかを返す
+func (cm *ConcurrencyManager) HasXLock(blk file.BlockID) bool {
 	lock := cm.getConcurrencyManagerLock(blk)
 
 	if lock == nil {
@@ -4,11 +4,13 @@ import (
 	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/ksrnnb/go-rdb/file"
 	myTesting "github.com/ksrnnb/go-rdb/testing"
 	"github.com/ksrnnb/go-rdb/tx"
 	"github.com/ksrnnb/go-rdb/tx/concurrency"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -78,3 +80,73 @@ func TestConcurrency(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestLatch(t *testing.T) {
+	lt := concurrency.NewLockTable()
+	cm1 := concurrency.NewConcurrencyManager(lt)
+	cm2 := concurrency.NewConcurrencyManager(lt)
+	cm3 := concurrency.NewConcurrencyManager(lt)
+	blk := file.NewBlockID("testfile", 1)
+
+	// 共有の latch は同時にかけられて、重ねてかけた場合は同じ回数外すまで外れない
+	require.NoError(t, cm1.SLatch(blk))
+	require.NoError(t, cm1.SLatch(blk))
+	require.NoError(t, cm2.SLatch(blk))
+	assert.ErrorIs(t, cm1.XLatch(blk), concurrency.ErrLatchUpgrade)
+
+	acquired := make(chan struct{})
+	go func() {
+		assert.NoError(t, cm3.XLatch(blk))
+		close(acquired)
+	}()
+	cm1.Unlatch(blk)
+	cm2.Unlatch(blk)
+	select {
+	case <-acquired:
+		t.Fatal("exclusive latch must wait for shared latches")
+	case <-time.After(50 * time.Millisecond):
+	}
+	cm1.Unlatch(blk)
+	<-acquired
+
+	// latch をかけていてもロックは他のトランザクションのロックと重ならない
+	require.NoError(t, cm3.XLock(blk))
+	assert.False(t, cm2.TrySLock(blk))
+	assert.False(t, cm2.TryXLock(blk))
+
+	// Release は外していない latch も外す
+	cm3.Release()
+	require.NoError(t, cm1.XLatch(blk))
+	cm1.Unlatch(blk)
+
+	// 共有ロックどうしは重なり、他のトランザクションが共有ロックをかけている間は排他ロックをかけられない
+	assert.True(t, cm1.TrySLock(blk))
+	assert.True(t, cm2.TrySLock(blk))
+	assert.False(t, cm1.TryXLock(blk))
+	cm2.Release()
+	assert.True(t, cm1.TryXLock(blk))
+	cm1.Release()
+}
+
+func TestXLockWaitsForXLock(t *testing.T) {
+	lt := concurrency.NewLockTable()
+	cm1 := concurrency.NewConcurrencyManager(lt)
+	cm2 := concurrency.NewConcurrencyManager(lt)
+	blk := file.NewBlockID("testfile", 1)
+
+	// 排他ロックは他のトランザクションの排他ロックが外れるまで待つ
+	require.NoError(t, cm1.XLock(blk))
+	acquired := make(chan struct{})
+	go func() {
+		assert.NoError(t, cm2.XLock(blk))
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("exclusive lock must wait for another exclusive lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	cm1.Release()
+	<-acquired
+	cm2.Release()
+}
@@ -0,0 +1,68 @@
+package concurrency
+
+import (
+	"sync"
+
+	"github.com/ksrnnb/go-rdb/file"
+)
+
+// LatchTable はページを読み書きする間だけかける latch を管理する
+// ロックと違ってトランザクションの終わりまで保持しないので、待ち時間の上限はなく、
+// latch をかける順番を決めて deadlock を避ける (B-tree ではルートから leaf に向かって、同じ階層では左から右にかける)
+type LatchTable struct {
+	mux     sync.Mutex
+	latches map[file.BlockID]*latch
+}
+
+// latch は1つのブロックの latch で、 users は latch を待っているか保持しているトランザクションの数
+type latch struct {
+	rw    sync.RWMutex
+	users int
+}
+
+func NewLatchTable() *LatchTable {
+	return &LatchTable{latches: make(map[file.BlockID]*latch)}
+}
+
+// SLatch は blk に共有の latch をかける
+// 他のトランザクションが排他の latch をかけている場合は外すまで待つ
+func (lt *LatchTable) SLatch(blk file.BlockID) {
+	lt.acquire(blk).rw.RLock()
+}
+
+// XLatch は blk に排他の latch をかける
+// 他のトランザクションが latch をかけている場合は全て外すまで待つ
+func (lt *LatchTable) XLatch(blk file.BlockID) {
+	lt.acquire(blk).rw.Lock()
+}
+
+// Unlatch は ty の種類でかけた blk の latch を外す
+func (lt *LatchTable) Unlatch(blk file.BlockID, ty LockType) {
+	lt.mux.Lock()
+	l := lt.latches[blk]
+	l.users--
+	if l.users == 0 {
+		delete(lt.latches, blk)
+	}
+	lt.mux.Unlock()
+
+	if ty == XLockType {
+		l.rw.Unlock()
+	} else {
+		l.rw.RUnlock()
+	}
+}
+
+// acquire は blk の latch を返す
+// 使うトランザクションがなくなった latch は Unlatch で削除するので、なければ作る
+func (lt *LatchTable) acquire(blk file.BlockID) *latch {
+	lt.mux.Lock()
+	defer lt.mux.Unlock()
+	l, ok := lt.latches[blk]
+	if !ok {
+		l = &latch{}
+		lt.latches[blk] = l
+	}
+	l.users++
+	return l
+}
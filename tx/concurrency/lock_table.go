@@ -1,16 +1,16 @@
 package concurrency
 
 import (
-	"errors"
 	"sync"
 	"time"
 
 	"github.com/ksrnnb/go-rdb/file"
+	"github.com/ksrnnb/go-rdb/sqlstate"
 )
 
 const maxWaitingTime = 60 * time.Second
 
-var ErrLockAbort = errors.New("concurrency: lock abort error")
+var ErrLockAbort = sqlstate.New(sqlstate.LockNotAvailable, "concurrency: lock abort error")
 
 type Lock struct {
 	blk file.BlockID
@@ -35,6 +35,8 @@ func (ls LockStatus) SLock() LockStatus {
 type LockTable struct {
 	cond  *sync.Cond
 	locks map[file.BlockID]LockStatus
+	// latches はトランザクションの間で共有する、ロックより短い期間の latch
+	latches *LatchTable
 }
 
 type lockResult struct {
@@ -43,8 +45,9 @@ type lockResult struct {
 
 func NewLockTable() *LockTable {
 	return &LockTable{
-		cond:  sync.NewCond(&sync.Mutex{}),
-		locks: make(map[file.BlockID]LockStatus),
+		cond:    sync.NewCond(&sync.Mutex{}),
+		locks:   make(map[file.BlockID]LockStatus),
+		latches: NewLatchTable(),
 	}
 }
 
@@ -116,6 +119,32 @@ func (lt *LockTable) xLock(lr chan<- lockResult, blk file.BlockID, start time.Ti
 	lr <- lockResult{}
 }
 
+// TrySLock は待たずに共有ロックをかけて、他のトランザクションが排他ロックをかけている場合は false を返す
+func (lt *LockTable) TrySLock(blk file.BlockID) bool {
+	lt.cond.L.Lock()
+	defer lt.cond.L.Unlock()
+
+	if lt.hasXLock(blk) {
+		return false
+	}
+	l := lt.getLockStatus(blk)
+	lt.setLockStatus(blk, l.SLock())
+	return true
+}
+
+// TryXLock は待たずに排他ロックをかけて、他のトランザクションが共有ロックをかけている場合は false を返す
+// XLock と同じく、呼び出し側で共有ロックをかけておく
+func (lt *LockTable) TryXLock(blk file.BlockID) bool {
+	lt.cond.L.Lock()
+	defer lt.cond.L.Unlock()
+
+	if lt.hasOtherSLocks(blk) {
+		return false
+	}
+	lt.setLockStatus(blk, XLocked)
+	return true
+}
+
 func (lt *LockTable) Unlock(blk file.BlockID) {
 	lt.cond.L.Lock()
 	defer lt.cond.L.Unlock()
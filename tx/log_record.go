@@ -13,12 +13,14 @@ const (
 	Rollback
 	SetInt
 	SetString
+	ActionStart
+	ActionEnd
 )
 
 type LogRecord interface {
-	Op() int              // returns the log record's type
-	TxNumber() int        // returns the transaction id stored with the log record
-	Undo(tx *Transaction) // undoes the operation encoded by this log record
+	Op() int                    // returns the log record's type
+	TxNumber() int              // returns the transaction id stored with the log record
+	Undo(tx *Transaction) error // undoes the operation encoded by this log record
 }
 
 func CreateLogRecord(b []byte) (LogRecord, error) {
@@ -41,6 +43,10 @@ func CreateLogRecord(b []byte) (LogRecord, error) {
 		return NewSetIntRecord(p)
 	case SetString:
 		return NewSetStringRecord(p)
+	case ActionStart:
+		return NewActionStartRecord(p)
+	case ActionEnd:
+		return NewActionEndRecord(p)
 	default:
 		return nil, fmt.Errorf("tx: CreateLogRecord() failed, recordType value of page is invalid")
 	}
@@ -116,7 +116,22 @@ func (rm *RecoveryManager) SetString(buf *buffer.Buffer, offset int) (latestLSN
 	return writeSetStringToLog(rm.lm, rm.txnum, blk, offset, oldVal)
 }
 
+// StartAction は他のトランザクションと共有するページへの一連の変更の始まりをログに書き込む
+func (rm *RecoveryManager) StartAction() error {
+	_, err := writeActionStartToLog(rm.lm, rm.txnum)
+	return err
+}
+
+// EndAction は一連の変更の終わりと、変更を論理的に取り消すための undoName と undoData をログに書き込む
+func (rm *RecoveryManager) EndAction(undoName string, undoData []byte) error {
+	_, err := writeActionEndToLog(rm.lm, rm.txnum, undoName, undoData)
+	return err
+}
+
+// doRollBack はトランザクションのログレコードを新しいものから順に取り消す
+// 終えた一連の変更は ActionEnd のログレコードで論理的に取り消して、 ActionStart までのログレコードは読み飛ばす
 func (rm *RecoveryManager) doRollBack() error {
+	skipping := false
 	iter, err := rm.lm.Iterator()
 
 	if err != nil {
@@ -135,48 +150,125 @@ func (rm *RecoveryManager) doRollBack() error {
 			return err
 		}
 
-		if rec.TxNumber() == rm.txnum {
-			if rec.Op() == Start {
-				return nil
-			}
-			rec.Undo(rm.tx)
+		if rec.TxNumber() != rm.txnum {
+			continue
+		}
+		if rec.Op() == Start {
+			return nil
+		}
+		skipping, err = undoUnlessSkipped(rm.tx, rec, skipping)
+		if err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// doRecover は終わっていないトランザクションのログレコードを、最後のチェックポイントまで取り消す
+// 終えた一連の変更を論理的に取り消すときは木などの構造をたどるので、先に途中で終わった一連の変更を全て物理的に戻して、
+// 他のトランザクションが変更しかけたページを元の状態にしてから、新しいものから順に取り消す
 func (rm *RecoveryManager) doRecover() error {
+	recs, err := rm.unfinishedRecords()
+	if err != nil {
+		return err
+	}
+
+	unfinishedActions, err := undoUnfinishedActions(rm.tx, recs)
+	if err != nil {
+		return err
+	}
+
+	// 途中で終わった一連の変更は戻したので、 ActionStart まで読み飛ばす
+	skipping := unfinishedActions
+	for _, rec := range recs {
+		skipping[rec.TxNumber()], err = undoUnlessSkipped(rm.tx, rec, skipping[rec.TxNumber()])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unfinishedRecords は最後のチェックポイントより新しい、終わっていないトランザクションのログレコードを新しいものから順に返す
+func (rm *RecoveryManager) unfinishedRecords() ([]LogRecord, error) {
 	var finishedTxs []int
+	var recs []LogRecord
 	iter, err := rm.lm.Iterator()
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	for iter.HasNext() {
 		b, err := iter.Next()
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		rec, err := CreateLogRecord(b)
 
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		if rec.Op() == CheckPoint {
-			return nil
+			return recs, nil
 		}
 
 		if rec.Op() == Commit || rec.Op() == Rollback {
 			finishedTxs = append(finishedTxs, rec.TxNumber())
 		} else if !contains(finishedTxs, rec.TxNumber()) {
-			rec.Undo(rm.tx)
+			recs = append(recs, rec)
 		}
 	}
-	return nil
+	return recs, nil
+}
+
+// undoUnfinishedActions は StartAction の後に EndAction がないトランザクションの、 ActionStart より新しいログレコードを物理的に戻す
+// 途中で終わった一連の変更はトランザクションの最後の変更なので、新しいものから順に ActionStart か ActionEnd か Start が見つかるまでたどる
+// 戻したトランザクションを true にした map を返す
+func undoUnfinishedActions(tx *Transaction, recs []LogRecord) (map[int]bool, error) {
+	unfinished := make(map[int]bool)
+	decided := make(map[int]bool)
+	pending := make(map[int][]LogRecord)
+	for _, rec := range recs {
+		txnum := rec.TxNumber()
+		if decided[txnum] {
+			continue
+		}
+		switch rec.Op() {
+		case ActionStart:
+			for _, r := range pending[txnum] {
+				if err := r.Undo(tx); err != nil {
+					return nil, err
+				}
+			}
+			unfinished[txnum] = true
+			decided[txnum] = true
+		case ActionEnd, Start:
+			decided[txnum] = true
+		default:
+			pending[txnum] = append(pending[txnum], rec)
+		}
+		if decided[txnum] {
+			delete(pending, txnum)
+		}
+	}
+	return unfinished, nil
+}
+
+// undoUnlessSkipped は一連の変更の途中のログレコードを読み飛ばしている場合を除いて rec を取り消し、
+// 次のログレコードを読み飛ばすかどうかを返す
+// ロールバックでは、途中で終わった一連の変更は ActionEnd がないので、ほかの変更と同じように物理的に戻す
+func undoUnlessSkipped(tx *Transaction, rec LogRecord, skipping bool) (bool, error) {
+	if skipping {
+		return rec.Op() != ActionStart, nil
+	}
+	if err := rec.Undo(tx); err != nil {
+		return false, err
+	}
+	return rec.Op() == ActionEnd, nil
 }
 
 func contains(heystack []int, needle int) bool {
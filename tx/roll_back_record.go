@@ -32,7 +32,7 @@ func (rr *RollbackRecord) TxNumber() int {
 
 // Undo() undoes the operation encoded by this log record
 // do nothing in Rollback
-func (rr *RollbackRecord) Undo(tx *Transaction) {}
+func (rr *RollbackRecord) Undo(tx *Transaction) error { return nil }
 
 func (rr *RollbackRecord) String() string {
 	return "<ROLLBACK " + strconv.Itoa(rr.txnum) + ">"
@@ -64,10 +64,14 @@ func (sir *SetIntRecord) TxNumber() int {
 }
 
 // Undo() undoes the operation encoded by this log record
-func (sir *SetIntRecord) Undo(tx *Transaction) {
-	tx.Pin(sir.blk)
-	tx.SetInt(sir.blk, sir.offset, sir.val, false)
-	tx.Unpin(sir.blk)
+func (sir *SetIntRecord) Undo(tx *Transaction) error {
+	if err := tx.Pin(sir.blk); err != nil {
+		return err
+	}
+	if err := tx.SetInt(sir.blk, sir.offset, sir.val, false); err != nil {
+		return err
+	}
+	return tx.Unpin(sir.blk)
 }
 
 func (sir *SetIntRecord) String() string {
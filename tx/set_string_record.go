@@ -72,10 +72,14 @@ func (ssr *SetStringRecord) TxNumber() int {
 }
 
 // Undo() undoes the operation encoded by this log record
-func (ssr *SetStringRecord) Undo(tx *Transaction) {
-	tx.Pin(ssr.blk)
-	tx.SetString(ssr.blk, ssr.offset, ssr.val, false)
-	tx.Unpin(ssr.blk)
+func (ssr *SetStringRecord) Undo(tx *Transaction) error {
+	if err := tx.Pin(ssr.blk); err != nil {
+		return err
+	}
+	if err := tx.SetString(ssr.blk, ssr.offset, ssr.val, false); err != nil {
+		return err
+	}
+	return tx.Unpin(ssr.blk)
 }
 
 func (ssr *SetStringRecord) String() string {
@@ -33,7 +33,7 @@ func (sr *StartRecord) TxNumber() int {
 
 // Undo() undoes the operation encoded by this log record
 // do nothing in Start
-func (sr *StartRecord) Undo(tx *Transaction) {}
+func (sr *StartRecord) Undo(tx *Transaction) error { return nil }
 
 func (sr *StartRecord) String() string {
 	return "<START " + strconv.Itoa(sr.txnum) + ">"
@@ -75,7 +75,7 @@ func (tx *Transaction) Unpin(blk file.BlockID) error {
 }
 
 func (tx *Transaction) GetInt(blk file.BlockID, offset int) (int, error) {
-	err := tx.cm.SLock(blk)
+	err := tx.readLock(blk)
 	if err != nil {
 		return 0, err
 	}
@@ -96,7 +96,7 @@ func (tx *Transaction) GetInt(blk file.BlockID, offset int) (int, error) {
 }
 
 func (tx *Transaction) SetInt(blk file.BlockID, offset int, val int, okToLog bool) error {
-	err := tx.cm.XLock(blk)
+	err := tx.writeLock(blk)
 	if err != nil {
 		return err
 	}
@@ -124,7 +124,7 @@ func (tx *Transaction) SetInt(blk file.BlockID, offset int, val int, okToLog boo
 }
 
 func (tx *Transaction) GetString(blk file.BlockID, offset int) (string, error) {
-	err := tx.cm.SLock(blk)
+	err := tx.readLock(blk)
 	if err != nil {
 		return "", err
 	}
@@ -144,7 +144,7 @@ func (tx *Transaction) GetString(blk file.BlockID, offset int) (string, error) {
 }
 
 func (tx *Transaction) SetString(blk file.BlockID, offset int, val string, okToLog bool) error {
-	err := tx.cm.XLock(blk)
+	err := tx.writeLock(blk)
 	if err != nil {
 		return err
 	}
@@ -171,9 +171,108 @@ func (tx *Transaction) SetString(blk file.BlockID, offset int, val string, okToL
 	return nil
 }
 
+// readLock は blk を読み込む前に共有ロックをかける
+// latch をかけたページは latch で読み書きを保護するので、ロックをかけない
+func (tx *Transaction) readLock(blk file.BlockID) error {
+	latched, err := tx.cm.Latched(blk, false)
+	if err != nil || latched {
+		return err
+	}
+	return tx.cm.SLock(blk)
+}
+
+// writeLock は blk に書き込む前に排他ロックをかける
+// 排他の latch をかけたページはロックをかけずに書き込めて、共有の latch しかかけていない場合はエラーを返す
+func (tx *Transaction) writeLock(blk file.BlockID) error {
+	latched, err := tx.cm.Latched(blk, true)
+	if err != nil || latched {
+		return err
+	}
+	return tx.cm.XLock(blk)
+}
+
+// SLock は blk に共有ロックをかけて、トランザクションの終わりまで保持する
+// 他のトランザクションが排他ロックをかけている場合は外すまで待つので、 latch をかけている間は呼ばない
+func (tx *Transaction) SLock(blk file.BlockID) error {
+	return tx.cm.SLock(blk)
+}
+
+// XLock は blk に排他ロックをかけて、トランザクションの終わりまで保持する
+// 他のトランザクションがロックをかけている場合は外すまで待つので、 latch をかけている間は呼ばない
+func (tx *Transaction) XLock(blk file.BlockID) error {
+	return tx.cm.XLock(blk)
+}
+
+// TrySLock は待たずに blk に共有ロックをかけて、かけられなかった場合は false を返す
+// latch をかけたページの読み書きはロックをかけないので、 latch で保護するページの論理的な内容は明示的にロックする
+// かけられなかった場合は latch を全て外してから SLock で待つ
+func (tx *Transaction) TrySLock(blk file.BlockID) bool {
+	return tx.cm.TrySLock(blk)
+}
+
+// TryXLock は待たずに blk に排他ロックをかけて、かけられなかった場合は false を返す
+func (tx *Transaction) TryXLock(blk file.BlockID) bool {
+	return tx.cm.TryXLock(blk)
+}
+
+// HasXLock はこのトランザクションが blk に排他ロックをかけているかどうかを返す
+func (tx *Transaction) HasXLock(blk file.BlockID) bool {
+	return tx.cm.HasXLock(blk)
+}
+
+// SLatch は blk に共有の latch をかける
+// latch はロックと違ってトランザクションの終わりを待たずに Unlatch で外す
+// latch をかけている間はロックをかけずに blk を読み込みできる
+func (tx *Transaction) SLatch(blk file.BlockID) error {
+	return tx.cm.SLatch(blk)
+}
+
+// XLatch は blk に排他の latch をかける
+// latch をかけている間はロックをかけずに blk を読み書きできる
+// 書き込んだ内容は他のトランザクションがすぐに読み書きするので、 StartAction と EndAction の間で書き込む
+func (tx *Transaction) XLatch(blk file.BlockID) error {
+	return tx.cm.XLatch(blk)
+}
+
+// Unlatch は SLatch か XLatch でかけた latch を外す
+func (tx *Transaction) Unlatch(blk file.BlockID) {
+	tx.cm.Unlatch(blk)
+}
+
+// UndoFunc は EndAction で終えた一連の変更を、 EndAction に渡した undoData をもとに論理的に取り消す
+// ロールバックとリカバリで呼ばれるので、 undoData だけから取り消せるようにする
+type UndoFunc func(tx *Transaction, undoData []byte) error
+
+var undoFuncs = make(map[string]UndoFunc)
+
+// RegisterUndo は EndAction の undoName で呼ぶ UndoFunc を登録する
+// リカバリの前に登録しておく必要があるので、パッケージの初期化で呼ぶ
+func RegisterUndo(undoName string, f UndoFunc) {
+	undoFuncs[undoName] = f
+}
+
+// StartAction は latch をかけて他のトランザクションと共有するページへの、一連の変更を始める
+// 一連の変更を終えた後は他のトランザクションが同じページを変更しているかもしれないので、ページの値を物理的に戻せない
+// そのため EndAction で終えた変更は、ロールバックとリカバリで ActionEnd のログレコードから論理的に取り消す
+// 途中で終わった変更は、まだ latch をかけているページだけを変更しているので物理的に戻す
+func (tx *Transaction) StartAction() error {
+	return tx.rm.StartAction()
+}
+
+// EndAction は StartAction で始めた一連の変更を終えて、取り消すときに undoName で登録した UndoFunc に undoData を渡すようにする
+func (tx *Transaction) EndAction(undoName string, undoData []byte) error {
+	return tx.rm.EndAction(undoName, undoData)
+}
+
+// EndOfFile は Size と Append でロックするブロックを返す
+// latch をかけると、ロックをかけずにファイルの大きさを読み書きできる
+func (tx *Transaction) EndOfFile(filename string) file.BlockID {
+	return file.NewBlockID(filename, endOfFile)
+}
+
 func (tx *Transaction) Size(filename string) (int, error) {
-	blk := file.NewBlockID(filename, endOfFile)
-	err := tx.cm.SLock(blk)
+	blk := tx.EndOfFile(filename)
+	err := tx.readLock(blk)
 	if err != nil {
 		return 0, err
 	}
@@ -182,8 +281,8 @@ func (tx *Transaction) Size(filename string) (int, error) {
 }
 
 func (tx *Transaction) Append(filename string) (file.BlockID, error) {
-	blk := file.NewBlockID(filename, endOfFile)
-	err := tx.cm.XLock(blk)
+	blk := tx.EndOfFile(filename)
+	err := tx.writeLock(blk)
 	if err != nil {
 		return file.BlockID{}, err
 	}
@@ -198,3 +297,13 @@ func (tx *Transaction) BlockSize() int {
 func (tx *Transaction) AvailableBuffers() int {
 	return tx.bm.Available()
 }
+
+// IOStats はバッファの pin とディスクの読み書きの回数
+type IOStats = buffer.IOStats
+
+// IOStats はトランザクションを開始してからの I/O の累計を返す
+// 他のトランザクションの I/O は含まないので、差分をとって scan ごとの I/O を数える
+// コミットでディスクに書き込むブロックは含まない
+func (tx *Transaction) IOStats() IOStats {
+	return tx.bl.ios
+}
@@ -1,6 +1,8 @@
 package tx_test
 
 import (
+	"errors"
+	"os"
 	"testing"
 
 	"github.com/ksrnnb/go-rdb/file"
@@ -11,7 +13,14 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+func initializeFiles(t *testing.T) {
+	t.Helper()
+	err := os.RemoveAll("../data")
+	require.NoError(t, err)
+}
+
 func TestTransaction(t *testing.T) {
+	initializeFiles(t)
 	sdb := myTesting.NewSimpleDB(t, "data", 400, 8)
 	fm := sdb.FileManager()
 	lm := sdb.LogManager()
@@ -69,3 +78,151 @@ func TestTransaction(t *testing.T) {
 	assert.Equal(t, 2, intVal, "get int value")
 	require.NoError(t, tx4.Commit())
 }
+
+func TestTransaction_RollbackReportsUndoError(t *testing.T) {
+	initializeFiles(t)
+	sdb := myTesting.NewSimpleDB(t, "data", 400, 8)
+	fm := sdb.FileManager()
+	lm := sdb.LogManager()
+	bm := sdb.BufferManager()
+	lt := concurrency.NewLockTable()
+	tng := tx.NewTransactionNumberGenerator()
+
+	errUndo := errors.New("undo failed")
+	tx.RegisterUndo("failing", func(tx *tx.Transaction, undoData []byte) error {
+		return errUndo
+	})
+
+	// 論理的に取り消せなかった場合は、ロールバックがエラーを返す
+	tx1, err := tx.NewTransaction(fm, lm, bm, lt, tng)
+	require.NoError(t, err)
+	blk := file.NewBlockID("testfile", 1)
+	require.NoError(t, tx1.Pin(blk))
+	require.NoError(t, tx1.StartAction())
+	require.NoError(t, tx1.SetInt(blk, 80, 1, true))
+	require.NoError(t, tx1.EndAction("failing", nil))
+	assert.ErrorIs(t, tx1.Rollback(), errUndo)
+
+	// 取り消す関数を登録していない場合もエラーを返す
+	tx2, err := tx.NewTransaction(fm, lm, bm, lt, tng)
+	require.NoError(t, err)
+	require.NoError(t, tx2.StartAction())
+	require.NoError(t, tx2.EndAction("unregistered", nil))
+	assert.Error(t, tx2.Rollback())
+}
+
+func TestTransaction_RecoverInterleavedActions(t *testing.T) {
+	initializeFiles(t)
+	sdb := myTesting.NewSimpleDB(t, "data", 400, 8)
+	fm := sdb.FileManager()
+	lm := sdb.LogManager()
+	bm := sdb.BufferManager()
+	lt := concurrency.NewLockTable()
+	tng := tx.NewTransactionNumberGenerator()
+
+	blkA := file.NewBlockID("testfile", 1)
+	blkB := file.NewBlockID("testfile", 2)
+	tx0, err := tx.NewTransaction(fm, lm, bm, lt, tng)
+	require.NoError(t, err)
+	require.NoError(t, tx0.Pin(blkA))
+	require.NoError(t, tx0.Pin(blkB))
+	require.NoError(t, tx0.SetInt(blkA, 0, 100, true))
+	require.NoError(t, tx0.SetInt(blkB, 0, 10, true))
+	require.NoError(t, tx0.Commit())
+
+	// observe は blkA の値を1つ戻す論理的な取り消しで、取り消すときに blkB の値を読む
+	// 木をたどる取り消しが、他のトランザクションが変更しかけたページを読む場合にあたる
+	observed := make([]int, 0)
+	tx.RegisterUndo("observe", func(tx *tx.Transaction, undoData []byte) error {
+		if err := tx.Pin(blkB); err != nil {
+			return err
+		}
+		v, err := tx.GetInt(blkB, 0)
+		if err != nil {
+			return err
+		}
+		observed = append(observed, v)
+		if err := tx.Pin(blkA); err != nil {
+			return err
+		}
+		a, err := tx.GetInt(blkA, 0)
+		if err != nil {
+			return err
+		}
+		return tx.SetInt(blkA, 0, a-1, false)
+	})
+	increment := func(tx *tx.Transaction, want int) {
+		require.NoError(t, tx.StartAction())
+		require.NoError(t, tx.Pin(blkA))
+		require.NoError(t, tx.SetInt(blkA, 0, want, true))
+		require.NoError(t, tx.EndAction("observe", nil))
+	}
+
+	// txA の一連の変更の間に、 txB が blkB を変更しかけたまま終わらない
+	txA, err := tx.NewTransaction(fm, lm, bm, lt, tng)
+	require.NoError(t, err)
+	txB, err := tx.NewTransaction(fm, lm, bm, lt, tng)
+	require.NoError(t, err)
+	increment(txA, 101)
+	require.NoError(t, txB.StartAction())
+	require.NoError(t, txB.XLatch(blkB))
+	require.NoError(t, txB.Pin(blkB))
+	require.NoError(t, txB.SetInt(blkB, 0, 20, true))
+	increment(txA, 102)
+
+	// コミットもロールバックもしないまま再起動したものとして、新しいロックテーブルでリカバリする
+	rtx, err := tx.NewTransaction(fm, lm, bm, concurrency.NewLockTable(), tng)
+	require.NoError(t, err)
+	require.NoError(t, rtx.Recover())
+
+	// 論理的な取り消しは、 txB の途中の変更を戻した後の blkB を読む
+	assert.Equal(t, []int{10, 10}, observed)
+	tx1, err := tx.NewTransaction(fm, lm, bm, concurrency.NewLockTable(), tng)
+	require.NoError(t, err)
+	require.NoError(t, tx1.Pin(blkA))
+	require.NoError(t, tx1.Pin(blkB))
+	a, err := tx1.GetInt(blkA, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 100, a)
+	b, err := tx1.GetInt(blkB, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 10, b)
+	require.NoError(t, tx1.Commit())
+}
+
+func TestTransaction_IOStats(t *testing.T) {
+	initializeFiles(t)
+	sdb := myTesting.NewSimpleDB(t, "data", 400, 8)
+	fm := sdb.FileManager()
+	lm := sdb.LogManager()
+	bm := sdb.BufferManager()
+	lt := concurrency.NewLockTable()
+	tng := tx.NewTransactionNumberGenerator()
+
+	tx1, err := tx.NewTransaction(fm, lm, bm, lt, tng)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, tx1.Pin(file.NewBlockID("iofile", i)))
+	}
+	assert.Equal(t, tx.IOStats{Pins: 3, BlocksRead: 3}, tx1.IOStats())
+
+	// バッファにあるブロックはディスクから読み込まない
+	tx2, err := tx.NewTransaction(fm, lm, bm, lt, tng)
+	require.NoError(t, err)
+	require.NoError(t, tx2.Pin(file.NewBlockID("iofile", 0)))
+	assert.Equal(t, tx.IOStats{Pins: 1}, tx2.IOStats())
+
+	// 変更したバッファは、次の pin で追い出すときにディスクに書き込む
+	// 他のトランザクションの I/O は tx1 に数えない
+	for i := 0; i < 8; i++ {
+		blk := file.NewBlockID("otherfile", i)
+		require.NoError(t, tx2.Pin(blk))
+		require.NoError(t, tx2.SetInt(blk, 0, i, false))
+		require.NoError(t, tx2.Unpin(blk))
+	}
+	assert.Equal(t, tx.IOStats{Pins: 9, BlocksRead: 8, BlocksWritten: 7}, tx2.IOStats())
+	assert.Equal(t, tx.IOStats{Pins: 3, BlocksRead: 3}, tx1.IOStats())
+
+	require.NoError(t, tx2.Commit())
+	require.NoError(t, tx1.Commit())
+}